@@ -1,6 +1,7 @@
 package digitalstrom
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -25,10 +26,14 @@ const (
 	defaultStructurePollingInterval               = 250
 	defaultMaxSimultanousPolls                    = 10
 	defaultBinaryInputsPollingInterval            = 300
+	defaultStatesPollingInterval                  = 300
+	defaultMaxRequestsPerSecond                   = 10
 )
 
 // Account Main communication module to communicate with API. It caches and updates Devices for
-// faster communication
+// faster communication. The cached structure, maps and values are guarded by an internal lock.
+// While polling or the event listener is running, they should not be accessed directly. Use
+// Snapshot to get a consistent copy instead.
 type Account struct {
 	Connection         Connection
 	Structure          Structure
@@ -38,15 +43,20 @@ type Account struct {
 	Floors             map[int]*Floor
 	Circuits           map[string]*Circuit
 	TemperatureControl map[int]*TemperatureControlState
+	States             map[string]*ApartmentState
 	//Scenes     map[string]Scene
+	cacheMutex  *sync.RWMutex
+	valueTimes  map[string]time.Time
+	staleBefore time.Time // values updated before are stale, zero when the cache is not stale
 
 	// updating
-	PollingSetup      PollingSetup
-	pollingHelpers    pollingHelpers
-	quitTickerChannel chan bool
+	PollingSetup   PollingSetup
+	pollingHelpers pollingHelpers
 
 	// events
-	Events EventChannels
+	Events        EventChannels
+	eventBus      *eventBus
+	eventListener eventListener
 }
 
 type TemperatureControlState struct {
@@ -71,9 +81,17 @@ type PollingSetup struct {
 	DefaultStructurePollingInterval               int `json:"default_on_value_polling_interval"`
 	DefaultTemperatureControlStatePollingInterval int `json:"default_temperature_control_state_polling_interval"`
 	DefaultBinaryInputsPollingInterval            int `json:"default_binary_inputs_polling_interval"`
+	DefaultStatesPollingInterval                  int `json:"default_states_polling_interval"`
 	MaxParallelPolls                              int `json:"max_parallel_polls"`
+	// MaxRequestsPerSecond limits the requests of the polling to the dSS, 0 for no limit
+	MaxRequestsPerSecond float64 `json:"max_requests_per_second"`
 }
 
+// EventChannels allows to receive events on a single channel per event type. Sends are blocking
+// the dispatching poller, so each channel has to be consumed continuously.
+//
+// Deprecated: use Account.Subscribe or Account.SubscribeChannel, which support multiple consumers,
+// filters and do not stall polling when a consumer is slow.
 type EventChannels struct {
 	SensorValueChanged                 chan<- SensorValueChangeEvent
 	ChannelValueChanged                chan<- ChannelValueChangeEvent
@@ -82,6 +100,9 @@ type EventChannels struct {
 	OnStateValueChanged                chan<- OnStateValueChangeEvent
 	ZoneTemperatureControlStateChanged chan<- ZoneTemperatureControlChangeEvent
 	BinaryInputStateChanged            chan<- BinaryInputStateChangeEvent
+	ZoneSensorValueChanged             chan<- ZoneSensorValueChangeEvent
+	SceneCalled                        chan<- SceneCalledEvent
+	StateChanged                       chan<- StateChangeEvent
 	chanMutex                          *sync.Mutex
 }
 
@@ -91,8 +112,18 @@ type pollingHelpers struct {
 	lastPollMap       map[string]time.Time
 	activePollingMap  map[string]time.Time
 	pollingStopped    bool
-	mapMutex          *sync.Mutex
-	countMutex        *sync.Mutex
+	pollCounts        map[string]uint64
+	pollErrors        map[string]uint64
+	cancelPolling     context.CancelFunc
+	pollingRun        uint64 // counts the starts, so a stopping run does not stop its successor
+	scheduler         Scheduler
+	// intervals of the values added to the scheduler, intervalsChanged is set when pollIntervalMap differs
+	scheduledIntervals map[string]int
+	intervalsChanged   bool
+	budget             requestBudget
+	wake               chan struct{}
+	mapMutex           *sync.Mutex
+	countMutex         *sync.Mutex
 }
 
 var logger = stdr.New(stdlog.New(os.Stderr, "", stdlog.LstdFlags|stdlog.Lshortfile))
@@ -103,12 +134,14 @@ func SetLogger(newLogger logr.Logger) {
 }
 
 // NewAccount sets connection baseURL to default, generates maps and returns
-// empty Account instance
-func NewAccount() *Account {
-	return &Account{
+// empty Account instance. The options could be used to change how the certificate of the dSS is verified.
+func NewAccount(options ...AccountOption) *Account {
+	a := &Account{
 		Connection: Connection{
-			BaseURL:    defautBaseURL,
-			HTTPClient: http.DefaultClient,
+			BaseURL:     defautBaseURL,
+			HTTPClient:  http.DefaultClient,
+			RetryPolicy: DefaultRetryPolicy,
+			stats:       &requestStats{},
 		},
 		Devices:            make(map[string]*Device),
 		Groups:             make(map[int]*Group),
@@ -116,7 +149,9 @@ func NewAccount() *Account {
 		Floors:             make(map[int]*Floor),
 		Circuits:           make(map[string]*Circuit),
 		TemperatureControl: make(map[int]*TemperatureControlState),
-		quitTickerChannel:  make(chan bool),
+		States:             make(map[string]*ApartmentState),
+		cacheMutex:         &sync.RWMutex{},
+		valueTimes:         make(map[string]time.Time),
 		PollingSetup: PollingSetup{
 			DefaultCircuitsPollingInterval:                defaultCircuitPollingInterval,
 			DefaultChannelsPollingInterval:                defaultChannelPollingInterval,
@@ -124,113 +159,211 @@ func NewAccount() *Account {
 			DefaultStructurePollingInterval:               defaultStructurePollingInterval,
 			DefaultTemperatureControlStatePollingInterval: defaultTemperatureControlStatePollingInterval,
 			DefaultBinaryInputsPollingInterval:            defaultBinaryInputsPollingInterval,
+			DefaultStatesPollingInterval:                  defaultStatesPollingInterval,
 			MaxParallelPolls:                              defaultMaxSimultanousPolls,
+			MaxRequestsPerSecond:                          defaultMaxRequestsPerSecond,
 		},
 		pollingHelpers: pollingHelpers{
-			parallelPollCount: 0,
-			pollIntervalMap:   nil,
-			activePollingMap:  make(map[string]time.Time),
-			lastPollMap:       make(map[string]time.Time),
-			pollingStopped:    true,
-			mapMutex:          &sync.Mutex{},
-			countMutex:        &sync.Mutex{},
+			parallelPollCount:  0,
+			pollIntervalMap:    nil,
+			activePollingMap:   make(map[string]time.Time),
+			lastPollMap:        make(map[string]time.Time),
+			pollingStopped:     true,
+			pollCounts:         make(map[string]uint64),
+			pollErrors:         make(map[string]uint64),
+			scheduler:          NewPriorityScheduler(DefaultSchedulerSetup),
+			scheduledIntervals: make(map[string]int),
+			wake:               make(chan struct{}, 1),
+			mapMutex:           &sync.Mutex{},
+			countMutex:         &sync.Mutex{},
 		},
 		Events: EventChannels{
 			chanMutex: &sync.Mutex{},
 		},
+		eventBus: newEventBus(),
+		eventListener: eventListener{
+			eventNames: defaultSubscribedEvents,
+			mutex:      &sync.Mutex{},
+		},
+	}
+	a.Connection.breaker.Store(&circuitBreaker{setup: DefaultCircuitBreakerSetup, onChange: a.connectivityChanged})
+	o := &accountOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	if o.tls != nil {
+		a.Connection.SetTLS(*o.tls)
 	}
+	return a
+}
 
+// connectivityChanged is called by the circuit breaker of the connection when it opens or closes
+func (a *Account) connectivityChanged(state CircuitState, err error) {
+	if state == CSopen {
+		logger.Error(err, "dSS unreachable, pausing polling")
+	} else {
+		logger.Info("dSS reachable again, resuming polling")
+	}
+	a.eventBus.publish(ConnectivityChangeEvent{Connected: state == CSclosed, Err: err})
 }
 
 // ApplicationLogin uses the assigned applicationToken to generate a session token. The timeout depends on server settings,
 // default is 180 seconds. This timeout will be automatically reset by every performed request.
 func (a *Account) ApplicationLogin() error {
-	return a.Connection.applicationLogin()
+	return a.ApplicationLoginContext(context.Background())
+}
+
+// ApplicationLoginContext is like ApplicationLogin but aborts all performed requests when ctx is done.
+func (a *Account) ApplicationLoginContext(ctx context.Context) error {
+	return a.Connection.applicationLogin(ctx)
 }
 
 //GetSensor Returning the sensor with die index ID <sensorIndex> of device with display ID <deviceID> or nil when either
 // device with the given ID couldn't be found or the sensor index is higher than the amount of sensors the device has
 func (a *Account) GetSensor(deviceID string, sensorIndex int) (*Sensor, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
 	device, ok := a.Devices[deviceID]
 	if !ok {
-		return nil, errors.New("no device with id '" + deviceID + "' found")
+		return nil, &NotFoundError{Kind: "device", ID: deviceID}
 	}
-	if sensorIndex >= len(device.Sensors) {
-		return nil, errors.New("sensorIndex " + strconv.Itoa(sensorIndex) + " out of range for device " + deviceID)
+	if sensorIndex < 0 || sensorIndex >= len(device.Sensors) {
+		return nil, &NotFoundError{Kind: "sensor", ID: strconv.Itoa(sensorIndex), Parent: deviceID}
 	}
 	return device.Sensors[sensorIndex], nil
 }
 
 func (a *Account) IsDevicePresent(deviceID string) (bool, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
 	device, ok := a.Devices[deviceID]
 	if !ok {
-		return false, errors.New("no device with id '" + deviceID + "' found")
+		return false, &NotFoundError{Kind: "device", ID: deviceID}
 	}
 	return device.IsPresent, nil
 
 }
 
+// GetDevice returns the device with the given display ID.
+func (a *Account) GetDevice(deviceID string) (*Device, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	device, ok := a.Devices[deviceID]
+	if !ok {
+		return nil, &NotFoundError{Kind: "device", ID: deviceID}
+	}
+	return device, nil
+}
+
+// GetCircuit returns the circuit with the given display ID.
+func (a *Account) GetCircuit(circuitID string) (*Circuit, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	circuit, ok := a.Circuits[circuitID]
+	if !ok {
+		return nil, &NotFoundError{Kind: "circuit", ID: circuitID}
+	}
+	return circuit, nil
+}
+
 func (a *Account) GetDeviceByUuid(uuid string) (*Device, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	return a.getDeviceByUuid(uuid)
+}
 
+// getDeviceByUuid is the unlocked variant of GetDeviceByUuid. The cache lock has to be held by the caller.
+func (a *Account) getDeviceByUuid(uuid string) (*Device, error) {
 	for _, dev := range a.Devices {
 		if dev.UUID == uuid {
 			return dev, nil
 		}
 	}
 
-	return nil, fmt.Errorf("found no device with dsuid=%s", uuid)
+	return nil, &NotFoundError{Kind: "device", ID: uuid}
 }
 
 //GetOutputChannel Returning the output channel with die index ID <channelIndex> of device with display ID <deviceID> or nil when either
 // device with the given ID couldn't be found or the OutputChannel index is higher than the amount of channels the device has
 func (a *Account) GetOutputChannel(deviceID string, channelIndex int) (*OutputChannel, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
 	device, ok := a.Devices[deviceID]
 	if !ok {
-		return nil, errors.New("no device with id '" + deviceID + "' found")
+		return nil, &NotFoundError{Kind: "device", ID: deviceID}
 	}
-	if channelIndex >= len(device.OutputChannels) {
-		return nil, errors.New("channelIndex " + strconv.Itoa(channelIndex) + " out of range for device " + deviceID)
+	if channelIndex < 0 || channelIndex >= len(device.OutputChannels) {
+		return nil, &NotFoundError{Kind: "channel", ID: strconv.Itoa(channelIndex), Parent: deviceID}
 	}
 	return device.OutputChannels[channelIndex], nil
 }
 
 // Init of the Account. ApplicationLogin will be performed and complete structure requested. ApplicationToken has to be set in advance.
 func (a *Account) Init() error {
+	return a.InitContext(context.Background())
+}
+
+// InitContext is like Init but aborts all performed requests when ctx is done.
+func (a *Account) InitContext(ctx context.Context) error {
 	logger.Info("account initialization")
 	logger.Info("performing application login")
-	err := a.ApplicationLogin()
+	err := a.ApplicationLoginContext(ctx)
 	if err != nil {
 		logger.Error(err, "initialisation has been aborted")
 		return err
 	}
 	logger.Info("requesting complete structure")
-	s, err := a.RequestStructure()
+	s, err := a.RequestStructureContext(ctx)
 	if err != nil {
 		logger.Error(err, "initialisation has been aborted")
 		return err
 	}
+	a.cacheMutex.Lock()
+	// sensor values and binary input states of the dSS replace the cached ones, which might have been loaded
+	// from a file. Output channel values are not part of the structure, the known ones are kept.
+	changes := a.valueChanges(s)
+	a.carryOverChannelValues(s)
 	a.setStructure(*s)
+	a.touchStructureValues(true)
+	a.cacheMutex.Unlock()
+	a.dispatchValueChanges(changes)
 	logger.Info("requesting circuits")
-	circuits, err := a.RequestCircuits()
+	circuits, err := a.RequestCircuitsContext(ctx)
 	if err != nil {
 		logger.Error(err, "initialisation has been aborted")
 		return err
 	}
+	a.cacheMutex.Lock()
 	// fill the circuit map for fast access
 	for i := range circuits {
+		// metering values are not part of the circuit request, keep the known values
+		if old, ok := a.Circuits[circuits[i].DisplayID]; ok {
+			circuits[i].Consumption = old.Consumption
+			circuits[i].MeterValue = old.MeterValue
+		}
 		a.Circuits[circuits[i].DisplayID] = &circuits[i]
 	}
+	a.cacheMutex.Unlock()
 	logger.Info("requesting temperature control states")
-	tempValues, err := a.RequestTemperatureControlStatus()
+	tempValues, err := a.RequestTemperatureControlStatusContext(ctx)
 	if err != nil {
 		logger.Error(err, "initialisation has been aborted")
 		return err
 	}
+	a.cacheMutex.Lock()
 	// fill the circuit map for fast access
 	for i := range tempValues {
 		a.TemperatureControl[tempValues[i].ZoneId] = &tempValues[i]
 	}
 	a.assignTempControlStatesToZones()
+	a.touchValue("temperatureControlState")
+	a.cacheMutex.Unlock()
+	logger.Info("requesting apartment states")
+	err = a.PollApartmentStatesContext(ctx)
+	if err != nil {
+		// states are read from the property tree, which might not be accessible for the application
+		logger.Error(err, "apartment states not available")
+	}
 	logger.Info("account successfully initialized")
 	return nil
 }
@@ -238,23 +371,34 @@ func (a *Account) Init() error {
 // RegisterApplication an application with the given applicitonName. Performs a request to generate an application token. A second request requires the
 // Username and Password in order to generate a temporary session token. A third request enables the application token to login without
 // further user credentials (applicationLogin). Returns the application token or an error. The application token will not be assigned automatically.
-// Thus, in order to use the generated application token, it has to be set afterwards (Account.SetApplicationToken).
+// Thus, in order to use the generated application token, it has to be set afterwards (Account.SetApplicationToken) or
+// loaded from the TokenStore, which the token is saved to if set.
 func (a *Account) RegisterApplication(applicationName string, username string, password string) (string, error) {
-	return a.Connection.register(username, password, applicationName)
+	return a.RegisterApplicationContext(context.Background(), applicationName, username, password)
+}
+
+// RegisterApplicationContext is like RegisterApplication but aborts all performed requests when ctx is done.
+func (a *Account) RegisterApplicationContext(ctx context.Context, applicationName string, username string, password string) (string, error) {
+	return a.Connection.register(ctx, username, password, applicationName)
 }
 
 // RequestCircuits performs a getCircuits request. The received circuit array
 // has to be assigned to the account separately
 //
 func (a *Account) RequestCircuits() ([]Circuit, error) {
-	res, err := a.Connection.Get(a.Connection.BaseURL + "/json/apartment/getCircuits")
+	return a.RequestCircuitsContext(context.Background())
+}
+
+// RequestCircuitsContext is like RequestCircuits but aborts all performed requests when ctx is done.
+func (a *Account) RequestCircuitsContext(ctx context.Context) ([]Circuit, error) {
+	res, err := a.Connection.GetContext(ctx, a.Connection.BaseURL+"/json/apartment/getCircuits")
 
 	if err != nil {
 		return nil, err
 	}
 
 	if !res.OK {
-		return nil, errors.New(res.Message)
+		return nil, res.apiError()
 	}
 	// get result as map[string]interface{}
 	jsonString, err := json.Marshal(res.Result["circuits"])
@@ -267,7 +411,7 @@ func (a *Account) RequestCircuits() ([]Circuit, error) {
 	// let json.Unmarshal do the job of mapping to Circuit
 	err = json.Unmarshal(jsonString, &circuits)
 	if err != nil {
-		return nil, err
+		return nil, res.invalid("circuits", err)
 	}
 
 	// there we are, return everything
@@ -276,14 +420,19 @@ func (a *Account) RequestCircuits() ([]Circuit, error) {
 
 // RequestTemperatureControlStatus performs a getTemperatureControlStatus request.
 func (a *Account) RequestTemperatureControlStatus() ([]TemperatureControlState, error) {
-	res, err := a.Connection.Get(a.Connection.BaseURL + "/json/apartment/getTemperatureControlStatus")
+	return a.RequestTemperatureControlStatusContext(context.Background())
+}
+
+// RequestTemperatureControlStatusContext is like RequestTemperatureControlStatus but aborts all performed requests when ctx is done.
+func (a *Account) RequestTemperatureControlStatusContext(ctx context.Context) ([]TemperatureControlState, error) {
+	res, err := a.Connection.GetContext(ctx, a.Connection.BaseURL+"/json/apartment/getTemperatureControlStatus")
 
 	if err != nil {
 		return nil, err
 	}
 
 	if !res.OK {
-		return nil, errors.New(res.Message)
+		return nil, res.apiError()
 	}
 	// get result as map[string]interface{}
 	jsonString, err := json.Marshal(res.Result["zones"])
@@ -296,7 +445,7 @@ func (a *Account) RequestTemperatureControlStatus() ([]TemperatureControlState,
 
 	err = json.Unmarshal(jsonString, &tempControlState)
 	if err != nil {
-		return nil, err
+		return nil, res.invalid("zones", err)
 	}
 
 	// there we are, return everything
@@ -305,15 +454,20 @@ func (a *Account) RequestTemperatureControlStatus() ([]TemperatureControlState,
 
 // RequestStructure performs a getStructure request and returns it or an error that might have been occured.
 func (a *Account) RequestStructure() (*Structure, error) {
+	return a.RequestStructureContext(context.Background())
+}
+
+// RequestStructureContext is like RequestStructure but aborts all performed requests when ctx is done.
+func (a *Account) RequestStructureContext(ctx context.Context) (*Structure, error) {
 
-	res, err := a.Connection.Get(a.Connection.BaseURL + "/json/apartment/getStructure")
+	res, err := a.Connection.GetContext(ctx, a.Connection.BaseURL+"/json/apartment/getStructure")
 
 	if err != nil {
 		return nil, err
 	}
 
 	if !res.OK {
-		return nil, errors.New(res.Message)
+		return nil, res.apiError()
 	}
 	jsonString, _ := json.Marshal(res.Result)
 
@@ -322,7 +476,7 @@ func (a *Account) RequestStructure() (*Structure, error) {
 
 	// assign the structure to our account
 	if err != nil {
-		return nil, err
+		return nil, res.invalid("apartment", err)
 	}
 	// return the shit
 	return &s, err
@@ -331,21 +485,33 @@ func (a *Account) RequestStructure() (*Structure, error) {
 // RequestSystemInfo performs a get system/version request and returns the result or
 // the error that has been occurred
 func (a *Account) RequestSystemInfo() (*System, error) {
-	res, err := a.Connection.Get(a.Connection.BaseURL + "/json/system/version")
+	return a.RequestSystemInfoContext(context.Background())
+}
+
+// RequestSystemInfoContext is like RequestSystemInfo but aborts all performed requests when ctx is done.
+func (a *Account) RequestSystemInfoContext(ctx context.Context) (*System, error) {
+	return a.Connection.requestSystemInfo(ctx)
+}
+
+// requestSystemInfo performs a get system/version request. No token is required.
+func (c *Connection) requestSystemInfo(ctx context.Context) (*System, error) {
+	res, err := c.GetContext(ctx, c.BaseURL+"/json/system/version")
 
 	if err != nil {
 		return nil, err
 	}
 
 	if !res.OK {
-		return nil, errors.New(res.Message)
+		return nil, res.apiError()
 	}
 	jsonString, _ := json.Marshal(res.Result)
 
 	s := System{}
 	err = json.Unmarshal(jsonString, &s)
-
-	return &s, err
+	if err != nil {
+		return nil, res.invalid("result", err)
+	}
+	return &s, nil
 }
 
 // ResetPollingIntervals will remove all intervals for sensors,
@@ -356,6 +522,7 @@ func (a *Account) RequestSystemInfo() (*System, error) {
 func (a *Account) ResetPollingIntervals() {
 	a.pollingHelpers.mapMutex.Lock()
 	a.pollingHelpers.pollIntervalMap = make(map[string]int)
+	a.pollingIntervalsChanged()
 	a.pollingHelpers.mapMutex.Unlock()
 }
 
@@ -363,55 +530,47 @@ func (a *Account) ResetPollingIntervals() {
 // When no update intervals are given in advance, a complete list of update intervals will
 // be generated automatically (including all sensors, output channesl and circuits) by using
 // the related default intervals. Intervals can be set individually, intervals with a value lower
-// than 0 will be skipped
+// than 0 will be skipped. The order of the polls is decided by the Scheduler, see SetScheduler.
 func (a *Account) StartPolling() {
+	a.StartPollingContext(context.Background())
+}
+
+// StartPollingContext starts the update routine like StartPolling does. Polling stops either
+// when StopPolling is called or when the given context is done. Running polls will be aborted
+// in both cases.
+func (a *Account) StartPollingContext(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
 	a.Events.chanMutex.Lock()
 	a.pollingHelpers.pollingStopped = false
+	a.pollingHelpers.cancelPolling = cancel
+	a.pollingHelpers.pollingRun++
+	run := a.pollingHelpers.pollingRun
 	a.Events.chanMutex.Unlock()
 	a.preparePolling()
-	ticker := *time.NewTicker(time.Second)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				for id, interval := range a.pollingHelpers.pollIntervalMap {
-					if interval >= 0 { // intervals lower than 0 will be skipped
-
-						if a.isPollingIntervalReached(id, interval) {
-							a.pollingHelpers.countMutex.Lock()
-							pollCount := a.pollingHelpers.parallelPollCount
-							a.pollingHelpers.countMutex.Unlock()
-							if pollCount < a.PollingSetup.MaxParallelPolls {
-								a.pollingHelpers.mapMutex.Lock()
-								_, ok := a.pollingHelpers.activePollingMap[id]
-								a.pollingHelpers.mapMutex.Unlock()
-								if !ok {
-									a.pollingHelpers.countMutex.Lock()
-									a.pollingHelpers.parallelPollCount++
-									a.pollingHelpers.countMutex.Unlock()
-									go a.performPolling(id)
-								}
-							}
-						}
-					}
-				}
-			case <-a.quitTickerChannel:
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+	go a.runPolling(ctx, run)
 }
 
 // SetApplicationToken that will be used for ApplicationLogin
 func (a *Account) SetApplicationToken(token string) {
+	a.Connection.sessionMutex.Lock()
 	a.Connection.ApplicationToken = token
+	a.Connection.sessionMutex.Unlock()
+}
+
+// SetTokenStore sets the store the application token is loaded from on login, unless it has been set, and
+// saved to after RegisterApplication
+func (a *Account) SetTokenStore(store TokenStore) {
+	a.Connection.TokenStore = store
 }
 
 // SetDefaultPollingIntervals is setting for all sensors, channels and circuits
 // the corresponding default interval. Intervals that were set manually before, will be overwritten.
 func (a *Account) SetDefaultPollingIntervals() {
 	a.ResetPollingIntervals()
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	a.pollingHelpers.mapMutex.Lock()
+	defer a.pollingHelpers.mapMutex.Unlock()
 	for devID, dev := range a.Devices {
 		for i := range dev.Sensors {
 			id := "sensor•" + devID + "•" + strconv.Itoa(i)
@@ -429,22 +588,30 @@ func (a *Account) SetDefaultPollingIntervals() {
 	a.pollingHelpers.pollIntervalMap["structure"] = a.PollingSetup.DefaultStructurePollingInterval
 	a.pollingHelpers.pollIntervalMap["temperatureControlState"] = a.PollingSetup.DefaultTemperatureControlStatePollingInterval
 	a.pollingHelpers.pollIntervalMap["binaryInputs"] = a.PollingSetup.DefaultBinaryInputsPollingInterval
-
+	a.pollingHelpers.pollIntervalMap["states"] = a.PollingSetup.DefaultStatesPollingInterval
+	a.pollingIntervalsChanged()
 }
 
 // SetOutputChannelValue sets the value for the given OutputChannel. Returns error
 func (a *Account) SetOutputChannelValue(channel *OutputChannel, value string) error {
+	return a.SetOutputChannelValueContext(context.Background(), channel, value)
+}
+
+// SetOutputChannelValueContext is like SetOutputChannelValue but aborts all performed requests when ctx is done.
+func (a *Account) SetOutputChannelValueContext(ctx context.Context, channel *OutputChannel, value string) error {
 	params := make(map[string]string)
+	a.cacheMutex.RLock()
 	params["dsuid"] = channel.device.UUID
+	a.cacheMutex.RUnlock()
 	params["channelvalues"] = string(channel.ChannelType) + "=" + value
 
-	res, err := a.Connection.Request(a.Connection.BaseURL+"/json/device/setOutputChannelValue", get, "", params)
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/device/setOutputChannelValue", get, "", params)
 	if err != nil {
 		return err
 	}
 
 	if !res.OK {
-		return errors.New(res.Message)
+		return res.apiError()
 	}
 	return nil
 
@@ -453,7 +620,7 @@ func (a *Account) SetOutputChannelValue(channel *OutputChannel, value string) er
 // SetSessionToken for manually setting the token. Be aware of a timout for each session token. It is recommended to perform
 // an ApplicationLogin using the ApplicationToken. This will update the session token automatically.
 func (a *Account) SetSessionToken(token string) {
-	a.Connection.SessionToken = token
+	a.Connection.SetSessionToken(token)
 }
 
 // SetPollingInterval sets the automatic polling interval for the element identified
@@ -462,10 +629,6 @@ func (a *Account) SetSessionToken(token string) {
 // only those elements will be polled, that were added. To set default polling intervals for
 // all elements, call SetDefaultPollingIntervals()
 func (a *Account) SetPollingInterval(id string, interval int) error {
-	if a.pollingHelpers.pollIntervalMap == nil {
-		a.pollingHelpers.pollIntervalMap = make(map[string]int)
-	}
-
 	s := strings.Split(id, "list")
 	if len(s) < 2 {
 		return errors.New(id + " is not a valid identifier")
@@ -473,7 +636,13 @@ func (a *Account) SetPollingInterval(id string, interval int) error {
 
 	// ToDo: do better id test (sensor existing, channel existing, circuit existing)
 
+	a.pollingHelpers.mapMutex.Lock()
+	if a.pollingHelpers.pollIntervalMap == nil {
+		a.pollingHelpers.pollIntervalMap = make(map[string]int)
+	}
 	a.pollingHelpers.pollIntervalMap[id] = interval
+	a.pollingIntervalsChanged()
+	a.pollingHelpers.mapMutex.Unlock()
 	return nil
 }
 
@@ -487,12 +656,21 @@ func (a *Account) SetURL(url string) {
 func (a *Account) StopPolling() {
 	a.Events.chanMutex.Lock()
 	a.pollingHelpers.pollingStopped = true
+	cancel := a.pollingHelpers.cancelPolling
+	a.pollingHelpers.cancelPolling = nil
 	a.Events.chanMutex.Unlock()
-	a.quitTickerChannel <- true
+	if cancel != nil {
+		cancel()
+	}
 }
 
 // TurnOn sends eithe a turnOn or turnOff request for the given 'device', depending on value of paramter 'on'
 func (a *Account) TurnOn(device *Device, on bool) error {
+	return a.TurnOnContext(context.Background(), device, on)
+}
+
+// TurnOnContext is like TurnOn but aborts all performed requests when ctx is done.
+func (a *Account) TurnOnContext(ctx context.Context, device *Device, on bool) error {
 
 	var url = ""
 	if on {
@@ -501,13 +679,13 @@ func (a *Account) TurnOn(device *Device, on bool) error {
 		url = "/json/device/turnOff"
 	}
 
-	res, err := a.Connection.Request(a.Connection.BaseURL+url, get, "", map[string]string{"dsuid": device.UUID})
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+url, get, "", map[string]string{"dsuid": device.UUID})
 	if err != nil {
 		return err
 	}
 
 	if !res.OK {
-		return errors.New(res.Message)
+		return res.apiError()
 	}
 
 	return nil
@@ -518,25 +696,33 @@ func (a *Account) TurnOn(device *Device, on bool) error {
 // returned. In case an error occured during the request, -1 will be return as well as the
 // error itself.
 func (a *Account) PollCircuitMeterValue(circuit *Circuit) (int, error) {
+	return a.PollCircuitMeterValueContext(context.Background(), circuit)
+}
+
+// PollCircuitMeterValueContext is like PollCircuitMeterValue but aborts all performed requests when ctx is done.
+func (a *Account) PollCircuitMeterValueContext(ctx context.Context, circuit *Circuit) (int, error) {
 
-	res, err := a.Connection.Request(a.Connection.BaseURL+"/json/circuit/getEnergyMeterValue", get, "", map[string]string{"dsuid": circuit.DSUID})
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/circuit/getEnergyMeterValue", get, "", map[string]string{"dsuid": circuit.DSUID})
 	if err != nil {
 		return -1, err
 	}
 	if !res.OK {
-		return -1, errors.New(res.Message)
+		return -1, res.apiError()
 	}
 	value, ok := res.Result["meterValue"].(float64)
 	if !ok {
-		return -1, errors.New("unexpected response - no field 'meterValue' found in response")
+		return -1, res.malformed("meterValue")
 	}
 
 	newValue := int(value)
 
-	if newValue != circuit.MeterValue {
-		oldValue := circuit.MeterValue
-		circuit.MeterValue = newValue
+	a.cacheMutex.Lock()
+	oldValue := circuit.MeterValue
+	circuit.MeterValue = newValue
+	a.touchValue("circuit•" + circuit.DisplayID)
+	a.cacheMutex.Unlock()
 
+	if newValue != oldValue {
 		a.dispatchMeterValueChange(circuit.DisplayID, oldValue, newValue)
 	}
 
@@ -547,65 +733,85 @@ func (a *Account) PollCircuitMeterValue(circuit *Circuit) (int, error) {
 // The requested value will be assigned to the circuit object automatically. Additionally the requested Value will be
 // return or an error (when ocurred)
 func (a *Account) PollCircuitConsumptionValue(circuit *Circuit) (int, error) {
+	return a.PollCircuitConsumptionValueContext(context.Background(), circuit)
+}
+
+// PollCircuitConsumptionValueContext is like PollCircuitConsumptionValue but aborts all performed requests when ctx is done.
+func (a *Account) PollCircuitConsumptionValueContext(ctx context.Context, circuit *Circuit) (int, error) {
 
-	res, err := a.Connection.Request(a.Connection.BaseURL+"/json/circuit/getConsumption", get, "", map[string]string{"dsuid": circuit.DSUID})
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/circuit/getConsumption", get, "", map[string]string{"dsuid": circuit.DSUID})
 	if err != nil {
 		return -1, err
 	}
 	if !res.OK {
-		return -1, errors.New(res.Message)
+		return -1, res.apiError()
 	}
 	value, ok := res.Result["consumption"].(float64)
 	if !ok {
-		return -1, errors.New("unexpected response - no field consumption found in response")
+		return -1, res.malformed("consumption")
 	}
 
 	newValue := int(value)
 
-	if newValue != circuit.Consumption {
-		oldValue := circuit.Consumption
-		circuit.Consumption = newValue
+	a.cacheMutex.Lock()
+	oldValue := circuit.Consumption
+	circuit.Consumption = newValue
+	a.touchValue("circuit•" + circuit.DisplayID)
+	a.cacheMutex.Unlock()
+
+	if newValue != oldValue {
 		a.dispatchConsumptionValueChange(circuit.DisplayID, oldValue, newValue)
 	}
 	return newValue, nil
 }
 
-// PollOnValues ...
+// PollStructureValues requests the structure and updates the cache accordingly. On states, names, presence and
+// group memberships of devices and names of zones are compared with the cached structure. When devices, zones,
+// groups or floors have been added, removed or moved, the cached structure is replaced. Cached sensor values, binary
+// input states and output channel values are carried over into the new structure. Pointers to devices, zones,
+// sensors, channels and binary inputs obtained before, e.g. by GetDevice or GetSensor, are invalidated then: they
+// are no longer updated and have to be requested again after a DeviceAddedEvent, DeviceRemovedEvent,
+// DeviceMovedEvent, ZoneAddedEvent or ZoneRemovedEvent. Sensors and channels of new devices are polled with
+// the default intervals. OnStateValueChangeEvent, DeviceAddedEvent, DeviceRemovedEvent, DevicePresenceChangeEvent,
+// DeviceRenamedEvent, DeviceMovedEvent, GroupMembershipChangeEvent, ZoneAddedEvent, ZoneRemovedEvent and
+// ZoneRenamedEvent are dispatched for the detected changes.
 func (a *Account) PollStructureValues() error {
-	s, err := a.RequestStructure()
+	return a.PollStructureValuesContext(context.Background())
+}
+
+// PollStructureValuesContext is like PollStructureValues but aborts all performed requests when ctx is done.
+func (a *Account) PollStructureValuesContext(ctx context.Context) error {
+	s, err := a.RequestStructureContext(ctx)
 	if err != nil {
 		return err
 	}
-	for i := range s.Apartment.Zones {
-		zone := s.Apartment.Zones[i]
-		for n := range zone.Devices {
-			device := zone.Devices[n]
-			if (device.IsPresent) && (device.IsValid) {
+	// collect the changes while holding the lock and dispatch them afterwards, so
+	// event consumers are able to read the cache
+	a.cacheMutex.Lock()
+	diff := a.diffStructure(s)
+	a.applyStructure(s, diff.rebuild)
+	a.cacheMutex.Unlock()
 
-				ad, ok := a.Devices[device.DisplayID]
-				if ok {
-					if ad.On != device.On {
-						oldval := ad.On
-						ad.On = device.On
-
-						a.dispatchOnValueChange(device.DisplayID, oldval, ad.On)
-
-						ad.IsPresent = device.IsPresent
-						ad.IsValid = device.IsValid
-					}
-				}
-			}
-		}
+	a.publishEvents(diff.events)
+	for _, event := range diff.onEvents {
+		a.dispatchOnValueChange(event.DeviceId, event.OldValue, event.NewValue)
 	}
 	return nil
 }
 
 func (a *Account) PollTemperatureControlValues() error {
-	vals, err := a.RequestTemperatureControlStatus()
+	return a.PollTemperatureControlValuesContext(context.Background())
+}
+
+// PollTemperatureControlValuesContext is like PollTemperatureControlValues but aborts all performed requests when ctx is done.
+func (a *Account) PollTemperatureControlValuesContext(ctx context.Context) error {
+	vals, err := a.RequestTemperatureControlStatusContext(ctx)
 	if err != nil {
 		return err
 	}
 
+	changed := []int{}
+	a.cacheMutex.Lock()
 	for i := range vals {
 		tempCtrl, ok := a.TemperatureControl[vals[i].ZoneId]
 		somethingChanged := false
@@ -635,38 +841,55 @@ func (a *Account) PollTemperatureControlValues() error {
 				tempCtrl.TemperatureValue = vals[i].TemperatureValue
 			}
 			if somethingChanged {
-				a.dispatchTemperatureControlStateChanged(tempCtrl.ZoneId)
+				changed = append(changed, tempCtrl.ZoneId)
 			}
 		}
 	}
+	a.touchValue("temperatureControlState")
+	a.cacheMutex.Unlock()
+
+	for _, zoneId := range changed {
+		a.dispatchTemperatureControlStateChanged(zoneId)
+	}
 	return nil
 }
 
 // PollSensorValue is requesting the current value the given sensor has. The value will be assigned
 // the the sensor.
 func (a *Account) PollBinaryInputs() error {
+	return a.PollBinaryInputsContext(context.Background())
+}
+
+// PollBinaryInputsContext is like PollBinaryInputs but aborts all performed requests when ctx is done.
+func (a *Account) PollBinaryInputsContext(ctx context.Context) error {
 	params := make(map[string]string)
-	res, err := a.Connection.Request(a.Connection.BaseURL+"/json/apartment/getDeviceBinaryInputs", get, "", params)
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/apartment/getDeviceBinaryInputs", get, "", params)
 	if err != nil {
 		return err
 	}
 	if !res.OK {
-		return errors.New(res.Message)
+		return res.apiError()
 	}
 
-	var devArr []interface{}
-
-	devices, ok := res.Result["devices"]
+	devArr, ok := res.Result["devices"].([]interface{})
 	if !ok {
-		return fmt.Errorf("foun no field 'devices' - unexpected response")
+		return res.malformed("devices")
 	}
-	devArr = devices.([]interface{})
 	for i := range devArr {
-		dev := devArr[i].(map[string]interface{})
-		inputs := dev["binaryInputs"].([]interface{})
+		dev, _ := devArr[i].(map[string]interface{})
+		dsuid, ok := dev["dsuid"].(string)
+		inputs, ok2 := dev["binaryInputs"].([]interface{})
+		if !ok || !ok2 {
+			return res.malformed("binaryInputs")
+		}
 		for n := range inputs {
-			input := inputs[n].(map[string]interface{})
-			a.updateBinaryInputState(dev["dsuid"].(string), int(input["inputId"].(float64)), int(input["state"].(float64)))
+			input, _ := inputs[n].(map[string]interface{})
+			inputID, ok := input["inputId"].(float64)
+			state, ok2 := input["state"].(float64)
+			if !ok || !ok2 {
+				return res.malformed("binaryInputs")
+			}
+			a.updateBinaryInputState(dsuid, int(inputID), int(state))
 		}
 	}
 	return nil
@@ -675,58 +898,82 @@ func (a *Account) PollBinaryInputs() error {
 // PollSensorValue is requesting the current value the given sensor has. The value will be assigned
 // the the sensor.
 func (a *Account) PollSensorValue(sensor *Sensor) (float64, error) {
+	return a.PollSensorValueContext(context.Background(), sensor)
+}
+
+// PollSensorValueContext is like PollSensorValue but aborts all performed requests when ctx is done.
+func (a *Account) PollSensorValueContext(ctx context.Context, sensor *Sensor) (float64, error) {
 	params := make(map[string]string)
+	a.cacheMutex.RLock()
 	if len(sensor.device.ID) > 0 {
 		params["dsid"] = sensor.device.ID
 	} else {
 		params["dsuid"] = sensor.device.UUID
 	}
 	params["sensorIndex"] = strconv.Itoa(sensor.Index)
-	res, err := a.Connection.Request(a.Connection.BaseURL+"/json/device/getSensorValue", get, "", params)
+	a.cacheMutex.RUnlock()
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/device/getSensorValue", get, "", params)
 	if err != nil {
 		return 0, err
 	}
 	if !res.OK {
-		return 0, errors.New(res.Message)
+		return 0, res.apiError()
 	}
 
 	value, ok := res.Result["sensorValue"].(float64)
 	//fmt.Printf("sensor "+sensor.device.DisplayID+".%d = %f\r\n", sensor.Index, value)
 	if !ok {
-		return 0, errors.New("unable to extract sensorValue from request result")
+		return 0, res.malformed("sensorValue")
 	}
 
-	if sensor.Value != value {
-		oldValue := sensor.Value
-		sensor.Value = value
-		a.dispatchSensorValueChange(sensor.device.DisplayID, sensor.Index, oldValue, value)
+	a.cacheMutex.Lock()
+	oldValue := sensor.Value
+	sensor.Value = value
+	deviceID := sensor.device.DisplayID
+	a.touchValue("sensor•" + deviceID + "•" + strconv.Itoa(sensor.Index))
+	a.cacheMutex.Unlock()
+
+	if oldValue != value {
+		a.dispatchSensorValueChange(deviceID, sensor.Index, oldValue, value)
 	}
 
 	return value, nil
 }
 
 func (a *Account) PollChannelValue(channel *OutputChannel) (int, error) {
+	return a.PollChannelValueContext(context.Background(), channel)
+}
+
+// PollChannelValueContext is like PollChannelValue but aborts all performed requests when ctx is done.
+func (a *Account) PollChannelValueContext(ctx context.Context, channel *OutputChannel) (int, error) {
 	params := make(map[string]string)
+	a.cacheMutex.RLock()
 	params["dsuid"] = channel.device.UUID
 	params["offset"] = strconv.Itoa(channel.ChannelIndex)
-	res, err := a.Connection.Request(a.Connection.BaseURL+"/json/device/getOutputValue", get, "", params)
+	a.cacheMutex.RUnlock()
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/device/getOutputValue", get, "", params)
 	if err != nil {
 		return 0, err
 	}
 	if !res.OK {
-		return 0, errors.New(res.Message)
+		return 0, res.apiError()
 	}
 
 	value, ok := res.Result["value"].(float64)
 	if !ok {
-		return 0, errors.New("unable to extract channel output value from request result")
+		return 0, res.malformed("value")
 	}
 
 	int_value := int(value)
-	if channel.Value != int_value {
-		oldValue := channel.Value
-		channel.Value = int_value
-		a.dispatchOutputChannelValueChange(channel.device.DisplayID, channel.ChannelIndex, oldValue, int_value)
+	a.cacheMutex.Lock()
+	oldValue := channel.Value
+	channel.Value = int_value
+	deviceID := channel.device.DisplayID
+	a.touchValue("channel•" + deviceID + "•" + strconv.Itoa(channel.ChannelIndex))
+	a.cacheMutex.Unlock()
+
+	if oldValue != int_value {
+		a.dispatchOutputChannelValueChange(deviceID, channel.ChannelIndex, oldValue, int_value)
 	}
 
 	return int_value, nil
@@ -758,6 +1005,22 @@ func (a *Account) CloseChannels() {
 		close(a.Events.ZoneTemperatureControlStateChanged)
 		a.Events.ZoneTemperatureControlStateChanged = nil
 	}
+	if a.Events.ZoneSensorValueChanged != nil {
+		close(a.Events.ZoneSensorValueChanged)
+		a.Events.ZoneSensorValueChanged = nil
+	}
+	if a.Events.SceneCalled != nil {
+		close(a.Events.SceneCalled)
+		a.Events.SceneCalled = nil
+	}
+	if a.Events.StateChanged != nil {
+		close(a.Events.StateChanged)
+		a.Events.StateChanged = nil
+	}
+	if a.Events.BinaryInputStateChanged != nil {
+		close(a.Events.BinaryInputStateChanged)
+		a.Events.BinaryInputStateChanged = nil
+	}
 	a.Events.chanMutex.Unlock()
 }
 
@@ -782,48 +1045,62 @@ func (a *Account) assignTempControlStatesToZones() {
 // and floors for fast access. It should be called whenever a structure,
 // circuit or groups are requested
 func (a *Account) buildMaps() {
+	// the maps are pointing into the structure, so entries of a previous structure
+	// have to be removed
+	for id := range a.Zones {
+		delete(a.Zones, id)
+	}
+	for id := range a.Groups {
+		delete(a.Groups, id)
+	}
+	for id := range a.Devices {
+		delete(a.Devices, id)
+	}
+	for id := range a.Floors {
+		delete(a.Floors, id)
+	}
 	for i := range a.Structure.Apartment.Zones {
-		zone := a.Structure.Apartment.Zones[i]
-		a.Zones[zone.ID] = &zone
-		for j := range a.Structure.Apartment.Zones[i].Groups {
-			group := a.Structure.Apartment.Zones[i].Groups[j]
-			a.Groups[group.ID] = &group
+		zone := &a.Structure.Apartment.Zones[i]
+		a.Zones[zone.ID] = zone
+		for j := range zone.Groups {
+			group := &zone.Groups[j]
+			a.Groups[group.ID] = group
 		}
-		for j := range a.Structure.Apartment.Zones[i].Devices {
-			device := a.Structure.Apartment.Zones[i].Devices[j]
-			a.Devices[device.DisplayID] = &device
+		for j := range zone.Devices {
+			device := &zone.Devices[j]
+			a.Devices[device.DisplayID] = device
 		}
-
 	}
 	for i := range a.Structure.Apartment.Floors {
-		floor := a.Structure.Apartment.Floors[i]
-		a.Floors[floor.ID] = &floor
+		floor := &a.Structure.Apartment.Floors[i]
+		a.Floors[floor.ID] = floor
 	}
 }
 
 func (a *Account) preparePolling() {
-	// create a new map to temporarily store the last updates for each value
-	if a.pollingHelpers.pollIntervalMap == nil {
+	a.pollingHelpers.mapMutex.Lock()
+	intervalsMissing := a.pollingHelpers.pollIntervalMap == nil
+	a.pollingHelpers.mapMutex.Unlock()
+	if intervalsMissing {
 		a.SetDefaultPollingIntervals()
 	}
+	// create a new map to temporarily store the last updates for each value
+	a.pollingHelpers.mapMutex.Lock()
 	a.pollingHelpers.lastPollMap = make(map[string]time.Time)
 	for key := range a.pollingHelpers.pollIntervalMap {
 		a.pollingHelpers.lastPollMap[key] = time.Now()
 	}
-	a.pollingHelpers.parallelPollCount = 0
-}
-
-// isPollingIntervalReached checks whether the value with the given ID
-// needs to be updated.
-func (a *Account) isPollingIntervalReached(id string, interval int) bool {
-	a.pollingHelpers.mapMutex.Lock()
-	t, ok := a.pollingHelpers.lastPollMap[id]
-	a.pollingHelpers.mapMutex.Unlock()
-	if !ok {
-		return true
+	// values of a previous run are scheduled again
+	for id := range a.pollingHelpers.scheduledIntervals {
+		a.pollingHelpers.scheduler.Remove(id)
 	}
-	return time.Since(t).Seconds() > float64(interval)
-
+	a.pollingHelpers.scheduledIntervals = make(map[string]int)
+	a.pollingHelpers.budget = requestBudget{}
+	a.pollingIntervalsChanged()
+	a.pollingHelpers.mapMutex.Unlock()
+	a.pollingHelpers.countMutex.Lock()
+	a.pollingHelpers.parallelPollCount = 0
+	a.pollingHelpers.countMutex.Unlock()
 }
 
 func (a *Account) setPollingTimeStamp(id string) {
@@ -836,132 +1113,400 @@ func (a *Account) setPollingTimeStamp(id string) {
 	// use mutex to prevent concurrent map writes
 
 	delete(a.pollingHelpers.activePollingMap, id)
+	a.reschedulePoll(id)
+	a.pollingHelpers.mapMutex.Unlock()
+	// another poll could be started now
+	a.wakePolling()
+}
+
+// refreshPollingTimeStamp resets the polling interval of the value with the given id
+// without polling it, e.g. when the value has been received via the event listener.
+func (a *Account) refreshPollingTimeStamp(id string) {
+	a.pollingHelpers.mapMutex.Lock()
+	if a.pollingHelpers.lastPollMap != nil {
+		a.pollingHelpers.lastPollMap[id] = time.Now()
+	}
+	a.reschedulePoll(id)
+	a.pollingHelpers.mapMutex.Unlock()
+}
+
+// expirePollingTimeStamp forces the value with the given id to be polled
+// during the next polling cycle.
+func (a *Account) expirePollingTimeStamp(id string) {
+	a.pollingHelpers.mapMutex.Lock()
+	delete(a.pollingHelpers.lastPollMap, id)
+	a.reschedulePoll(id)
 	a.pollingHelpers.mapMutex.Unlock()
 }
 
 func (a *Account) dispatchBinaryInputStateChange(deviceId string, inputId int, oldValue int, newValue int) {
 	//logger.Info(fmt.Sprintf("BinaryInput (id=%d) of device '%s' state changed  from %d to %d", inputId, deviceId, oldValue, newValue))
+	event := BinaryInputStateChangeEvent{DeviceId: deviceId, ZoneId: a.deviceZoneID(deviceId), InputId: inputId, OldValue: oldValue, NewValue: newValue}
+	a.eventBus.publish(event)
 
-	if a.pollingHelpers.pollingStopped {
+	if a.isDispatchingStopped() {
 		return
 	}
 	a.Events.chanMutex.Lock()
 	if a.Events.BinaryInputStateChanged != nil {
-
-		a.Events.BinaryInputStateChanged <- BinaryInputStateChangeEvent{DeviceId: deviceId, InputId: inputId, OldValue: oldValue, NewValue: newValue}
-
+		a.Events.BinaryInputStateChanged <- event
 	}
 	a.Events.chanMutex.Unlock()
 }
 
 func (a *Account) dispatchConsumptionValueChange(circuitID string, oldValue int, newValue int) {
-
 	//logger.Info(fmt.Sprintf("ConsumptionValueChange for ciruit %s (%d to %d))", circuitID, oldValue, newValue))
+	event := CircuitConsumptionValueChangeEvent{CircuitID: circuitID, OldValue: oldValue, NewValue: newValue}
+	a.eventBus.publish(event)
 
-	if a.pollingHelpers.pollingStopped {
+	if a.isDispatchingStopped() {
 		return
 	}
 	a.Events.chanMutex.Lock()
 	if a.Events.CircuitConsumptionValueChanged != nil {
-
-		a.Events.CircuitConsumptionValueChanged <- CircuitConsumptionValueChangeEvent{CircuitID: circuitID, OldValue: oldValue, NewValue: newValue}
-
+		a.Events.CircuitConsumptionValueChanged <- event
 	}
 	a.Events.chanMutex.Unlock()
 }
 
 func (a *Account) dispatchMeterValueChange(circuitID string, oldValue int, newValue int) {
-
 	//logger.Info(fmt.Sprintf("MeterValueChange for ciruit %s (from %d to %d))", circuitID, oldValue, newValue))
-	if a.pollingHelpers.pollingStopped {
+	event := CircuitMeterValueChangeEvent{CircuitID: circuitID, OldValue: oldValue, NewValue: newValue}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
 		return
 	}
 	a.Events.chanMutex.Lock()
 	if a.Events.CircuitMeterValueChanged != nil {
-		a.Events.CircuitMeterValueChanged <- CircuitMeterValueChangeEvent{CircuitID: circuitID, OldValue: oldValue, NewValue: newValue}
+		a.Events.CircuitMeterValueChanged <- event
 	}
 	a.Events.chanMutex.Unlock()
 }
 
 func (a *Account) dispatchOutputChannelValueChange(deviceID string, channelIndex int, oldValue int, newValue int) {
-
 	//logger.Info(fmt.Sprintf("calling OnOutputChannelValueChange for channel %s.%d (from %d to %d))", deviceID, channelIndex, oldValue, newValue))
-	if a.pollingHelpers.pollingStopped {
+	event := ChannelValueChangeEvent{DeviceID: deviceID, ZoneId: a.deviceZoneID(deviceID), ChannelIndex: channelIndex, OldValue: oldValue, NewValue: newValue}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
 		return
 	}
 	a.Events.chanMutex.Lock()
 	if a.Events.ChannelValueChanged != nil {
-		a.Events.ChannelValueChanged <- ChannelValueChangeEvent{DeviceID: deviceID, ChannelIndex: channelIndex, OldValue: oldValue, NewValue: newValue}
+		a.Events.ChannelValueChanged <- event
 	}
 	a.Events.chanMutex.Unlock()
 }
 
 func (a *Account) dispatchSensorValueChange(deviceID string, sensorIndex int, oldValue float64, newValue float64) {
 	//logger.Info(fmt.Sprintf("calling OnSensorValueChange for sensor %s.%d (from %f to %f))", deviceID, sensorIndex, oldValue, newValue))
-	if a.pollingHelpers.pollingStopped {
+	event := SensorValueChangeEvent{DeviceId: deviceID, ZoneId: a.deviceZoneID(deviceID), SensorIndex: sensorIndex, OldValue: oldValue, NewValue: newValue}
+	if sensor, err := a.GetSensor(deviceID, sensorIndex); err == nil {
+		event.SensorType = sensor.Type
+	}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
 		return
 	}
 	a.Events.chanMutex.Lock()
 	if a.Events.SensorValueChanged != nil {
-		a.Events.SensorValueChanged <- SensorValueChangeEvent{DeviceId: deviceID, SensorIndex: sensorIndex, OldValue: oldValue, NewValue: newValue}
+		a.Events.SensorValueChanged <- event
 	}
 	a.Events.chanMutex.Unlock()
 }
 
 func (a *Account) dispatchOnValueChange(deviceID string, oldValue bool, newValue bool) {
-
 	//logger.Info(fmt.Sprintf("calling OnValueChange for sensor %s.On (from %t to %t))", deviceID, oldValue, newValue))
-	if a.pollingHelpers.pollingStopped {
+	event := OnStateValueChangeEvent{DeviceId: deviceID, ZoneId: a.deviceZoneID(deviceID), OldValue: oldValue, NewValue: newValue}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
 		return
 	}
 	a.Events.chanMutex.Lock()
-	if a.Events.SensorValueChanged != nil {
-		a.Events.OnStateValueChanged <- OnStateValueChangeEvent{DeviceId: deviceID, OldValue: oldValue, NewValue: newValue}
+	if a.Events.OnStateValueChanged != nil {
+		a.Events.OnStateValueChanged <- event
 	}
 	a.Events.chanMutex.Unlock()
 }
 
 func (a *Account) dispatchTemperatureControlStateChanged(zoneId int) {
 	//logger.Info(fmt.Sprintf("calling OnTemperatureControlStateChange for zone %d", zoneId))
-	if a.pollingHelpers.pollingStopped {
+	event := ZoneTemperatureControlChangeEvent{ZoneId: zoneId}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
 		return
 	}
+	a.Events.chanMutex.Lock()
 	if a.Events.ZoneTemperatureControlStateChanged != nil {
-		a.Events.ZoneTemperatureControlStateChanged <- ZoneTemperatureControlChangeEvent{ZoneId: zoneId}
+		a.Events.ZoneTemperatureControlStateChanged <- event
 	}
+	a.Events.chanMutex.Unlock()
 }
 
-func (a *Account) updateBinaryInputState(dsuid string, inputId int, state int) error {
+func (a *Account) dispatchZoneSensorValueChange(zoneId int, groupId int, sensorType SensorType, newValue float64) {
+	event := ZoneSensorValueChangeEvent{ZoneId: zoneId, GroupId: groupId, SensorType: sensorType, NewValue: newValue}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
+		return
+	}
+	a.Events.chanMutex.Lock()
+	if a.Events.ZoneSensorValueChanged != nil {
+		a.Events.ZoneSensorValueChanged <- event
+	}
+	a.Events.chanMutex.Unlock()
+}
 
-	device, err := a.GetDeviceByUuid(dsuid)
+func (a *Account) dispatchSceneCalled(zoneId int, groupId int, deviceId string, sceneNumber int, undo bool) {
+	event := SceneCalledEvent{ZoneId: zoneId, GroupId: groupId, DeviceId: deviceId, SceneNumber: sceneNumber, Undo: undo}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
+		return
+	}
+	a.Events.chanMutex.Lock()
+	if a.Events.SceneCalled != nil {
+		a.Events.SceneCalled <- event
+	}
+	a.Events.chanMutex.Unlock()
+}
+
+func (a *Account) dispatchStateChange(name string, state string, oldValue int, newValue int) {
+	event := StateChangeEvent{Name: name, State: state, OldValue: oldValue, NewValue: newValue}
+	a.eventBus.publish(event)
+
+	if a.isDispatchingStopped() {
+		return
+	}
+	a.Events.chanMutex.Lock()
+	if a.Events.StateChanged != nil {
+		a.Events.StateChanged <- event
+	}
+	a.Events.chanMutex.Unlock()
+}
+
+// deviceZoneID returns the id of the zone the device with the given display ID is part of or -1
+// when the device is unknown
+func (a *Account) deviceZoneID(deviceID string) int {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	device, ok := a.Devices[deviceID]
+	if !ok {
+		return -1
+	}
+	return device.ZoneID
+}
+
+// isDispatchingStopped returns true when neither polling nor the event listener
+// is running. In that case no events will be sent to the event channels.
+func (a *Account) isDispatchingStopped() bool {
+	return a.isPollingStopped() && !a.IsEventListenerRunning()
+}
+
+// isPollingStopped returns true when polling has not been started or has been stopped.
+func (a *Account) isPollingStopped() bool {
+	a.Events.chanMutex.Lock()
+	defer a.Events.chanMutex.Unlock()
+	return a.pollingHelpers.pollingStopped
+}
+
+func (a *Account) updateBinaryInputState(dsuid string, inputId int, state int) error {
+	a.cacheMutex.Lock()
+	device, err := a.getDeviceByUuid(dsuid)
 	if err != nil {
+		a.cacheMutex.Unlock()
 		return err
 	}
 
 	input, err := device.GetBinaryInputByInputID(inputId)
-
 	if err != nil {
+		a.cacheMutex.Unlock()
 		return err
 	}
 
-	if input.State != state {
-		oldState := input.State
-		input.State = state
-		a.dispatchBinaryInputStateChange(device.DisplayID, inputId, oldState, state)
+	oldState := input.State
+	input.State = state
+	deviceID := device.DisplayID
+	a.touchValue("binaryInput•" + deviceID + "•" + strconv.Itoa(inputId))
+	a.cacheMutex.Unlock()
+
+	if oldState != state {
+		a.dispatchBinaryInputStateChange(deviceID, inputId, oldState, state)
 	}
 	return nil
 }
 
-func (a *Account) performPolling(id string) {
+// touchStructureValues records the current time as update time of the structure and of all sensor values
+// and binary input states. Unless fresh is set, only values without update time are touched, which are the
+// ones of new devices, as the values of known devices have been carried over and keep their update times.
+// The cache lock has to be held by the caller.
+func (a *Account) touchStructureValues(fresh bool) {
+	touch := a.touchNewValue
+	if fresh {
+		touch = a.touchValue
+	}
+	a.touchValue("structure")
+	for id, device := range a.Devices {
+		for _, sensor := range device.Sensors {
+			touch("sensor•" + id + "•" + strconv.Itoa(sensor.Index))
+		}
+		for _, input := range device.BinaryInputs {
+			touch("binaryInput•" + id + "•" + strconv.Itoa(input.InputID))
+		}
+	}
+}
+
+// touchNewValue records the current time as update time of the value with the given id, unless it has an
+// update time already. The cache lock has to be held by the caller.
+func (a *Account) touchNewValue(id string) {
+	if _, ok := a.valueTimes[id]; !ok {
+		a.touchValue(id)
+	}
+}
+
+// carryOverValues copies the known output channel values, sensor values and binary input states of cached
+// devices into the given structure. It is used when a polled structure replaces the cached one. Sensor values
+// and binary input states are kept up to date by polling and events and would otherwise be replaced silently.
+// The cache lock has to be held by the caller.
+func (a *Account) carryOverValues(s *Structure) {
+	a.carryOverChannelValues(s)
+	for i := range s.Apartment.Zones {
+		for j := range s.Apartment.Zones[i].Devices {
+			device := &s.Apartment.Zones[i].Devices[j]
+			old, ok := a.Devices[device.DisplayID]
+			if !ok {
+				continue
+			}
+			// the indices of the sensors are assigned when the structure is cached
+			for n, sensor := range device.Sensors {
+				for _, oldSensor := range old.Sensors {
+					if oldSensor.Index == n {
+						sensor.Value = oldSensor.Value
+						sensor.Valid = oldSensor.Valid
+					}
+				}
+			}
+			for _, input := range device.BinaryInputs {
+				for _, oldInput := range old.BinaryInputs {
+					if oldInput.InputID == input.InputID {
+						input.State = oldInput.State
+					}
+				}
+			}
+		}
+	}
+}
+
+// carryOverChannelValues copies the known output channel values of cached devices into the given structure.
+// Output channel values are not part of the structure request, so they are kept whenever the structure is
+// replaced. The cache lock has to be held by the caller.
+func (a *Account) carryOverChannelValues(s *Structure) {
+	for i := range s.Apartment.Zones {
+		for j := range s.Apartment.Zones[i].Devices {
+			device := &s.Apartment.Zones[i].Devices[j]
+			old, ok := a.Devices[device.DisplayID]
+			if !ok {
+				continue
+			}
+			for _, channel := range device.OutputChannels {
+				for _, oldChannel := range old.OutputChannels {
+					if oldChannel.ChannelIndex == channel.ChannelIndex {
+						channel.Value = oldChannel.Value
+					}
+				}
+			}
+		}
+	}
+}
+
+// sensorValueChange is a cached sensor value that differs from the one delivered by the dSS
+type sensorValueChange struct {
+	deviceID    string
+	sensorIndex int
+	oldValue    float64
+	newValue    float64
+}
+
+// binaryInputStateChange is a cached binary input state that differs from the one delivered by the dSS
+type binaryInputStateChange struct {
+	deviceID string
+	inputID  int
+	oldValue int
+	newValue int
+}
+
+// structureValueChanges are the changes of sensor values and binary input states found by valueChanges
+type structureValueChanges struct {
+	sensors []sensorValueChange
+	inputs  []binaryInputStateChange
+}
+
+// valueChanges returns the sensor values and binary input states of cached devices that differ from the
+// ones of the given structure. The cache lock has to be held by the caller.
+func (a *Account) valueChanges(s *Structure) structureValueChanges {
+	changes := structureValueChanges{}
+	for i := range s.Apartment.Zones {
+		for _, device := range s.Apartment.Zones[i].Devices {
+			old, ok := a.Devices[device.DisplayID]
+			if !ok {
+				continue
+			}
+			for n, sensor := range device.Sensors {
+				for _, oldSensor := range old.Sensors {
+					if oldSensor.Index == n && oldSensor.Value != sensor.Value {
+						changes.sensors = append(changes.sensors, sensorValueChange{device.DisplayID, n, oldSensor.Value, sensor.Value})
+					}
+				}
+			}
+			for _, input := range device.BinaryInputs {
+				for _, oldInput := range old.BinaryInputs {
+					if oldInput.InputID == input.InputID && oldInput.State != input.State {
+						changes.inputs = append(changes.inputs, binaryInputStateChange{device.DisplayID, input.InputID, oldInput.State, input.State})
+					}
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// dispatchValueChanges dispatches the changes returned by valueChanges. It must not be called while
+// holding the cache lock.
+func (a *Account) dispatchValueChanges(changes structureValueChanges) {
+	for _, c := range changes.sensors {
+		a.dispatchSensorValueChange(c.deviceID, c.sensorIndex, c.oldValue, c.newValue)
+	}
+	for _, c := range changes.inputs {
+		a.dispatchBinaryInputStateChange(c.deviceID, c.inputID, c.oldValue, c.newValue)
+	}
+}
+
+// touchValue records the current time as update time of the value with the given id. The cache lock
+// has to be held by the caller.
+func (a *Account) touchValue(id string) {
+	a.valueTimes[id] = time.Now()
+}
+
+// countPoll updates the polling statistics of the given category
+func (a *Account) countPoll(category string, err error) {
+	a.pollingHelpers.countMutex.Lock()
+	a.pollingHelpers.pollCounts[category]++
+	if err != nil {
+		a.pollingHelpers.pollErrors[category]++
+	}
+	a.pollingHelpers.countMutex.Unlock()
+}
+
+func (a *Account) performPolling(ctx context.Context, id string) {
 
 	// independed from update result, set the current timestamp to reset the interval
 	defer a.setPollingTimeStamp(id)
-	// remember that value with this id will be polled now
-	a.pollingHelpers.mapMutex.Lock()
-	a.pollingHelpers.activePollingMap[id] = time.Now()
-	a.pollingHelpers.mapMutex.Unlock()
 
-	if a.pollingHelpers.pollingStopped {
+	if a.isPollingStopped() {
 		return
 	}
 
@@ -976,18 +1521,20 @@ func (a *Account) performPolling(id string) {
 			logger.Info(fmt.Sprintf("WARNING: %s is not a valid curcuitID ", id))
 			return
 		}
+		a.cacheMutex.RLock()
 		circuit, ok := a.Circuits[s[1]]
+		hasMetering := ok && circuit.HasMetering
+		a.cacheMutex.RUnlock()
 
-		if !ok {
+		if !hasMetering {
 			return
 		}
 
-		if !circuit.HasMetering {
-			return
+		_, err := a.PollCircuitConsumptionValueContext(ctx, circuit)
+		if err == nil {
+			_, err = a.PollCircuitMeterValueContext(ctx, circuit)
 		}
-
-		a.PollCircuitConsumptionValue(circuit)
-		a.PollCircuitMeterValue(circuit)
+		a.countPoll(s[0], err)
 
 	case "sensor":
 		if len(s) != 3 {
@@ -1006,11 +1553,15 @@ func (a *Account) performPolling(id string) {
 		if err != nil {
 			return
 		}
-		if !sensor.device.IsPresent {
+		a.cacheMutex.RLock()
+		present = sensor.device.IsPresent
+		a.cacheMutex.RUnlock()
+		if !present {
 			return
 		}
 
-		a.PollSensorValue(sensor)
+		_, err = a.PollSensorValueContext(ctx, sensor)
+		a.countPoll(s[0], err)
 
 	case "channel":
 		if len(s) != 3 {
@@ -1030,18 +1581,24 @@ func (a *Account) performPolling(id string) {
 			return
 		}
 
-		if !channel.device.IsPresent {
+		a.cacheMutex.RLock()
+		present = channel.device.IsPresent
+		a.cacheMutex.RUnlock()
+		if !present {
 			return
 		}
-		a.PollChannelValue(channel)
+		_, err = a.PollChannelValueContext(ctx, channel)
+		a.countPoll(s[0], err)
 
 		return
 	case "structure":
-		a.PollStructureValues()
+		a.countPoll(s[0], a.PollStructureValuesContext(ctx))
 	case "temperatureControlState":
-		a.PollTemperatureControlValues()
+		a.countPoll(s[0], a.PollTemperatureControlValuesContext(ctx))
 	case "binaryInputs":
-		a.PollBinaryInputs()
+		a.countPoll(s[0], a.PollBinaryInputsContext(ctx))
+	case "states":
+		a.countPoll(s[0], a.PollApartmentStatesContext(ctx))
 	default:
 		// place error logging for invalid id over here
 
@@ -0,0 +1,289 @@
+package digitalstrom_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+	"github.com/go-logr/logr"
+)
+
+func TestMain(m *testing.M) {
+	digitalstrom.SetLogger(logr.Discard())
+	os.Exit(m.Run())
+}
+
+// newTestAccount starts a dsstest server with the default apartment and returns an initialized account
+// using it. The server is closed when the test has finished.
+func newTestAccount(t *testing.T) (*dsstest.Server, *digitalstrom.Account) {
+	t.Helper()
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	t.Cleanup(srv.Close)
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetApplicationToken(dsstest.ApplicationToken)
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return srv, a
+}
+
+func TestInit(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	if len(a.Devices) != 3 {
+		t.Errorf("expected 3 devices, got %d", len(a.Devices))
+	}
+	if len(a.Zones) != 2 {
+		t.Errorf("expected 2 zones, got %d", len(a.Zones))
+	}
+	if _, ok := a.Circuits["0000c001"]; !ok {
+		t.Errorf("circuit 0000c001 not cached")
+	}
+	if _, ok := a.TemperatureControl[2]; !ok {
+		t.Errorf("temperature control state of zone 2 not cached")
+	}
+	sensor, err := a.GetSensor("00000003", 0)
+	if err != nil {
+		t.Fatalf("GetSensor failed: %v", err)
+	}
+	if sensor.Value != 21.5 {
+		t.Errorf("expected sensor value 21.5, got %v", sensor.Value)
+	}
+}
+
+func TestInitWithUnknownApplicationToken(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetApplicationToken("unknown")
+
+	err := a.Init()
+	if !errors.Is(err, digitalstrom.ErrAuthentication) {
+		t.Fatalf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestRegisterApplication(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	srv.SetCredentials("user", "secret")
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+
+	_, err := a.RegisterApplication("test", "user", "wrong")
+	if !errors.Is(err, digitalstrom.ErrAuthentication) {
+		t.Fatalf("expected an authentication error for wrong credentials, got %v", err)
+	}
+
+	token, err := a.RegisterApplication("test", "user", "secret")
+	if err != nil {
+		t.Fatalf("RegisterApplication failed: %v", err)
+	}
+	a.SetApplicationToken(token)
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init with the registered token failed: %v", err)
+	}
+}
+
+func TestRegisterApplicationErrorsHideCredentials(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	const password = "s3cr3t-password"
+	srv.SetCredentials("user", password)
+	// the connection is closed without response when the credentials are sent
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json/system/login" {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	a := digitalstrom.NewAccount()
+	a.SetURL(proxy.URL)
+	a.Connection.RetryPolicy = digitalstrom.RetryPolicy{MaxAttempts: 1}
+
+	_, err := a.RegisterApplication("test", "user", password)
+	if !errors.Is(err, digitalstrom.ErrTransport) {
+		t.Fatalf("expected a transport error, got %v", err)
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if strings.Contains(e.Error(), password) {
+			t.Errorf("error %T contains the password: %v", e, e)
+		}
+		if urlErr, ok := e.(*url.Error); ok && strings.Contains(urlErr.URL, password) {
+			t.Errorf("url of the error contains the password: %s", urlErr.URL)
+		}
+	}
+}
+
+func TestRequestAfterExpiredSession(t *testing.T) {
+	srv, a := newTestAccount(t)
+	srv.ExpireSession()
+
+	device, err := a.GetDevice("00000001")
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+	if err := a.TurnOn(device, true); err != nil {
+		t.Fatalf("TurnOn failed: %v", err)
+	}
+	if n := len(srv.CallsTo("/json/system/loginApplication")); n != 2 {
+		t.Errorf("expected a second application login, got %d logins", n)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		if !apartment.GetDevice("00000001").On {
+			t.Errorf("device has not been turned on")
+		}
+	})
+}
+
+func TestConcurrentRequestsAfterExpiredSession(t *testing.T) {
+	srv, a := newTestAccount(t)
+	srv.ExpireSession()
+	sensor, err := a.GetSensor("00000003", 0)
+	if err != nil {
+		t.Fatalf("GetSensor failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.PollSensorValue(sensor); err != nil {
+				t.Errorf("PollSensorValue failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(srv.CallsTo("/json/system/loginApplication")); n != 2 {
+		t.Errorf("expected a single application login for all requests, got %d logins", n)
+	}
+	if a.Connection.GetSessionToken() == "" {
+		t.Errorf("session token has not been set")
+	}
+}
+
+func TestConcurrentApplicationLoginWithTokenStore(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	t.Setenv("DSS_TEST_APPLICATION_TOKEN", dsstest.ApplicationToken)
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetTokenStore(digitalstrom.EnvTokenStore{Name: "DSS_TEST_APPLICATION_TOKEN"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.ApplicationLogin(); err != nil {
+				t.Errorf("ApplicationLogin failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if a.Connection.ApplicationToken != dsstest.ApplicationToken {
+		t.Errorf("application token has not been loaded from the store")
+	}
+}
+
+func TestDeprecatedSessionTokenField(t *testing.T) {
+	_, a := newTestAccount(t)
+	if a.Connection.SessionToken != a.Connection.GetSessionToken() {
+		t.Errorf("session token field has not been set by the login")
+	}
+	a.Connection.SessionToken = "manual"
+	if a.Connection.GetSessionToken() != "manual" {
+		t.Errorf("session token written to the field is not used")
+	}
+}
+
+func TestPollSensorValue(t *testing.T) {
+	srv, a := newTestAccount(t)
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000003").Sensors[0].Value = 23
+	})
+	sensor, err := a.GetSensor("00000003", 0)
+	if err != nil {
+		t.Fatalf("GetSensor failed: %v", err)
+	}
+
+	value, err := a.PollSensorValue(sensor)
+	if err != nil {
+		t.Fatalf("PollSensorValue failed: %v", err)
+	}
+	if value != 23 {
+		t.Errorf("expected value 23, got %v", value)
+	}
+	if got, _ := a.Snapshot().GetSensor("00000003", 0); got.Value != 23 {
+		t.Errorf("expected cached value 23, got %v", got.Value)
+	}
+}
+
+func TestAPIFailure(t *testing.T) {
+	srv, a := newTestAccount(t)
+	srv.SetFailure("/json/device/turnOn", "device not reachable")
+	device, _ := a.GetDevice("00000001")
+
+	err := a.TurnOn(device, true)
+	var apiErr *digitalstrom.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if apiErr.Message != "device not reachable" || apiErr.Endpoint != "/json/device/turnOn" {
+		t.Errorf("unexpected APIError %+v", apiErr)
+	}
+}
+
+// waitFor fails the test when cond does not become true within the given time
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %v", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStructureWithoutChannelValues(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000001").OutputChannels[0].Value = 80
+	})
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetApplicationToken(dsstest.ApplicationToken)
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	// like the dSS, the fake does not deliver output channel values with the structure
+	channel, err := a.GetOutputChannel("00000001", 0)
+	if err != nil {
+		t.Fatalf("GetOutputChannel failed: %v", err)
+	}
+	if channel.Value != 0 {
+		t.Errorf("output channel value has been delivered with the structure: %d", channel.Value)
+	}
+	if value, err := a.PollChannelValue(channel); err != nil || value != 80 {
+		t.Errorf("expected polled value 80, got %d, %v", value, err)
+	}
+}
@@ -0,0 +1,188 @@
+package digitalstrom
+
+import (
+	"context"
+	"errors"
+)
+
+// Apartment States (AS). Names of the apartment-wide states the dSS maintains.
+const (
+	ASpresence    = "presence"
+	AShibernation = "hibernation"
+	ASalarm1      = "alarm"
+	ASalarm2      = "alarm2"
+	ASalarm3      = "alarm3"
+	ASalarm4      = "alarm4"
+	ASpanic       = "panic"
+	ASfire        = "fire"
+	ASrain        = "rain"
+	ASwind        = "wind"
+	AShail        = "hail"
+	ASfrost       = "frost"
+	ASholiday     = "holiday" // vacation
+)
+
+// State Values (SV). Values of the State field of an ApartmentState.
+const (
+	SVpresent  = "present"
+	SVabsent   = "absent"
+	SVawake    = "awake"
+	SVsleeping = "sleeping"
+	SVactive   = "active"
+	SVinactive = "inactive"
+)
+
+// ApartmentState is the current state of an apartment-wide state like presence or an alarm
+type ApartmentState struct {
+	Name  string
+	State string
+	Value int
+}
+
+// stateScenes define how a state is changed by apartment scenes. States without an off scene are
+// reset by undoing the on scene.
+type stateScenes struct {
+	on          SceneNumber
+	off         SceneNumber
+	hasOff      bool
+	activeState string
+	activeValue int
+	offState    string
+	offValue    int
+}
+
+var apartmentStateScenes = map[string]stateScenes{
+	ASpresence:    {SNpresent, SNabsent, true, SVpresent, 1, SVabsent, 2},
+	AShibernation: {SNsleeping, SNwakeup, true, SVsleeping, 2, SVawake, 1},
+	ASalarm1:      {SNalarm1, 0, false, SVactive, 1, SVinactive, 2},
+	ASalarm2:      {SNalarm2, 0, false, SVactive, 1, SVinactive, 2},
+	ASalarm3:      {SNalarm3, 0, false, SVactive, 1, SVinactive, 2},
+	ASalarm4:      {SNalarm4, 0, false, SVactive, 1, SVinactive, 2},
+	ASpanic:       {SNpanic, 0, false, SVactive, 1, SVinactive, 2},
+	ASfire:        {SNfire, 0, false, SVactive, 1, SVinactive, 2},
+	ASwind:        {SNwind, SNnoWind, true, SVactive, 1, SVinactive, 2},
+	ASrain:        {SNrain, SNnoRain, true, SVactive, 1, SVinactive, 2},
+	AShail:        {SNhail, SNnoHail, true, SVactive, 1, SVinactive, 2},
+}
+
+// RequestApartmentStates requests all apartment states from the property tree of the dSS (/usr/states).
+func (a *Account) RequestApartmentStates() ([]ApartmentState, error) {
+	return a.RequestApartmentStatesContext(context.Background())
+}
+
+// RequestApartmentStatesContext is like RequestApartmentStates but aborts all performed requests when ctx is done.
+func (a *Account) RequestApartmentStatesContext(ctx context.Context) ([]ApartmentState, error) {
+	result, err := a.RequestPropertyQuery2Context(ctx, "/usr/states/*(state,value)")
+	if err != nil {
+		return nil, err
+	}
+	states := []ApartmentState{}
+	for name, nodes := range result.Children {
+		if len(nodes) == 0 {
+			continue
+		}
+		state := ApartmentState{Name: name}
+		state.State, _ = nodes[0].Values["state"].(string)
+		state.Value, _ = jsonInt(nodes[0].Values, "value")
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// PollApartmentStates requests all apartment states and updates the cache. A StateChangeEvent is
+// dispatched for each changed state.
+func (a *Account) PollApartmentStates() error {
+	return a.PollApartmentStatesContext(context.Background())
+}
+
+// PollApartmentStatesContext is like PollApartmentStates but aborts all performed requests when ctx is done.
+func (a *Account) PollApartmentStatesContext(ctx context.Context) error {
+	states, err := a.RequestApartmentStatesContext(ctx)
+	if err != nil {
+		return err
+	}
+	changed := []StateChangeEvent{}
+	a.cacheMutex.Lock()
+	for i := range states {
+		cached, ok := a.States[states[i].Name]
+		if !ok {
+			a.States[states[i].Name] = &states[i]
+			continue
+		}
+		if cached.State != states[i].State || cached.Value != states[i].Value {
+			changed = append(changed, StateChangeEvent{Name: cached.Name, State: states[i].State, OldValue: cached.Value, NewValue: states[i].Value})
+			*cached = states[i]
+		}
+	}
+	a.touchValue("states")
+	a.cacheMutex.Unlock()
+
+	for _, event := range changed {
+		a.dispatchStateChange(event.Name, event.State, event.OldValue, event.NewValue)
+	}
+	return nil
+}
+
+// GetApartmentState returns the cached state with the given name, e.g. ASpresence.
+func (a *Account) GetApartmentState(name string) (ApartmentState, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	state, ok := a.States[name]
+	if !ok {
+		return ApartmentState{}, &NotFoundError{Kind: "apartmentState", ID: name}
+	}
+	return *state, nil
+}
+
+// SetApartmentState changes the state with the given name by calling the corresponding apartment
+// scene. For ASpresence, active means present, for AShibernation, active means sleeping. Alarms, panic
+// and fire are reset by undoing their scene. ASfrost and ASholiday could not be set by scenes.
+func (a *Account) SetApartmentState(name string, active bool) error {
+	return a.SetApartmentStateContext(context.Background(), name, active)
+}
+
+// SetApartmentStateContext is like SetApartmentState but aborts all performed requests when ctx is done.
+func (a *Account) SetApartmentStateContext(ctx context.Context, name string, active bool) error {
+	scenes, ok := apartmentStateScenes[name]
+	if !ok {
+		return errors.New("apartment state '" + name + "' could not be set by scenes")
+	}
+	var err error
+	switch {
+	case active:
+		err = a.CallApartmentSceneContext(ctx, ATbroadcast, scenes.on, false)
+	case scenes.hasOff:
+		err = a.CallApartmentSceneContext(ctx, ATbroadcast, scenes.off, false)
+	default:
+		err = a.UndoApartmentSceneContext(ctx, ATbroadcast, scenes.on)
+	}
+	if err != nil {
+		return err
+	}
+	state, value := scenes.offState, scenes.offValue
+	if active {
+		state, value = scenes.activeState, scenes.activeValue
+	}
+	a.updateApartmentState(name, state, value)
+	return nil
+}
+
+// updateApartmentState updates a cached state and dispatches a StateChangeEvent when it has been
+// changed. States that are not cached are ignored.
+func (a *Account) updateApartmentState(name string, state string, value int) {
+	a.cacheMutex.Lock()
+	cached, ok := a.States[name]
+	changed := ok && (cached.State != state || cached.Value != value)
+	oldValue := 0
+	if changed {
+		oldValue = cached.Value
+		cached.State = state
+		cached.Value = value
+		a.touchValue("states")
+	}
+	a.cacheMutex.Unlock()
+
+	if changed {
+		a.dispatchStateChange(name, state, oldValue, value)
+	}
+}
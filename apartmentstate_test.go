@@ -0,0 +1,125 @@
+package digitalstrom_test
+
+import (
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// subscribeStates returns a channel receiving the apartment state change events of the account
+func subscribeStates(t *testing.T, a *digitalstrom.Account) chan digitalstrom.Event {
+	t.Helper()
+	events := make(chan digitalstrom.Event, 10)
+	cancel := a.SubscribeChannel(digitalstrom.SubscriptionSetup{
+		Name:   "apartment states",
+		Filter: digitalstrom.EventFilter{Kinds: []digitalstrom.EventKind{digitalstrom.EKstateChanged}},
+	}, events)
+	t.Cleanup(cancel)
+	return events
+}
+
+func TestApartmentStatesAreCached(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	state, err := a.GetApartmentState(digitalstrom.ASpresence)
+	if err != nil {
+		t.Fatalf("GetApartmentState failed: %v", err)
+	}
+	if state.State != digitalstrom.SVpresent || state.Value != 1 {
+		t.Errorf("unexpected state %+v", state)
+	}
+	if state, _ := a.GetApartmentState(digitalstrom.ASalarm1); state.State != digitalstrom.SVinactive || state.Value != 2 {
+		t.Errorf("unexpected state %+v", state)
+	}
+	if _, err := a.GetApartmentState(digitalstrom.ASfrost); err == nil {
+		t.Errorf("expected an error for a state that is not known to the dSS")
+	}
+}
+
+func TestSetApartmentState(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStates(t, a)
+
+	if err := a.SetApartmentState(digitalstrom.ASpresence, false); err != nil {
+		t.Fatalf("SetApartmentState failed: %v", err)
+	}
+	params := srv.CallsTo("/json/apartment/callScene")[0].Params
+	if params.Get("groupID") != "0" || params.Get("sceneNumber") != "72" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	if state, _ := a.GetApartmentState(digitalstrom.ASpresence); state.State != digitalstrom.SVabsent || state.Value != 2 {
+		t.Errorf("cached state has not been updated: %+v", state)
+	}
+	event, ok := receiveEvent(t, events).(digitalstrom.StateChangeEvent)
+	if !ok || event.Name != digitalstrom.ASpresence || event.OldValue != 1 || event.NewValue != 2 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// alarms have no off scene and are reset by undoing their scene
+	if err := a.SetApartmentState(digitalstrom.ASalarm1, true); err != nil {
+		t.Fatalf("SetApartmentState failed: %v", err)
+	}
+	if err := a.SetApartmentState(digitalstrom.ASalarm1, false); err != nil {
+		t.Fatalf("SetApartmentState failed: %v", err)
+	}
+	undo := srv.CallsTo("/json/apartment/undoScene")
+	if len(undo) != 1 || undo[0].Params.Get("sceneNumber") != "74" {
+		t.Errorf("alarm has not been reset by undoing its scene: %v", undo)
+	}
+	if state, _ := a.GetApartmentState(digitalstrom.ASalarm1); state.State != digitalstrom.SVinactive {
+		t.Errorf("cached state has not been reset: %+v", state)
+	}
+
+	if err := a.SetApartmentState(digitalstrom.ASholiday, true); err == nil {
+		t.Errorf("expected an error for a state that could not be set by scenes")
+	}
+}
+
+func TestPollApartmentStates(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStates(t, a)
+
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.Properties["/usr/states/hibernation/state"] = digitalstrom.SVsleeping
+		apartment.Properties["/usr/states/hibernation/value"] = 2
+	})
+	if err := a.PollApartmentStates(); err != nil {
+		t.Fatalf("PollApartmentStates failed: %v", err)
+	}
+	if state, _ := a.GetApartmentState(digitalstrom.AShibernation); state.State != digitalstrom.SVsleeping || state.Value != 2 {
+		t.Errorf("cached state has not been updated: %+v", state)
+	}
+	event, ok := receiveEvent(t, events).(digitalstrom.StateChangeEvent)
+	if !ok || event.Name != digitalstrom.AShibernation || event.State != digitalstrom.SVsleeping || event.OldValue != 1 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event for an unchanged state %+v", event)
+	default:
+	}
+}
+
+func TestStateChangeEventUpdatesCache(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStates(t, a)
+	if err := a.StartEventListener(); err != nil {
+		t.Fatalf("StartEventListener failed: %v", err)
+	}
+	defer a.StopEventListener()
+
+	srv.PushEvent(dsstest.Event{Name: digitalstrom.EventStateChange, Properties: map[string]interface{}{
+		"statename": digitalstrom.ASpresence,
+		"state":     digitalstrom.SVabsent,
+		"oldvalue":  "1",
+		"value":     "2",
+	}})
+	event, ok := receiveEvent(t, events).(digitalstrom.StateChangeEvent)
+	if !ok || event.Name != digitalstrom.ASpresence || event.NewValue != 2 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if state, _ := a.GetApartmentState(digitalstrom.ASpresence); state.State != digitalstrom.SVabsent || state.Value != 2 {
+		t.Errorf("cached state has not been updated: %+v", state)
+	}
+}
@@ -1,12 +1,16 @@
 package digitalstrom
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type requestMethod string
@@ -16,29 +20,34 @@ const (
 	defautBaseURL = "https://192.168.178.178:8080" // TODO: should be exchanged by default dS web API address
 )
 
-// RequestError structure to give a proper error that could occur during
-// http requests
-type RequestError struct {
-	Err        string
-	StatusCode int
-	Request    http.Request
-}
-
-// Error function of structure RequestError
-func (e *RequestError) Error() string {
-	return fmt.Sprintf("connection error - status code %d", e.StatusCode)
-}
-
 // RequestResult represents all successful http request results received
 // from the digitalStrom server. It always contains the OK value as idication
 // whether the requested data could be delivered or not. When OK is true, RequestResult
 // contains Result, the requested data as json (map[string]interface{}). When
 // OK is false, RequestResult contains Message, the problem desription the digitalStrom had
-// for not delivering the requested data.
+// for not delivering the requested data. Some requests (e.g. property/getChildren) are delivering
+// a list instead of an object, the list is contained in ResultList then.
 type RequestResult struct {
-	OK      bool                   `json:"ok"`
-	Message string                 `json:"message"`
-	Result  map[string]interface{} `json:"result"`
+	OK         bool                   `json:"ok"`
+	Message    string                 `json:"message"`
+	Result     map[string]interface{} `json:"-"`
+	ResultList []interface{}          `json:"-"`
+	endpoint   string
+}
+
+// apiError returns the APIError for a result with OK false
+func (r *RequestResult) apiError() error {
+	return &APIError{Endpoint: r.endpoint, Message: r.Message}
+}
+
+// malformed returns the MalformedResponseError for a missing or invalid field of the result
+func (r *RequestResult) malformed(field string) error {
+	return &MalformedResponseError{Endpoint: r.endpoint, Field: field}
+}
+
+// invalid returns the MalformedResponseError for a field of the result with an invalid value
+func (r *RequestResult) invalid(field string, err error) error {
+	return &MalformedResponseError{Endpoint: r.endpoint, Field: field, Err: err}
 }
 
 const (
@@ -50,10 +59,37 @@ const (
 // Connection Holds access credentials and URL information for a specific account.
 // Contains routines for GET, PUSH, PUT and DELETE
 type Connection struct {
+	// SessionToken is the token of the current session.
+	//
+	// Deprecated: the field is kept for compatibility, reading or writing it directly is not safe while
+	// requests are performed concurrently. Use GetSessionToken and SetSessionToken instead.
 	SessionToken     string
 	ApplicationToken string
 	BaseURL          string
 	HTTPClient       *http.Client
+	RetryPolicy      RetryPolicy
+	TokenStore       TokenStore // optional, loads the application token when it is not set
+	stats            *requestStats
+	breaker          atomic.Pointer[circuitBreaker] // replaced by SetCircuitBreaker while requests might be performed
+	login            *loginCall                     // application login performed after an expired session, if any
+	sessionMutex     sync.Mutex                     // guards SessionToken, ApplicationToken and login
+}
+
+// loginCall is an application login shared by all requests that failed because of the same expired session
+type loginCall struct {
+	done chan struct{}
+	err  error
+}
+
+// requestStats counts the requests performed by a connection
+type requestStats struct {
+	requests      uint64
+	requestErrors uint64
+	apiErrors     uint64
+	retries       uint64
+	logins        uint64
+	requestTime   time.Duration
+	mutex         sync.Mutex
 }
 
 // Get Performs a GET request and returns the response body as string
@@ -61,6 +97,11 @@ func (c *Connection) Get(url string) (*RequestResult, error) {
 	return c.Request(url, get, "", nil)
 }
 
+// GetContext Performs a GET request that will be aborted when the given context is done
+func (c *Connection) GetContext(ctx context.Context, url string) (*RequestResult, error) {
+	return c.RequestContext(ctx, url, get, "", nil)
+}
+
 // Post Performs a Post Request with the given content and returns the response body as string
 func (c *Connection) Post(url string, body string) (*RequestResult, error) {
 	return c.Request(url, post, body, nil)
@@ -72,177 +113,393 @@ func (c *Connection) Put(url string, body string) (*RequestResult, error) {
 }
 
 // Request is performing an Http-Request. In case it receives an HTTP-Error 403, an application Login will be performed and the
-// request will be repeated (only one time).
+// request will be repeated (only one time). An empty response results in a MalformedResponseError, so a returned
+// RequestResult is never nil when there is no error.
 func (c *Connection) Request(url string, method requestMethod, body string, params map[string]string) (*RequestResult, error) {
-	res, err := c.doRequest(url, method, body, params)
+	return c.RequestContext(context.Background(), url, method, body, params)
+}
+
+// RequestContext is performing an Http-Request like Request does. The request, including a required application login,
+// will be aborted when the given context is canceled or its deadline is exceeded. Failed requests are repeated according
+// to the RetryPolicy. ErrCircuitOpen is returned without performing the request while the dSS is unreachable.
+func (c *Connection) RequestContext(ctx context.Context, url string, method requestMethod, body string, params map[string]string) (*RequestResult, error) {
+	breaker := c.breaker.Load()
+	err := breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	var res *RequestResult
+	for attempt := 1; ; attempt++ {
+		res, err = c.requestWithLogin(ctx, url, method, body, params)
+		if attempt >= c.RetryPolicy.MaxAttempts || !c.RetryPolicy.shouldRetry(ctx, url, res, err) {
+			break
+		}
+		c.countRetry()
+		if e := sleepContext(ctx, c.RetryPolicy.backoff(attempt)); e != nil {
+			break
+		}
+	}
+	breaker.record(requestOutcome(ctx, err), err)
+	if res != nil && !res.OK {
+		c.countAPIError()
+	}
+	return res, err
+}
+
+// requestWithLogin performs the request and repeats it once after an application login when the
+// session has been expired
+func (c *Connection) requestWithLogin(ctx context.Context, url string, method requestMethod, body string, params map[string]string) (*RequestResult, error) {
+	token := c.GetSessionToken()
+	res, err := c.doRequest(ctx, url, method, body, params)
 	if err != nil {
 		if reqErr, ok := err.(*RequestError); ok {
 			if reqErr.StatusCode == 403 {
-				e := c.applicationLogin()
+				e := c.renewSession(ctx, token)
 				if e != nil {
 					return res, e
 				}
-				return c.doRequest(url, method, body, params)
+				res, err = c.doRequest(ctx, url, method, body, params)
 			}
 		}
-		return res, err
 	}
-	return res, nil
+	return res, err
+}
+
+// renewSession performs an application login after the given session token has been expired. Concurrent
+// requests share a single login, and no login is performed when the session has been renewed already.
+func (c *Connection) renewSession(ctx context.Context, expiredToken string) error {
+	c.sessionMutex.Lock()
+	if c.SessionToken != expiredToken {
+		c.sessionMutex.Unlock()
+		return nil
+	}
+	call := c.login
+	if call != nil {
+		c.sessionMutex.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call = &loginCall{done: make(chan struct{})}
+	c.login = call
+	c.sessionMutex.Unlock()
+
+	call.err = c.applicationLogin(ctx)
+	c.sessionMutex.Lock()
+	c.login = nil
+	c.sessionMutex.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// GetSessionToken returns the token of the current session, which is empty before the first login.
+func (c *Connection) GetSessionToken() string {
+	c.sessionMutex.Lock()
+	defer c.sessionMutex.Unlock()
+	return c.SessionToken
+}
+
+// SetSessionToken replaces the token of the current session.
+func (c *Connection) SetSessionToken(token string) {
+	c.sessionMutex.Lock()
+	c.SessionToken = token
+	c.sessionMutex.Unlock()
 }
 
-func (c *Connection) generateHTTPRequest(url string, method requestMethod, body string, params map[string]string) (*http.Request, error) {
+func (c *Connection) generateHTTPRequest(ctx context.Context, url string, method requestMethod, body string, params map[string]string) (*http.Request, error) {
 	//	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 
 	var req *http.Request
 	var err error
 
 	if len(body) > 0 {
-		req, err = http.NewRequest(string(method), url, strings.NewReader(body))
+		req, err = http.NewRequestWithContext(ctx, string(method), url, strings.NewReader(body))
 	} else {
-		req, err = http.NewRequest(string(method), url, nil)
+		req, err = http.NewRequestWithContext(ctx, string(method), url, nil)
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	q := req.URL.Query()
-	if c.checkAccessToken() {
-		q.Add("token", c.SessionToken)
+	if token := c.GetSessionToken(); token != "" {
+		q.Add("token", token)
 	}
 
 	for p, v := range params {
 		q.Add(p, v)
 	}
 
-	req.URL.RawQuery = q.Encode()
+	// Encode escapes spaces as '+', which is only decoded in forms. The dSS expects '%20', while a literal
+	// '+' is escaped as '%2B' anyway.
+	req.URL.RawQuery = strings.ReplaceAll(q.Encode(), "+", "%20")
 	return req, nil
 }
 
 // doRequest is performing an http request. It is not recommended to use method "Connection.Request". In case the session token has been expired
 // doRequest is giving back the error and is not trying to login automatically
-func (c *Connection) doRequest(url string, method requestMethod, body string, params map[string]string) (*RequestResult, error) {
+func (c *Connection) doRequest(ctx context.Context, url string, method requestMethod, body string, params map[string]string) (*RequestResult, error) {
 
 	//	logger.Info("performing http-request: " + url)
 
-	req, err := c.generateHTTPRequest(url, method, body, params)
+	req, err := c.generateHTTPRequest(ctx, url, method, body, params)
 	if err != nil {
 		return nil, err
 	}
 
+	endpoint := req.URL.Path
+	start := time.Now()
 	res, err := c.HTTPClient.Do(req)
+	c.countRequest(time.Since(start), err != nil || res.StatusCode != http.StatusOK)
 	if err != nil {
-		logger.Error(err, fmt.Sprintf("unable to perform http request (%s)", url))
+		var mismatchErr *CertificateMismatchError
+		if errors.As(err, &mismatchErr) {
+			logger.Error(mismatchErr, "refusing certificate of the dSS")
+			return nil, mismatchErr
+		}
+		redactURLError(err)
+		err = &TransportError{Endpoint: endpoint, Err: err}
+		logger.Error(err, "unable to perform http request")
 		return nil, err
 	}
 
 	defer res.Body.Close()
 
-	// TODO: generate a better error message according to StatusCode
 	if res.StatusCode != http.StatusOK {
-		err := &RequestError{"Unexpected Resonse for " + url, res.StatusCode, *req}
+		err := &RequestError{Endpoint: endpoint, StatusCode: res.StatusCode}
 		logger.Error(err, "unable to perform http request")
 		return nil, err
 	}
-	if res.Header.Get("Content-Length") != "0" {
-		resp, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			logger.Error(err, "unable to read response body (content length: "+res.Header.Get("Content-Length")+")")
-			return nil, err
-		}
-		return convertToRequestResult(resp)
+	if res.Header.Get("Content-Length") == "0" {
+		return nil, &MalformedResponseError{Endpoint: endpoint, Err: errors.New("empty response")}
+	}
+	resp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		err = &TransportError{Endpoint: endpoint, Err: err}
+		logger.Error(err, "unable to read response body (content length: "+res.Header.Get("Content-Length")+")")
+		return nil, err
+	}
+	result, err := convertToRequestResult(resp)
+	if err != nil {
+		return nil, &MalformedResponseError{Endpoint: endpoint, Err: err}
+	}
+	result.endpoint = endpoint
+	return result, nil
+}
+
+func (c *Connection) countRequest(duration time.Duration, failed bool) {
+	if c.stats == nil {
+		return
+	}
+	c.stats.mutex.Lock()
+	c.stats.requests++
+	c.stats.requestTime += duration
+	if failed {
+		c.stats.requestErrors++
+	}
+	c.stats.mutex.Unlock()
+}
+
+func (c *Connection) countAPIError() {
+	if c.stats == nil {
+		return
+	}
+	c.stats.mutex.Lock()
+	c.stats.apiErrors++
+	c.stats.mutex.Unlock()
+}
+
+func (c *Connection) countRetry() {
+	if c.stats == nil {
+		return
 	}
+	c.stats.mutex.Lock()
+	c.stats.retries++
+	c.stats.mutex.Unlock()
+}
 
-	return nil, err
+func (c *Connection) countLogin() {
+	if c.stats == nil {
+		return
+	}
+	c.stats.mutex.Lock()
+	c.stats.logins++
+	c.stats.mutex.Unlock()
 }
 
 func convertToRequestResult(body []byte) (*RequestResult, error) {
 	var requestResult RequestResult
+	var raw struct {
+		Result json.RawMessage `json:"result"`
+	}
 
 	err := json.Unmarshal(body, &requestResult)
 	if err != nil {
 		return nil, err
 	}
+	err = json.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, err
+	}
+	result := strings.TrimSpace(string(raw.Result))
+	if strings.HasPrefix(result, "[") {
+		err = json.Unmarshal(raw.Result, &requestResult.ResultList)
+	} else if len(result) > 0 && result != "null" {
+		err = json.Unmarshal(raw.Result, &requestResult.Result)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	return &requestResult, nil
 }
 
-func (c *Connection) applicationLogin() error {
-	if !c.checkApplicationToken() {
-		return errors.New("applicationToken is not set")
+// jsonInt returns the value with the given key as int. The dSS delivers values like event
+// properties either as string or as number.
+func jsonInt(values map[string]interface{}, key string) (int, bool) {
+	switch v := values[key].(type) {
+	case float64:
+		return int(v), true
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// jsonFloat returns the value with the given key as float64. The dSS delivers values like event
+// properties either as string or as number.
+func jsonFloat(values map[string]interface{}, key string) (float64, bool) {
+	switch v := values[key].(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func (c *Connection) applicationLogin(ctx context.Context) error {
+	applicationToken, err := c.loadApplicationToken()
+	if err != nil {
+		return err
 	}
-	params := map[string]string{"loginToken": c.ApplicationToken}
-	res, err := c.doRequest(c.BaseURL+"/json/system/loginApplication", get, "", params)
+	params := map[string]string{"loginToken": applicationToken}
+	c.countLogin()
+	res, err := c.doRequest(ctx, c.BaseURL+"/json/system/loginApplication", get, "", params)
 	if err != nil {
 		return err
 	}
 
-	if res.OK {
-		c.SessionToken = res.Result["token"].(string)
-		return nil
+	if !res.OK {
+		return &AuthError{Endpoint: res.endpoint, Message: res.Message}
+	}
+	token, ok := res.Result["token"].(string)
+	if !ok {
+		return res.malformed("token")
 	}
-	return errors.New(res.Message)
+	c.SetSessionToken(token)
+	return nil
 }
 
 // register an application with the given applicationName. Performs a request to generate an application token. A second request requires the
 // Username and Password in order to generate a temporary session token. A third request enables the application token to login without
 // further user credentials (applicationLogin). Returns the application token or an error. The application token will not be assigned automatically.
-// Thus, in order to use the generated application token, it has to be set afterwards.
-func (c *Connection) register(username string, password string, applicationName string) (string, error) {
+// Thus, in order to use the generated application token, it has to be set afterwards. When a TokenStore is set, the token is saved there.
+// Credentials are never logged and errors only contain the endpoint, not the URL with its query.
+func (c *Connection) register(ctx context.Context, username string, password string, applicationName string) (string, error) {
 
-	logger.Info("registering new application with name " + applicationName)
+	logger.Info("registering new application with name " + applicationName + " at " + redactURL(c.BaseURL))
 
 	// request an ApplicationToken
-	res, err := c.doRequest(c.BaseURL+"/json/system/requestApplicationToken", get, "", map[string]string{"applicationName": applicationName})
+	res, err := c.doRequest(ctx, c.BaseURL+"/json/system/requestApplicationToken", get, "", map[string]string{"applicationName": applicationName})
 	if err != nil {
 		logger.Error(err, "registration has been aborted")
 		return "", err
 	}
 	if !res.OK {
-		e := errors.New(res.Message)
+		e := res.apiError()
 		logger.Error(e, "registration has been aborted")
 		return "", e
 	}
 
-	applicationToken := res.Result["applicationToken"].(string)
+	applicationToken, ok := res.Result["applicationToken"].(string)
+	if !ok {
+		return "", res.malformed("applicationToken")
+	}
 
-	logger.Info("got application token for '" + applicationName)
+	logger.Info("got application token for '" + applicationName + "'")
 
-	// performing a login in order to generate a temporary session token
-	// HINT during the http request generation, parameters will be URL-encoded. This leads to a problem with the password
-	// when it contains symbols that will change during the encoding problem. The digitalstrom server is not performing an
-	// URL decoding to restore the password
-	// This is a workaround, that might fail with passwords which are not url compatible
+	// performing a login in order to generate a temporary session token. The credentials are passed as parameters, so they
+	// are percent-encoded like all other parameters.
 	logger.Info("request session token with user credentials")
-	res, err = c.doRequest(c.BaseURL+"/json/system/login?user="+username+"&password="+password, get, "", nil)
+	res, err = c.doRequest(ctx, c.BaseURL+"/json/system/login", get, "", map[string]string{"user": username, "password": password})
 	if err != nil {
 		logger.Error(err, "registration has been aborted")
 		return "", err
 	}
 	if !res.OK {
-		e := errors.New(res.Message)
+		e := &AuthError{Endpoint: res.endpoint, Message: res.Message}
 		logger.Error(e, "registration has been aborted")
 		return "", e
 	}
 
-	sessionToken := res.Result["token"].(string)
+	sessionToken, ok := res.Result["token"].(string)
+	if !ok {
+		return "", res.malformed("token")
+	}
 	logger.Info("got session token, trying to enable the application token")
 	// use the session token to enable the application token. Future logins wont need user credentials anymore, the application token will be used to
 	// perform an application login
-	res, err = c.doRequest(c.BaseURL+"/json/system/enableToken", get, "", map[string]string{"applicationToken": applicationToken, "token": sessionToken})
+	res, err = c.doRequest(ctx, c.BaseURL+"/json/system/enableToken", get, "", map[string]string{"applicationToken": applicationToken, "token": sessionToken})
 	if err != nil {
 		logger.Error(err, "registration has been aborted")
 		return "", err
 	}
-	logger.Info("application '" + applicationName + " has been registered successfully")
+	if !res.OK {
+		e := &AuthError{Endpoint: res.endpoint, Message: res.Message}
+		logger.Error(e, "registration has been aborted")
+		return "", e
+	}
+	logger.Info("application '" + applicationName + "' has been registered successfully")
+	if c.TokenStore != nil {
+		err = c.TokenStore.SaveToken(applicationToken)
+		if errors.Is(err, ErrReadOnlyTokenStore) {
+			logger.Info("application token not saved, the token store is read-only")
+		} else if err != nil {
+			e := &TokenSaveError{Err: err}
+			logger.Error(e, "unable to save the application token")
+			return applicationToken, e
+		}
+	}
 	return applicationToken, nil
 }
 
-func (c *Connection) checkApplicationToken() bool {
-	// ToDo do propper checks
-	return c.ApplicationToken != ""
+// loadApplicationToken returns the application token, which is loaded from the TokenStore if it has not
+// been set.
+func (c *Connection) loadApplicationToken() (string, error) {
+	c.sessionMutex.Lock()
+	defer c.sessionMutex.Unlock()
+	if !c.checkApplicationToken() && c.TokenStore != nil {
+		token, err := c.TokenStore.LoadToken()
+		if err != nil {
+			return "", &AuthError{Endpoint: "/json/system/loginApplication", Message: "applicationToken could not be loaded: " + err.Error(), Err: err}
+		}
+		c.ApplicationToken = token
+	}
+	if !c.checkApplicationToken() {
+		return "", &AuthError{Endpoint: "/json/system/loginApplication", Message: "applicationToken is not set"}
+	}
+	return c.ApplicationToken, nil
 }
 
-func (c *Connection) checkAccessToken() bool {
+// checkApplicationToken returns true when the application token is set. The session lock has to be held
+// by the caller.
+func (c *Connection) checkApplicationToken() bool {
 	// ToDo do propper checks
-	return c.SessionToken != ""
+	return c.ApplicationToken != ""
 }
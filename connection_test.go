@@ -0,0 +1,48 @@
+package digitalstrom
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertToRequestResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		ok         bool
+		message    string
+		result     map[string]interface{}
+		resultList []interface{}
+	}{
+		{"object", `{"ok":true,"result":{"value":42}}`, true, "", map[string]interface{}{"value": float64(42)}, nil},
+		{"list", `{"ok":true,"result":[{"name":"zones"},"x"]}`, true, "", nil, []interface{}{map[string]interface{}{"name": "zones"}, "x"}},
+		{"empty list", `{"ok":true,"result": [ ]}`, true, "", nil, []interface{}{}},
+		{"null", `{"ok":true,"result":null}`, true, "", nil, nil},
+		{"no result", `{"ok":true}`, true, "", nil, nil},
+		{"failure", `{"ok":false,"message":"device not found"}`, false, "device not found", nil, nil},
+	}
+	for _, test := range tests {
+		res, err := convertToRequestResult([]byte(test.body))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if res.OK != test.ok || res.Message != test.message {
+			t.Errorf("%s: unexpected ok %v and message %q", test.name, res.OK, res.Message)
+		}
+		if !reflect.DeepEqual(res.Result, test.result) {
+			t.Errorf("%s: expected Result %v, got %v", test.name, test.result, res.Result)
+		}
+		if !reflect.DeepEqual(res.ResultList, test.resultList) {
+			t.Errorf("%s: expected ResultList %v, got %v", test.name, test.resultList, res.ResultList)
+		}
+	}
+}
+
+func TestConvertToRequestResultRejectsInvalidJSON(t *testing.T) {
+	for _, body := range []string{`{"ok":true`, `{"ok":true,"result":"text"}`, `{"ok":true,"result":[1,}`} {
+		if _, err := convertToRequestResult([]byte(body)); err == nil {
+			t.Errorf("expected an error for %s", body)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/connctd/digitalstrom"
+)
+
+// defaultProfileName is used when a token is registered without an active profile
+const defaultProfileName = "default"
+
+// config is the content of the console configuration file
+type config struct {
+	Active   string              `json:"active,omitempty"`
+	Profiles map[string]*profile `json:"profiles"`
+}
+
+// profile holds the settings of a single installation
+type profile struct {
+	URL              string          `json:"url"`
+	ApplicationToken string          `json:"applicationToken,omitempty"`
+	TLS              *profileTLS     `json:"tls,omitempty"`
+	Polling          *profilePolling `json:"polling,omitempty"`
+}
+
+// profilePolling contains the polling defaults of a profile. MaxRequestsPerSecond is a pointer, so a
+// profile could disable the limit with 0, it replaces the field of the same name of PollingSetup.
+type profilePolling struct {
+	digitalstrom.PollingSetup
+	MaxRequestsPerSecond *float64 `json:"max_requests_per_second,omitempty"`
+}
+
+// profileTLS configures how the certificate of the dSS is verified. Without a CA file or pinned keys the
+// certificate is trusted on first use, as most dSS use a self-signed certificate.
+type profileTLS struct {
+	InsecureSkipVerify bool     `json:"insecureSkipVerify,omitempty"` // disables all checks
+	CAFile             string   `json:"caFile,omitempty"`             // PEM file with the certificates to trust
+	PinnedSPKI         []string `json:"pinnedSPKI,omitempty"`         // base64 encoded SHA-256 hashes of the public keys
+}
+
+// configPath is the path of the configuration file, it could be changed with -config
+var configPath = defaultConfigPath()
+
+// fingerprintPath is the file of the certificate fingerprints recorded by trust on first use
+func fingerprintPath() string {
+	if configPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(configPath), "known_hosts.json")
+}
+
+// activeConfig is the loaded configuration file
+var activeConfig = &config{Profiles: map[string]*profile{}}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dsconsole", "config.json")
+}
+
+// loadConfig reads the configuration file. A missing file is not an error.
+func loadConfig() error {
+	activeConfig = &config{Profiles: map[string]*profile{}}
+	if configPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, activeConfig)
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if activeConfig.Profiles == nil {
+		activeConfig.Profiles = map[string]*profile{}
+	}
+	return nil
+}
+
+// saveConfig writes the configuration file. It is only readable by the user, as it contains tokens.
+func saveConfig() error {
+	if configPath == "" {
+		return errors.New("no config directory available, use -config <file>")
+	}
+	data, err := json.MarshalIndent(activeConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(configPath), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(configPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(configPath, 0600)
+}
+
+// applyProfile sets url, application token, http client and polling defaults of the profile
+func applyProfile(a *digitalstrom.Account, p *profile) error {
+	err := applyTLS(a, p.TLS)
+	if err != nil {
+		return err
+	}
+	a.SetURL(p.URL)
+	a.SetApplicationToken(p.ApplicationToken)
+	if p.Polling != nil {
+		mergePollingSetup(&a.PollingSetup, p.Polling)
+	}
+	return nil
+}
+
+// applyTLS sets a http client verifying the certificate of the dSS according to the settings
+func applyTLS(a *digitalstrom.Account, settings *profileTLS) error {
+	if settings == nil {
+		settings = &profileTLS{}
+	}
+	if settings.InsecureSkipVerify {
+		a.Connection.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+		return nil
+	}
+	setup := digitalstrom.TLSSetup{PinnedSPKI: settings.PinnedSPKI}
+	if settings.CAFile != "" {
+		pool, err := digitalstrom.LoadCABundle(settings.CAFile)
+		if err != nil {
+			return err
+		}
+		setup.RootCAs = pool
+	}
+	if setup.RootCAs == nil && len(setup.PinnedSPKI) == 0 {
+		setup.TrustOnFirstUse = true
+		if path := fingerprintPath(); path != "" {
+			setup.FingerprintStore = &digitalstrom.FileFingerprintStore{Path: path}
+		}
+	}
+	a.Connection.SetTLS(setup)
+	return nil
+}
+
+// printCertificateHint explains how to continue when the certificate of the dSS has changed
+func printCertificateHint(err error) {
+	var mismatchErr *digitalstrom.CertificateMismatchError
+	if !errors.As(err, &mismatchErr) || mismatchErr.Pinned {
+		return
+	}
+	fmt.Printf("The certificate of the dSS has changed. If it has been replaced, remove the entry of '%s' from %s.\r\n", mismatchErr.Host, fingerprintPath())
+}
+
+// mergePollingSetup overwrites all intervals and limits of setup that are set in defaults
+func mergePollingSetup(setup *digitalstrom.PollingSetup, defaults *profilePolling) {
+	values := []struct{ target, value *int }{
+		{&setup.DefaultCircuitsPollingInterval, &defaults.DefaultCircuitsPollingInterval},
+		{&setup.DefaultSensorsPollingInterval, &defaults.DefaultSensorsPollingInterval},
+		{&setup.DefaultChannelsPollingInterval, &defaults.DefaultChannelsPollingInterval},
+		{&setup.DefaultStructurePollingInterval, &defaults.DefaultStructurePollingInterval},
+		{&setup.DefaultTemperatureControlStatePollingInterval, &defaults.DefaultTemperatureControlStatePollingInterval},
+		{&setup.DefaultBinaryInputsPollingInterval, &defaults.DefaultBinaryInputsPollingInterval},
+		{&setup.DefaultStatesPollingInterval, &defaults.DefaultStatesPollingInterval},
+		{&setup.MaxParallelPolls, &defaults.MaxParallelPolls},
+	}
+	for _, v := range values {
+		if *v.value > 0 {
+			*v.target = *v.value
+		}
+	}
+	if defaults.MaxRequestsPerSecond != nil && *defaults.MaxRequestsPerSecond >= 0 {
+		setup.MaxRequestsPerSecond = *defaults.MaxRequestsPerSecond
+	}
+}
+
+// saveRegisteredToken stores url and application token in the active profile, which is created if there is
+// none
+func saveRegisteredToken(url string, token string) {
+	name := activeConfig.Active
+	if name == "" {
+		name = defaultProfileName
+		activeConfig.Active = name
+	}
+	p, ok := activeConfig.Profiles[name]
+	if !ok {
+		p = &profile{}
+		activeConfig.Profiles[name] = p
+	}
+	p.URL = url
+	p.ApplicationToken = token
+	err := saveConfig()
+	if err != nil {
+		errorln("Error. Unable to save the token to the config file.")
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Token saved to profile '%s' in %s.\r\n", name, configPath)
+}
+
+func processProfileCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 2 {
+		usageln("Error. Not a correct command. Use -> profile <list|use|add|remove> ... Type 'help' for complete command descriptions.")
+		return
+	}
+	switch cmd[1] {
+	case "list":
+		names := make([]string, 0, len(activeConfig.Profiles))
+		for name := range activeConfig.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rows := [][]interface{}{}
+		for _, name := range names {
+			rows = append(rows, []interface{}{name, activeConfig.Profiles[name].URL, name == activeConfig.Active})
+		}
+		if writeTable([]string{"name", "url", "active"}, rows) {
+			return
+		}
+		fmt.Println("Profiles (" + configPath + ")")
+		if len(names) == 0 {
+			fmt.Println("    no Profiles found")
+			return
+		}
+		for _, name := range names {
+			marker := " "
+			if name == activeConfig.Active {
+				marker = "*"
+			}
+			fmt.Println("  " + marker + " " + toLen(name, 20) + " " + activeConfig.Profiles[name].URL)
+		}
+	case "use":
+		if len(cmd) != 3 {
+			usageln("Error. Not a correct command. Use -> profile use <name>.")
+			return
+		}
+		p, ok := activeConfig.Profiles[cmd[2]]
+		if !ok {
+			errorf("Error. Profile '%s' not found.\r\n", cmd[2])
+			return
+		}
+		err := applyProfile(a, p)
+		if err != nil {
+			errorf("Error. Unable to use profile '%s'.\r\n", cmd[2])
+			fmt.Println(err)
+			return
+		}
+		activeConfig.Active = cmd[2]
+		err = saveConfig()
+		if err != nil {
+			errorln("Error. Unable to save the config file.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("OK. Using profile '%s' (%s). Type 'init' to connect.\r\n", cmd[2], p.URL)
+	case "add":
+		if len(cmd) < 3 || len(cmd) > 5 {
+			usageln("Error. Not a correct command. Use -> profile add <name> [url] [application token].")
+			return
+		}
+		// without url and token the current settings are stored
+		p := &profile{URL: a.Connection.BaseURL, ApplicationToken: a.Connection.ApplicationToken}
+		if len(cmd) > 3 {
+			p.URL = cmd[3]
+			p.ApplicationToken = ""
+		}
+		if len(cmd) > 4 {
+			p.ApplicationToken = cmd[4]
+		}
+		if old, ok := activeConfig.Profiles[cmd[2]]; ok {
+			p.TLS, p.Polling = old.TLS, old.Polling
+		}
+		activeConfig.Profiles[cmd[2]] = p
+		err := saveConfig()
+		if err != nil {
+			errorln("Error. Unable to save the config file.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("OK. Profile '%s' saved. Type 'profile use %s' to use it.\r\n", cmd[2], cmd[2])
+	case "remove":
+		if len(cmd) != 3 {
+			usageln("Error. Not a correct command. Use -> profile remove <name>.")
+			return
+		}
+		if _, ok := activeConfig.Profiles[cmd[2]]; !ok {
+			errorf("Error. Profile '%s' not found.\r\n", cmd[2])
+			return
+		}
+		delete(activeConfig.Profiles, cmd[2])
+		if activeConfig.Active == cmd[2] {
+			activeConfig.Active = ""
+		}
+		err := saveConfig()
+		if err != nil {
+			errorln("Error. Unable to save the config file.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("OK. Profile '%s' removed.\r\n", cmd[2])
+	default:
+		usagef("\r\nError. '%s' is an unknown profile command. Should be 'list', 'use', 'add' or 'remove'.\r\n", cmd[1])
+	}
+}
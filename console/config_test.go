@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+)
+
+func TestMergePollingSetup(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  string
+		expected float64
+		sensors  int
+	}{
+		{"no limit given", `{"default_sensors_polling_interval": 60}`, 10, 60},
+		{"limit disabled", `{"max_requests_per_second": 0}`, 0, 800},
+		{"limit changed", `{"max_requests_per_second": 2.5}`, 2.5, 800},
+	}
+	for _, test := range tests {
+		defaults := &profilePolling{}
+		if err := json.Unmarshal([]byte(test.profile), defaults); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		a := digitalstrom.NewAccount()
+		mergePollingSetup(&a.PollingSetup, defaults)
+		if a.PollingSetup.MaxRequestsPerSecond != test.expected {
+			t.Errorf("%s: expected %v requests per second, got %v", test.name, test.expected, a.PollingSetup.MaxRequestsPerSecond)
+		}
+		if a.PollingSetup.DefaultSensorsPollingInterval != test.sensors {
+			t.Errorf("%s: expected sensor interval %d, got %d", test.name, test.sensors, a.PollingSetup.DefaultSensorsPollingInterval)
+		}
+	}
+}
@@ -26,17 +26,19 @@ package main
 
 import (
 	"bufio"
-	"crypto/tls"
+	"errors"
 	"fmt"
-	"net/http"
 
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"log"
 
 	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/mqtt"
 	"github.com/go-logr/stdr"
 )
 
@@ -48,63 +50,91 @@ type node struct {
 	name   string
 }
 
+// propertyPath is the current node when browsing the property tree
+var propertyPath = "/"
+
+// bridge is the running mqtt bridge or nil
+var bridge *mqtt.Bridge
+
 func main() {
 
 	setLogger()
 
-	printWelcomeMsg()
-
 	// generate new Account instance
 	account := *digitalstrom.NewAccount()
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	// without -at or a profile, the token is read from DSS_APPLICATION_TOKEN
+	account.SetTokenStore(digitalstrom.EnvTokenStore{})
+	// evaluate program arguments, remaining arguments are a command to execute without the interactive console
+	cmd := processProgramArguments(&account, os.Args[1:])
+	if len(cmd) > 0 {
+		runOneShot(&account, cmd)
 	}
-	client := &http.Client{Transport: tr}
-	account.Connection.HTTPClient = client
-	// evaluate program arguments
-	argsWithoutProg := os.Args[1:]
-	if len(argsWithoutProg) > 0 {
-		processProgramArguments(&account, argsWithoutProg)
+
+	printWelcomeMsg()
+	if activeConfig.Active != "" {
+		fmt.Printf("Using profile '%s' (%s).\r\n", activeConfig.Active, account.Connection.BaseURL)
 	}
 
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		// waiting for imput by user, split command into pieces by seperator " "
 		cmd := strings.Split(readNextCommand(reader), " ")
-		switch cmd[0] {
-		case "":
-		case "request":
-			processRequestCommand(&account, cmd)
-		case "update":
-			processUpdateCommand(&account, cmd)
-		case "init":
-			processInitCommand(&account, cmd)
-		case "login":
-			processLoginCommand(&account, cmd)
-		case "list":
-			processListCommand(&account, cmd)
-		case "print":
-			processPrintCommand(&account, cmd)
-		case "register":
-			processRegisterCommand(&account, cmd)
-		case "help":
-			printHelp()
-		case "cmd":
-			processCmdCommand(&account, cmd)
-		case "set":
-			processSetCommand(&account, cmd)
-		case "reset":
-			processResetCommand(&account, cmd)
-		case "exit":
-			printByeMsg()
-			os.Exit(0)
-		default:
+		if !processCommand(&account, cmd) {
 			fmt.Println("Unknown command '" + cmd[0] + "'.")
 		}
 	}
 }
 
+// processCommand executes the command and returns false when the command is unknown
+func processCommand(a *digitalstrom.Account, cmd []string) bool {
+	switch cmd[0] {
+	case "":
+	case "request":
+		processRequestCommand(a, cmd)
+	case "update":
+		processUpdateCommand(a, cmd)
+	case "init":
+		processInitCommand(a, cmd)
+	case "login":
+		processLoginCommand(a, cmd)
+	case "list":
+		processListCommand(a, cmd)
+	case "print":
+		processPrintCommand(a, cmd)
+	case "register":
+		processRegisterCommand(a, cmd)
+	case "help":
+		printHelp()
+	case "cmd":
+		processCmdCommand(a, cmd)
+	case "set":
+		processSetCommand(a, cmd)
+	case "reset":
+		processResetCommand(a, cmd)
+	case "property":
+		processPropertyCommand(a, cmd)
+	case "save":
+		processSaveCommand(a, cmd)
+	case "load":
+		processLoadCommand(a, cmd)
+	case "discover":
+		processDiscoverCommand(a, cmd)
+	case "structure":
+		processStructureCommand(a, cmd)
+	case "mqtt":
+		processMqttCommand(a, cmd)
+	case "profile":
+		processProfileCommand(a, cmd)
+	case "exit":
+		printByeMsg()
+		os.Exit(0)
+	default:
+		return false
+	}
+	return true
+}
+
 // ------------------------- Helper Functions -------------------------------------
 
 func readNextCommand(r *bufio.Reader) string {
@@ -125,43 +155,115 @@ func setLogger() {
 }
 
 // ---------------------------- Command Processing ----------------------------------
-func processProgramArguments(a *digitalstrom.Account, args []string) {
 
+// processProgramArguments evaluates the options and returns the remaining arguments, which are a command.
+// The selected or active profile of the config file is applied before -url and -at.
+func processProgramArguments(a *digitalstrom.Account, args []string) []string {
+	var url, token, profileName string
+	var cmd []string
+
+loop:
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "-at":
 			i++
 			if len(args) <= i {
-				fmt.Printf("\r\nError. Application Token missing. To set Application Token, type '-at <application-token>.\r\n\r\n")
-				os.Exit(1)
+				usagef("\r\nError. Application Token missing. To set Application Token, type '-at <application-token>.\r\n\r\n")
+				os.Exit(exitUsage)
 			}
-			a.SetApplicationToken(args[i])
+			token = args[i]
 		case "-url":
 			i++
 			if len(args) <= i {
-				fmt.Printf("\r\nError. URL addres is missing. To set base URL, type '-url <address>.\r\n\r\n")
-				os.Exit(1)
+				usagef("\r\nError. URL addres is missing. To set base URL, type '-url <address>.\r\n\r\n")
+				os.Exit(exitUsage)
+			}
+			url = args[i]
+		case "-config":
+			i++
+			if len(args) <= i {
+				usagef("\r\nError. Config file is missing. To use another config file, type '-config <file>.\r\n\r\n")
+				os.Exit(exitUsage)
 			}
-			a.SetURL(args[i])
+			configPath = args[i]
+		case "-p":
+			i++
+			if len(args) <= i {
+				usagef("\r\nError. Profile name is missing. To select a profile, type '-p <profile>.\r\n\r\n")
+				os.Exit(exitUsage)
+			}
+			profileName = args[i]
 		case "-r":
-			processRegisterCommand(a, args)
+			applyConfig(a, profileName)
+			processRegisterCommand(a, args[i:])
 			fmt.Println()
-			os.Exit(0)
+			os.Exit(exitCode)
 		case "--help", "-h":
 			printProgramArguments()
 			fmt.Println()
 			os.Exit(0)
+		case "--json", "--csv":
+			// evaluated by runOneShot, as they could also follow the command
+			cmd = args[i:]
+			break loop
 		default:
-			fmt.Printf("\r\nError. Unknown program argument '%s'.\r\nPlease type 'console -h' for a list of possible arguments.\r\n\r\n", args[i])
-			os.Exit(0)
+			if strings.HasPrefix(args[i], "-") {
+				usagef("\r\nError. Unknown program argument '%s'.\r\nPlease type 'console -h' for a list of possible arguments.\r\n\r\n", args[i])
+				os.Exit(exitUsage)
+			}
+			cmd = args[i:]
+			break loop
 		}
 	}
 
+	applyConfig(a, profileName)
+	if url != "" {
+		a.SetURL(url)
+	}
+	if token != "" {
+		a.SetApplicationToken(token)
+	}
+	return cmd
+}
+
+// applyConfig loads the config file and applies the profile with the given name or the active profile
+func applyConfig(a *digitalstrom.Account, profileName string) {
+	err := loadConfig()
+	if err != nil {
+		fmt.Println("Error. Unable to load the config file.")
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+	if profileName == "" {
+		profileName = activeConfig.Active
+	}
+	if profileName == "" {
+		// the certificate of the dSS is trusted on first use
+		err = applyTLS(a, nil)
+		if err != nil {
+			fmt.Println("Error. Unable to set up certificate verification.")
+			fmt.Println(err)
+			os.Exit(exitUsage)
+		}
+		return
+	}
+	p, ok := activeConfig.Profiles[profileName]
+	if !ok {
+		fmt.Printf("\r\nError. Profile '%s' not found in %s.\r\n\r\n", profileName, configPath)
+		os.Exit(exitUsage)
+	}
+	err = applyProfile(a, p)
+	if err != nil {
+		fmt.Printf("Error. Unable to use profile '%s'.\r\n", profileName)
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+	activeConfig.Active = profileName
 }
 
 func processInitCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) > 2 {
-		fmt.Println("Too many arguments for init command. init [applicationToken] expected.")
+		usageln("Too many arguments for init command. init [applicationToken] expected.")
 		return
 	}
 	if len(cmd) == 2 {
@@ -169,16 +271,321 @@ func processInitCommand(a *digitalstrom.Account, cmd []string) {
 	}
 	err := a.Init()
 	if err != nil {
-		fmt.Println("Error. Initialisation not successful.")
+		errorln("Error. Initialisation not successful.")
 		fmt.Println(err)
+		printCertificateHint(err)
 		return
 	}
 	fmt.Println("Success. Account is initiaised with complete structure.")
 }
 
+func processDiscoverCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) > 2 {
+		usageln("Error. Too many parameters. Use -> discover [timeout in s]")
+		return
+	}
+	setup := digitalstrom.DiscoverySetup{HTTPClient: a.Connection.HTTPClient}
+	if len(cmd) == 2 {
+		timeout, err := strconv.Atoi(cmd[1])
+		if err != nil || timeout <= 0 {
+			usagef("Error. '%s' is not a valid timeout. Timeout in seconds expected.\r\n", cmd[1])
+			return
+		}
+		setup.Timeout = time.Duration(timeout) * time.Second
+	}
+	fmt.Println("Searching for digitalSTROM servers ...")
+	servers, err := digitalstrom.Discover(setup)
+	if err != nil {
+		errorln("Error. Unable to discover servers.")
+		fmt.Println(err)
+		return
+	}
+	if len(servers) == 0 {
+		fmt.Println("    no servers found")
+		return
+	}
+	for _, server := range servers {
+		version := "unknown version"
+		if server.System != nil {
+			version = server.System.Version
+		}
+		fmt.Println("   " + toLen(server.BaseURL, 30) + " " + toLen(server.Name, 30) + " " + version)
+	}
+	fmt.Println("Use 'set url <url>' to connect to one of them.")
+}
+
+func processMqttCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 2 {
+		usageln("Error. Not a correct command. Use -> mqtt <start|stop> ... Type 'help' for complete command descriptions.")
+		return
+	}
+	switch cmd[1] {
+	case "start":
+		if len(cmd) < 3 || len(cmd) > 4 {
+			usageln("Error. Not a correct command. Use -> mqtt start <address> [topic prefix]")
+			return
+		}
+		if bridge != nil {
+			errorln("Error. The mqtt bridge is already running. Use 'mqtt stop' first.")
+			return
+		}
+		setup := mqtt.BridgeSetup{
+			Client: mqtt.ClientSetup{Address: cmd[2], ClientID: "dsconsole"},
+			ErrorHandler: func(err error) {
+				fmt.Println("\r\nmqtt: " + err.Error())
+			},
+		}
+		if len(cmd) == 4 {
+			setup.Topics.Prefix = cmd[3]
+		}
+		b := mqtt.NewBridge(a, setup)
+		err := b.Start()
+		if err != nil {
+			errorln("Error. Unable to connect to the mqtt broker.")
+			fmt.Println(err)
+			return
+		}
+		bridge = b
+		fmt.Println("OK")
+	case "stop":
+		if bridge == nil {
+			errorln("Error. The mqtt bridge is not running.")
+			return
+		}
+		bridge.Stop()
+		bridge = nil
+		fmt.Println("OK")
+	default:
+		usagef("\r\nError. '%s' is an unknown mqtt command. Should be 'start' or 'stop'.\r\n", cmd[1])
+	}
+}
+
+func processSaveCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) != 2 {
+		usageln("Error. save <file> expected.")
+		return
+	}
+	err := a.SaveFile(cmd[1])
+	if err != nil {
+		errorln("Error. Cache could not be saved.")
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("OK. Cache saved to %s.\r\n", cmd[1])
+}
+
+func processLoadCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 2 || len(cmd) > 3 {
+		usageln("Error. load <file> [reconcile] expected.")
+		return
+	}
+	err := a.LoadFile(cmd[1])
+	if err != nil {
+		errorln("Error. Cache could not be loaded.")
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("OK. Cache loaded from %s, values are marked as stale.\r\n", cmd[1])
+	if len(cmd) == 3 && cmd[2] == "reconcile" {
+		err = a.Reconcile()
+		if err != nil {
+			errorln("Error. Reconciliation not successful.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("OK. Cache reconciled with the dSS.")
+	}
+}
+
+func processPropertyCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 2 {
+		usageln("Error. Not a valid property command.")
+		return
+	}
+	switch cmd[1] {
+	case "pwd":
+		fmt.Println(propertyPath)
+	case "cd":
+		path := "/"
+		if len(cmd) > 2 {
+			path = resolvePropertyPath(cmd[2])
+		}
+		t, err := a.RequestPropertyType(path)
+		if err != nil {
+			errorln("Error. Property node not found.")
+			fmt.Println(err)
+			return
+		}
+		if t != digitalstrom.PTnone {
+			errorf("Error. '%s' is a %s property, not a node.\r\n", path, t)
+			return
+		}
+		propertyPath = path
+	case "ls":
+		path := propertyPath
+		if len(cmd) > 2 {
+			path = resolvePropertyPath(cmd[2])
+		}
+		children, err := a.RequestPropertyChildren(path)
+		if err != nil {
+			errorln("Error. Children could not be requested.")
+			fmt.Println(err)
+			return
+		}
+		rows := [][]interface{}{}
+		for _, child := range children {
+			rows = append(rows, []interface{}{child.Name, string(child.Type)})
+		}
+		if writeTable([]string{"name", "type"}, rows) {
+			return
+		}
+		for _, child := range children {
+			if child.Type == digitalstrom.PTnone {
+				fmt.Println("  " + child.Name + "/")
+			} else {
+				fmt.Println("  " + toLen(child.Name, 30) + " " + string(child.Type))
+			}
+		}
+	case "type":
+		if len(cmd) != 3 {
+			usageln("Error. property type <path> expected.")
+			return
+		}
+		t, err := a.RequestPropertyType(resolvePropertyPath(cmd[2]))
+		if err != nil {
+			errorln("Error. Type could not be requested.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(t)
+	case "get":
+		if len(cmd) != 3 {
+			usageln("Error. property get <path> expected.")
+			return
+		}
+		path := resolvePropertyPath(cmd[2])
+		value, err := requestPropertyValue(a, path)
+		if err != nil {
+			errorln("Error. Property could not be requested.")
+			fmt.Println(err)
+			return
+		}
+		if writeTable([]string{"path", "value"}, [][]interface{}{{path, value}}) {
+			return
+		}
+		fmt.Println(value)
+	case "set":
+		if len(cmd) < 5 {
+			usageln("Error. property set <path> <string|integer|boolean|floating> <value> expected.")
+			return
+		}
+		err := setPropertyValue(a, resolvePropertyPath(cmd[2]), digitalstrom.PropertyType(cmd[3]), strings.Join(cmd[4:], " "))
+		if err != nil {
+			errorln("Error. Property could not be set.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("OK. Property has been set.")
+	case "query", "query2":
+		if len(cmd) != 3 {
+			usagef("Error. property %s <query> expected.\r\n", cmd[1])
+			return
+		}
+		query := resolvePropertyPath(cmd[2])
+		var result *digitalstrom.PropertyQueryNode
+		var err error
+		if cmd[1] == "query" {
+			result, err = a.RequestPropertyQuery(query)
+		} else {
+			result, err = a.RequestPropertyQuery2(query)
+		}
+		if err != nil {
+			errorln("Error. Query not successful.")
+			fmt.Println(err)
+			return
+		}
+		if writeJSON(result) {
+			return
+		}
+		n := generatePropertyQueryNode(query, result)
+		fmt.Println()
+		printNode("", "", true, &n, 100)
+	default:
+		usagef("Unknown parameter for property '%s'.\r\n", cmd[1])
+	}
+}
+
+// resolvePropertyPath returns the absolute path of a path relative to the current property node
+func resolvePropertyPath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = strings.TrimSuffix(propertyPath, "/") + "/" + path
+	}
+	parts := []string{}
+	for _, part := range strings.Split(path, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// requestPropertyValue requests the type of the property and its value afterwards
+func requestPropertyValue(a *digitalstrom.Account, path string) (string, error) {
+	t, err := a.RequestPropertyType(path)
+	if err != nil {
+		return "", err
+	}
+	switch t {
+	case digitalstrom.PTstring:
+		return a.RequestPropertyString(path)
+	case digitalstrom.PTinteger:
+		value, err := a.RequestPropertyInteger(path)
+		return strconv.Itoa(value), err
+	case digitalstrom.PTboolean:
+		value, err := a.RequestPropertyBoolean(path)
+		return strconv.FormatBool(value), err
+	case digitalstrom.PTfloating:
+		value, err := a.RequestPropertyFloating(path)
+		return strconv.FormatFloat(value, 'f', -1, 64), err
+	}
+	return "", fmt.Errorf("property '%s' has no value (type %s)", path, t)
+}
+
+func setPropertyValue(a *digitalstrom.Account, path string, t digitalstrom.PropertyType, value string) error {
+	switch t {
+	case digitalstrom.PTstring:
+		return a.SetPropertyString(path, value)
+	case digitalstrom.PTinteger:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		return a.SetPropertyInteger(path, i)
+	case digitalstrom.PTboolean:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		return a.SetPropertyBoolean(path, b)
+	case digitalstrom.PTfloating:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		return a.SetPropertyFloating(path, f)
+	}
+	return fmt.Errorf("unknown property type '%s'", t)
+}
+
 func processResetCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) < 2 {
-		fmt.Println("Error. Not a valid reset command.")
+		usageln("Error. Not a valid reset command.")
 		return
 	}
 	switch cmd[1] {
@@ -186,41 +593,45 @@ func processResetCommand(a *digitalstrom.Account, cmd []string) {
 		a.ResetPollingIntervals()
 		fmt.Println("OK. All polling intervals are removed.")
 	default:
-		fmt.Printf("Unknown parameter for reset '%s'.\r\n", cmd[1])
+		usagef("Unknown parameter for reset '%s'.\r\n", cmd[1])
 	}
 }
 
 func processLoginCommand(a *digitalstrom.Account, cmd []string) {
 	err := a.ApplicationLogin()
 	if err != nil {
-		fmt.Println("Error. Application Login not successful.")
+		errorln("Error. Application Login not successful.")
 		fmt.Println(err)
 		return
 	}
 
-	fmt.Printf("Login successful - new session token = %s\r\n", a.Connection.SessionToken)
+	fmt.Printf("Login successful - new session token = %s\r\n", a.Connection.GetSessionToken())
 }
 
 func processRegisterCommand(a *digitalstrom.Account, cmd []string) {
 
 	if len(cmd) != 5 {
-		fmt.Println("Error. Not a valid register command.")
+		usageln("Error. Not a valid register command.")
 		return
 	}
 	a.SetURL(cmd[1])
 	atoken, err := a.RegisterApplication(cmd[4], cmd[2], cmd[3])
-	if err != nil {
-		fmt.Println("Error. Unable to register application.")
+	if errors.Is(err, digitalstrom.ErrTokenNotSaved) {
+		// the application is registered, only the token store failed
+		fmt.Println("Warning. " + err.Error())
+	} else if err != nil {
+		errorln("Error. Unable to register application.")
 		fmt.Println(err)
 		return
 	}
-	fmt.Printf("Application with name '%s' registered at '%s'.\r\n", cmd[3], a.Connection.BaseURL)
+	fmt.Printf("Application with name '%s' registered at '%s'.\r\n", cmd[4], a.Connection.BaseURL)
 	fmt.Printf("Your applicaiton token = %s\r\n", atoken)
+	saveRegisteredToken(a.Connection.BaseURL, atoken)
 }
 
 func processUpdateCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) < 2 {
-		fmt.Println("Error. You have to give a parameter what to get. Type 'help' for a full command list.")
+		usageln("Error. You have to give a parameter what to get. Type 'help' for a full command list.")
 		return
 	}
 	switch cmd[1] {
@@ -240,6 +651,8 @@ func processUpdateCommand(a *digitalstrom.Account, cmd []string) {
 		updateAll(a)
 	case "auto":
 		processAutoUpdateCmd(a, cmd)
+	case "events":
+		processEventsUpdateCmd(a, cmd)
 	case "channel":
 		processUpdateChannelCmd(a, cmd)
 	case "channels":
@@ -248,69 +661,87 @@ func processUpdateCommand(a *digitalstrom.Account, cmd []string) {
 		processUpdateTemperatureControlCmd(a, cmd)
 	case "binInputs":
 		processUpdateBinaryInputsCmd(a, cmd)
+	case "states":
+		processUpdateStatesCmd(a, cmd)
 	default:
-		fmt.Printf("Error, '%s' is an unkonwn parameter for update command.\r\n", cmd[1])
+		usagef("Error, '%s' is an unkonwn parameter for update command.\r\n", cmd[1])
 	}
 }
 
 func updateAll(a *digitalstrom.Account) {
+	snapshot := a.Snapshot()
+	rows := [][]interface{}{}
 	fmt.Println("Updating Sensor Values")
-	for i := range a.Devices {
-		for j := range a.Devices[i].Sensors {
+	for id, device := range snapshot.Devices {
+		for j := range device.Sensors {
 
-			sensor := a.Devices[i].Sensors[j]
-			fmt.Printf("   Updating sensor value for '%s.%d - %s' ... ", a.Devices[i].DisplayID, sensor.Index, sensor.Type.GetName())
-			value, err := a.PollSensorValue(a.Devices[i].Sensors[j])
+			sensor, err := a.GetSensor(id, j)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("   Updating sensor value for '%s.%d - %s' ... ", id, j, device.Sensors[j].Type.GetName())
+			value, err := a.PollSensorValue(sensor)
 			if err != nil {
-				fmt.Printf("ERROR. %s\r\n", err)
+				errorf("ERROR. %s\r\n", err)
 			} else {
 				fmt.Printf("OK. value = %f\r\n", value)
+				rows = append(rows, sensorRow(id, sensor, value))
 			}
 
 		}
 	}
 	fmt.Println()
 	fmt.Println("Updating OutputChannel Values")
-	for i := range a.Devices {
-		for j := range a.Devices[i].OutputChannels {
+	for id, device := range snapshot.Devices {
+		for j := range device.OutputChannels {
 
-			channel := a.Devices[i].OutputChannels[j]
-			fmt.Printf("   Updating output channel value for '%s.%d - %s' ... ", a.Devices[i].DisplayID, channel.ChannelIndex, channel.ChannelName)
-			value, err := a.PollChannelValue(a.Devices[i].OutputChannels[j])
+			channel, err := a.GetOutputChannel(id, j)
 			if err != nil {
-				fmt.Printf("ERROR. %s\r\n", err)
+				continue
+			}
+			fmt.Printf("   Updating output channel value for '%s.%d - %s' ... ", id, j, device.OutputChannels[j].ChannelName)
+			value, err := a.PollChannelValue(channel)
+			if err != nil {
+				errorf("ERROR. %s\r\n", err)
 			} else {
 				fmt.Printf("OK. value = %d\r\n", value)
+				rows = append(rows, channelRow(id, channel, value))
 			}
 
 		}
 	}
 	fmt.Println()
 	fmt.Println("Updating Circuit Values")
-	for i := range a.Circuits {
-		fmt.Printf("   Updating consumption of circuit '%s (%s)' ... ", a.Circuits[i].DisplayID, a.Circuits[i].Name)
-		circuit := a.Circuits[i]
+	for id := range snapshot.Circuits {
+		circuit, err := a.GetCircuit(id)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("   Updating consumption of circuit '%s (%s)' ... ", id, snapshot.Circuits[id].Name)
 		value, err := a.PollCircuitConsumptionValue(circuit)
 		if err != nil {
-			fmt.Printf("ERROR. %s\r\n", err)
+			errorf("ERROR. %s\r\n", err)
 		} else {
 			fmt.Printf("OK. value = %d W\r\n", value)
+			rows = append(rows, circuitRow("consumption", circuit, value))
 		}
 
-		fmt.Printf("   Updating meter value of circuit '%s (%s)' ... ", a.Circuits[i].DisplayID, a.Circuits[i].Name)
+		fmt.Printf("   Updating meter value of circuit '%s (%s)' ... ", id, snapshot.Circuits[id].Name)
 		value, err = a.PollCircuitMeterValue(circuit)
 		if err != nil {
-			fmt.Printf("ERROR. %s\r\n", err)
+			errorf("ERROR. %s\r\n", err)
 		} else {
 			fmt.Printf("OK. value = %d Ws\r\n", value)
+			rows = append(rows, circuitRow("meter", circuit, value))
 		}
 	}
 	fmt.Println()
+	writeTable(valueColumns, rows)
 }
 
 func processAutoUpdateCmd(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) != 3 {
-		fmt.Println("Error. Bad update auto command. use -> update auto <on|off>")
+		usageln("Error. Bad update auto command. use -> update auto <on|off>")
 		return
 	}
 	switch cmd[2] {
@@ -321,32 +752,62 @@ func processAutoUpdateCmd(a *digitalstrom.Account, cmd []string) {
 		a.StopPolling()
 		fmt.Println("OK. Automatic updates are stopped.")
 	default:
-		fmt.Printf("Error. %s is not a valid parameter. Type either 'on' or 'off'.", cmd[2])
+		usagef("Error. %s is not a valid parameter. Type either 'on' or 'off'.", cmd[2])
+	}
+}
+
+func processEventsUpdateCmd(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) != 3 {
+		usageln("Error. Bad update events command. use -> update events <on|off>")
+		return
+	}
+	switch cmd[2] {
+	case "on":
+		err := a.StartEventListener()
+		if err != nil {
+			errorln("Error. Unable to start event listener.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("OK. Values will be updated by dSS events.")
+	case "off":
+		err := a.StopEventListener()
+		if err != nil {
+			errorln("Error. Unable to unsubscribe events.")
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("OK. Event listener is stopped.")
+	default:
+		usagef("Error. %s is not a valid parameter. Type either 'on' or 'off'.", cmd[2])
 	}
 }
 
 func processUpdateDeviceCmd(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) != 3 {
-		fmt.Println("Error. Bad update device command. use -> update device <deviceDisplayID>")
+		usageln("Error. Bad update device command. use -> update device <deviceDisplayID>")
 		return
 	}
 
-	dev, ok := a.Devices[cmd[2]]
-	if !ok {
-		fmt.Printf("Error. Device with id '%s' not found.\r\n", cmd[2])
+	dev, err := a.GetDevice(cmd[2])
+	if err != nil {
+		errorf("Error. Device with id '%s' not found.\r\n", cmd[2])
 		return
 	}
 
+	rows := [][]interface{}{}
 	for j := range dev.Sensors {
 		sensor := dev.Sensors[j]
 		fmt.Printf("   Updating sensor value for '%s.%d - %s' ... ", dev.DisplayID, sensor.Index, sensor.Type.GetName())
 		value, err := a.PollSensorValue(dev.Sensors[j])
 		if err != nil {
-			fmt.Printf("ERROR. %s\r\n", err)
+			errorf("ERROR. %s\r\n", err)
 		} else {
 			fmt.Printf("OK. value = %f\r\n", value)
+			rows = append(rows, sensorRow(dev.DisplayID, sensor, value))
 		}
 	}
+	writeTable(valueColumns, rows)
 
 }
 
@@ -354,7 +815,7 @@ func processUpdateOnCmd(a *digitalstrom.Account, cmd []string) {
 
 	err := a.PollStructureValues()
 	if err != nil {
-		fmt.Printf("Error. Unable to update On values '%s'.\r\n", cmd[2])
+		errorf("Error. Unable to update On values '%s'.\r\n", cmd[2])
 		fmt.Println(err)
 		return
 	}
@@ -363,7 +824,17 @@ func processUpdateOnCmd(a *digitalstrom.Account, cmd []string) {
 func processUpdateBinaryInputsCmd(a *digitalstrom.Account, cmd []string) {
 	err := a.PollBinaryInputs()
 	if err != nil {
-		fmt.Printf("Error. Unable to update binary inputs.\r\n")
+		errorf("Error. Unable to update binary inputs.\r\n")
+		fmt.Println(err)
+		return
+	}
+	fmt.Println()
+}
+
+func processUpdateStatesCmd(a *digitalstrom.Account, cmd []string) {
+	err := a.PollApartmentStates()
+	if err != nil {
+		errorf("Error. Unable to update apartment states.\r\n")
 		fmt.Println(err)
 		return
 	}
@@ -373,7 +844,7 @@ func processUpdateBinaryInputsCmd(a *digitalstrom.Account, cmd []string) {
 func processUpdateTemperatureControlCmd(a *digitalstrom.Account, cmd []string) {
 	err := a.PollTemperatureControlValues()
 	if err != nil {
-		fmt.Printf("Error. Unable to update temperature control states.\r\n")
+		errorf("Error. Unable to update temperature control states.\r\n")
 		fmt.Println(err)
 		return
 	}
@@ -382,58 +853,63 @@ func processUpdateTemperatureControlCmd(a *digitalstrom.Account, cmd []string) {
 
 func processUpdateSensorsCmd(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) != 3 {
-		fmt.Println("Error. Bad update sensor command. use -> update sensors <deviceDisplayID>")
+		usageln("Error. Bad update sensor command. use -> update sensors <deviceDisplayID>")
 		return
 	}
-	device, ok := a.Devices[cmd[2]]
-	if !ok {
-		fmt.Printf("Error, device with display ID '%s' not found.\r\n", cmd[2])
+	device, err := a.GetDevice(cmd[2])
+	if err != nil {
+		errorf("Error, device with display ID '%s' not found.\r\n", cmd[2])
 		return
 	}
 
+	rows := [][]interface{}{}
 	for i := range device.Sensors {
 		fmt.Printf("Updating sensor %s.%d ...", cmd[2], i)
 		value, err := a.PollSensorValue(device.Sensors[i])
 		if err != nil {
-			fmt.Printf("ERROR.Unable to update sensor '%d' of device '%s'.\r\n", i, cmd[2])
+			errorf("ERROR.Unable to update sensor '%d' of device '%s'.\r\n", i, cmd[2])
 			fmt.Println(err)
 			return
 		}
 		fmt.Printf("OK. Value = %f\r\n", value)
+		rows = append(rows, sensorRow(cmd[2], device.Sensors[i], value))
 	}
 	fmt.Println()
+	writeTable(valueColumns, rows)
 }
 
 func processUpdateChannelsCmd(a *digitalstrom.Account, cmd []string) {
 
 	if len(cmd) != 3 {
-		fmt.Println("Error. Bad update channel command. use -> update channels <deviceDisplayID>")
+		usageln("Error. Bad update channel command. use -> update channels <deviceDisplayID>")
 		return
 	}
 
-	dev, ok := a.Devices[cmd[2]]
-
-	if !ok {
-		fmt.Printf("Error. Unable to find device with displayId '%s'\r\n", cmd[2])
+	dev, err := a.GetDevice(cmd[2])
+	if err != nil {
+		errorf("Error. Unable to find device with displayId '%s'\r\n", cmd[2])
 		return
 	}
 
+	rows := [][]interface{}{}
 	for i := range dev.OutputChannels {
 		fmt.Printf("   Updating output channel value for '%s.%d - %s' ... ", cmd[2], i, dev.OutputChannels[i].ChannelName)
 		value, err := a.PollChannelValue(dev.OutputChannels[i])
 		if err != nil {
-			fmt.Printf("ERROR. %s\r\n", err)
+			errorf("ERROR. %s\r\n", err)
 		} else {
 			fmt.Printf("OK. value = %d\r\n", value)
+			rows = append(rows, channelRow(cmd[2], dev.OutputChannels[i], value))
 		}
 
 	}
+	writeTable(valueColumns, rows)
 }
 
 func processUpdateChannelCmd(a *digitalstrom.Account, cmd []string) {
 
 	if len(cmd) != 4 {
-		fmt.Println("Error. Bad update channel command. use -> update channel <deviceDisplayID> <channelIndex>")
+		usageln("Error. Bad update channel command. use -> update channel <deviceDisplayID> <channelIndex>")
 		return
 	}
 
@@ -441,51 +917,62 @@ func processUpdateChannelCmd(a *digitalstrom.Account, cmd []string) {
 	channelIndex, err := strconv.Atoi(cmd[3])
 
 	if err != nil {
-		fmt.Printf("\n\rError. '%s' is not a number. Parameter <channelIndex> shall be a number.\r\n", cmd[3])
+		usagef("\n\rError. '%s' is not a number. Parameter <channelIndex> shall be a number.\r\n", cmd[3])
 		return
 	}
 
 	channel, err := a.GetOutputChannel(deviceID, channelIndex)
 	if err != nil {
-		fmt.Println("Error, unable to update output channel value. Output channel not found.")
+		errorln("Error, unable to update output channel value. Output channel not found.")
 		fmt.Println(err)
 		return
 	}
 
-	_, err = a.PollChannelValue(channel)
+	raw, err := a.PollChannelValue(channel)
 	if err != nil {
-		fmt.Printf("Error. Unable to update channel '%d' of device '%s'.\r\n", channelIndex, deviceID)
+		errorf("Error. Unable to update channel '%d' of device '%s'.\r\n", channelIndex, deviceID)
 		fmt.Println(err)
 		return
 	}
-	fmt.Printf("Channel updated. New value = %.2d\r\n", channel.Value)
+	value, unit, err := a.GetOutputChannelPhysicalValue(deviceID, channelIndex)
+	if err != nil {
+		errorln(err)
+		return
+	}
+	if writeTable(valueColumns, [][]interface{}{channelRow(deviceID, channel, raw)}) {
+		return
+	}
+	fmt.Printf("Channel updated. New value = %s %s\r\n", strconv.FormatFloat(value, 'f', 1, 64), unit)
 }
 
 func processUpdateSensorCmd(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) != 4 {
-		fmt.Println("Error. Bad update sensor command. use -> update sensor <deviceDisplayID> <sensorIndex>")
+		usageln("Error. Bad update sensor command. use -> update sensor <deviceDisplayID> <sensorIndex>")
 		return
 	}
 
 	index, err := strconv.Atoi(cmd[3])
 	if err != nil {
-		fmt.Printf("\n\rError. '%s' is not a number. Parameter <sensorIndex> should be a number.\r\n", cmd[3])
+		usagef("\n\rError. '%s' is not a number. Parameter <sensorIndex> should be a number.\r\n", cmd[3])
 		return
 	}
 
 	sensor, err := a.GetSensor(cmd[2], index)
 	if err != nil {
-		fmt.Println("Error, unable to update sensor value. Sensor not found.")
+		errorln("Error, unable to update sensor value. Sensor not found.")
 		fmt.Println(err)
 		return
 	}
 
-	_, err = a.PollSensorValue(sensor)
+	value, err := a.PollSensorValue(sensor)
 	if err != nil {
-		fmt.Printf("Error. Unable to update sensor '%d' of device '%s'.\r\n", index, cmd[2])
+		errorf("Error. Unable to update sensor '%d' of device '%s'.\r\n", index, cmd[2])
 		fmt.Println(err)
 		return
 	}
+	if writeTable(valueColumns, [][]interface{}{sensorRow(cmd[2], sensor, value)}) {
+		return
+	}
 
 	fmt.Printf("Sensor updated. New value = %.2f\r\n", sensor.Value)
 
@@ -493,50 +980,60 @@ func processUpdateSensorCmd(a *digitalstrom.Account, cmd []string) {
 
 func processUpdateMeterValueCmd(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) != 3 {
-		fmt.Println("Error. Bad get meter command. use -> get meter <circuitDisplayID>")
+		usageln("Error. Bad get meter command. use -> get meter <circuitDisplayID>")
 		return
 	}
-	circuit, ok := a.Circuits[cmd[2]]
-	if !ok {
-		fmt.Printf("Unable to find circuit with displayID '%s'.\r\n", cmd[2])
+	circuit, err := a.GetCircuit(cmd[2])
+	if err != nil {
+		errorf("Unable to find circuit with displayID '%s'.\r\n", cmd[2])
 		return
 	}
 
 	value, err := a.PollCircuitMeterValue(circuit)
 	if err != nil {
-		fmt.Println("Error")
+		errorln("Error")
 		fmt.Println(err)
 		return
 	}
+	if writeTable(valueColumns, [][]interface{}{circuitRow("meter", circuit, value)}) {
+		return
+	}
 	fmt.Println("Current metering value of circuit with id '" + circuit.DisplayID + "' = " + strconv.Itoa(value) + " Ws")
 }
 
 func processUpdateConsumptionCmd(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) != 3 {
-		fmt.Println("Error. Bad update consumption command. use -> update consumption <circuitDisplayID>")
+		usageln("Error. Bad update consumption command. use -> update consumption <circuitDisplayID>")
 		return
 	}
-	circuit, ok := a.Circuits[cmd[2]]
-	if !ok {
-		fmt.Printf("Unable to find circuit with displayID '%s'\r\n", cmd[2])
+	circuit, err := a.GetCircuit(cmd[2])
+	if err != nil {
+		errorf("Unable to find circuit with displayID '%s'\r\n", cmd[2])
 		return
 	}
 
 	value, err := a.PollCircuitConsumptionValue(circuit)
 	if err != nil {
-		fmt.Println("Error")
+		errorln("Error")
 		fmt.Println(err)
 		return
 	}
+	if writeTable(valueColumns, [][]interface{}{circuitRow("consumption", circuit, value)}) {
+		return
+	}
 	fmt.Printf("Current consumption of circuit with id '%s' = %dW\r\n", circuit.DisplayID, value)
 }
 
 func processRequestCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 2 {
+		usageln("Error. Not a valid request command. Type 'help' for complete command descriptions.")
+		return
+	}
 	switch cmd[1] {
 	case "structure":
 		_, err := a.RequestStructure()
 		if err != nil {
-			fmt.Println("Unable to receive structure")
+			errorln("Unable to receive structure")
 			fmt.Println(err)
 			return
 		}
@@ -545,7 +1042,7 @@ func processRequestCommand(a *digitalstrom.Account, cmd []string) {
 	case "circuits":
 		_, err := a.RequestCircuits()
 		if err != nil {
-			fmt.Println("Unable to receive circuits")
+			errorln("Unable to receive circuits")
 			fmt.Println(err)
 			return
 		}
@@ -554,10 +1051,13 @@ func processRequestCommand(a *digitalstrom.Account, cmd []string) {
 	case "system":
 		system, err := a.RequestSystemInfo()
 		if err != nil {
-			fmt.Println("Unable to request system info.")
+			errorln("Unable to request system info.")
 			fmt.Println(err)
 			return
 		}
+		if writeJSON(system) {
+			return
+		}
 		fmt.Println()
 		fmt.Println("System Information:")
 		fmt.Printf("                  Version %s/r/n", system.Version)
@@ -569,19 +1069,19 @@ func processRequestCommand(a *digitalstrom.Account, cmd []string) {
 		fmt.Printf("                   Serial %s\r\n", system.Serial)
 		break
 	default:
-		fmt.Printf("Error. '%s' is unknown for get command. Type 'help' for further infos.\r\n", cmd[1])
+		usagef("Error. '%s' is unknown for get command. Type 'help' for further infos.\r\n", cmd[1])
 	}
 }
 
 func processSetCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) == 1 {
-		fmt.Println("\r\rError. Not a valid set command. Type 'help' for complete command descriptions.")
+		usageln("\r\rError. Not a valid set command. Type 'help' for complete command descriptions.")
 		return
 	}
 	switch cmd[1] {
 	case "url":
 		if len(cmd) != 3 {
-			fmt.Println("\r\nError. Parameter <url> missing. Use -> set url <url>.")
+			usageln("\r\nError. Parameter <url> missing. Use -> set url <url>.")
 			return
 		}
 		a.SetURL(cmd[2])
@@ -589,7 +1089,7 @@ func processSetCommand(a *digitalstrom.Account, cmd []string) {
 
 	case "at":
 		if len(cmd) != 3 {
-			fmt.Printf("\r\nError. Parameter <application token> missing. Use -> set at <application token>.\r\n")
+			usagef("\r\nError. Parameter <application token> missing. Use -> set at <application token>.\r\n")
 			return
 		}
 		a.SetApplicationToken(cmd[2])
@@ -597,7 +1097,7 @@ func processSetCommand(a *digitalstrom.Account, cmd []string) {
 
 	case "st":
 		if len(cmd) != 3 {
-			fmt.Printf("\r\nError. Parameter <session token> missing. Use -> set st <session token>.\r\n")
+			usagef("\r\nError. Parameter <session token> missing. Use -> set st <session token>.\r\n")
 			return
 		}
 		a.SetSessionToken(cmd[2])
@@ -609,7 +1109,7 @@ func processSetCommand(a *digitalstrom.Account, cmd []string) {
 	case "max":
 		processSetMaxCommand(a, cmd)
 	default:
-		fmt.Printf("\r\nError. Unknown set command '%s'.\r\n", cmd[1])
+		usagef("\r\nError. Unknown set command '%s'.\r\n", cmd[1])
 	}
 }
 
@@ -617,15 +1117,27 @@ func processSetMaxCommand(a *digitalstrom.Account, cmd []string) {
 	switch cmd[2] {
 	case "parallelpolls":
 		if len(cmd) < 4 {
-			fmt.Printf("\r\nError. Parameter <number of polls> missing. Use -> set max parallelpolls <number of polls>.\r\n")
+			usagef("\r\nError. Parameter <number of polls> missing. Use -> set max parallelpolls <number of polls>.\r\n")
 			return
 		}
 		number, err := strconv.Atoi(cmd[3])
 		if err != nil {
-			fmt.Printf("Error. '%s' is not a valid number of max polls\r\n", cmd[3])
+			usagef("Error. '%s' is not a valid number of max polls\r\n", cmd[3])
 		}
 		a.PollingSetup.MaxParallelPolls = number
 		fmt.Printf("OK. Maximal amount of parallel polls was set to %d.\r\n", number)
+	case "requestspersecond":
+		if len(cmd) < 4 {
+			usagef("\r\nError. Parameter <number of requests> missing. Use -> set max requestspersecond <number of requests>.\r\n")
+			return
+		}
+		number, err := strconv.ParseFloat(cmd[3], 64)
+		if err != nil || number < 0 {
+			usagef("Error. '%s' is not a valid number of requests per second\r\n", cmd[3])
+			return
+		}
+		a.PollingSetup.MaxRequestsPerSecond = number
+		fmt.Printf("OK. Polling is limited to %g requests per second.\r\n", number)
 	}
 }
 
@@ -636,11 +1148,11 @@ func processSetDefaultCmd(a *digitalstrom.Account, cmd []string) {
 		fmt.Println("OK. All polling intervals are set to default.")
 	case "pollinterval":
 		if len(cmd) != 5 {
-			fmt.Println("Error. This is not a valid set command for setting default polling intervals.")
+			usageln("Error. This is not a valid set command for setting default polling intervals.")
 		}
 		interval, err := strconv.Atoi(cmd[4])
 		if err != nil {
-			fmt.Printf("Error. '%s' is not a valid interval value. Must be a number!", cmd[4])
+			usagef("Error. '%s' is not a valid interval value. Must be a number!", cmd[4])
 			return
 		}
 		switch cmd[3] {
@@ -651,7 +1163,7 @@ func processSetDefaultCmd(a *digitalstrom.Account, cmd []string) {
 		case "channel":
 			a.PollingSetup.DefaultSensorsPollingInterval = interval
 		default:
-			fmt.Printf("Error. Unknown parameter '%s'. Should be 'sensor', 'circuit' or 'channel'.\r\n", cmd[3])
+			usagef("Error. Unknown parameter '%s'. Should be 'sensor', 'circuit' or 'channel'.\r\n", cmd[3])
 			return
 		}
 		fmt.Printf("OK. New default polling interval for all %ss are set to %d seconds.\r\n", cmd[3], interval)
@@ -659,70 +1171,170 @@ func processSetDefaultCmd(a *digitalstrom.Account, cmd []string) {
 		fmt.Println("If you want to reset all polling intervals in order to use the default ones")
 		fmt.Println("type 'reset pollingintervals' followed by 'set default pollingintervals'.")
 	default:
-		fmt.Printf("Error. Unkown parameter for setting default '%s'\r\n", cmd[2])
+		usagef("Error. Unkown parameter for setting default '%s'\r\n", cmd[2])
 	}
 }
 
 func processSetUpdateIntervalCmd(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) < 4 {
-		fmt.Println("Error. Not a valid set updateinterval command. Type 'help' for a complete command description.")
+		usageln("Error. Not a valid set updateinterval command. Type 'help' for a complete command description.")
 		return
 	}
 
 	id := ""
 	interval, err := strconv.Atoi(cmd[len(cmd)-1])
 	if err != nil {
-		fmt.Printf("'%s' is not a valid value for interval seconds.\r\n", cmd[len(cmd)-1])
+		usagef("'%s' is not a valid value for interval seconds.\r\n", cmd[len(cmd)-1])
 		return
 	}
 	switch cmd[2] {
 	case "sensor":
 		index, err := strconv.Atoi(cmd[4])
 		if err != nil {
-			fmt.Printf("'%s' is not a valid sensor index. Sensor index must be a number.\r\n", cmd[4])
+			usagef("'%s' is not a valid sensor index. Sensor index must be a number.\r\n", cmd[4])
 			return
 		}
 		_, err = a.GetSensor(cmd[3], index)
 		if err != nil {
-			fmt.Printf("Device '%s' has no sensor with index '%d'\r\n", cmd[3], index)
+			errorf("Device '%s' has no sensor with index '%d'\r\n", cmd[3], index)
 			return
 		}
 		id = "sensor." + cmd[3] + "." + cmd[4]
 	case "channel":
-		dev, ok := a.Devices[cmd[3]]
-		if !ok {
-			fmt.Printf("Error. No device with id '%s' found.\r\n", cmd[3])
+		dev, err := a.GetDevice(cmd[3])
+		if err != nil {
+			errorf("Error. No device with id '%s' found.\r\n", cmd[3])
 			return
 		}
-		_, err := dev.GetOutputChannel(digitalstrom.OutputChannelType(cmd[4]))
+		_, err = dev.GetOutputChannel(digitalstrom.OutputChannelType(cmd[4]))
 		if err != nil {
-			fmt.Printf("Device '%s' has no output channel of type %s", cmd[3], cmd[4])
+			errorf("Device '%s' has no output channel of type %s", cmd[3], cmd[4])
 			return
 		}
 		id = "channel." + cmd[3] + "." + cmd[4]
 	case "circuit":
-		circuit, ok := a.Circuits[cmd[3]]
-		if !ok {
-			fmt.Printf("Error. No circuit with ID '%s' found.\r\n", cmd[3])
+		circuit, err := a.GetCircuit(cmd[3])
+		if err != nil {
+			errorf("Error. No circuit with ID '%s' found.\r\n", cmd[3])
 			return
 		}
 		id = "circuit." + circuit.DisplayID
 	default:
-		fmt.Printf("Unknown element id for update interval command: '%s'\r\n", cmd[2])
+		usagef("Unknown element id for update interval command: '%s'\r\n", cmd[2])
 		return
 	}
 	err = a.SetPollingInterval(id, interval)
 	if err != nil {
-		fmt.Printf("Error. Unable to set update interval for %s.\r\n", id)
+		errorf("Error. Unable to set update interval for %s.\r\n", id)
 		fmt.Println(err)
 		return
 	}
 	fmt.Printf("OK. Update interval for %s was set to %d seconds.\r\n", id, interval)
 }
 
+func processStructureCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 4 {
+		usageln("Error. Not a correct command. Use -> structure <name|move|addzone|removezone|addgroup|groupadd|groupremove> ... Type 'help' for complete command descriptions.")
+		return
+	}
+	// all numbers are ids, the last argument of 'name', 'addzone' and 'addgroup' is a name that may contain spaces
+	number := func(i int) (int, bool) {
+		id, err := strconv.Atoi(cmd[i])
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. ID must be a number.\r\n", cmd[i])
+			return 0, false
+		}
+		return id, true
+	}
+
+	var err error
+	switch cmd[1] {
+	case "name":
+		if len(cmd) < 5 {
+			usageln("Error. Not a correct command. Use -> structure name <device|zone|floor> <id> <name>.")
+			return
+		}
+		name := strings.Join(cmd[4:], " ")
+		switch cmd[2] {
+		case "device":
+			err = a.SetDeviceName(cmd[3], name)
+		case "zone":
+			zoneID, ok := number(3)
+			if !ok {
+				return
+			}
+			err = a.SetZoneName(zoneID, name)
+		case "floor":
+			floorID, ok := number(3)
+			if !ok {
+				return
+			}
+			err = a.SetFloorName(floorID, name)
+		default:
+			usagef("\r\nError. '%s' is an unknown target. Should be 'device', 'zone' or 'floor'.\r\n", cmd[2])
+			return
+		}
+	case "move":
+		zoneID, ok := number(3)
+		if !ok {
+			return
+		}
+		err = a.MoveDevice(cmd[2], zoneID)
+	case "addzone":
+		zoneID, ok := number(2)
+		if !ok {
+			return
+		}
+		err = a.AddZone(zoneID, strings.Join(cmd[3:], " "))
+	case "removezone":
+		zoneID, ok := number(2)
+		if !ok {
+			return
+		}
+		err = a.RemoveZone(zoneID)
+	case "addgroup":
+		if len(cmd) < 6 {
+			usageln("Error. Not a correct command. Use -> structure addgroup <zoneID> <groupID> <color> <name>.")
+			return
+		}
+		zoneID, ok := number(2)
+		if !ok {
+			return
+		}
+		groupID, ok := number(3)
+		if !ok {
+			return
+		}
+		color, ok := number(4)
+		if !ok {
+			return
+		}
+		err = a.AddGroup(zoneID, groupID, strings.Join(cmd[5:], " "), color)
+	case "groupadd", "groupremove":
+		groupID, ok := number(2)
+		if !ok {
+			return
+		}
+		if cmd[1] == "groupadd" {
+			err = a.GroupAddDevice(groupID, cmd[3])
+		} else {
+			err = a.GroupRemoveDevice(groupID, cmd[3])
+		}
+	default:
+		usagef("\r\nError. '%s' is an unknown parameter for structure.\r\n", cmd[1])
+		return
+	}
+	if err != nil {
+		errorln("Error. Unable to change the structure.")
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("OK")
+}
+
 func processCmdCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) <= 1 {
-		fmt.Println("\r\rError. Not a valid command. Type 'help' for complete command descriptions.")
+		usageln("\r\rError. Not a valid command. Type 'help' for complete command descriptions.")
 		return
 	}
 
@@ -733,24 +1345,46 @@ func processCmdCommand(a *digitalstrom.Account, cmd []string) {
 		processOnCommand(a, cmd, false)
 	case "channel":
 		processChannelCommand(a, cmd)
+	case "channels":
+		processChannelsCommand(a, cmd)
+	case "scene":
+		processSceneCommand(a, cmd)
+	case "operationmode", "nominalvalue", "controlvalue":
+		processTemperatureControlCommand(a, cmd)
+	case "state":
+		processStateCommand(a, cmd)
 	default:
-		fmt.Printf("\r\nError. '%s' is an unknown parameter for cmd.\r\n", cmd[1])
+		usagef("\r\nError. '%s' is an unknown parameter for cmd.\r\n", cmd[1])
 	}
 }
 
+func processStateCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) != 4 || (cmd[3] != "on" && cmd[3] != "off") {
+		usageln("Error. Not a correct command. Use -> cmd state <stateName> <on|off>.")
+		return
+	}
+	err := a.SetApartmentState(cmd[2], cmd[3] == "on")
+	if err != nil {
+		errorf("Error. Unable to set state '%s'.\r\n", cmd[2])
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("OK")
+}
+
 func processOnCommand(a *digitalstrom.Account, cmd []string, on bool) {
 	if len(cmd) != 3 {
-		fmt.Println("\r\rError. Not a valid set on|off command. Use -> set on|off <deviceID>.")
+		usageln("\r\rError. Not a valid set on|off command. Use -> set on|off <deviceID>.")
 		return
 	}
-	dev, ok := a.Devices[cmd[2]]
-	if !ok {
-		fmt.Printf("Error. Device with display ID '%s' not found.\r\n", cmd[1])
+	dev, err := a.GetDevice(cmd[2])
+	if err != nil {
+		errorf("Error. Device with display ID '%s' not found.\r\n", cmd[1])
 		return
 	}
-	err := a.TurnOn(dev, on)
+	err = a.TurnOn(dev, on)
 	if err != nil {
-		fmt.Printf("Error. Unable to set device '%s' on|off.\r\n", cmd[2])
+		errorf("Error. Unable to set device '%s' on|off.\r\n", cmd[2])
 		fmt.Println(err)
 		return
 	}
@@ -759,131 +1393,316 @@ func processOnCommand(a *digitalstrom.Account, cmd []string, on bool) {
 
 func processChannelCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) != 5 {
-		fmt.Println("Error. Not a correct command. Use -> cmd channel <deviceId> <channeType> <vaue>.")
+		usageln("Error. Not a correct command. Use -> cmd channel <deviceId> <channeType> <vaue>.")
 		return
 	}
-	device, ok := a.Devices[cmd[2]]
-	if !ok {
-		fmt.Printf("\r\nError. No device with id '%s' found.\r\n", cmd[2])
+	device, err := a.GetDevice(cmd[2])
+	if err != nil {
+		errorf("\r\nError. No device with id '%s' found.\r\n", cmd[2])
 		return
 	}
 	channel, err := device.GetOutputChannel(digitalstrom.OutputChannelType(cmd[3]))
 	if err != nil {
-		fmt.Printf("\r\nError. Unable to get channel '%s'.\r\n", cmd[3])
+		errorf("\r\nError. Unable to get channel '%s'.\r\n", cmd[3])
 		return
 	}
-	err = a.SetOutputChannelValue(channel, cmd[4])
+	if value, e := strconv.ParseFloat(cmd[4], 64); e == nil {
+		err = a.SetOutputChannelPhysicalValue(channel, value)
+	} else {
+		err = a.SetOutputChannelValue(channel, cmd[4])
+	}
 	if err != nil {
-		fmt.Printf("\r\nUnable to set value (%s) for channel '%s'.\r\n", cmd[4], cmd[3])
+		errorf("\r\nUnable to set value (%s) for channel '%s'.\r\n", cmd[4], cmd[3])
 		fmt.Println(err)
 		return
 	}
 	fmt.Printf("\r\nOK. Channel '%s' of device '%s' was set to '%s' sucessfuly.\r\n", cmd[3], cmd[2], cmd[4])
 }
 
+func processChannelsCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 4 {
+		usageln("Error. Not a correct command. Use -> cmd channels <deviceId> <channelType>=<value> [<channelType>=<value> ...].")
+		return
+	}
+	device, err := a.GetDevice(cmd[2])
+	if err != nil {
+		errorf("\r\nError. No device with id '%s' found.\r\n", cmd[2])
+		return
+	}
+	values := make(map[digitalstrom.OutputChannelType]float64)
+	for _, arg := range cmd[3:] {
+		pair := strings.SplitN(arg, "=", 2)
+		if len(pair) != 2 {
+			usagef("\r\nError. '%s' is not a valid channel value, <channelType>=<value> expected.\r\n", arg)
+			return
+		}
+		value, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil {
+			usagef("\r\nError. '%s' is not a number.\r\n", pair[1])
+			return
+		}
+		values[digitalstrom.OutputChannelType(pair[0])] = value
+	}
+	err = a.SetOutputChannelValues(device, values, true)
+	if err != nil {
+		errorf("\r\nUnable to set channel values of device '%s'.\r\n", cmd[2])
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("\r\nOK. %d channels of device '%s' were set sucessfuly.\r\n", len(values), cmd[2])
+}
+
+func processSceneCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 4 {
+		usageln("Error. Not a correct command. Use -> cmd scene <zone|device|apartment> ... Type 'help' for complete command descriptions.")
+		return
+	}
+	// optional parameter 'force' at the end of the command
+	force := cmd[len(cmd)-1] == "force"
+	if force {
+		cmd = cmd[:len(cmd)-1]
+	}
+	scene, err := strconv.Atoi(cmd[len(cmd)-1])
+	if err != nil {
+		usagef("\n\rError. '%s' is not a number. Scene number must be a number.\r\n", cmd[len(cmd)-1])
+		return
+	}
+
+	var callErr error
+	switch cmd[2] {
+	case "zone":
+		if len(cmd) != 6 {
+			usageln("Error. Not a correct command. Use -> cmd scene zone <zoneID> <groupID> <scene> [force].")
+			return
+		}
+		zoneID, err := strconv.Atoi(cmd[3])
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. Zone ID must be a number.\r\n", cmd[3])
+			return
+		}
+		groupID, err := strconv.Atoi(cmd[4])
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. Group ID must be a number.\r\n", cmd[4])
+			return
+		}
+		callErr = a.CallZoneScene(zoneID, digitalstrom.ApplicationType(groupID), digitalstrom.SceneNumber(scene), force)
+	case "device":
+		if len(cmd) != 5 {
+			usageln("Error. Not a correct command. Use -> cmd scene device <deviceID> <scene> [force].")
+			return
+		}
+		device, err := a.GetDevice(cmd[3])
+		if err != nil {
+			errorf("\r\nError. No device with id '%s' found.\r\n", cmd[3])
+			return
+		}
+		callErr = a.CallDeviceScene(device, digitalstrom.SceneNumber(scene), force)
+	case "apartment":
+		if len(cmd) != 5 {
+			usageln("Error. Not a correct command. Use -> cmd scene apartment <groupID> <scene> [force].")
+			return
+		}
+		groupID, err := strconv.Atoi(cmd[3])
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. Group ID must be a number.\r\n", cmd[3])
+			return
+		}
+		callErr = a.CallApartmentScene(digitalstrom.ApplicationType(groupID), digitalstrom.SceneNumber(scene), force)
+	default:
+		usagef("\r\nError. '%s' is an unknown scene target. Should be 'zone', 'device' or 'apartment'.\r\n", cmd[2])
+		return
+	}
+	if callErr != nil {
+		errorf("\r\nError. Unable to call scene %d (%s).\r\n", scene, digitalstrom.SceneNumber(scene).GetName())
+		fmt.Println(callErr)
+		return
+	}
+	fmt.Printf("\r\nOK. Scene %d (%s) called.\r\n", scene, digitalstrom.SceneNumber(scene).GetName())
+}
+
+func processTemperatureControlCommand(a *digitalstrom.Account, cmd []string) {
+	if len(cmd) < 4 {
+		usageln("Error. Not a correct command. Type 'help' for complete command descriptions.")
+		return
+	}
+	zoneID, err := strconv.Atoi(cmd[2])
+	if err != nil {
+		usagef("\n\rError. '%s' is not a number. Zone ID must be a number.\r\n", cmd[2])
+		return
+	}
+	switch cmd[1] {
+	case "operationmode":
+		if len(cmd) != 4 {
+			usageln("Error. Not a correct command. Use -> cmd operationmode <zoneID> <mode>.")
+			return
+		}
+		mode, err := strconv.Atoi(cmd[3])
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. Operation mode must be a number.\r\n", cmd[3])
+			return
+		}
+		err = a.SetZoneOperationMode(zoneID, digitalstrom.OperationMode(mode))
+		if err != nil {
+			errorf("\r\nError. Unable to set operation mode of zone %d.\r\n", zoneID)
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("\r\nOK. Operation mode of zone %d set to %d (%s).\r\n", zoneID, mode, digitalstrom.OperationMode(mode).GetName())
+	case "nominalvalue":
+		if len(cmd) != 5 {
+			usageln("Error. Not a correct command. Use -> cmd nominalvalue <zoneID> <mode> <temperature>.")
+			return
+		}
+		mode, err := strconv.Atoi(cmd[3])
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. Operation mode must be a number.\r\n", cmd[3])
+			return
+		}
+		value, err := strconv.ParseFloat(cmd[4], 64)
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. Temperature must be a number.\r\n", cmd[4])
+			return
+		}
+		err = a.SetZoneTemperatureControlValues(zoneID, map[digitalstrom.OperationMode]float64{digitalstrom.OperationMode(mode): value})
+		if err != nil {
+			errorf("\r\nError. Unable to set nominal value of zone %d.\r\n", zoneID)
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("\r\nOK. Nominal value of zone %d for %s set to %s.\r\n", zoneID, digitalstrom.OperationMode(mode).GetName(), cmd[4])
+	case "controlvalue":
+		if len(cmd) != 4 {
+			usageln("Error. Not a correct command. Use -> cmd controlvalue <zoneID> <value>.")
+			return
+		}
+		value, err := strconv.ParseFloat(cmd[3], 64)
+		if err != nil {
+			usagef("\n\rError. '%s' is not a number. Control value must be a number.\r\n", cmd[3])
+			return
+		}
+		err = a.SetZoneControlValue(zoneID, value)
+		if err != nil {
+			errorf("\r\nError. Unable to set control value of zone %d.\r\n", zoneID)
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("\r\nOK. Control value of zone %d set to %s.\r\n", zoneID, cmd[3])
+	}
+}
+
 func processPrintCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) == 1 {
-		fmt.Println("\r\rError. Not a valid print command. use -> print <what to print>. Type 'print help' for complete command description.")
+		usageln("\r\rError. Not a valid print command. use -> print <what to print>. Type 'print help' for complete command description.")
 		return
 	}
+	snapshot := a.Snapshot()
 	switch cmd[1] {
 	case "help":
 		printHelp()
 	case "structure":
-		processPrintStructureCmd(a, cmd)
+		processPrintStructureCmd(snapshot, cmd)
 	case "device":
-		processPrintDeviceCmd(a, cmd)
+		processPrintDeviceCmd(snapshot, cmd)
 	case "devices":
-		processPrintDevicesCmd(a, cmd)
+		processPrintDevicesCmd(snapshot, cmd)
 	case "circuit":
-		processPrintCircuitCmd(a, cmd)
+		processPrintCircuitCmd(snapshot, cmd)
 	case "circuits":
-		processPrintCircuitsCmd(a, cmd)
+		processPrintCircuitsCmd(snapshot, cmd)
 	case "floor":
-		processPrintFloorCmd(a, cmd)
+		processPrintFloorCmd(snapshot, cmd)
 	case "zone":
-		processPrintZoneCmd(a, cmd)
+		processPrintZoneCmd(snapshot, cmd)
 	case "group":
-		processPrintGroupCmd(a, cmd)
+		processPrintGroupCmd(snapshot, cmd)
 	case "temperatureControls":
-		processPrintTemperatureControlsCmd(a, cmd)
+		processPrintTemperatureControlsCmd(snapshot, cmd)
 	case "temperatureControl":
-		processPrintTemperatureControlCmd(a, cmd)
+		processPrintTemperatureControlCmd(snapshot, cmd)
 	case "token":
 		fmt.Printf("  application token = %s\r\n", a.Connection.ApplicationToken)
-		fmt.Printf("      session token = %s\r\n", a.Connection.SessionToken)
+		fmt.Printf("      session token = %s\r\n", a.Connection.GetSessionToken())
 	case "url":
 		fmt.Printf("          base url = %s\r\n", a.Connection.BaseURL)
 	default:
-		fmt.Printf(" Error. Unknown parameter '%s' for print command.\r\n", cmd[1])
+		usagef(" Error. Unknown parameter '%s' for print command.\r\n", cmd[1])
 	}
 }
 
 func processListCommand(a *digitalstrom.Account, cmd []string) {
 	if len(cmd) == 1 {
-		fmt.Println("\r\rError. Not a valid list command. use -> list <what to list>. Type 'print help' for complete command description.")
+		usageln("\r\rError. Not a valid list command. use -> list <what to list>. Type 'print help' for complete command description.")
 		return
 	}
+	snapshot := a.Snapshot()
 	switch cmd[1] {
 	case "devices":
-		printDeviceList(a)
+		printDeviceList(snapshot)
 	case "zones":
-		printZoneList(a)
+		printZoneList(snapshot)
 	case "floors":
-		printFloorList(a)
+		printFloorList(snapshot)
 	case "groups":
-		printGroupList(a)
+		printGroupList(snapshot)
 	case "circuits":
-		printCircuitList(a)
+		printCircuitList(snapshot)
+	case "states":
+		printStateList(snapshot)
 	default:
-		fmt.Printf("Error, list '%s' is unknown.\r\n", cmd[1])
+		usagef("Error, list '%s' is unknown.\r\n", cmd[1])
 	}
 }
 
-func processPrintStructureCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintStructureCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) > 3 {
-		fmt.Println("\r\nError. Too many parameters for cmd 'print structure'. use -> print structure [level of depth]")
+		usageln("\r\nError. Too many parameters for cmd 'print structure'. use -> print structure [level of depth]")
+		return
+	}
+	if writeJSON(snapshot.Structure) {
 		return
 	}
 
 	if len(cmd) == 3 {
 		s, err := strconv.Atoi(cmd[2])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as number expected.\r\n", cmd[2])
+			usagef("\n\rError. '%s' is not a number. Level of depth as number expected.\r\n", cmd[2])
 			return
 		}
-		printStructure(a, s+1)
+		printStructure(snapshot, s+1)
 		return
 	}
-	printStructure(a, -1)
+	printStructure(snapshot, -1)
 }
 
-func processPrintZoneCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintZoneCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) < 3 {
-		fmt.Println("\r\nError. No Zone ID given. Use -> print zone <zoneID> [level of depth]")
+		usageln("\r\nError. No Zone ID given. Use -> print zone <zoneID> [level of depth]")
 		return
 	}
 	if len(cmd) > 4 {
-		fmt.Println("\r\nError. Too many parameters. Use -> print zone <zoneID> [level of depth]")
+		usageln("\r\nError. Too many parameters. Use -> print zone <zoneID> [level of depth]")
 		return
 	}
 
 	id, err := strconv.Atoi(cmd[2])
 	if err != nil {
-		fmt.Printf("\n\rError. '%s' is not a number. Zone ID must be a number.\r\n", cmd[2])
+		usagef("\n\rError. '%s' is not a number. Zone ID must be a number.\r\n", cmd[2])
 		return
 	}
 
-	zone, ok := a.Zones[id]
+	zone, ok := snapshot.Zones[id]
 	if !ok {
-		fmt.Printf("\n\rError. Zone with id '%s' was not found.\r\n", cmd[2])
+		errorf("\n\rError. Zone with id '%s' was not found.\r\n", cmd[2])
+		return
+	}
+	if writeJSON(zone) {
 		return
 	}
 	node := generateZoneNode(zone)
 	if len(cmd) == 4 {
 		l, err := strconv.Atoi(cmd[3])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as number expected.\r\n", cmd[3])
+			usagef("\n\rError. '%s' is not a number. Level of depth as number expected.\r\n", cmd[3])
 			return
 		}
 		printNode("", "", true, &node, l+1)
@@ -892,32 +1711,35 @@ func processPrintZoneCmd(a *digitalstrom.Account, cmd []string) {
 	printNode("", "", true, &node, -1)
 }
 
-func processPrintGroupCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintGroupCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) < 3 {
-		fmt.Println("\r\nError. No group id given. Use -> print group <groupID> [level of depth]")
+		usageln("\r\nError. No group id given. Use -> print group <groupID> [level of depth]")
 		return
 	}
 	if len(cmd) > 4 {
-		fmt.Println("\r\nError. Too many parameters. Use -> print group <groupID> [level of depth]")
+		usageln("\r\nError. Too many parameters. Use -> print group <groupID> [level of depth]")
 		return
 	}
 
 	id, err := strconv.Atoi(cmd[2])
 	if err != nil {
-		fmt.Printf("\n\rError. '%s' is not a number. Group ID must be a number. \r\n", cmd[2])
+		usagef("\n\rError. '%s' is not a number. Group ID must be a number. \r\n", cmd[2])
 		return
 	}
 
-	group, ok := a.Groups[id]
+	group, ok := snapshot.Groups[id]
 	if !ok {
-		fmt.Printf("\n\rError. Group with id '%s' could not be found.\r\n", cmd[2])
+		errorf("\n\rError. Group with id '%s' could not be found.\r\n", cmd[2])
+		return
+	}
+	if writeJSON(group) {
 		return
 	}
 	node := generateGroupNode(group)
 	if len(cmd) == 4 {
 		l, err := strconv.Atoi(cmd[3])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as number expected.\r\n", cmd[3])
+			usagef("\n\rError. '%s' is not a number. Level of depth as number expected.\r\n", cmd[3])
 			return
 		}
 		printNode("", "", true, &node, l+1)
@@ -926,68 +1748,77 @@ func processPrintGroupCmd(a *digitalstrom.Account, cmd []string) {
 	printNode("", "", true, &node, -1)
 }
 
-func processPrintTemperatureControlsCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintTemperatureControlsCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) != 2 {
-		fmt.Println("\r\nError. Invalid command. Use -> print temperatureControls]")
+		usageln("\r\nError. Invalid command. Use -> print temperatureControls]")
+		return
+	}
+	if writeJSON(snapshot.TemperatureControl) {
 		return
 	}
 	node := node{name: "Temperature Control States"}
 
-	for _, tempCtrlState := range a.TemperatureControl {
+	for _, tempCtrlState := range snapshot.TemperatureControl {
 		node.childs = append(node.childs, generateTemperatureControlStateNode(tempCtrlState))
 	}
 
 	printNode("", "", true, &node, -1)
 }
 
-func processPrintTemperatureControlCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintTemperatureControlCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) != 3 {
-		fmt.Println("\r\nError. Invalid print temperatureControl command. Use -> print temperatureControl <zoneId>]")
+		usageln("\r\nError. Invalid print temperatureControl command. Use -> print temperatureControl <zoneId>]")
 		return
 	}
 
 	id, err := strconv.Atoi(cmd[2])
 	if err != nil {
-		fmt.Printf("\n\rError. '%s' is not a number. Zone ID must be a number.\r\n", cmd[2])
+		usagef("\n\rError. '%s' is not a number. Zone ID must be a number.\r\n", cmd[2])
 		return
 	}
-	tempCtrlState, ok := a.TemperatureControl[id]
+	tempCtrlState, ok := snapshot.TemperatureControl[id]
 	if !ok {
-		fmt.Printf("\n\rError. Found no temperature control state for zone %d.\r\n", id)
+		errorf("\n\rError. Found no temperature control state for zone %d.\r\n", id)
 		return
 	}
 
+	if writeJSON(tempCtrlState) {
+		return
+	}
 	node := generateTemperatureControlStateNode(tempCtrlState)
 
 	printNode("", "", true, &node, -1)
 }
 
-func processPrintFloorCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintFloorCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) < 3 {
-		fmt.Println("\r\nError. No floor id given. Use -> print floor <floorID> [level of depth]")
+		usageln("\r\nError. No floor id given. Use -> print floor <floorID> [level of depth]")
 		return
 	}
 	if len(cmd) > 4 {
-		fmt.Println("\r\nError. Too many parameters. Use -> print floor <floorID> [level of depth]")
+		usageln("\r\nError. Too many parameters. Use -> print floor <floorID> [level of depth]")
 		return
 	}
 
 	id, err := strconv.Atoi(cmd[2])
 	if err != nil {
-		fmt.Printf("\n\rError. '%s' is not a number. Floor ID must be a number.\r\n", cmd[2])
+		usagef("\n\rError. '%s' is not a number. Floor ID must be a number.\r\n", cmd[2])
 		return
 	}
 
-	floor, ok := a.Floors[id]
+	floor, ok := snapshot.Floors[id]
 	if !ok {
-		fmt.Printf("\n\rError. Floor with id '%s' was not found.\r\n", cmd[2])
+		errorf("\n\rError. Floor with id '%s' was not found.\r\n", cmd[2])
+		return
+	}
+	if writeJSON(floor) {
 		return
 	}
 	node := generateFloorNode(floor)
 	if len(cmd) == 4 {
 		l, err := strconv.Atoi(cmd[3])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as number expected. \r\n", cmd[3])
+			usagef("\n\rError. '%s' is not a number. Level of depth as number expected. \r\n", cmd[3])
 			return
 		}
 		printNode("", "", true, &node, l+1)
@@ -996,25 +1827,28 @@ func processPrintFloorCmd(a *digitalstrom.Account, cmd []string) {
 	printNode("", "", true, &node, -1)
 }
 
-func processPrintDeviceCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintDeviceCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) < 3 {
-		fmt.Println("\r\nError. No device id given. Use -> print device <deviceDisplayID> [level of depth]")
+		usageln("\r\nError. No device id given. Use -> print device <deviceDisplayID> [level of depth]")
 		return
 	}
 	if len(cmd) > 4 {
-		fmt.Println("\r\nError. Too many parameters. Use -> print device <deviceDisplayID> [level of depth]")
+		usageln("\r\nError. Too many parameters. Use -> print device <deviceDisplayID> [level of depth]")
 		return
 	}
-	device, ok := a.Devices[cmd[2]]
+	device, ok := snapshot.Devices[cmd[2]]
 	if !ok {
-		fmt.Printf("\n\rError. Device with displayID '%s' could not be found.\r\n", cmd[2])
+		errorf("\n\rError. Device with displayID '%s' could not be found.\r\n", cmd[2])
+		return
+	}
+	if writeJSON(device) {
 		return
 	}
 	node := generateDeviceNode(device)
 	if len(cmd) == 4 {
 		l, err := strconv.Atoi(cmd[3])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[3])
+			usagef("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[3])
 			return
 		}
 		printNode("", "", true, &node, l+1)
@@ -1023,13 +1857,16 @@ func processPrintDeviceCmd(a *digitalstrom.Account, cmd []string) {
 	printNode("", "", true, &node, -1)
 }
 
-func processPrintDevicesCmd(a *digitalstrom.Account, cmd []string) {
-	node := generateDevicesNode(a)
+func processPrintDevicesCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
+	if writeJSON(snapshot.Devices) {
+		return
+	}
+	node := generateDevicesNode(snapshot)
 
 	if len(cmd) == 3 {
 		l, err := strconv.Atoi(cmd[2])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[2])
+			usagef("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[2])
 			return
 		}
 		printNode("", "", true, &node, l+1)
@@ -1038,22 +1875,25 @@ func processPrintDevicesCmd(a *digitalstrom.Account, cmd []string) {
 	printNode("", "", true, &node, -1)
 }
 
-func processPrintCircuitCmd(a *digitalstrom.Account, cmd []string) {
+func processPrintCircuitCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
 	if len(cmd) <= 2 {
-		fmt.Println("Error. Bad print circuit command. Use -> print cuircuit <circuitID> [level of depth]")
+		usageln("Error. Bad print circuit command. Use -> print cuircuit <circuitID> [level of depth]")
 		return
 	}
-	circuit, ok := a.Circuits[cmd[2]]
+	circuit, ok := snapshot.Circuits[cmd[2]]
 	if !ok {
-		fmt.Printf("\r\nError. Unable to find circuit with id '%s'.\r\n", cmd[2])
+		errorf("\r\nError. Unable to find circuit with id '%s'.\r\n", cmd[2])
 		return
 	}
 
+	if writeJSON(circuit) {
+		return
+	}
 	node := generateCircuitNode(circuit)
 	if len(cmd) == 4 {
 		l, err := strconv.Atoi(cmd[3])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[2])
+			usagef("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[2])
 			return
 		}
 		printNode("", "", true, &node, l+1)
@@ -1063,13 +1903,16 @@ func processPrintCircuitCmd(a *digitalstrom.Account, cmd []string) {
 
 }
 
-func processPrintCircuitsCmd(a *digitalstrom.Account, cmd []string) {
-	node := generateCircuitsNode(a)
+func processPrintCircuitsCmd(snapshot *digitalstrom.Snapshot, cmd []string) {
+	if writeJSON(snapshot.Circuits) {
+		return
+	}
+	node := generateCircuitsNode(snapshot)
 
 	if len(cmd) == 3 {
 		l, err := strconv.Atoi(cmd[2])
 		if err != nil {
-			fmt.Printf("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[2])
+			usagef("\n\rError. '%s' is not a number. Level of depth as mumber expected.\r\n", cmd[2])
 			return
 		}
 		printNode("", "", true, &node, l+1)
@@ -1168,10 +2011,10 @@ func generateGroupNode(group *digitalstrom.Group) node {
 	return n
 }
 
-func generateDevicesNode(a *digitalstrom.Account) node {
+func generateDevicesNode(snapshot *digitalstrom.Snapshot) node {
 	n := node{name: "Devices"}
 
-	for _, device := range a.Devices {
+	for _, device := range snapshot.Devices {
 		n.childs = append(n.childs, generateDeviceNode(device))
 	}
 
@@ -1239,16 +2082,47 @@ func generateOutputChannelNode(channel *digitalstrom.OutputChannel) node {
 	n.elems = append(n.elems, "ID    "+channel.ChannelID)
 	n.elems = append(n.elems, "Type  "+string(channel.ChannelType))
 	n.elems = append(n.elems, "Index "+strconv.Itoa(channel.ChannelIndex))
-	n.elems = append(n.elems, "Value "+strconv.Itoa(channel.Value))
+	n.elems = append(n.elems, "Value "+strconv.Itoa(channel.Value)+" (raw)")
+	if value, unit := channel.PhysicalValue(); unit != digitalstrom.CUnone {
+		n.elems = append(n.elems, "      "+strconv.FormatFloat(value, 'f', 1, 64)+" "+string(unit))
+	}
 
 	return n
 
 }
 
-func generateCircuitsNode(a *digitalstrom.Account) node {
+func generatePropertyQueryNode(name string, result *digitalstrom.PropertyQueryNode) node {
+	n := node{name: name}
+
+	keys := []string{}
+	for key := range result.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		n.elems = append(n.elems, toLen(key, 20)+" "+fmt.Sprint(result.Values[key]))
+	}
+	keys = keys[:0]
+	for key := range result.Children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for i, child := range result.Children[key] {
+			childName := key
+			if len(result.Children[key]) > 1 {
+				childName += " [" + strconv.Itoa(i) + "]"
+			}
+			n.childs = append(n.childs, generatePropertyQueryNode(childName, child))
+		}
+	}
+	return n
+}
+
+func generateCircuitsNode(snapshot *digitalstrom.Snapshot) node {
 	n := node{name: "Circuits"}
 
-	for _, circuit := range a.Circuits {
+	for _, circuit := range snapshot.Circuits {
 		n.childs = append(n.childs, generateCircuitNode(circuit))
 	}
 
@@ -1276,16 +2150,32 @@ func generateCircuitNode(c *digitalstrom.Circuit) node {
 func printProgramArguments() {
 	fmt.Println("possible commands: console [-at <application token>]")
 	fmt.Println("                           [-url <url>]")
+	fmt.Println("                           [-config <file>]")
+	fmt.Println("                           [-p <profile>]")
 	fmt.Println("                           [-r <url> <username> <password> <application name>]")
 	fmt.Println("                           [-h]")
 	fmt.Println("                           [--help]")
+	fmt.Println("                           [<command> [--json|--csv]]")
 	fmt.Println()
-	fmt.Println("      -at     set the application token")
+	fmt.Println("      -at     set the application token, DSS_APPLICATION_TOKEN is used if not set")
 	fmt.Println("      -url    set the server address (including protocol and port)")
-	fmt.Println("      -r      registers a new application")
+	fmt.Println("      -config use another config file than ~/.config/dsconsole/config.json")
+	fmt.Println("      -p      use the profile with the given name instead of the active one")
+	fmt.Println("      -r      registers a new application, the token is saved to the active profile")
 	fmt.Println("      -h      prints this help screen")
 	fmt.Println("      --help  prints this help screen")
 	fmt.Println()
+	fmt.Println("   A command executes a single console command, e.g. 'list devices' or 'cmd on <deviceID>', and exits")
+	fmt.Println("   instead of starting the interactive console. The account is initialized before, if the command needs it.")
+	fmt.Println("      --json  writes the result of list, print, update, property and request commands as json to stdout")
+	fmt.Println("      --csv   writes the result of list, update and property commands as csv to stdout")
+	fmt.Println("   All other messages are written to stderr then. Exit codes:")
+	fmt.Println("      0       success")
+	fmt.Println("      1       the command failed")
+	fmt.Println("      2       invalid command or parameters")
+	fmt.Println("      3       the dSS is not reachable or the account could not be initialized")
+	fmt.Println("      4       the dSS refused the application token")
+	fmt.Println()
 }
 
 func printWelcomeMsg() {
@@ -1316,15 +2206,28 @@ func printHelp() {
 	fmt.Println("             cmd on <deviceID>")
 	fmt.Println("                 off <deviceID>")
 	fmt.Println("                 channel <deviceID> <channelType> <value>")
+	fmt.Println("                 channels <deviceID> <channelType>=<value> [<channelType>=<value> ...]")
+	fmt.Println("                 scene zone <zoneID> <groupID> <scene> [force]")
+	fmt.Println("                 scene device <deviceID> <scene> [force]")
+	fmt.Println("                 scene apartment <groupID> <scene> [force]")
+	fmt.Println("                 operationmode <zoneID> <mode>")
+	fmt.Println("                 nominalvalue <zoneID> <mode> <temperature>")
+	fmt.Println("                 controlvalue <zoneID> <value>")
+	fmt.Println("                 state <stateName> <on|off>")
+	fmt.Println("        discover [timeout in s]")
 	fmt.Println("            exit")
 	fmt.Println("            init [applicationToken]")
 	fmt.Println("            list circuits")
 	fmt.Println("                 devices")
 	fmt.Println("                 floors")
 	fmt.Println("                 groups")
+	fmt.Println("                 states")
 	fmt.Println("                 zones")
+	fmt.Println("            load <file> [reconcile]")
 	fmt.Println("            help")
 	fmt.Println("           login")
+	fmt.Println("            mqtt start <address> [topic prefix]")
+	fmt.Println("                 stop")
 	fmt.Println("           print circuit <circuitID> [depth level]")
 	fmt.Println("                 circuits [depth level]")
 	fmt.Println("                 device <deviceID> [depth level]")
@@ -1337,38 +2240,61 @@ func printHelp() {
 	fmt.Println("                 temperatureControls")
 	fmt.Println("                 token")
 	fmt.Println("                 zone <zoneID> [depth level]")
+	fmt.Println("         profile add <name> [url] [application token]")
+	fmt.Println("                 list")
+	fmt.Println("                 remove <name>")
+	fmt.Println("                 use <name>")
+	fmt.Println("        property cd [path]")
+	fmt.Println("                 get <path>")
+	fmt.Println("                 ls [path]")
+	fmt.Println("                 pwd")
+	fmt.Println("                 query <query>")
+	fmt.Println("                 query2 <query>")
+	fmt.Println("                 set <path> <string|integer|boolean|floating> <value>")
+	fmt.Println("                 type <path>")
 	fmt.Println("        register <username> <password> <application name>")
 	fmt.Println("         request circuits")
 	fmt.Println("                 structure")
 	fmt.Println("                 system")
 	fmt.Println("                 temperatureControls")
 	fmt.Println("           reset pollingintervals")
+	fmt.Println("            save <file>")
 	fmt.Println("             set at <application token>")
 	fmt.Println("                 default pollingintervals")
 	fmt.Println("                 default pollinterval <'sensor'|'circuit'|'channel'> <interval in s>")
 	fmt.Println("                 max parallelpolls <number of polls>")
+	fmt.Println("                 max requestspersecond <number of requests, 0 for no limit>")
 	fmt.Println("                 pollinterval sensor <deviceID> <sensorIndex> <interval in s>")
 	fmt.Println("                 pollinterval channel <deviceID> <channelType> <interval in s>")
 	fmt.Println("                 pollinterval circuit <circuitID> <interval in s>")
 	fmt.Println("                 st <session token>")
 	fmt.Println("                 url <url>")
+	fmt.Println("       structure addgroup <zoneID> <groupID> <color> <name>")
+	fmt.Println("                 addzone <zoneID> [name]")
+	fmt.Println("                 groupadd <groupID> <deviceID>")
+	fmt.Println("                 groupremove <groupID> <deviceID>")
+	fmt.Println("                 move <deviceID> <zoneID>")
+	fmt.Println("                 name <device|zone|floor> <id> <name>")
+	fmt.Println("                 removezone <zoneID>")
 	fmt.Println("          update all")
 	fmt.Println("                 auto <on|off>")
 	fmt.Println("                 channel <deviceID> <channelType>")
 	fmt.Println("                 channels <deviceID>")
 	fmt.Println("                 consumption <circuitID>")
+	fmt.Println("                 events <on|off>")
 	fmt.Println("                 binInputs")
 	fmt.Println("                 meter <circuitID>")
 	fmt.Println("                 on")
 	fmt.Println("                 sensor <deviceID> <sensorIndex>")
 	fmt.Println("                 sensors <deviceID>")
+	fmt.Println("                 states")
 	fmt.Println("                 temperatureControls")
 
 }
 
-func printStructure(a *digitalstrom.Account, level int) {
+func printStructure(snapshot *digitalstrom.Snapshot, level int) {
 
-	structure := &a.Structure
+	structure := &snapshot.Structure
 
 	if structure == nil {
 		fmt.Println("No Structure available yet. Please request the structure (type 'request structure') or init the account (type 'init').")
@@ -1380,29 +2306,43 @@ func printStructure(a *digitalstrom.Account, level int) {
 	printNode("", "", true, &node, level)
 }
 
-func printZoneList(a *digitalstrom.Account) {
+func printZoneList(snapshot *digitalstrom.Snapshot) {
+	rows := [][]interface{}{}
+	for id, zone := range snapshot.Zones {
+		rows = append(rows, []interface{}{id, zone.Name})
+	}
+	if writeTable([]string{"id", "name"}, sortRows(rows)) {
+		return
+	}
 	fmt.Println("Zones")
-	if len(a.Groups) == 0 {
+	if len(snapshot.Groups) == 0 {
 		fmt.Println("    no Zones found")
 		return
 	}
-	for id, zone := range a.Zones {
+	for id, zone := range snapshot.Zones {
 		fmt.Println("   " + strconv.Itoa(id) + " " + zone.Name)
 	}
 }
 
-func printGroupList(a *digitalstrom.Account) {
+func printGroupList(snapshot *digitalstrom.Snapshot) {
 	var line string
 
+	rows := [][]interface{}{}
+	for id, group := range snapshot.Groups {
+		rows = append(rows, []interface{}{id, group.Color, group.Name})
+	}
+	if writeTable([]string{"id", "color", "name"}, sortRows(rows)) {
+		return
+	}
 	fmt.Println("Groups")
-	if len(a.Groups) == 0 {
+	if len(snapshot.Groups) == 0 {
 		fmt.Println("    no Groups found")
 		return
 	}
 	fmt.Println()
 	fmt.Println("     ID   Color    Name")
 	fmt.Println()
-	for id, group := range a.Groups {
+	for id, group := range snapshot.Groups {
 		line = "  " + toLen(strconv.Itoa(id), 5)
 		line = line + toLen(strconv.Itoa(group.Color), 7)
 		line = line + toLen(group.Name, 10)
@@ -1410,39 +2350,84 @@ func printGroupList(a *digitalstrom.Account) {
 	}
 }
 
-func printFloorList(a *digitalstrom.Account) {
+func printFloorList(snapshot *digitalstrom.Snapshot) {
+	rows := [][]interface{}{}
+	for id, floor := range snapshot.Floors {
+		rows = append(rows, []interface{}{id, floor.Name})
+	}
+	if writeTable([]string{"id", "name"}, sortRows(rows)) {
+		return
+	}
 	fmt.Println("Floors")
-	if len(a.Floors) == 0 {
+	if len(snapshot.Floors) == 0 {
 		fmt.Println("    no Floors found")
 		return
 	}
-	for id, floor := range a.Floors {
+	for id, floor := range snapshot.Floors {
 		fmt.Println("   " + strconv.Itoa(id) + " " + floor.Name)
 	}
 }
 
-func printDeviceList(a *digitalstrom.Account) {
+func printDeviceList(snapshot *digitalstrom.Snapshot) {
+	rows := [][]interface{}{}
+	for id, dev := range snapshot.Devices {
+		rows = append(rows, []interface{}{id, dev.ID, dev.Name, dev.ZoneID, dev.IsPresent, dev.On})
+	}
+	if writeTable([]string{"id", "dsid", "name", "zone", "present", "on"}, sortRows(rows)) {
+		return
+	}
 	fmt.Println("Devices")
-	if len(a.Devices) == 0 {
+	if len(snapshot.Devices) == 0 {
 		fmt.Println("    no Devices found")
 		return
 	}
-	for id, dev := range a.Devices {
+	for id, dev := range snapshot.Devices {
 		fmt.Printf("   %s  %s  %s\r\n", id, dev.ID, dev.Name)
 	}
 }
 
-func printCircuitList(a *digitalstrom.Account) {
+func printCircuitList(snapshot *digitalstrom.Snapshot) {
+	rows := [][]interface{}{}
+	for id, circuit := range snapshot.Circuits {
+		rows = append(rows, []interface{}{id, circuit.Name, circuit.HasMetering, circuit.Consumption, circuit.MeterValue})
+	}
+	if writeTable([]string{"id", "name", "metering", "consumption", "meter"}, sortRows(rows)) {
+		return
+	}
 	fmt.Println("Circuits")
-	if len(a.Devices) == 0 {
+	if len(snapshot.Devices) == 0 {
 		fmt.Println("    no Circuits found")
 		return
 	}
-	for id, circuit := range a.Circuits {
+	for id, circuit := range snapshot.Circuits {
 		fmt.Println("   " + id + " " + circuit.Name)
 	}
 }
 
+func printStateList(snapshot *digitalstrom.Snapshot) {
+	rows := [][]interface{}{}
+	for name, state := range snapshot.States {
+		rows = append(rows, []interface{}{name, state.State, state.Value})
+	}
+	if writeTable([]string{"name", "state", "value"}, sortRows(rows)) {
+		return
+	}
+	fmt.Println("States")
+	if len(snapshot.States) == 0 {
+		fmt.Println("    no States found")
+		return
+	}
+	names := make([]string, 0, len(snapshot.States))
+	for name := range snapshot.States {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state := snapshot.States[name]
+		fmt.Println("   " + toLen(name, 12) + " " + state.State + " (" + strconv.Itoa(state.Value) + ")")
+	}
+}
+
 func printAppartment(a *digitalstrom.Apartment, level int) {
 	root := generateApartmentNode(a)
 
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/connctd/digitalstrom"
+)
+
+// Exit codes of one-shot commands
+const (
+	exitOK          = 0
+	exitFailure     = 1 // the command failed, e.g. unknown device or request error
+	exitUsage       = 2 // invalid command or parameters
+	exitUnavailable = 3 // the dSS is not reachable or the account could not be initialized
+	exitAuth        = 4 // the dSS refused the application token
+)
+
+// Output formats of one-shot commands
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// outputFormat is the format results are written in, only one-shot commands use json or csv
+var outputFormat = formatText
+
+// resultOut receives the machine readable results. All other messages are written to stdout, which is
+// redirected to stderr in json and csv mode.
+var resultOut io.Writer = os.Stdout
+
+// exitCode is the exit code of the first failure of a one-shot command
+var exitCode = exitOK
+
+// commandsWithoutInit could be executed before the account is initialized
+var commandsWithoutInit = map[string]bool{"help": true, "discover": true, "register": true, "init": true, "login": true, "profile": true}
+
+// interactiveCommands make no sense in a process that exits after the command
+var interactiveCommands = map[string]bool{"exit": true, "load": true, "mqtt": true, "reset": true, "set": true}
+
+// runOneShot executes a single command given as program arguments and exits with the exit code of the
+// command. The account is initialized before, unless the command does not need it.
+func runOneShot(a *digitalstrom.Account, args []string) {
+	cmd := []string{}
+	for _, arg := range args {
+		switch arg {
+		case "--json":
+			outputFormat = formatJSON
+		case "--csv":
+			outputFormat = formatCSV
+		default:
+			cmd = append(cmd, arg)
+		}
+	}
+	if outputFormat != formatText {
+		resultOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	if len(cmd) == 0 {
+		usageln("Error. No command given. Please type 'console -h' for a list of possible arguments.")
+		os.Exit(exitCode)
+	}
+	if interactiveCommands[cmd[0]] || (cmd[0] == "update" && len(cmd) > 1 && (cmd[1] == "auto" || cmd[1] == "events")) {
+		usageln("Error. '" + strings.Join(cmd, " ") + "' is only available in the interactive console.")
+		os.Exit(exitCode)
+	}
+	if !commandsWithoutInit[cmd[0]] {
+		err := a.Init()
+		if err != nil {
+			fmt.Println("Error. Initialisation not successful.")
+			fmt.Println(err)
+			printCertificateHint(err)
+			var authErr *digitalstrom.AuthError
+			if errors.As(err, &authErr) {
+				os.Exit(exitAuth)
+			}
+			os.Exit(exitUnavailable)
+		}
+	}
+	if !processCommand(a, cmd) {
+		usageln("Unknown command '" + cmd[0] + "'.")
+	}
+	os.Exit(exitCode)
+}
+
+// writeTable writes the rows in the selected machine readable format, json as list of objects. It returns
+// false in text mode, the caller has to print the human readable output then.
+func writeTable(columns []string, rows [][]interface{}) bool {
+	switch outputFormat {
+	case formatJSON:
+		objects := make([]map[string]interface{}, 0, len(rows))
+		for _, row := range rows {
+			object := make(map[string]interface{}, len(columns))
+			for i, column := range columns {
+				object[column] = row[i]
+			}
+			objects = append(objects, object)
+		}
+		writeJSON(objects)
+	case formatCSV:
+		w := csv.NewWriter(resultOut)
+		w.Write(columns)
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for i, value := range row {
+				if value != nil {
+					record[i] = fmt.Sprint(value)
+				}
+			}
+			w.Write(record)
+		}
+		w.Flush()
+	default:
+		return false
+	}
+	return true
+}
+
+// valueColumns are the columns of the results of all update commands
+var valueColumns = []string{"type", "id", "index", "name", "value", "unit"}
+
+func sensorRow(deviceID string, sensor *digitalstrom.Sensor, value float64) []interface{} {
+	return []interface{}{"sensor", deviceID, sensor.Index, sensor.Type.GetName(), value, ""}
+}
+
+func channelRow(deviceID string, channel *digitalstrom.OutputChannel, raw int) []interface{} {
+	value, unit := float64(raw), digitalstrom.CUnone
+	if r, ok := channel.ChannelType.GetRange(); ok {
+		value, unit = r.ToPhysical(raw), r.Unit
+	}
+	return []interface{}{"channel", deviceID, channel.ChannelIndex, string(channel.ChannelType), value, string(unit)}
+}
+
+func circuitRow(kind string, circuit *digitalstrom.Circuit, value int) []interface{} {
+	unit := "W"
+	if kind == "meter" {
+		unit = "Ws"
+	}
+	return []interface{}{kind, circuit.DisplayID, nil, circuit.Name, value, unit}
+}
+
+// sortRows sorts the rows by their first column, which is either a number or a string
+func sortRows(rows [][]interface{}) [][]interface{} {
+	sort.Slice(rows, func(i, j int) bool {
+		a, aIsInt := rows[i][0].(int)
+		b, bIsInt := rows[j][0].(int)
+		if aIsInt && bIsInt {
+			return a < b
+		}
+		return fmt.Sprint(rows[i][0]) < fmt.Sprint(rows[j][0])
+	})
+	return rows
+}
+
+// writeJSON writes the value as json. CSV is not supported for nested values. It returns false in text mode,
+// the caller has to print the human readable output then.
+func writeJSON(v interface{}) bool {
+	switch outputFormat {
+	case formatJSON:
+		enc := json.NewEncoder(resultOut)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			errorln("Error. Unable to encode the result.")
+			fmt.Println(err)
+		}
+	case formatCSV:
+		usageln("Error. CSV output is not supported for this command, use --json.")
+	default:
+		return false
+	}
+	return true
+}
+
+// errorln prints the message of a failed command like fmt.Println
+func errorln(a ...interface{}) {
+	fail(exitFailure)
+	fmt.Println(a...)
+}
+
+// errorf prints the message of a failed command like fmt.Printf
+func errorf(format string, a ...interface{}) {
+	fail(exitFailure)
+	fmt.Printf(format, a...)
+}
+
+// usageln prints the message of an invalid command like fmt.Println
+func usageln(a ...interface{}) {
+	fail(exitUsage)
+	fmt.Println(a...)
+}
+
+// usagef prints the message of an invalid command like fmt.Printf
+func usagef(format string, a ...interface{}) {
+	fail(exitUsage)
+	fmt.Printf(format, a...)
+}
+
+// fail sets the exit code unless a previous failure has set it already
+func fail(code int) {
+	if exitCode == exitOK {
+		exitCode = code
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// consoleArgsEnv contains the program arguments as json list when the test binary is started as console by
+// runConsole
+const consoleArgsEnv = "DIGITALSTROM_CONSOLE_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(consoleArgsEnv); ok {
+		os.Args = []string{"console"}
+		programArgs := []string{}
+		if err := json.Unmarshal([]byte(args), &programArgs); err != nil {
+			panic(err)
+		}
+		os.Args = append(os.Args, programArgs...)
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// runConsole runs the console with the given program arguments in a separate process, as one-shot
+// commands exit the process. It returns stdout, stderr and the exit code.
+func runConsole(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0])
+	// the console writes its log into the working directory and must not read the config file of the user
+	dir := t.TempDir()
+	cmd.Env = append(os.Environ(), consoleArgsEnv+"="+string(encoded), "HOME="+dir, "XDG_CONFIG_HOME="+dir)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("unable to start the console: %v", err)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
+func newTestServer(t *testing.T) *dsstest.Server {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOneShotJSONOutput(t *testing.T) {
+	srv := newTestServer(t)
+
+	stdout, stderr, code := runConsole(t, "-url", srv.URL, "-at", dsstest.ApplicationToken, "list", "devices", "--json")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	devices := []map[string]interface{}{}
+	if err := json.Unmarshal([]byte(stdout), &devices); err != nil {
+		t.Fatalf("stdout is no json list: %v\n%s", err, stdout)
+	}
+	if len(devices) != 3 || devices[0]["id"] != "00000001" || devices[0]["name"] != "Ceiling Lamp" || devices[2]["zone"] != float64(2) {
+		t.Errorf("unexpected devices %v", devices)
+	}
+}
+
+func TestOneShotCSVOutput(t *testing.T) {
+	srv := newTestServer(t)
+
+	stdout, stderr, code := runConsole(t, "-url", srv.URL, "-at", dsstest.ApplicationToken, "--csv", "list", "circuits")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	if err != nil {
+		t.Fatalf("stdout is no csv: %v\n%s", err, stdout)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != "id,name,metering,consumption,meter" ||
+		strings.Join(records[1], ",") != "0000c001,Meter 1,true,120,5400000" {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestOneShotExitCodes(t *testing.T) {
+	srv := newTestServer(t)
+	closed := dsstest.NewServer(dsstest.NewApartment())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{"command executed", []string{"-url", srv.URL, "-at", dsstest.ApplicationToken, "cmd", "on", "00000001"}, exitOK},
+		{"unknown device", []string{"-url", srv.URL, "-at", dsstest.ApplicationToken, "cmd", "on", "00000009"}, exitFailure},
+		{"unknown command", []string{"-url", srv.URL, "-at", dsstest.ApplicationToken, "unknown"}, exitUsage},
+		{"missing parameter", []string{"-url", srv.URL, "-at", dsstest.ApplicationToken, "cmd", "on"}, exitUsage},
+		{"no command", []string{"--json"}, exitUsage},
+		{"unknown program argument", []string{"-x"}, exitUsage},
+		{"interactive command", []string{"-url", srv.URL, "-at", dsstest.ApplicationToken, "update", "auto"}, exitUsage},
+		{"dSS not reachable", []string{"-url", closed.URL, "-at", dsstest.ApplicationToken, "list", "devices"}, exitUnavailable},
+		{"unknown application token", []string{"-url", srv.URL, "-at", "unknown", "list", "devices"}, exitAuth},
+	}
+	for _, test := range tests {
+		_, stderr, code := runConsole(t, test.args...)
+		if code != test.expected {
+			t.Errorf("%s: expected exit code %d, got %d: %s", test.name, test.expected, code, stderr)
+		}
+	}
+}
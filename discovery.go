@@ -0,0 +1,290 @@
+package digitalstrom
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/connctd/digitalstrom/internal/mdns"
+)
+
+const (
+	// mdnsAddress is the multicast address of mDNS queries
+	mdnsAddress             = "224.0.0.251:5353"
+	defaultDiscoveryTimeout = 3 * time.Second
+	discoveryResendInterval = time.Second
+)
+
+// defaultDiscoveryServices are the services a dSS is advertising its web interface and JSON API by
+var defaultDiscoveryServices = []string{"_dssweb._tcp", "_http._tcp"}
+
+// DiscoverySetup configures Discover. All fields are optional.
+type DiscoverySetup struct {
+	// Services to browse for, default _dssweb._tcp and _http._tcp. Instances of _http._tcp are only
+	// taken into account when their name contains "digitalSTROM" or "dSS".
+	Services []string
+	// Address the queries are sent to, default is the mDNS multicast address 224.0.0.251:5353
+	Address string
+	// Timeout is the time to wait for answers, default 3 seconds
+	Timeout time.Duration
+	// HTTPClient is used to request the system info of the found servers, default http.DefaultClient
+	HTTPClient *http.Client
+	// SkipSystemInfo disables the system info requests
+	SkipSystemInfo bool
+}
+
+// DiscoveredServer is a dSS found by Discover. System is nil when the system info could not be
+// requested, e.g. because the certificate of the dSS is not trusted by the used http client.
+type DiscoveredServer struct {
+	Name    string // name of the advertised instance
+	Service string // e.g. "_dssweb._tcp"
+	Host    string
+	IP      net.IP
+	Port    int
+	Text    map[string]string // content of the TXT record
+	BaseURL string            // could be used with SetURL
+	System  *System
+}
+
+// discoveredInstance collects the records of a service instance while browsing
+type discoveredInstance struct {
+	name    string
+	service string
+	host    string
+	port    int
+	hasSRV  bool
+	text    []string
+	source  net.IP
+}
+
+// Discover browses the local network for digitalSTROM servers via mDNS/DNS-SD and returns the
+// candidates sorted by name. Discover waits for answers until the timeout of the setup has passed.
+func Discover(setup DiscoverySetup) ([]DiscoveredServer, error) {
+	return DiscoverContext(context.Background(), setup)
+}
+
+// DiscoverContext is like Discover but aborts all performed requests when ctx is done.
+func DiscoverContext(ctx context.Context, setup DiscoverySetup) ([]DiscoveredServer, error) {
+	if len(setup.Services) == 0 {
+		setup.Services = defaultDiscoveryServices
+	}
+	if setup.Address == "" {
+		setup.Address = mdnsAddress
+	}
+	if setup.Timeout <= 0 {
+		setup.Timeout = defaultDiscoveryTimeout
+	}
+	if setup.HTTPClient == nil {
+		setup.HTTPClient = http.DefaultClient
+	}
+
+	instances, hosts, err := browse(ctx, setup)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []DiscoveredServer{}
+	for _, instance := range instances {
+		if !instance.hasSRV || !isDSSInstance(instance) {
+			continue
+		}
+		server := DiscoveredServer{
+			Name:    instance.name,
+			Service: strings.TrimSuffix(instance.service, ".local."),
+			Host:    instance.host,
+			IP:      instance.source,
+			Port:    instance.port,
+			Text:    make(map[string]string),
+		}
+		// advertised IPv4 addresses are preferred over the source address of the answer
+		for _, ip := range hosts[strings.ToLower(instance.host)] {
+			if ip.To4() != nil || server.IP.To4() == nil {
+				server.IP = ip
+			}
+			if ip.To4() != nil {
+				break
+			}
+		}
+		for _, text := range instance.text {
+			key, value, _ := strings.Cut(text, "=")
+			server.Text[strings.ToLower(key)] = value
+		}
+		scheme := "https"
+		if server.Service == "_http._tcp" && server.Port != 443 && server.Port != 8080 {
+			scheme = "http"
+		}
+		server.BaseURL = scheme + "://" + net.JoinHostPort(server.IP.String(), strconv.Itoa(server.Port))
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Name != servers[j].Name {
+			return servers[i].Name < servers[j].Name
+		}
+		return servers[i].BaseURL < servers[j].BaseURL
+	})
+
+	if setup.SkipSystemInfo {
+		return servers, nil
+	}
+	for i := range servers {
+		c := &Connection{BaseURL: servers[i].BaseURL, HTTPClient: setup.HTTPClient}
+		system, err := c.requestSystemInfo(ctx)
+		if err != nil {
+			logger.Error(err, "unable to request system info of discovered server "+redactURL(servers[i].BaseURL))
+			continue
+		}
+		servers[i].System = system
+	}
+	return servers, ctx.Err()
+}
+
+// browse sends PTR queries for the services and collects the answers until the timeout has passed.
+// Missing SRV, TXT and address records are queried as soon as an instance or host is known. The
+// queries are repeated every second.
+func browse(ctx context.Context, setup DiscoverySetup) (map[string]*discoveredInstance, map[string][]net.IP, error) {
+	dst, err := net.ResolveUDPAddr("udp", setup.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	network := "udp4"
+	if dst.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	services := make(map[string]bool)
+	for _, service := range setup.Services {
+		services[strings.ToLower(strings.TrimSuffix(service, ".")+".local.")] = true
+	}
+	instances := make(map[string]*discoveredInstance)
+	hosts := make(map[string][]net.IP)
+
+	// questions returns the PTR questions and the questions for missing records
+	questions := func() []mdns.Question {
+		q := []mdns.Question{}
+		for service := range services {
+			q = append(q, mdns.Question{Name: service, Type: mdns.TypePTR, Unicast: true})
+		}
+		for _, instance := range instances {
+			name := mdns.JoinName(instance.name, instance.service)
+			if !instance.hasSRV {
+				q = append(q, mdns.Question{Name: name, Type: mdns.TypeSRV, Unicast: true})
+				q = append(q, mdns.Question{Name: name, Type: mdns.TypeTXT, Unicast: true})
+			} else if len(hosts[strings.ToLower(instance.host)]) == 0 {
+				q = append(q, mdns.Question{Name: instance.host, Type: mdns.TypeA, Unicast: true})
+			}
+		}
+		return q
+	}
+	send := func(q []mdns.Question) error {
+		data, err := (&mdns.Message{Questions: q}).Pack()
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteToUDP(data, dst)
+		return err
+	}
+
+	deadline := time.Now().Add(setup.Timeout)
+	nextSend := time.Now()
+	buf := make([]byte, 9000)
+	for time.Now().Before(deadline) {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if !time.Now().Before(nextSend) {
+			err = send(questions())
+			if err != nil {
+				return nil, nil, err
+			}
+			nextSend = time.Now().Add(discoveryResendInterval)
+		}
+		readDeadline := nextSend
+		if deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return nil, nil, err
+		}
+		msg, err := mdns.Unpack(buf[:n])
+		if err != nil || !msg.Response {
+			continue
+		}
+		known := len(instances)
+		for _, r := range append(msg.Answers, msg.Additionals...) {
+			addDiscoveryRecord(r, src.IP, services, instances, hosts)
+		}
+		// new instances are resolved without waiting for the next regular query
+		if len(instances) > known {
+			nextSend = time.Now()
+		}
+	}
+	return instances, hosts, ctx.Err()
+}
+
+// addDiscoveryRecord adds the content of a record to the found instances and hosts
+func addDiscoveryRecord(r mdns.Record, source net.IP, services map[string]bool, instances map[string]*discoveredInstance, hosts map[string][]net.IP) {
+	switch r.Type {
+	case mdns.TypePTR:
+		if !services[strings.ToLower(r.Name)] {
+			return
+		}
+		key := strings.ToLower(r.Target)
+		if _, ok := instances[key]; !ok {
+			name, service := mdns.SplitName(r.Target)
+			instances[key] = &discoveredInstance{name: name, service: service, source: source}
+		}
+	case mdns.TypeSRV:
+		if instance, ok := instances[strings.ToLower(r.Name)]; ok {
+			instance.host = r.Target
+			instance.port = int(r.Port)
+			instance.hasSRV = true
+		}
+	case mdns.TypeTXT:
+		if instance, ok := instances[strings.ToLower(r.Name)]; ok {
+			instance.text = r.Text
+		}
+	case mdns.TypeA, mdns.TypeAAAA:
+		key := strings.ToLower(r.Name)
+		for _, ip := range hosts[key] {
+			if ip.Equal(r.IP) {
+				return
+			}
+		}
+		hosts[key] = append(hosts[key], r.IP)
+	}
+}
+
+// isDSSInstance returns true for all instances of dSS specific services and for instances of
+// generic services whose name is pointing to a dSS
+func isDSSInstance(instance *discoveredInstance) bool {
+	if !strings.HasPrefix(strings.ToLower(instance.service), "_http.") {
+		return true
+	}
+	name := strings.ToLower(instance.name)
+	return strings.Contains(name, "digitalstrom") || strings.Contains(name, "dss")
+}
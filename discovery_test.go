@@ -0,0 +1,94 @@
+package digitalstrom_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+	"github.com/connctd/digitalstrom/internal/mdns"
+)
+
+func TestDiscover(t *testing.T) {
+	tlsServer := dsstest.NewTLSServer(dsstest.NewApartment())
+	defer tlsServer.Close()
+	plainServer := dsstest.NewServer(dsstest.NewApartment())
+	defer plainServer.Close()
+	responder, err := dsstest.NewResponder("127.0.0.1:0", tlsServer, plainServer)
+	if err != nil {
+		t.Fatalf("NewResponder failed: %v", err)
+	}
+	defer responder.Close()
+
+	servers, err := digitalstrom.Discover(digitalstrom.DiscoverySetup{
+		Address:    responder.Addr(),
+		Timeout:    500 * time.Millisecond,
+		HTTPClient: tlsServer.Client(),
+	})
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d: %+v", len(servers), servers)
+	}
+
+	expected := []struct {
+		name    string
+		service string
+		baseURL string
+	}{
+		{"dSS dsstest 2", "_http._tcp", plainServer.URL},
+		{"digitalSTROM Server dsstest 1", "_dssweb._tcp", tlsServer.URL},
+	}
+	for i, e := range expected {
+		server := servers[i]
+		if server.Name != e.name || server.Service != e.service || server.BaseURL != e.baseURL {
+			t.Errorf("expected %s (%s) at %s, got %s (%s) at %s", e.name, e.service, e.baseURL, server.Name, server.Service, server.BaseURL)
+		}
+		if server.Text["path"] != "/" {
+			t.Errorf("%s: TXT record not decoded: %v", server.Name, server.Text)
+		}
+		if server.System == nil || server.System.Version != "1.19.0" {
+			t.Errorf("%s: system info not requested: %+v", server.Name, server.System)
+		}
+	}
+}
+
+func TestDiscoverIgnoresOtherHTTPServices(t *testing.T) {
+	responder, err := mdns.NewResponder("127.0.0.1:0",
+		mdns.Service{
+			Instance: "Office Printer",
+			Service:  "_http._tcp.local.",
+			Host:     "printer.local.",
+			IPs:      []net.IP{net.ParseIP("192.0.2.10")},
+			Port:     80,
+		},
+		mdns.Service{
+			Instance: "digitalSTROM Server",
+			Service:  "_dssweb._tcp.local.",
+			Host:     "dss.local.",
+			IPs:      []net.IP{net.ParseIP("192.0.2.20")},
+			Port:     8080,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewResponder failed: %v", err)
+	}
+	defer responder.Close()
+
+	servers, err := digitalstrom.Discover(digitalstrom.DiscoverySetup{
+		Address:        responder.Addr(),
+		Timeout:        500 * time.Millisecond,
+		SkipSystemInfo: true,
+	})
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if len(servers) != 1 {
+		t.Fatalf("expected only the dSS, got %+v", servers)
+	}
+	if servers[0].Host != "dss.local." || servers[0].BaseURL != "https://192.0.2.20:8080" {
+		t.Errorf("unexpected server %+v", servers[0])
+	}
+}
@@ -0,0 +1,231 @@
+package dsstest
+
+import (
+	"github.com/connctd/digitalstrom"
+)
+
+// Apartment is the in-memory model the Server is working on. Sensor values, output channel values
+// (raw, as delivered by getOutputValue), binary input states and On states are part of the devices in Structure, consumption and meter
+// values are part of the Circuits. Temperature control configurations and the nominal values of
+// each operation mode are stored per zone id. Properties is the property tree of the dSS, the keys
+// are the paths of the property nodes and the values are strings, ints, bools or float64s. Tests
+// could modify the model at any time by using Server.Update.
+type Apartment struct {
+	Structure                digitalstrom.Structure
+	Circuits                 []digitalstrom.Circuit
+	TemperatureControl       []digitalstrom.TemperatureControlState
+	TemperatureControlConfig map[int]digitalstrom.TemperatureControlConfig
+	TemperatureControlValues map[int]map[digitalstrom.OperationMode]float64
+	Properties               map[string]interface{}
+	System                   digitalstrom.System
+}
+
+// NewApartment returns a small installation with one floor, two zones, a dimmable lamp, an RGB lamp,
+// a room sensor with binary input and one metering circuit. Tests could use it as a starting point
+// and modify it to their needs.
+func NewApartment() *Apartment {
+	lamp := digitalstrom.Device{
+		ID:        "302ed89f43f00e4000000001",
+		DisplayID: "00000001",
+		UUID:      "302ed89f43f0000000000000000000001",
+		Name:      "Ceiling Lamp",
+		ZoneID:    1,
+		IsPresent: true,
+		IsValid:   true,
+		MeterDSID: "302ed89f43f00e40000c0001",
+		OutputChannels: []*digitalstrom.OutputChannel{
+			{ChannelID: "brightness", ChannelType: digitalstrom.OCTbrightness, ChannelIndex: 0, ChannelName: "Brightness"},
+		},
+		Groups: []int{int(digitalstrom.ATlights)},
+	}
+	rgbLamp := digitalstrom.Device{
+		ID:        "302ed89f43f00e4000000002",
+		DisplayID: "00000002",
+		UUID:      "302ed89f43f0000000000000000000002",
+		Name:      "RGB Lamp",
+		ZoneID:    1,
+		IsPresent: true,
+		IsValid:   true,
+		MeterDSID: "302ed89f43f00e40000c0001",
+		OutputChannels: []*digitalstrom.OutputChannel{
+			{ChannelID: "brightness", ChannelType: digitalstrom.OCTbrightness, ChannelIndex: 0, ChannelName: "Brightness"},
+			{ChannelID: "hue", ChannelType: digitalstrom.OCThue, ChannelIndex: 1, ChannelName: "Hue"},
+			{ChannelID: "saturation", ChannelType: digitalstrom.OCTsaturation, ChannelIndex: 2, ChannelName: "Saturation"},
+		},
+		Groups: []int{int(digitalstrom.ATlights)},
+	}
+	sensor := digitalstrom.Device{
+		ID:        "302ed89f43f00e4000000003",
+		DisplayID: "00000003",
+		UUID:      "302ed89f43f0000000000000000000003",
+		Name:      "Room Sensor",
+		ZoneID:    2,
+		IsPresent: true,
+		IsValid:   true,
+		MeterDSID: "302ed89f43f00e40000c0001",
+		Sensors: []*digitalstrom.Sensor{
+			{Type: digitalstrom.STroomTemperature, Valid: true, Value: 21.5},
+			{Type: digitalstrom.STroomRelativeHumidity, Valid: true, Value: 45},
+		},
+		BinaryInputCount: 1,
+		BinaryInputs: []*digitalstrom.BinaryInput{
+			{TargetGroup: 8, InputType: digitalstrom.BITwindowIsOpen, InputID: 0, State: 1},
+		},
+		Groups: []int{int(digitalstrom.ATheating)},
+	}
+
+	return &Apartment{
+		Structure: digitalstrom.Structure{
+			Apartment: digitalstrom.Apartment{
+				Zones: []digitalstrom.Zone{
+					{
+						ID: 1, Name: "Living Room", IsPresent: true, FloorID: 1,
+						Devices: []digitalstrom.Device{lamp, rgbLamp},
+						Groups: []digitalstrom.Group{
+							{ID: 1, Name: "yellow", ApplicationType: digitalstrom.ATlights, IsPresent: true, IsValid: true, Devices: []string{lamp.UUID, rgbLamp.UUID}},
+						},
+					},
+					{
+						ID: 2, Name: "Kitchen", IsPresent: true, FloorID: 1,
+						Devices: []digitalstrom.Device{sensor},
+						Groups: []digitalstrom.Group{
+							{ID: 3, Name: "heating", ApplicationType: digitalstrom.ATheating, IsPresent: true, IsValid: true, Devices: []string{sensor.UUID}},
+						},
+					},
+				},
+				Floors: []digitalstrom.Floor{
+					{ID: 1, Order: 0, Name: "Ground Floor", Zones: []int{1, 2}},
+				},
+			},
+		},
+		Circuits: []digitalstrom.Circuit{
+			{
+				Name:        "Meter 1",
+				DSID:        "302ed89f43f00e40000c0001",
+				DSUID:       "302ed89f43f00e40000c000000000001",
+				DisplayID:   "0000c001",
+				IsPresent:   true,
+				IsValid:     true,
+				HasDevices:  true,
+				HasMetering: true,
+				Consumption: 120,
+				MeterValue:  5400000,
+			},
+		},
+		TemperatureControl: []digitalstrom.TemperatureControlState{
+			{ZoneId: 2, Name: "Kitchen", ControlMode: 1, ControlState: 0, OperationMode: 1, TemperatureValue: 21.5, NominalValue: 22, ControlValue: 30},
+		},
+		TemperatureControlConfig: map[int]digitalstrom.TemperatureControlConfig{
+			2: {ControlMode: digitalstrom.CMpid, EmergencyValue: 75, CtrlKp: 5, CtrlTs: 1, CtrlTi: 240, CtrlImin: -13.33, CtrlImax: 13.33, CtrlYmin: 0, CtrlYmax: 100, CtrlAntiWindUp: true},
+		},
+		TemperatureControlValues: map[int]map[digitalstrom.OperationMode]float64{
+			2: {
+				digitalstrom.OMoff:        8,
+				digitalstrom.OMcomfort:    22,
+				digitalstrom.OMeconomy:    20,
+				digitalstrom.OMnotUsed:    18,
+				digitalstrom.OMnight:      17,
+				digitalstrom.OMholiday:    16,
+				digitalstrom.OMcooling:    23,
+				digitalstrom.OMcoolingOff: 35,
+			},
+		},
+		Properties: map[string]interface{}{
+			"/apartment/zones/zone1/ZoneID":       1,
+			"/apartment/zones/zone1/name":         "Living Room",
+			"/apartment/zones/zone2/ZoneID":       2,
+			"/apartment/zones/zone2/name":         "Kitchen",
+			"/config/geodata/latitude":            50.7753,
+			"/config/geodata/longitude":           6.0839,
+			"/config/subsystems/Metering/enabled": true,
+			"/system/host/hostname":               "dsstest",
+			"/system/version/version":             "1.19.0",
+			"/usr/states/alarm/state":             "inactive",
+			"/usr/states/alarm/value":             2,
+			"/usr/states/hibernation/state":       "awake",
+			"/usr/states/hibernation/value":       1,
+			"/usr/states/presence/state":          "present",
+			"/usr/states/presence/value":          1,
+		},
+		System: digitalstrom.System{
+			Version:       "1.19.0",
+			DistroVersion: "dsstest",
+			Hardware:      "dsstest",
+		},
+	}
+}
+
+// GetDevice returns the device with the given dSUID, dSID or display ID or nil when it could not be found.
+func (a *Apartment) GetDevice(id string) *digitalstrom.Device {
+	for i := range a.Structure.Apartment.Zones {
+		zone := &a.Structure.Apartment.Zones[i]
+		for j := range zone.Devices {
+			device := &zone.Devices[j]
+			if device.UUID == id || device.ID == id || device.DisplayID == id {
+				return device
+			}
+		}
+	}
+	return nil
+}
+
+// GetZone returns the zone with the given id or nil when it could not be found.
+func (a *Apartment) GetZone(id int) *digitalstrom.Zone {
+	for i := range a.Structure.Apartment.Zones {
+		if a.Structure.Apartment.Zones[i].ID == id {
+			return &a.Structure.Apartment.Zones[i]
+		}
+	}
+	return nil
+}
+
+// GetCircuit returns the circuit with the given dSUID, dSID or display ID or nil when it could not be found.
+func (a *Apartment) GetCircuit(id string) *digitalstrom.Circuit {
+	for i := range a.Circuits {
+		circuit := &a.Circuits[i]
+		if circuit.DSUID == id || circuit.DSID == id || circuit.DisplayID == id {
+			return circuit
+		}
+	}
+	return nil
+}
+
+// GetTemperatureControl returns the temperature control state of the zone with the given id or nil
+// when the zone has no temperature control.
+func (a *Apartment) GetTemperatureControl(zoneID int) *digitalstrom.TemperatureControlState {
+	for i := range a.TemperatureControl {
+		if a.TemperatureControl[i].ZoneId == zoneID {
+			return &a.TemperatureControl[i]
+		}
+	}
+	return nil
+}
+
+// devices returns all devices of the apartment
+func (a *Apartment) devices() []*digitalstrom.Device {
+	devices := []*digitalstrom.Device{}
+	for i := range a.Structure.Apartment.Zones {
+		zone := &a.Structure.Apartment.Zones[i]
+		for j := range zone.Devices {
+			devices = append(devices, &zone.Devices[j])
+		}
+	}
+	return devices
+}
+
+// zoneDevices returns the devices of the zone with the given id. Zone 0 is the apartment-wide zone
+// containing all devices. It returns false when the zone could not be found.
+func (a *Apartment) zoneDevices(id int) ([]*digitalstrom.Device, bool) {
+	if id == 0 {
+		return a.devices(), true
+	}
+	zone := a.GetZone(id)
+	if zone == nil {
+		return nil, false
+	}
+	devices := []*digitalstrom.Device{}
+	for i := range zone.Devices {
+		devices = append(devices, &zone.Devices[i])
+	}
+	return devices, true
+}
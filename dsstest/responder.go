@@ -0,0 +1,52 @@
+package dsstest
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/connctd/digitalstrom/internal/mdns"
+)
+
+// Responder is an mDNS responder advertising Servers the way a dSS does, so digitalstrom.Discover
+// could be tested without a dSS in the network. TLS servers are advertised as _dssweb._tcp, plain
+// servers as _http._tcp.
+//
+//	srv := dsstest.NewTLSServer(dsstest.NewApartment())
+//	responder, err := dsstest.NewResponder("127.0.0.1:0", srv)
+//	defer responder.Close()
+//
+//	servers, err := digitalstrom.Discover(digitalstrom.DiscoverySetup{
+//		Address:    responder.Addr(),
+//		HTTPClient: srv.Client(),
+//	})
+type Responder struct {
+	*mdns.Responder
+}
+
+// NewResponder starts a responder for the given servers listening on address. Use a loopback address
+// like "127.0.0.1:0" and pass Addr as DiscoverySetup.Address, or the multicast address "224.0.0.251:5353"
+// to be found by a regular discovery. The Responder has to be closed by the caller.
+func NewResponder(address string, servers ...*Server) (*Responder, error) {
+	services := []mdns.Service{}
+	for i, s := range servers {
+		addr := s.Listener.Addr().(*net.TCPAddr)
+		service := mdns.Service{
+			Instance: "dSS dsstest " + strconv.Itoa(i+1),
+			Service:  "_http._tcp.local.",
+			Host:     "dsstest-" + strconv.Itoa(i+1) + ".local.",
+			IPs:      []net.IP{addr.IP},
+			Port:     addr.Port,
+			Text:     []string{"path=/"},
+		}
+		if s.TLS != nil {
+			service.Instance = "digitalSTROM Server dsstest " + strconv.Itoa(i+1)
+			service.Service = "_dssweb._tcp.local."
+		}
+		services = append(services, service)
+	}
+	r, err := mdns.NewResponder(address, services...)
+	if err != nil {
+		return nil, err
+	}
+	return &Responder{r}, nil
+}
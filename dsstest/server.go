@@ -0,0 +1,1504 @@
+// Package dsstest provides an in-process stand-in for a digitalSTROM server (dSS) to test code that
+// uses the digitalstrom library without a real installation. The Server serves the JSON API endpoints
+// the library is using and works on an in-memory Apartment that could be modified and inspected by
+// tests.
+//
+//	srv := dsstest.NewServer(dsstest.NewApartment())
+//	defer srv.Close()
+//
+//	account := digitalstrom.NewAccount()
+//	account.SetURL(srv.URL)
+//	account.SetApplicationToken(dsstest.ApplicationToken)
+//	err := account.Init()
+package dsstest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+)
+
+// Default credentials the Server accepts
+const (
+	ApplicationToken = "dsstest-application-token"
+	Username         = "dssadmin"
+	Password         = "dssadmin"
+)
+
+// maxEventTimeout limits the time an event/get request is kept open
+const maxEventTimeout = 60 * time.Second
+
+// Call is a request that has been received by the Server
+type Call struct {
+	Path   string
+	Params url.Values
+}
+
+// Event is a dSS event that could be delivered to subscribers with Server.PushEvent
+type Event struct {
+	Name       string                 `json:"name"`
+	Properties map[string]interface{} `json:"properties"`
+	Source     map[string]interface{} `json:"source"`
+}
+
+type handlerFunc func(r *http.Request, params url.Values) (interface{}, error)
+
+// Server is a stand-in dSS serving the JSON API via http. It embeds an httptest.Server, thus its URL
+// could be used as base URL for an account.
+type Server struct {
+	*httptest.Server
+
+	mutex             sync.Mutex
+	apartment         *Apartment
+	handlers          map[string]handlerFunc
+	calls             []Call
+	failures          map[string]string
+	statusFailures    map[string]*statusFailure
+	applicationTokens map[string]bool
+	pendingTokens     map[string]bool
+	sessionTokens     map[string]bool
+	sessionCount      int
+	username          string
+	password          string
+
+	pendingChannelValues map[string]map[digitalstrom.OutputChannelType]float64
+
+	subscriptions map[string]map[string]bool
+	eventQueues   map[string][]Event
+	eventNotify   chan struct{}
+}
+
+// NewServer starts and returns a new Server working on the given apartment. The Server accepts the
+// application token ApplicationToken. The Server has to be closed by the caller.
+func NewServer(apartment *Apartment) *Server {
+	s := newServer(apartment)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer starts and returns a new Server using TLS. Use Client() to get an http client that
+// trusts the certificate of the Server.
+func NewTLSServer(apartment *Apartment) *Server {
+	s := newServer(apartment)
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer(apartment *Apartment) *Server {
+	s := &Server{
+		apartment:            apartment,
+		failures:             make(map[string]string),
+		statusFailures:       make(map[string]*statusFailure),
+		applicationTokens:    map[string]bool{ApplicationToken: true},
+		username:             Username,
+		password:             Password,
+		pendingTokens:        make(map[string]bool),
+		sessionTokens:        make(map[string]bool),
+		pendingChannelValues: make(map[string]map[digitalstrom.OutputChannelType]float64),
+		subscriptions:        make(map[string]map[string]bool),
+		eventQueues:          make(map[string][]Event),
+		eventNotify:          make(chan struct{}),
+	}
+	s.handlers = map[string]handlerFunc{
+		"/json/system/loginApplication":               s.handleLoginApplication,
+		"/json/system/requestApplicationToken":        s.handleRequestApplicationToken,
+		"/json/system/login":                          s.handleLogin,
+		"/json/system/enableToken":                    s.handleEnableToken,
+		"/json/system/version":                        s.handleVersion,
+		"/json/apartment/getStructure":                s.handleGetStructure,
+		"/json/apartment/getCircuits":                 s.handleGetCircuits,
+		"/json/apartment/getTemperatureControlStatus": s.handleGetTemperatureControlStatus,
+		"/json/apartment/getDeviceBinaryInputs":       s.handleGetDeviceBinaryInputs,
+		"/json/apartment/callScene":                   s.handleApartmentScene,
+		"/json/apartment/undoScene":                   s.handleApartmentScene,
+		"/json/device/getSensorValue":                 s.handleGetSensorValue,
+		"/json/device/getOutputValue":                 s.handleGetOutputValue,
+		"/json/device/setOutputChannelValue":          s.handleSetOutputChannelValue,
+		"/json/apartment/setFloorName":                s.handleSetFloorName,
+		"/json/device/setName":                        s.handleSetDeviceName,
+		"/json/device/turnOn":                         s.handleTurnOn,
+		"/json/device/turnOff":                        s.handleTurnOff,
+		"/json/device/callScene":                      s.handleDeviceScene,
+		"/json/device/undoScene":                      s.handleDeviceScene,
+		"/json/device/saveScene":                      s.handleDeviceScene,
+		"/json/zone/callScene":                        s.handleZoneScene,
+		"/json/zone/undoScene":                        s.handleZoneScene,
+		"/json/zone/saveScene":                        s.handleZoneScene,
+		"/json/zone/getTemperatureControlConfig":      s.handleGetTemperatureControlConfig,
+		"/json/zone/setTemperatureControlConfig":      s.handleSetTemperatureControlConfig,
+		"/json/zone/getTemperatureControlValues":      s.handleGetTemperatureControlValues,
+		"/json/zone/setTemperatureControlValues":      s.handleSetTemperatureControlValues,
+		"/json/zone/pushSensorValue":                  s.handlePushSensorValue,
+		"/json/zone/setName":                          s.handleSetZoneName,
+		"/json/structure/zoneAddDevice":               s.handleZoneAddDevice,
+		"/json/structure/addZone":                     s.handleAddZone,
+		"/json/structure/removeZone":                  s.handleRemoveZone,
+		"/json/structure/addGroup":                    s.handleAddGroup,
+		"/json/structure/groupAddDevice":              s.handleGroupMembership,
+		"/json/structure/groupRemoveDevice":           s.handleGroupMembership,
+		"/json/circuit/getConsumption":                s.handleGetConsumption,
+		"/json/circuit/getEnergyMeterValue":           s.handleGetEnergyMeterValue,
+		"/json/metering/getResolutions":               s.handleGetResolutions,
+		"/json/metering/getSeries":                    s.handleGetSeries,
+		"/json/metering/getValues":                    s.handleGetValues,
+		"/json/metering/getLatest":                    s.handleGetLatest,
+		"/json/property/getString":                    s.handleGetProperty,
+		"/json/property/getInteger":                   s.handleGetProperty,
+		"/json/property/getBoolean":                   s.handleGetProperty,
+		"/json/property/getFloating":                  s.handleGetProperty,
+		"/json/property/setString":                    s.handleSetProperty,
+		"/json/property/setInteger":                   s.handleSetProperty,
+		"/json/property/setBoolean":                   s.handleSetProperty,
+		"/json/property/setFloating":                  s.handleSetProperty,
+		"/json/property/getChildren":                  s.handleGetPropertyChildren,
+		"/json/property/getType":                      s.handleGetPropertyType,
+		"/json/property/query":                        s.handlePropertyQuery,
+		"/json/property/query2":                       s.handlePropertyQuery,
+		"/json/event/subscribe":                       s.handleSubscribe,
+		"/json/event/unsubscribe":                     s.handleUnsubscribe,
+		"/json/event/get":                             s.handleGetEvents,
+	}
+	return s
+}
+
+// Update calls the given function with the apartment model of the server. The server does not handle
+// requests while the function is running. Use Update to modify or inspect the apartment.
+func (s *Server) Update(f func(a *Apartment)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f(s.apartment)
+}
+
+// Calls returns all requests the server received so far
+func (s *Server) Calls() []Call {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	calls := make([]Call, len(s.calls))
+	copy(calls, s.calls)
+	return calls
+}
+
+// CallsTo returns all requests the server received for the given path, e.g. "/json/device/turnOn"
+func (s *Server) CallsTo(path string) []Call {
+	calls := []Call{}
+	for _, call := range s.Calls() {
+		if call.Path == path {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// SetFailure lets the server answer all requests for the given path with ok=false and the given
+// message. An empty message removes the failure.
+func (s *Server) SetFailure(path string, message string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if message == "" {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = message
+}
+
+// statusFailure is an http status the server answers requests of a path with
+type statusFailure struct {
+	status int
+	times  int // remaining requests, lower than 1 for unlimited
+}
+
+// SetHTTPStatus lets the server answer the next requests of the path with the given http status,
+// e.g. 503, instead of processing them. times is the number of requests answered with the status,
+// values lower than 1 let all requests fail. Status 0 removes the failure.
+func (s *Server) SetHTTPStatus(path string, status int, times int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if status == 0 {
+		delete(s.statusFailures, path)
+		return
+	}
+	s.statusFailures[path] = &statusFailure{status: status, times: times}
+}
+
+// ExpireSession invalidates all session tokens. The next request will be answered with
+// http status 403 until a new application login has been performed.
+func (s *Server) ExpireSession() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessionTokens = make(map[string]bool)
+}
+
+// SetCredentials replaces the user credentials accepted by /json/system/login, which are Username and
+// Password by default
+func (s *Server) SetCredentials(username string, password string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.username, s.password = username, password
+}
+
+// PushEvent queues the given event for all subscriptions that subscribed to an event with its name
+func (s *Server) PushEvent(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, names := range s.subscriptions {
+		if names[event.Name] {
+			s.eventQueues[id] = append(s.eventQueues[id], event)
+		}
+	}
+	close(s.eventNotify)
+	s.eventNotify = make(chan struct{})
+}
+
+// ServeHTTP handles all requests of the dSS JSON API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	s.mutex.Lock()
+	s.calls = append(s.calls, Call{Path: r.URL.Path, Params: params})
+	handler, ok := s.handlers[r.URL.Path]
+	failure, failing := s.failures[r.URL.Path]
+	status := 0
+	if f, ok := s.statusFailures[r.URL.Path]; ok {
+		status = f.status
+		f.times--
+		if f.times == 0 {
+			delete(s.statusFailures, r.URL.Path)
+		}
+	}
+	authorized := isPublicPath(r.URL.Path)
+	for _, token := range params["token"] {
+		authorized = authorized || s.sessionTokens[token]
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if !authorized {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if failing {
+		writeResponse(w, nil, errors.New(failure))
+		return
+	}
+	result, err := handler(r, params)
+	writeResponse(w, result, err)
+}
+
+func isPublicPath(path string) bool {
+	switch path {
+	case "/json/system/loginApplication", "/json/system/requestApplicationToken", "/json/system/login", "/json/system/version":
+		return true
+	}
+	return false
+}
+
+func writeResponse(w http.ResponseWriter, result interface{}, err error) {
+	response := map[string]interface{}{"ok": err == nil}
+	if err != nil {
+		response["message"] = err.Error()
+	} else if result != nil {
+		response["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ------------------------------------ system --------------------------------------
+
+func (s *Server) handleLoginApplication(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.applicationTokens[params.Get("loginToken")] {
+		return nil, errors.New("Application-Authentication failed")
+	}
+	return map[string]interface{}{"token": s.newSessionToken()}, nil
+}
+
+func (s *Server) newSessionToken() string {
+	s.sessionCount++
+	token := fmt.Sprintf("dsstest-session-token-%d", s.sessionCount)
+	s.sessionTokens[token] = true
+	return token
+}
+
+func (s *Server) handleRequestApplicationToken(r *http.Request, params url.Values) (interface{}, error) {
+	if params.Get("applicationName") == "" {
+		return nil, errors.New("missing parameter 'applicationName'")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token := fmt.Sprintf("dsstest-application-token-%d", len(s.pendingTokens)+len(s.applicationTokens))
+	s.pendingTokens[token] = true
+	return map[string]interface{}{"applicationToken": token}, nil
+}
+
+func (s *Server) handleLogin(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if params.Get("user") != s.username || params.Get("password") != s.password {
+		return nil, errors.New("Authentication failed")
+	}
+	return map[string]interface{}{"token": s.newSessionToken()}, nil
+}
+
+func (s *Server) handleEnableToken(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token := params.Get("applicationToken")
+	if !s.pendingTokens[token] {
+		return nil, errors.New("unknown application token")
+	}
+	delete(s.pendingTokens, token)
+	s.applicationTokens[token] = true
+	return nil, nil
+}
+
+func (s *Server) handleVersion(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.apartment.System, nil
+}
+
+// ----------------------------------- apartment ------------------------------------
+
+func (s *Server) handleGetStructure(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// the dSS does not deliver output channel values with the structure, they are zeroed in a deep copy
+	structure := digitalstrom.Structure{}
+	b, err := json.Marshal(s.apartment.Structure)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &structure); err != nil {
+		return nil, err
+	}
+	for i := range structure.Apartment.Zones {
+		for _, device := range structure.Apartment.Zones[i].Devices {
+			for _, channel := range device.OutputChannels {
+				channel.Value = 0
+			}
+		}
+	}
+	return structure, nil
+}
+
+func (s *Server) handleGetCircuits(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return map[string]interface{}{"circuits": s.apartment.Circuits}, nil
+}
+
+func (s *Server) handleGetTemperatureControlStatus(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return map[string]interface{}{"zones": s.apartment.TemperatureControl}, nil
+}
+
+func (s *Server) handleGetDeviceBinaryInputs(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	devices := []interface{}{}
+	for _, device := range s.apartment.devices() {
+		if len(device.BinaryInputs) == 0 {
+			continue
+		}
+		inputs := []interface{}{}
+		for _, input := range device.BinaryInputs {
+			inputs = append(inputs, map[string]interface{}{
+				"inputId":     input.InputID,
+				"inputType":   input.InputType,
+				"targetGroup": input.TargetGroup,
+				"state":       input.State,
+			})
+		}
+		devices = append(devices, map[string]interface{}{
+			"dsuid":        device.UUID,
+			"name":         device.Name,
+			"binaryInputs": inputs,
+		})
+	}
+	return map[string]interface{}{"devices": devices}, nil
+}
+
+func (s *Server) handleApartmentScene(r *http.Request, params url.Values) (interface{}, error) {
+	scene, group, err := sceneParams(params)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if strings.HasSuffix(r.URL.Path, "callScene") {
+		for _, device := range s.apartment.devices() {
+			applyScene(device, group, scene)
+		}
+	}
+	s.applyStateScene(scene, strings.HasSuffix(r.URL.Path, "undoScene"))
+	return nil, nil
+}
+
+// stateScene is the change of an apartment state caused by an apartment scene
+type stateScene struct {
+	name  string
+	state string
+	value int
+}
+
+var stateScenes = map[digitalstrom.SceneNumber]stateScene{
+	digitalstrom.SNpresent:  {digitalstrom.ASpresence, digitalstrom.SVpresent, 1},
+	digitalstrom.SNabsent:   {digitalstrom.ASpresence, digitalstrom.SVabsent, 2},
+	digitalstrom.SNwakeup:   {digitalstrom.AShibernation, digitalstrom.SVawake, 1},
+	digitalstrom.SNsleeping: {digitalstrom.AShibernation, digitalstrom.SVsleeping, 2},
+	digitalstrom.SNalarm1:   {digitalstrom.ASalarm1, digitalstrom.SVactive, 1},
+	digitalstrom.SNalarm2:   {digitalstrom.ASalarm2, digitalstrom.SVactive, 1},
+	digitalstrom.SNalarm3:   {digitalstrom.ASalarm3, digitalstrom.SVactive, 1},
+	digitalstrom.SNalarm4:   {digitalstrom.ASalarm4, digitalstrom.SVactive, 1},
+	digitalstrom.SNpanic:    {digitalstrom.ASpanic, digitalstrom.SVactive, 1},
+	digitalstrom.SNfire:     {digitalstrom.ASfire, digitalstrom.SVactive, 1},
+	digitalstrom.SNwind:     {digitalstrom.ASwind, digitalstrom.SVactive, 1},
+	digitalstrom.SNnoWind:   {digitalstrom.ASwind, digitalstrom.SVinactive, 2},
+	digitalstrom.SNrain:     {digitalstrom.ASrain, digitalstrom.SVactive, 1},
+	digitalstrom.SNnoRain:   {digitalstrom.ASrain, digitalstrom.SVinactive, 2},
+	digitalstrom.SNhail:     {digitalstrom.AShail, digitalstrom.SVactive, 1},
+	digitalstrom.SNnoHail:   {digitalstrom.AShail, digitalstrom.SVinactive, 2},
+}
+
+// applyStateScene updates the apartment state in /usr/states that is changed by the scene. Undoing
+// an alarm, panic or fire scene resets the state to inactive. The server lock has to be held by the caller.
+func (s *Server) applyStateScene(scene digitalstrom.SceneNumber, undo bool) {
+	change, ok := stateScenes[scene]
+	if !ok {
+		return
+	}
+	if undo {
+		if change.state != digitalstrom.SVactive {
+			return
+		}
+		change.state, change.value = digitalstrom.SVinactive, 2
+	}
+	if s.apartment.Properties == nil {
+		s.apartment.Properties = make(map[string]interface{})
+	}
+	s.apartment.Properties["/usr/states/"+change.name+"/state"] = change.state
+	s.apartment.Properties["/usr/states/"+change.name+"/value"] = change.value
+}
+
+func (s *Server) handleSetFloorName(r *http.Request, params url.Values) (interface{}, error) {
+	floorID, err := strconv.Atoi(params.Get("floorID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid floor id '%s'", params.Get("floorID"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	floors := s.apartment.Structure.Apartment.Floors
+	for i := range floors {
+		if floors[i].ID == floorID {
+			floors[i].Name = params.Get("newName")
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("floor %d not found", floorID)
+}
+
+// ------------------------------------ device --------------------------------------
+
+func (s *Server) handleGetSensorValue(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	index, err := strconv.Atoi(params.Get("sensorIndex"))
+	if err != nil || index < 0 || index >= len(device.Sensors) {
+		return nil, fmt.Errorf("invalid sensorIndex '%s'", params.Get("sensorIndex"))
+	}
+	return map[string]interface{}{"sensorIndex": index, "sensorValue": device.Sensors[index].Value}, nil
+}
+
+func (s *Server) handleGetOutputValue(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := strconv.Atoi(params.Get("offset"))
+	if err != nil || offset < 0 || offset >= len(device.OutputChannels) {
+		return nil, fmt.Errorf("invalid offset '%s'", params.Get("offset"))
+	}
+	return map[string]interface{}{"offset": offset, "value": device.OutputChannels[offset].Value}, nil
+}
+
+// handleSetOutputChannelValue assigns the given channel values. Values are given as
+// channelvalues=<type>=<value>[;<type>=<value>]. With applyNow=false, the values are buffered
+// until a request of the same device with applyNow=true (default) is received.
+func (s *Server) handleSetOutputChannelValue(r *http.Request, params url.Values) (interface{}, error) {
+	applyNow := true
+	if params.Get("applyNow") != "" {
+		var err error
+		applyNow, err = strconv.ParseBool(params.Get("applyNow"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid applyNow '%s'", params.Get("applyNow"))
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	pending := s.pendingChannelValues[device.UUID]
+	for _, channelValue := range strings.Split(params.Get("channelvalues"), ";") {
+		pair := strings.SplitN(channelValue, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid channel value '%s'", channelValue)
+		}
+		channel, err := device.GetOutputChannel(digitalstrom.OutputChannelType(pair[0]))
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for channel '%s'", pair[1], pair[0])
+		}
+		if pending == nil {
+			pending = make(map[digitalstrom.OutputChannelType]float64)
+		}
+		pending[channel.ChannelType] = value
+	}
+	if !applyNow {
+		s.pendingChannelValues[device.UUID] = pending
+		return nil, nil
+	}
+	delete(s.pendingChannelValues, device.UUID)
+	for channelType, value := range pending {
+		channel, err := device.GetOutputChannel(channelType)
+		if err != nil {
+			return nil, err
+		}
+		// channel values are set in physical units, but delivered raw
+		if r, ok := channel.ChannelType.GetRange(); ok {
+			channel.Value = r.ToRaw(value)
+		} else {
+			channel.Value = int(value)
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) handleSetDeviceName(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	device.Name = params.Get("newName")
+	return nil, nil
+}
+
+func (s *Server) handleTurnOn(r *http.Request, params url.Values) (interface{}, error) {
+	return nil, s.setOn(params, true)
+}
+
+func (s *Server) handleTurnOff(r *http.Request, params url.Values) (interface{}, error) {
+	return nil, s.setOn(params, false)
+}
+
+func (s *Server) setOn(params url.Values, on bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return err
+	}
+	device.On = on
+	return nil
+}
+
+func (s *Server) handleDeviceScene(r *http.Request, params url.Values) (interface{}, error) {
+	scene, _, err := sceneParams(params)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(r.URL.Path, "callScene") {
+		applyScene(device, digitalstrom.ATbroadcast, scene)
+	}
+	return nil, nil
+}
+
+// getDevice returns the device that is addressed by the parameters dsuid or dsid
+func (s *Server) getDevice(params url.Values) (*digitalstrom.Device, error) {
+	id := params.Get("dsuid")
+	if id == "" {
+		id = params.Get("dsid")
+	}
+	if id == "" {
+		return nil, errors.New("missing parameter 'dsuid' or 'dsid'")
+	}
+	device := s.apartment.GetDevice(id)
+	if device == nil {
+		return nil, fmt.Errorf("device '%s' not found", id)
+	}
+	return device, nil
+}
+
+// ------------------------------------- zone ---------------------------------------
+
+func (s *Server) handleZoneScene(r *http.Request, params url.Values) (interface{}, error) {
+	scene, group, err := sceneParams(params)
+	if err != nil {
+		return nil, err
+	}
+	zoneID, err := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("id"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	devices, ok := s.apartment.zoneDevices(zoneID)
+	if !ok {
+		return nil, fmt.Errorf("zone %d not found", zoneID)
+	}
+	if strings.HasSuffix(r.URL.Path, "callScene") {
+		if group == digitalstrom.ATtemperatureControl {
+			for _, state := range s.apartment.TemperatureControl {
+				if zoneID == 0 || state.ZoneId == zoneID {
+					s.applyOperationMode(state.ZoneId, digitalstrom.OperationMode(scene))
+				}
+			}
+			return nil, nil
+		}
+		for _, device := range devices {
+			applyScene(device, group, scene)
+		}
+	}
+	return nil, nil
+}
+
+// applyOperationMode sets the operation mode and the corresponding nominal value of the zone
+func (s *Server) applyOperationMode(zoneID int, mode digitalstrom.OperationMode) {
+	state := s.apartment.GetTemperatureControl(zoneID)
+	if state == nil {
+		return
+	}
+	state.OperationMode = int(mode)
+	if value, ok := s.apartment.TemperatureControlValues[zoneID][mode]; ok {
+		state.NominalValue = value
+	}
+}
+
+func (s *Server) handleGetTemperatureControlConfig(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("id"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	config, ok := s.apartment.TemperatureControlConfig[zoneID]
+	if !ok {
+		return nil, fmt.Errorf("zone %d has no temperature control", zoneID)
+	}
+	return config, nil
+}
+
+func (s *Server) handleSetTemperatureControlConfig(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("id"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	config, ok := s.apartment.TemperatureControlConfig[zoneID]
+	if !ok {
+		return nil, fmt.Errorf("zone %d has no temperature control", zoneID)
+	}
+	// merge the given parameters into the stored configuration by using its json representation
+	values := map[string]interface{}{}
+	b, _ := json.Marshal(config)
+	json.Unmarshal(b, &values)
+	for key := range values {
+		if params.Get(key) == "" {
+			continue
+		}
+		switch values[key].(type) {
+		case bool:
+			values[key] = params.Get(key) == "true"
+		default:
+			f, err := strconv.ParseFloat(params.Get(key), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s' for %s", params.Get(key), key)
+			}
+			values[key] = f
+		}
+	}
+	b, _ = json.Marshal(values)
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	s.apartment.TemperatureControlConfig[zoneID] = config
+	if state := s.apartment.GetTemperatureControl(zoneID); state != nil {
+		state.ControlMode = int(config.ControlMode)
+	}
+	return nil, nil
+}
+
+// operationModeKeys are the parameter names of the nominal values of each operation mode
+var operationModeKeys = []string{"Off", "Comfort", "Economy", "NotUsed", "Night", "Holiday", "Cooling", "CoolingOff"}
+
+func (s *Server) handleGetTemperatureControlValues(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("id"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values, ok := s.apartment.TemperatureControlValues[zoneID]
+	if !ok {
+		return nil, fmt.Errorf("zone %d has no temperature control", zoneID)
+	}
+	result := map[string]interface{}{}
+	for mode, value := range values {
+		result[operationModeKeys[mode]] = value
+	}
+	return result, nil
+}
+
+func (s *Server) handleSetTemperatureControlValues(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("id"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values, ok := s.apartment.TemperatureControlValues[zoneID]
+	if !ok {
+		return nil, fmt.Errorf("zone %d has no temperature control", zoneID)
+	}
+	for mode, key := range operationModeKeys {
+		if params.Get(key) == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(params.Get(key), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for %s", params.Get(key), key)
+		}
+		values[digitalstrom.OperationMode(mode)] = value
+	}
+	if state := s.apartment.GetTemperatureControl(zoneID); state != nil {
+		state.NominalValue = values[digitalstrom.OperationMode(state.OperationMode)]
+	}
+	return nil, nil
+}
+
+func (s *Server) handlePushSensorValue(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("id"))
+	}
+	sensorType, err := strconv.Atoi(params.Get("sensorType"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sensorType '%s'", params.Get("sensorType"))
+	}
+	value, err := strconv.ParseFloat(params.Get("sensorValue"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sensorValue '%s'", params.Get("sensorValue"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state := s.apartment.GetTemperatureControl(zoneID)
+	if state == nil {
+		return nil, nil
+	}
+	switch digitalstrom.SensorType(sensorType) {
+	case digitalstrom.STroomTemperature:
+		state.TemperatureValue = value
+	case digitalstrom.STroomTemperatureControlVariable:
+		state.ControlValue = value
+	}
+	return nil, nil
+}
+
+func sceneParams(params url.Values) (digitalstrom.SceneNumber, digitalstrom.ApplicationType, error) {
+	scene, err := strconv.Atoi(params.Get("sceneNumber"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid sceneNumber '%s'", params.Get("sceneNumber"))
+	}
+	group := 0
+	if params.Get("groupID") != "" {
+		group, err = strconv.Atoi(params.Get("groupID"))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid groupID '%s'", params.Get("groupID"))
+		}
+	}
+	return digitalstrom.SceneNumber(scene), digitalstrom.ApplicationType(group), nil
+}
+
+// applyScene sets the On state of the device when it is part of the given group. Off, deep off,
+// standby and absent turn devices off, presets and maximum turn them on.
+func applyScene(device *digitalstrom.Device, group digitalstrom.ApplicationType, scene digitalstrom.SceneNumber) {
+	if group != digitalstrom.ATbroadcast {
+		member := false
+		for _, g := range device.Groups {
+			member = member || g == group.GetID()
+		}
+		if !member {
+			return
+		}
+	}
+	switch scene {
+	case digitalstrom.SNoff, digitalstrom.SNdeepOff, digitalstrom.SNstandby, digitalstrom.SNabsent, digitalstrom.SNsleeping:
+		device.On = false
+	case digitalstrom.SNpreset1, digitalstrom.SNpreset2, digitalstrom.SNpreset3, digitalstrom.SNpreset4, digitalstrom.SNmaximum:
+		device.On = true
+	}
+}
+
+func (s *Server) handleSetZoneName(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("id"))
+	}
+	name := params.Get("newName")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	zone := s.apartment.GetZone(zoneID)
+	if zone == nil {
+		return nil, fmt.Errorf("zone %d not found", zoneID)
+	}
+	zone.Name = name
+	if state := s.apartment.GetTemperatureControl(zoneID); state != nil {
+		state.Name = name
+	}
+	s.apartment.Properties["/apartment/zones/zone"+strconv.Itoa(zoneID)+"/name"] = name
+	return nil, nil
+}
+
+// ----------------------------------- structure ------------------------------------
+
+func (s *Server) handleZoneAddDevice(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("zone"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("zone"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	if s.apartment.GetZone(zoneID) == nil {
+		return nil, fmt.Errorf("zone %d not found", zoneID)
+	}
+	if device.ZoneID == zoneID {
+		return nil, nil
+	}
+	moved := *device
+	moved.ZoneID = zoneID
+	zones := s.apartment.Structure.Apartment.Zones
+	for i := range zones {
+		devices := []digitalstrom.Device{}
+		for _, d := range zones[i].Devices {
+			if d.UUID != moved.UUID {
+				devices = append(devices, d)
+			}
+		}
+		if zones[i].ID == zoneID {
+			devices = append(devices, moved)
+		}
+		zones[i].Devices = devices
+		for j := range zones[i].Groups {
+			group := &zones[i].Groups[j]
+			group.Devices = removeString(group.Devices, moved.UUID)
+			if zones[i].ID == zoneID && containsInt(moved.Groups, group.ID) {
+				group.Devices = append(group.Devices, moved.UUID)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) handleAddZone(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("zoneID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("zoneID"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.apartment.GetZone(zoneID) != nil {
+		return nil, fmt.Errorf("zone %d already exists", zoneID)
+	}
+	s.apartment.Structure.Apartment.Zones = append(s.apartment.Structure.Apartment.Zones, digitalstrom.Zone{
+		ID: zoneID, IsPresent: true, Devices: []digitalstrom.Device{}, Groups: []digitalstrom.Group{},
+	})
+	s.apartment.Properties["/apartment/zones/zone"+strconv.Itoa(zoneID)+"/ZoneID"] = zoneID
+	s.apartment.Properties["/apartment/zones/zone"+strconv.Itoa(zoneID)+"/name"] = ""
+	return map[string]interface{}{"zoneID": zoneID}, nil
+}
+
+func (s *Server) handleRemoveZone(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("zoneID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("zoneID"))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	zone := s.apartment.GetZone(zoneID)
+	if zone == nil {
+		return nil, fmt.Errorf("zone %d not found", zoneID)
+	}
+	if len(zone.Devices) > 0 {
+		return nil, fmt.Errorf("zone %d still contains devices", zoneID)
+	}
+	zones := []digitalstrom.Zone{}
+	for _, z := range s.apartment.Structure.Apartment.Zones {
+		if z.ID != zoneID {
+			zones = append(zones, z)
+		}
+	}
+	s.apartment.Structure.Apartment.Zones = zones
+	floors := s.apartment.Structure.Apartment.Floors
+	for i := range floors {
+		ids := []int{}
+		for _, id := range floors[i].Zones {
+			if id != zoneID {
+				ids = append(ids, id)
+			}
+		}
+		floors[i].Zones = ids
+	}
+	delete(s.apartment.Properties, "/apartment/zones/zone"+strconv.Itoa(zoneID)+"/ZoneID")
+	delete(s.apartment.Properties, "/apartment/zones/zone"+strconv.Itoa(zoneID)+"/name")
+	return nil, nil
+}
+
+func (s *Server) handleAddGroup(r *http.Request, params url.Values) (interface{}, error) {
+	zoneID, err := strconv.Atoi(params.Get("zoneID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone id '%s'", params.Get("zoneID"))
+	}
+	groupID, err := strconv.Atoi(params.Get("groupID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid group id '%s'", params.Get("groupID"))
+	}
+	color, _ := strconv.Atoi(params.Get("groupColor"))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	zone := s.apartment.GetZone(zoneID)
+	if zone == nil {
+		return nil, fmt.Errorf("zone %d not found", zoneID)
+	}
+	for _, group := range zone.Groups {
+		if group.ID == groupID {
+			return nil, fmt.Errorf("group %d already exists in zone %d", groupID, zoneID)
+		}
+	}
+	zone.Groups = append(zone.Groups, digitalstrom.Group{
+		ID: groupID, Name: params.Get("groupName"), Color: color, IsPresent: true, IsValid: true, Devices: []string{},
+	})
+	return map[string]interface{}{"groupID": groupID}, nil
+}
+
+// handleGroupMembership handles groupAddDevice and groupRemoveDevice. The device is added to or removed from
+// the group of its zone and of the apartment zone 0.
+func (s *Server) handleGroupMembership(r *http.Request, params url.Values) (interface{}, error) {
+	groupID, err := strconv.Atoi(params.Get("groupID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid group id '%s'", params.Get("groupID"))
+	}
+	join := strings.HasSuffix(r.URL.Path, "groupAddDevice")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device, err := s.getDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	device.Groups = removeInt(device.Groups, groupID)
+	if join {
+		device.Groups = append(device.Groups, groupID)
+	}
+	for i := range s.apartment.Structure.Apartment.Zones {
+		zone := &s.apartment.Structure.Apartment.Zones[i]
+		if zone.ID != device.ZoneID && zone.ID != 0 {
+			continue
+		}
+		for j := range zone.Groups {
+			group := &zone.Groups[j]
+			if group.ID != groupID {
+				continue
+			}
+			group.Devices = removeString(group.Devices, device.UUID)
+			if join {
+				group.Devices = append(group.Devices, device.UUID)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func removeInt(list []int, value int) []int {
+	result := []int{}
+	for _, v := range list {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func removeString(list []string, value string) []string {
+	result := []string{}
+	for _, v := range list {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// ------------------------------------ circuit -------------------------------------
+
+func (s *Server) handleGetConsumption(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	circuit := s.apartment.GetCircuit(params.Get("dsuid"))
+	if circuit == nil {
+		return nil, fmt.Errorf("circuit '%s' not found", params.Get("dsuid"))
+	}
+	return map[string]interface{}{"consumption": circuit.Consumption}, nil
+}
+
+func (s *Server) handleGetEnergyMeterValue(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	circuit := s.apartment.GetCircuit(params.Get("dsuid"))
+	if circuit == nil {
+		return nil, fmt.Errorf("circuit '%s' not found", params.Get("dsuid"))
+	}
+	return map[string]interface{}{"meterValue": circuit.MeterValue}, nil
+}
+
+// ------------------------------------ metering ------------------------------------
+
+// meteringResolutions are the resolutions in seconds the Server is pretending to record
+var meteringResolutions = []int{1, 60, 900, 86400}
+
+func (s *Server) handleGetResolutions(r *http.Request, params url.Values) (interface{}, error) {
+	resolutions := []map[string]interface{}{}
+	for _, resolution := range meteringResolutions {
+		resolutions = append(resolutions, map[string]interface{}{"resolution": resolution})
+	}
+	return map[string]interface{}{"resolutions": resolutions}, nil
+}
+
+func (s *Server) handleGetSeries(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	series := []map[string]interface{}{}
+	for _, circuit := range s.apartment.Circuits {
+		if !circuit.HasMetering {
+			continue
+		}
+		for _, t := range []string{"energy", "consumption"} {
+			series = append(series, map[string]interface{}{"dsid": circuit.DSID, "dSUID": circuit.DSUID, "type": t})
+		}
+	}
+	return map[string]interface{}{"series": series}, nil
+}
+
+// handleGetValues returns a series of constant values derived from the current consumption and meter
+// values of the requested circuits, ending with the current time.
+func (s *Server) handleGetValues(r *http.Request, params url.Values) (interface{}, error) {
+	circuits, err := s.meteringCircuits(params.Get("dsuid"))
+	if err != nil {
+		return nil, err
+	}
+	resolution, err := strconv.Atoi(params.Get("resolution"))
+	if err != nil || resolution <= 0 {
+		return nil, errors.New("invalid parameter 'resolution'")
+	}
+	count := 10
+	if params.Get("valueCount") != "" {
+		count, err = strconv.Atoi(params.Get("valueCount"))
+		if err != nil {
+			return nil, errors.New("invalid parameter 'valueCount'")
+		}
+	}
+	meteringType, unit := params.Get("type"), params.Get("unit")
+	if unit == "" {
+		unit = "Wh"
+	}
+
+	ids := []string{}
+	value := 0.0
+	for _, circuit := range circuits {
+		ids = append(ids, circuit.DSUID)
+		switch meteringType {
+		case "consumption":
+			value += float64(circuit.Consumption)
+		case "energy":
+			value += float64(circuit.MeterValue)
+		case "energyDelta":
+			value += float64(circuit.Consumption * resolution)
+		default:
+			return nil, fmt.Errorf("unknown metering type '%s'", meteringType)
+		}
+	}
+	if meteringType == "consumption" {
+		unit = "W"
+	} else if unit == "Wh" {
+		value = value / 3600
+	}
+
+	end := time.Now().Unix() / int64(resolution) * int64(resolution)
+	values := [][]interface{}{}
+	for i := count - 1; i >= 0; i-- {
+		values = append(values, []interface{}{end - int64(i*resolution), value})
+	}
+	return map[string]interface{}{
+		"meterID":    ids,
+		"type":       meteringType,
+		"unit":       unit,
+		"resolution": strconv.Itoa(resolution),
+		"values":     values,
+	}, nil
+}
+
+func (s *Server) handleGetLatest(r *http.Request, params url.Values) (interface{}, error) {
+	from := strings.TrimSuffix(strings.TrimPrefix(params.Get("from"), ".meters("), ")")
+	circuits, err := s.meteringCircuits(from)
+	if err != nil {
+		return nil, err
+	}
+	date := time.Now().Format("2006-01-02 15:04:05")
+	values := []map[string]interface{}{}
+	for _, circuit := range circuits {
+		value := float64(circuit.Consumption)
+		if params.Get("type") == "energy" {
+			value = float64(circuit.MeterValue) / 3600
+		}
+		values = append(values, map[string]interface{}{"dsid": circuit.DSID, "dSUID": circuit.DSUID, "value": value, "date": date})
+	}
+	return map[string]interface{}{"values": values}, nil
+}
+
+// meteringCircuits returns copies of the circuits addressed by the given meter, which is either a dSUID
+// or "all" respectively ".meters(all)"
+func (s *Server) meteringCircuits(meter string) ([]digitalstrom.Circuit, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if meter == "all" || meter == digitalstrom.MeteringAllMeters {
+		circuits := []digitalstrom.Circuit{}
+		for _, circuit := range s.apartment.Circuits {
+			if circuit.HasMetering {
+				circuits = append(circuits, circuit)
+			}
+		}
+		return circuits, nil
+	}
+	circuit := s.apartment.GetCircuit(meter)
+	if circuit == nil {
+		return nil, fmt.Errorf("meter '%s' not found", meter)
+	}
+	return []digitalstrom.Circuit{*circuit}, nil
+}
+
+// ------------------------------------ property ------------------------------------
+
+// propertyNode is a node of the property tree built from Apartment.Properties
+type propertyNode struct {
+	name     string
+	value    interface{}
+	children []*propertyNode
+}
+
+func (s *Server) handleGetProperty(r *http.Request, params url.Values) (interface{}, error) {
+	path := params.Get("path")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, ok := s.apartment.Properties[path]
+	if !ok {
+		return nil, fmt.Errorf("property '%s' not found", path)
+	}
+	requested := strings.TrimPrefix(r.URL.Path, "/json/property/get")
+	if !strings.EqualFold(propertyType(value), requested) {
+		return nil, fmt.Errorf("property '%s' is of type %s", path, propertyType(value))
+	}
+	return map[string]interface{}{"value": value}, nil
+}
+
+func (s *Server) handleSetProperty(r *http.Request, params url.Values) (interface{}, error) {
+	path := params.Get("path")
+	raw := params.Get("value")
+	var value interface{}
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, "/json/property/set") {
+	case "String":
+		value = raw
+	case "Integer":
+		value, err = strconv.Atoi(raw)
+	case "Boolean":
+		value, err = strconv.ParseBool(raw)
+	case "Floating":
+		value, err = strconv.ParseFloat(raw, 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%s'", raw)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path '%s'", path)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if old, ok := s.apartment.Properties[path]; ok && propertyType(old) != propertyType(value) {
+		return nil, fmt.Errorf("property '%s' is of type %s", path, propertyType(old))
+	}
+	if s.apartment.Properties == nil {
+		s.apartment.Properties = make(map[string]interface{})
+	}
+	s.apartment.Properties[path] = value
+	return nil, nil
+}
+
+func (s *Server) handleGetPropertyChildren(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	node := s.propertyTree().find(params.Get("path"))
+	if node == nil {
+		return nil, fmt.Errorf("property '%s' not found", params.Get("path"))
+	}
+	children := []map[string]interface{}{}
+	for _, child := range node.children {
+		children = append(children, map[string]interface{}{"name": child.name, "type": propertyType(child.value)})
+	}
+	return children, nil
+}
+
+func (s *Server) handleGetPropertyType(r *http.Request, params url.Values) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	node := s.propertyTree().find(params.Get("path"))
+	if node == nil {
+		return nil, fmt.Errorf("property '%s' not found", params.Get("path"))
+	}
+	return map[string]interface{}{"type": propertyType(node.value)}, nil
+}
+
+// handlePropertyQuery evaluates queries like "/apartment/zones/*(ZoneID,name)". Nodes without a list of
+// properties are not part of the result. Nodes matched by a wildcard are collected in a list named by
+// their parent (query) or added under their own name (query2).
+func (s *Server) handlePropertyQuery(r *http.Request, params url.Values) (interface{}, error) {
+	query := params.Get("query")
+	if !strings.HasPrefix(query, "/") {
+		return nil, fmt.Errorf("invalid query '%s'", query)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := map[string]interface{}{}
+	evaluatePropertyQuery(s.propertyTree(), strings.Split(strings.Trim(query, "/"), "/"), result, r.URL.Path == "/json/property/query2")
+	return result, nil
+}
+
+func evaluatePropertyQuery(node *propertyNode, segments []string, result map[string]interface{}, flat bool) {
+	if len(segments) == 0 || segments[0] == "" {
+		return
+	}
+	name := segments[0]
+	var properties []string
+	selected := false
+	if i := strings.Index(name, "("); i >= 0 && strings.HasSuffix(name, ")") {
+		properties = strings.Split(name[i+1:len(name)-1], ",")
+		name = name[:i]
+		selected = true
+	}
+	for _, child := range node.children {
+		if name != "*" && child.name != name {
+			continue
+		}
+		if !selected && name != "*" {
+			evaluatePropertyQuery(child, segments[1:], result, flat)
+			continue
+		}
+		object := map[string]interface{}{}
+		for _, property := range child.children {
+			if property.value == nil {
+				continue
+			}
+			for _, p := range properties {
+				if p == "*" || p == property.name {
+					object[property.name] = property.value
+				}
+			}
+		}
+		evaluatePropertyQuery(child, segments[1:], object, flat)
+		if name == "*" && !flat {
+			list, _ := result[node.name].([]interface{})
+			result[node.name] = append(list, object)
+		} else {
+			result[child.name] = object
+		}
+	}
+}
+
+// propertyTree builds the tree of Apartment.Properties with children sorted by name
+func (s *Server) propertyTree() *propertyNode {
+	root := &propertyNode{}
+	for path, value := range s.apartment.Properties {
+		node := root
+		for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+			node = node.child(name)
+		}
+		node.value = value
+	}
+	root.sort()
+	return root
+}
+
+func (n *propertyNode) child(name string) *propertyNode {
+	for _, child := range n.children {
+		if child.name == name {
+			return child
+		}
+	}
+	child := &propertyNode{name: name}
+	n.children = append(n.children, child)
+	return child
+}
+
+func (n *propertyNode) find(path string) *propertyNode {
+	node := n
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var next *propertyNode
+		for _, child := range node.children {
+			if child.name == name {
+				next = child
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+func (n *propertyNode) sort() {
+	sort.Slice(n.children, func(i, j int) bool { return n.children[i].name < n.children[j].name })
+	for _, child := range n.children {
+		child.sort()
+	}
+}
+
+func propertyType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int:
+		return "integer"
+	case bool:
+		return "boolean"
+	case float64:
+		return "floating"
+	}
+	return "none"
+}
+
+// ------------------------------------- event --------------------------------------
+
+func (s *Server) handleSubscribe(r *http.Request, params url.Values) (interface{}, error) {
+	id, name := params.Get("subscriptionID"), params.Get("name")
+	if id == "" || name == "" {
+		return nil, errors.New("missing parameter 'subscriptionID' or 'name'")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.subscriptions[id] == nil {
+		s.subscriptions[id] = make(map[string]bool)
+	}
+	s.subscriptions[id][name] = true
+	return nil, nil
+}
+
+func (s *Server) handleUnsubscribe(r *http.Request, params url.Values) (interface{}, error) {
+	id, name := params.Get("subscriptionID"), params.Get("name")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.subscriptions[id] == nil {
+		return nil, fmt.Errorf("subscription %s not found", id)
+	}
+	delete(s.subscriptions[id], name)
+	if len(s.subscriptions[id]) == 0 {
+		delete(s.subscriptions, id)
+		delete(s.eventQueues, id)
+	}
+	return nil, nil
+}
+
+// handleGetEvents returns the queued events of the subscription. When no events are queued, the
+// request is kept open until events are pushed or the timeout is reached.
+func (s *Server) handleGetEvents(r *http.Request, params url.Values) (interface{}, error) {
+	id := params.Get("subscriptionID")
+	timeout := maxEventTimeout
+	if ms, err := strconv.Atoi(params.Get("timeout")); err == nil && time.Duration(ms)*time.Millisecond < timeout {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	deadline := time.After(timeout)
+	for {
+		s.mutex.Lock()
+		if s.subscriptions[id] == nil {
+			s.mutex.Unlock()
+			return nil, fmt.Errorf("subscription %s not found", id)
+		}
+		events := s.eventQueues[id]
+		notify := s.eventNotify
+		if len(events) > 0 {
+			delete(s.eventQueues, id)
+			s.mutex.Unlock()
+			return map[string]interface{}{"events": events}, nil
+		}
+		s.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return map[string]interface{}{"events": []Event{}}, nil
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+}
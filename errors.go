@@ -0,0 +1,228 @@
+package digitalstrom
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Errors that could be checked with errors.Is. The errors returned by the library are of the types
+// below, which are matching the corresponding error, e.g. errors.Is(err, ErrNotFound) is true for
+// a *NotFoundError.
+var (
+	ErrAuthentication      = errors.New("authentication failed")
+	ErrSessionExpired      = errors.New("session expired")
+	ErrNotFound            = errors.New("not found")
+	ErrAPI                 = errors.New("dSS reported a failure")
+	ErrMalformedResponse   = errors.New("malformed response")
+	ErrTransport           = errors.New("transport error")
+	ErrNoToken             = errors.New("no application token stored")
+	ErrCertificateMismatch = errors.New("certificate mismatch")
+	ErrTokenNotSaved       = errors.New("application token not saved")
+	ErrReadOnlyTokenStore  = errors.New("token store is read-only")
+)
+
+// RequestError is returned when the dSS answered a request with an http status other than 200.
+// A status of 403 means that the session has been expired and the automatic application login did
+// not help, so it matches ErrSessionExpired.
+type RequestError struct {
+	Endpoint   string // path of the request, e.g. /json/device/getSensorValue
+	StatusCode int
+}
+
+// Error function of structure RequestError
+func (e *RequestError) Error() string {
+	return "request " + e.Endpoint + " failed - status code " + strconv.Itoa(e.StatusCode) + " (" + http.StatusText(e.StatusCode) + ")"
+}
+
+// Is returns true for ErrSessionExpired when the status code is 403
+func (e *RequestError) Is(target error) bool {
+	return target == ErrSessionExpired && e.StatusCode == http.StatusForbidden
+}
+
+// AuthError is returned when the application login or the login with user credentials failed,
+// e.g. because the application token is unknown or has not been enabled. Message is the reason
+// given by the dSS. Err is the error of the TokenStore when the application token could not be loaded.
+type AuthError struct {
+	Endpoint string
+	Message  string
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	return "authentication failed (" + e.Endpoint + ") - " + e.Message
+}
+
+// Is returns true for ErrAuthentication
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthentication
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// TokenSaveError is returned together with the new application token by RegisterApplication when the
+// application has been registered, but the TokenStore failed to save the token. The token is valid, so
+// callers should keep it. Err is the error of the TokenStore.
+type TokenSaveError struct {
+	Err error
+}
+
+func (e *TokenSaveError) Error() string {
+	return "application registered, but the token could not be saved: " + e.Err.Error()
+}
+
+// Is returns true for ErrTokenNotSaved
+func (e *TokenSaveError) Is(target error) bool {
+	return target == ErrTokenNotSaved
+}
+
+func (e *TokenSaveError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is returned when a device, sensor, output channel, circuit, binary input, zone or
+// apartment state is not part of the cache. Kind is one of "device", "sensor", "channel", "circuit",
+// "binaryInput", "zone" or "apartmentState", Parent is the display ID of the device for sensors,
+// channels and binary inputs.
+type NotFoundError struct {
+	Kind   string
+	ID     string
+	Parent string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Parent != "" {
+		return e.Kind + " '" + e.ID + "' of device '" + e.Parent + "' not found"
+	}
+	return e.Kind + " '" + e.ID + "' not found"
+}
+
+// Is returns true for ErrNotFound
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// APIError is returned when the dSS answered a request with ok=false. Message is the original
+// message of the dSS.
+type APIError struct {
+	Endpoint string
+	Message  string
+}
+
+func (e *APIError) Error() string {
+	return e.Message + " (" + e.Endpoint + ")"
+}
+
+// Is returns true for ErrAPI
+func (e *APIError) Is(target error) bool {
+	return target == ErrAPI
+}
+
+// MalformedResponseError is returned when a response is empty, could not be decoded or does not
+// contain an expected field. Err is the decoding error, if any.
+type MalformedResponseError struct {
+	Endpoint string
+	Field    string
+	Err      error
+}
+
+func (e *MalformedResponseError) Error() string {
+	if e.Err != nil {
+		return "unexpected response of " + e.Endpoint + " - " + e.Err.Error()
+	}
+	return "unexpected response of " + e.Endpoint + " - no valid field '" + e.Field + "' found"
+}
+
+// Is returns true for ErrMalformedResponse
+func (e *MalformedResponseError) Is(target error) bool {
+	return target == ErrMalformedResponse
+}
+
+func (e *MalformedResponseError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError is returned when a request could not be performed or its response could not be
+// read, e.g. because the dSS is not reachable. Err is the error of the http client.
+type TransportError struct {
+	Endpoint string
+	Err      error
+}
+
+// Error returns the message of the underlying error without the URL, which contains the session token.
+func (e *TransportError) Error() string {
+	err := e.Err
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return "request " + e.Endpoint + " failed - " + err.Error()
+}
+
+// Is returns true for ErrTransport
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransport
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// CertificateMismatchError is returned when the certificate of the dSS does not match the fingerprint
+// recorded by trust on first use or, if Pinned is true, none of the pinned SPKI hashes. Either the
+// certificate of the dSS has been replaced or the connection is intercepted. It is not retried.
+type CertificateMismatchError struct {
+	Host     string // host and port of the dSS
+	Expected string
+	Actual   string
+	Pinned   bool
+}
+
+func (e *CertificateMismatchError) Error() string {
+	if e.Pinned {
+		return "certificate of " + e.Host + " does not match the pinned public keys - SPKI hash is " + e.Actual
+	}
+	return "certificate of " + e.Host + " does not match the trusted fingerprint " + e.Expected + " - fingerprint is " + e.Actual
+}
+
+// Is returns true for ErrCertificateMismatch
+func (e *CertificateMismatchError) Is(target error) bool {
+	return target == ErrCertificateMismatch
+}
+
+// endpointOf returns the path of the given request URL, which is used to identify the endpoint in
+// errors without exposing tokens or credentials.
+func endpointOf(requestURL string) string {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "unknown endpoint"
+	}
+	return u.Path
+}
+
+// redactURLError removes password and query from the URL of the url.Error in the chain of err, which is
+// returned by the http client. The query contains the session token or, at the login with user
+// credentials, the password.
+func redactURLError(err error) {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+}
+
+// redactURL returns the URL without password and query, so it could be logged.
+func redactURL(requestURL string) string {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "invalid url"
+	}
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,89 @@
+package digitalstrom_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+func TestNotFoundError(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	_, err := a.GetDevice("unknown")
+	var notFound *digitalstrom.NotFoundError
+	if !errors.As(err, &notFound) || notFound.Kind != "device" || notFound.ID != "unknown" {
+		t.Errorf("expected a NotFoundError for the device, got %v", err)
+	}
+	_, err = a.GetSensor("00000003", 5)
+	if !errors.As(err, &notFound) || notFound.Kind != "sensor" || notFound.Parent != "00000003" {
+		t.Errorf("expected a NotFoundError for the sensor, got %v", err)
+	}
+	if !errors.Is(err, digitalstrom.ErrNotFound) {
+		t.Errorf("NotFoundError does not match ErrNotFound")
+	}
+}
+
+func TestSessionExpiredError(t *testing.T) {
+	srv, a := newTestAccount(t)
+	a.Connection.RetryPolicy = digitalstrom.RetryPolicy{MaxAttempts: 1}
+	// the request keeps failing after the automatic application login
+	srv.SetHTTPStatus("/json/device/turnOn", http.StatusForbidden, 0)
+	device, _ := a.GetDevice("00000001")
+
+	err := a.TurnOn(device, true)
+	if !errors.Is(err, digitalstrom.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+	if n := len(srv.CallsTo("/json/system/loginApplication")); n != 2 {
+		t.Errorf("expected an automatic application login, got %d logins", n)
+	}
+}
+
+func TestAuthErrorAfterRevokedToken(t *testing.T) {
+	srv, a := newTestAccount(t)
+	srv.ExpireSession()
+	a.SetApplicationToken("revoked")
+	device, _ := a.GetDevice("00000001")
+
+	err := a.TurnOn(device, true)
+	var authErr *digitalstrom.AuthError
+	if !errors.As(err, &authErr) || authErr.Endpoint != "/json/system/loginApplication" {
+		t.Errorf("expected an AuthError of the application login, got %v", err)
+	}
+}
+
+func TestMalformedResponseError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true,"result":{"token":`))
+	}))
+	defer srv.Close()
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetApplicationToken(dsstest.ApplicationToken)
+
+	err := a.ApplicationLogin()
+	var malformed *digitalstrom.MalformedResponseError
+	if !errors.As(err, &malformed) || malformed.Endpoint != "/json/system/loginApplication" {
+		t.Errorf("expected a MalformedResponseError, got %v", err)
+	}
+}
+
+func TestTransportErrorDoesNotContainToken(t *testing.T) {
+	srv, a := newTestAccount(t)
+	a.Connection.RetryPolicy = digitalstrom.RetryPolicy{MaxAttempts: 1}
+	srv.Close()
+	device, _ := a.GetDevice("00000001")
+
+	err := a.TurnOn(device, true)
+	if !errors.Is(err, digitalstrom.ErrTransport) {
+		t.Fatalf("expected a transport error, got %v", err)
+	}
+	if strings.Contains(err.Error(), a.Connection.GetSessionToken()) || strings.Contains(err.Error(), "token=") {
+		t.Errorf("error contains the session token: %v", err)
+	}
+}
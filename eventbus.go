@@ -0,0 +1,321 @@
+package digitalstrom
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy defines what happens when an event is published to a subscriber whose queue is full
+type OverflowPolicy int
+
+// Overflow Policies
+const (
+	// OverflowDropNewest discards the event that could not be queued
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued event in order to queue the new one
+	OverflowDropOldest
+	// OverflowBlock waits until the subscriber has consumed enough events. Polling and the event
+	// listener will be stalled by a slow subscriber, use with care.
+	OverflowBlock
+)
+
+const defaultSubscriptionBufferSize = 64
+
+// EventFilter restricts the events a subscriber receives. Empty lists match any value. When DeviceIDs,
+// ZoneIDs or SensorTypes are given, events without a device, zone or sensor type will not be received.
+type EventFilter struct {
+	Kinds       []EventKind
+	DeviceIDs   []string
+	ZoneIDs     []int
+	SensorTypes []SensorType
+}
+
+// SubscriptionSetup configures a subscription. Each subscriber has its own queue of BufferSize events
+// (default 64), so a slow subscriber does not affect other subscribers.
+type SubscriptionSetup struct {
+	Name       string
+	Filter     EventFilter
+	BufferSize int
+	Overflow   OverflowPolicy
+}
+
+// SubscriptionStats contains the counters of a single subscription
+type SubscriptionStats struct {
+	Name      string
+	Queued    int
+	Delivered uint64
+	Dropped   uint64
+}
+
+// EventStats contains the counters of all active subscriptions of an Account
+type EventStats struct {
+	Published     uint64
+	Delivered     uint64
+	Dropped       uint64
+	Subscriptions []SubscriptionStats
+}
+
+type eventBus struct {
+	subscriptions map[int]*subscription
+	nextID        int
+	published     uint64
+	delivered     uint64
+	dropped       uint64
+	mutex         *sync.RWMutex
+}
+
+type subscription struct {
+	setup     SubscriptionSetup
+	queue     chan Event
+	handler   func(Event)
+	done      chan struct{}
+	once      sync.Once
+	delivered uint64
+	dropped   uint64
+}
+
+// Subscribe registers a handler that will be called for every event matching the filter of the given
+// setup. Handlers are called sequentially from a dedicated goroutine per subscription. Subscribers receive
+// every change of the cache, regardless of whether it was detected by polling, the event listener or an
+// explicit Poll call. The returned function cancels the subscription, queued events will be discarded.
+func (a *Account) Subscribe(setup SubscriptionSetup, handler func(Event)) (cancel func()) {
+	return a.eventBus.subscribe(setup, handler)
+}
+
+// SubscribeChannel works like Subscribe but sends the events to the given channel. The channel will not
+// be closed when the subscription is cancelled.
+func (a *Account) SubscribeChannel(setup SubscriptionSetup, ch chan<- Event) (cancel func()) {
+	var sub *subscription
+	handler := func(event Event) {
+		select {
+		case ch <- event:
+		case <-sub.done:
+		}
+	}
+	sub = a.eventBus.newSubscription(setup, handler)
+	return a.eventBus.add(sub)
+}
+
+// EventStats returns the current counters of the event bus and all active subscriptions
+func (a *Account) EventStats() EventStats {
+	return a.eventBus.stats()
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscriptions: make(map[int]*subscription),
+		mutex:         &sync.RWMutex{},
+	}
+}
+
+func (b *eventBus) subscribe(setup SubscriptionSetup, handler func(Event)) func() {
+	return b.add(b.newSubscription(setup, handler))
+}
+
+func (b *eventBus) newSubscription(setup SubscriptionSetup, handler func(Event)) *subscription {
+	if setup.BufferSize <= 0 {
+		setup.BufferSize = defaultSubscriptionBufferSize
+	}
+	return &subscription{
+		setup:   setup,
+		queue:   make(chan Event, setup.BufferSize),
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+}
+
+func (b *eventBus) add(sub *subscription) func() {
+	b.mutex.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscriptions[id] = sub
+	b.mutex.Unlock()
+
+	go sub.run(b)
+
+	return func() {
+		// closing done first releases publishers that are blocked by this subscription
+		sub.once.Do(func() { close(sub.done) })
+		b.mutex.Lock()
+		delete(b.subscriptions, id)
+		b.mutex.Unlock()
+	}
+}
+
+// publish queues the event for all matching subscriptions
+func (b *eventBus) publish(event Event) {
+	atomic.AddUint64(&b.published, 1)
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, sub := range b.subscriptions {
+		if !sub.setup.Filter.matches(event) {
+			continue
+		}
+		if !sub.enqueue(event) {
+			atomic.AddUint64(&sub.dropped, 1)
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	}
+}
+
+func (b *eventBus) stats() EventStats {
+	stats := EventStats{
+		Published: atomic.LoadUint64(&b.published),
+		Delivered: atomic.LoadUint64(&b.delivered),
+		Dropped:   atomic.LoadUint64(&b.dropped),
+	}
+	b.mutex.RLock()
+	for _, sub := range b.subscriptions {
+		stats.Subscriptions = append(stats.Subscriptions, SubscriptionStats{
+			Name:      sub.setup.Name,
+			Queued:    len(sub.queue),
+			Delivered: atomic.LoadUint64(&sub.delivered),
+			Dropped:   atomic.LoadUint64(&sub.dropped),
+		})
+	}
+	b.mutex.RUnlock()
+	return stats
+}
+
+// enqueue adds the event to the queue of the subscription according to its overflow policy. Returns
+// false when an event has been dropped.
+func (s *subscription) enqueue(event Event) bool {
+	switch s.setup.Overflow {
+	case OverflowBlock:
+		select {
+		case s.queue <- event:
+			return true
+		case <-s.done:
+			return false
+		}
+	case OverflowDropOldest:
+		dropped := false
+		for {
+			select {
+			case s.queue <- event:
+				return !dropped
+			default:
+			}
+			select {
+			case <-s.queue:
+				dropped = true
+			default:
+			}
+		}
+	default:
+		select {
+		case s.queue <- event:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func (s *subscription) run(b *eventBus) {
+	for {
+		select {
+		case event := <-s.queue:
+			s.handler(event)
+			atomic.AddUint64(&s.delivered, 1)
+			atomic.AddUint64(&b.delivered, 1)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// matches returns true when the event passes all criteria of the filter
+func (f EventFilter) matches(event Event) bool {
+	if len(f.Kinds) > 0 {
+		found := false
+		for _, kind := range f.Kinds {
+			if kind == event.Kind() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	deviceID, zoneID, sensorType, hasSensorType := eventAttributes(event)
+	if len(f.DeviceIDs) > 0 {
+		found := false
+		for _, id := range f.DeviceIDs {
+			if deviceID != "" && id == deviceID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.ZoneIDs) > 0 {
+		found := false
+		for _, id := range f.ZoneIDs {
+			if zoneID >= 0 && id == zoneID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.SensorTypes) > 0 {
+		found := false
+		for _, t := range f.SensorTypes {
+			if hasSensorType && t == sensorType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// eventAttributes returns the attributes events could be filtered by. A zone id of -1 is returned
+// for events that are not related to a zone.
+func eventAttributes(event Event) (deviceID string, zoneID int, sensorType SensorType, hasSensorType bool) {
+	switch e := event.(type) {
+	case ChannelValueChangeEvent:
+		return e.DeviceID, e.ZoneId, 0, false
+	case SensorValueChangeEvent:
+		return e.DeviceId, e.ZoneId, e.SensorType, true
+	case OnStateValueChangeEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case BinaryInputStateChangeEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case ZoneTemperatureControlChangeEvent:
+		return "", e.ZoneId, 0, false
+	case ZoneSensorValueChangeEvent:
+		return "", e.ZoneId, e.SensorType, true
+	case SceneCalledEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case DeviceAddedEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case DeviceRemovedEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case DevicePresenceChangeEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case DeviceRenamedEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case DeviceMovedEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case GroupMembershipChangeEvent:
+		return e.DeviceId, e.ZoneId, 0, false
+	case ZoneAddedEvent:
+		return "", e.ZoneId, 0, false
+	case ZoneRemovedEvent:
+		return "", e.ZoneId, 0, false
+	case ZoneRenamedEvent:
+		return "", e.ZoneId, 0, false
+	}
+	return "", -1, 0, false
+}
@@ -0,0 +1,144 @@
+package digitalstrom
+
+import (
+	"testing"
+	"time"
+)
+
+// receive returns the next event of ch or fails the test
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+		return nil
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	sensorEvent := SensorValueChangeEvent{DeviceId: "00000003", ZoneId: 2, SensorType: STroomTemperature}
+	tests := []struct {
+		name   string
+		filter EventFilter
+		event  Event
+		match  bool
+	}{
+		{"empty filter", EventFilter{}, sensorEvent, true},
+		{"kind", EventFilter{Kinds: []EventKind{EKsensorValueChanged}}, sensorEvent, true},
+		{"other kind", EventFilter{Kinds: []EventKind{EKchannelValueChanged}}, sensorEvent, false},
+		{"device", EventFilter{DeviceIDs: []string{"00000003"}}, sensorEvent, true},
+		{"other device", EventFilter{DeviceIDs: []string{"00000001"}}, sensorEvent, false},
+		{"zone", EventFilter{ZoneIDs: []int{1, 2}}, sensorEvent, true},
+		{"other zone", EventFilter{ZoneIDs: []int{1}}, sensorEvent, false},
+		{"sensor type", EventFilter{SensorTypes: []SensorType{STroomTemperature}}, sensorEvent, true},
+		{"other sensor type", EventFilter{SensorTypes: []SensorType{STroomRelativeHumidity}}, sensorEvent, false},
+		{"event without device", EventFilter{DeviceIDs: []string{"00000003"}}, ZoneTemperatureControlChangeEvent{ZoneId: 2}, false},
+		{"event without zone", EventFilter{ZoneIDs: []int{0}}, CircuitMeterValueChangeEvent{CircuitID: "0000c001"}, false},
+		{"event without sensor type", EventFilter{SensorTypes: []SensorType{STroomTemperature}}, OnStateValueChangeEvent{DeviceId: "00000003"}, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.matches(test.event); got != test.match {
+			t.Errorf("%s: expected %v, got %v", test.name, test.match, got)
+		}
+	}
+}
+
+func TestSubscribersReceiveMatchingEvents(t *testing.T) {
+	a := NewAccount()
+	all := make(chan Event, 10)
+	sensors := make(chan Event, 10)
+	cancelAll := a.SubscribeChannel(SubscriptionSetup{Name: "all"}, all)
+	defer cancelAll()
+	cancelSensors := a.Subscribe(SubscriptionSetup{Name: "sensors", Filter: EventFilter{Kinds: []EventKind{EKsensorValueChanged}}}, func(event Event) {
+		sensors <- event
+	})
+	defer cancelSensors()
+
+	a.eventBus.publish(OnStateValueChangeEvent{DeviceId: "00000001", NewValue: true})
+	a.eventBus.publish(SensorValueChangeEvent{DeviceId: "00000003", NewValue: 20})
+
+	if _, ok := receive(t, all).(OnStateValueChangeEvent); !ok {
+		t.Errorf("expected the on state event first")
+	}
+	if _, ok := receive(t, all).(SensorValueChangeEvent); !ok {
+		t.Errorf("expected the sensor event second")
+	}
+	if event, ok := receive(t, sensors).(SensorValueChangeEvent); !ok || event.NewValue != 20 {
+		t.Errorf("unexpected event %#v", event)
+	}
+	select {
+	case event := <-sensors:
+		t.Errorf("filtered subscriber received %#v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the counters are updated when the handlers have returned
+	stats := a.EventStats()
+	for deadline := time.Now().Add(time.Second); stats.Delivered < 3 && time.Now().Before(deadline); stats = a.EventStats() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.Published != 2 || stats.Delivered != 3 || len(stats.Subscriptions) != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		b := newEventBus()
+		release := make(chan struct{})
+		received := make(chan Event, 10)
+		cancel := b.subscribe(SubscriptionSetup{BufferSize: 1, Overflow: policy}, func(event Event) {
+			received <- event
+			<-release
+		})
+		b.publish(StateChangeEvent{NewValue: 1})
+		// the handler blocks on the first event, so the second one fills the queue
+		receive(t, received)
+		b.publish(StateChangeEvent{NewValue: 2})
+		b.publish(StateChangeEvent{NewValue: 3})
+		close(release)
+
+		expected := 2
+		if policy == OverflowDropOldest {
+			expected = 3
+		}
+		if event := receive(t, received).(StateChangeEvent); event.NewValue != expected {
+			t.Errorf("policy %d: expected value %d, got %d", policy, expected, event.NewValue)
+		}
+		if stats := b.stats(); stats.Dropped != 1 {
+			t.Errorf("policy %d: expected 1 dropped event, got %d", policy, stats.Dropped)
+		}
+		cancel()
+	}
+}
+
+func TestCancelReleasesBlockedPublisher(t *testing.T) {
+	b := newEventBus()
+	block := make(chan struct{})
+	cancel := b.subscribe(SubscriptionSetup{BufferSize: 1, Overflow: OverflowBlock}, func(event Event) {
+		<-block
+	})
+	defer close(block)
+
+	published := make(chan struct{})
+	go func() {
+		// the first event is handled, the second is queued and the third blocks
+		for i := 0; i < 3; i++ {
+			b.publish(StateChangeEvent{NewValue: i})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatalf("publisher has not been blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatalf("publisher is still blocked after cancel")
+	}
+}
@@ -0,0 +1,441 @@
+package digitalstrom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default event listener setup values
+const (
+	defaultEventRequestTimeout     = 30 // seconds the dSS keeps an event/get request open
+	defaultEventRetryDelay         = 10 // seconds to wait before subscribing again after an error
+	defaultEventUnsubscribeTimeout = 10 // seconds to wait for the removal of the subscription when stopping
+)
+
+// Names of the dSS events the event listener is able to process
+const (
+	EventCallScene              = "callScene"
+	EventUndoScene              = "undoScene"
+	EventDeviceSensorValue      = "deviceSensorValue"
+	EventZoneSensorValue        = "zoneSensorValue"
+	EventStateChange            = "stateChange"
+	EventDeviceBinaryInputEvent = "deviceBinaryInputEvent"
+)
+
+var defaultSubscribedEvents = []string{
+	EventCallScene,
+	EventUndoScene,
+	EventDeviceSensorValue,
+	EventZoneSensorValue,
+	EventStateChange,
+	EventDeviceBinaryInputEvent,
+}
+
+type eventListener struct {
+	eventNames     []string
+	subscriptionID int
+	running        bool
+	starting       bool // subscribing, running is set as soon as all events have been subscribed
+	received       uint64
+	cancel         context.CancelFunc
+	mutex          *sync.Mutex
+}
+
+// dssEvent is a single event as it is delivered by the dSS event/get request
+type dssEvent struct {
+	Name       string                 `json:"name"`
+	Properties map[string]interface{} `json:"properties"`
+	Source     map[string]interface{} `json:"source"`
+}
+
+// SetSubscribedEvents sets the names of the events the event listener subscribes to. It takes effect
+// the next time the event listener is started. By default, all events the library is able to process
+// will be subscribed.
+func (a *Account) SetSubscribedEvents(names ...string) {
+	a.eventListener.mutex.Lock()
+	a.eventListener.eventNames = names
+	a.eventListener.mutex.Unlock()
+}
+
+// IsEventListenerRunning returns true when the event listener has been started.
+func (a *Account) IsEventListenerRunning() bool {
+	a.eventListener.mutex.Lock()
+	defer a.eventListener.mutex.Unlock()
+	return a.eventListener.running
+}
+
+// StartEventListener subscribes to the dSS event API and starts a routine that receives the events
+// via long polling requests. Received sensor values, binary input states, temperature values and
+// called scenes will be assigned to the cached values and dispatched to the event channels. Values
+// that were received via events will not be polled again until their polling interval is reached.
+// Polling (StartPolling) is still needed for all values that are not covered by events, such as
+// circuit values and output channel values.
+func (a *Account) StartEventListener() error {
+	return a.StartEventListenerContext(context.Background())
+}
+
+// StartEventListenerContext starts the event listener like StartEventListener does. The event listener
+// stops either when StopEventListener is called or when the given context is done.
+func (a *Account) StartEventListenerContext(ctx context.Context) error {
+	a.eventListener.mutex.Lock()
+	if a.eventListener.running || a.eventListener.starting {
+		a.eventListener.mutex.Unlock()
+		return errors.New("event listener is already running")
+	}
+	a.eventListener.starting = true
+	subscriptionID := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1000000) + 1
+	a.eventListener.subscriptionID = subscriptionID
+	names := a.eventListener.eventNames
+	a.eventListener.mutex.Unlock()
+
+	// the lock is not held while subscribing, as dispatching values checks whether the listener is running
+	err := a.subscribeEvents(ctx, subscriptionID, names)
+
+	a.eventListener.mutex.Lock()
+	if a.eventListener.subscriptionID != subscriptionID {
+		// StopEventListener has been called while subscribing
+		a.eventListener.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := a.unsubscribeWithTimeout(subscriptionID, names); err != nil {
+			logger.Error(err, "unable to remove the subscription of events")
+		}
+		return errors.New("event listener has been stopped while subscribing")
+	}
+	a.eventListener.starting = false
+	if err != nil {
+		a.eventListener.mutex.Unlock()
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	a.eventListener.running = true
+	a.eventListener.cancel = cancel
+	a.eventListener.mutex.Unlock()
+	go a.listenForEvents(ctx, subscriptionID, names)
+	return nil
+}
+
+// StopEventListener stops the event listener, aborts a pending event request and removes the
+// subscription from the dSS.
+// A listener that is still subscribing is aborted, StartEventListener removes its subscriptions then.
+func (a *Account) StopEventListener() error {
+	a.eventListener.mutex.Lock()
+	if a.eventListener.starting {
+		a.eventListener.starting = false
+		a.eventListener.subscriptionID = 0
+		a.eventListener.mutex.Unlock()
+		return nil
+	}
+	if !a.eventListener.running {
+		a.eventListener.mutex.Unlock()
+		return nil
+	}
+	a.eventListener.running = false
+	a.eventListener.cancel()
+	subscriptionID, names := a.eventListener.subscriptionID, a.eventListener.eventNames
+	a.eventListener.mutex.Unlock()
+
+	// the lock is not held while unsubscribing, so a dSS that does not answer blocks neither the event
+	// listener nor other callers for longer than the timeout
+	return a.unsubscribeWithTimeout(subscriptionID, names)
+}
+
+// unsubscribeWithTimeout removes the subscription of the given events, independent of the context the
+// listener has been started with
+func (a *Account) unsubscribeWithTimeout(subscriptionID int, names []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultEventUnsubscribeTimeout*time.Second)
+	defer cancel()
+	return a.unsubscribeEvents(ctx, subscriptionID, names)
+}
+
+// subscribeEvents performs an event/subscribe request for each given event name. When a request fails,
+// the events that have been subscribed already are unsubscribed again.
+func (a *Account) subscribeEvents(ctx context.Context, subscriptionID int, names []string) error {
+	for i, name := range names {
+		params := map[string]string{"name": name, "subscriptionID": strconv.Itoa(subscriptionID)}
+		res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/event/subscribe", get, "", params)
+		if err == nil && !res.OK {
+			err = res.apiError()
+		}
+		if err != nil {
+			if e := a.unsubscribeWithTimeout(subscriptionID, names[:i]); e != nil {
+				logger.Error(e, "unable to remove the subscription of events")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribeEvents performs an event/unsubscribe request for each given event name
+func (a *Account) unsubscribeEvents(ctx context.Context, subscriptionID int, names []string) error {
+	for _, name := range names {
+		params := map[string]string{"name": name, "subscriptionID": strconv.Itoa(subscriptionID)}
+		res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/event/unsubscribe", get, "", params)
+		if err != nil {
+			return err
+		}
+		if !res.OK {
+			return res.apiError()
+		}
+	}
+	return nil
+}
+
+// requestEvents performs an event/get request. The dSS answers as soon as events are available or
+// the timeout has been reached.
+func (a *Account) requestEvents(ctx context.Context, subscriptionID int) ([]dssEvent, error) {
+	params := map[string]string{
+		"subscriptionID": strconv.Itoa(subscriptionID),
+		"timeout":        strconv.Itoa(defaultEventRequestTimeout * 1000),
+	}
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/event/get", get, "", params)
+	if err != nil {
+		return nil, err
+	}
+	if !res.OK {
+		return nil, res.apiError()
+	}
+
+	events := []dssEvent{}
+	e, ok := res.Result["events"]
+	if !ok {
+		// timeout reached without any events
+		return events, nil
+	}
+	jsonString, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(jsonString, &events)
+	if err != nil {
+		return nil, res.invalid("events", err)
+	}
+	return events, nil
+}
+
+func (a *Account) listenForEvents(ctx context.Context, subscriptionID int, names []string) {
+	defer func() {
+		// the listener might have been stopped by the parent context
+		a.eventListener.mutex.Lock()
+		if a.eventListener.subscriptionID == subscriptionID {
+			a.eventListener.running = false
+		}
+		a.eventListener.mutex.Unlock()
+	}()
+	for {
+		events, err := a.requestEvents(ctx, subscriptionID)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error(err, "unable to receive events, subscribing again")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(defaultEventRetryDelay * time.Second):
+			}
+			// the subscription might be lost, e.g. when the dSS has been restarted
+			if err := a.subscribeEvents(ctx, subscriptionID, names); err != nil {
+				logger.Error(err, "unable to subscribe events")
+			}
+			continue
+		}
+
+		for i := range events {
+			a.processEvent(&events[i])
+		}
+	}
+}
+
+func (a *Account) processEvent(event *dssEvent) {
+	a.eventListener.mutex.Lock()
+	a.eventListener.received++
+	a.eventListener.mutex.Unlock()
+
+	switch event.Name {
+	case EventDeviceSensorValue:
+		a.processDeviceSensorValueEvent(event)
+	case EventDeviceBinaryInputEvent:
+		a.processDeviceBinaryInputEvent(event)
+	case EventZoneSensorValue:
+		a.processZoneSensorValueEvent(event)
+	case EventCallScene:
+		a.processCallSceneEvent(event, false)
+	case EventUndoScene:
+		a.processCallSceneEvent(event, true)
+	case EventStateChange:
+		a.processStateChangeEvent(event)
+	default:
+		// events without processing will be ignored
+	}
+}
+
+func (a *Account) processDeviceSensorValueEvent(event *dssEvent) {
+	a.cacheMutex.Lock()
+	device, err := a.getEventSourceDevice(event)
+	if err != nil {
+		a.cacheMutex.Unlock()
+		logger.Error(err, "unable to process event "+event.Name)
+		return
+	}
+	deviceID := device.DisplayID
+	index, ok := jsonInt(event.Properties, "sensorIndex")
+	if !ok || index < 0 || index >= len(device.Sensors) {
+		a.cacheMutex.Unlock()
+		logger.Info(fmt.Sprintf("WARNING: event %s contains no valid sensor index for device %s", event.Name, deviceID))
+		return
+	}
+	value, ok := jsonFloat(event.Properties, "sensorValueFloat")
+	if !ok {
+		a.cacheMutex.Unlock()
+		logger.Info(fmt.Sprintf("WARNING: event %s contains no sensor value for device %s", event.Name, deviceID))
+		return
+	}
+
+	sensor := device.Sensors[index]
+	oldValue := sensor.Value
+	sensor.Value = value
+	a.touchValue("sensor•" + deviceID + "•" + strconv.Itoa(index))
+	a.cacheMutex.Unlock()
+
+	if oldValue != value {
+		a.dispatchSensorValueChange(deviceID, index, oldValue, value)
+	}
+	a.refreshPollingTimeStamp("sensor•" + deviceID + "•" + strconv.Itoa(index))
+}
+
+func (a *Account) processDeviceBinaryInputEvent(event *dssEvent) {
+	a.cacheMutex.RLock()
+	device, err := a.getEventSourceDevice(event)
+	if err != nil {
+		a.cacheMutex.RUnlock()
+		logger.Error(err, "unable to process event "+event.Name)
+		return
+	}
+	deviceID, dsuid := device.DisplayID, device.UUID
+	index, ok := jsonInt(event.Properties, "inputIndex")
+	if !ok || index < 0 || index >= len(device.BinaryInputs) {
+		a.cacheMutex.RUnlock()
+		logger.Info(fmt.Sprintf("WARNING: event %s contains no valid input index for device %s", event.Name, deviceID))
+		return
+	}
+	inputID := device.BinaryInputs[index].InputID
+	a.cacheMutex.RUnlock()
+
+	state, ok := jsonInt(event.Properties, "inputState")
+	if !ok {
+		logger.Info(fmt.Sprintf("WARNING: event %s contains no input state for device %s", event.Name, deviceID))
+		return
+	}
+	a.updateBinaryInputState(dsuid, inputID, state)
+}
+
+func (a *Account) processZoneSensorValueEvent(event *dssEvent) {
+	zoneID, _ := jsonInt(event.Source, "zoneID")
+	groupID, _ := jsonInt(event.Source, "groupID")
+	sensorType, ok := jsonInt(event.Properties, "sensorType")
+	if !ok {
+		return
+	}
+	value, ok := jsonFloat(event.Properties, "sensorValueFloat")
+	if !ok {
+		return
+	}
+
+	// zone sensor values of the temperature control are part of the cached temperature control states
+	somethingChanged := false
+	a.cacheMutex.Lock()
+	tempCtrl, ok := a.TemperatureControl[zoneID]
+	if ok {
+		switch SensorType(sensorType) {
+		case STroomTemperature:
+			somethingChanged = tempCtrl.TemperatureValue != value
+			tempCtrl.TemperatureValue = value
+		case STroomTemperatureSetPoint:
+			somethingChanged = tempCtrl.NominalValue != value
+			tempCtrl.NominalValue = value
+		case STroomTemperatureControlVariable:
+			somethingChanged = tempCtrl.ControlValue != value
+			tempCtrl.ControlValue = value
+		}
+	}
+	a.cacheMutex.Unlock()
+
+	if somethingChanged {
+		a.dispatchTemperatureControlStateChanged(zoneID)
+	}
+	a.dispatchZoneSensorValueChange(zoneID, groupID, SensorType(sensorType), value)
+}
+
+func (a *Account) processCallSceneEvent(event *dssEvent, undo bool) {
+	zoneID, _ := jsonInt(event.Source, "zoneID")
+	groupID, _ := jsonInt(event.Source, "groupID")
+	sceneNumber, _ := jsonInt(event.Properties, "sceneID")
+	deviceID := ""
+	isDevice, _ := event.Source["isDevice"].(bool)
+	a.cacheMutex.RLock()
+	if isDevice {
+		device, err := a.getEventSourceDevice(event)
+		if err == nil {
+			deviceID = device.DisplayID
+		}
+	}
+
+	// scenes change On states and output channel values of the affected devices, which are not part of
+	// the event. Those values will be polled during the next polling cycle.
+	a.expirePollingTimeStamp("structure")
+	for id, device := range a.Devices {
+		if deviceID != "" && deviceID != id {
+			continue
+		}
+		if deviceID == "" && zoneID != 0 && device.ZoneID != zoneID {
+			continue
+		}
+		for i := range device.OutputChannels {
+			a.expirePollingTimeStamp("channel•" + id + "•" + strconv.Itoa(i))
+		}
+	}
+	a.cacheMutex.RUnlock()
+
+	a.dispatchSceneCalled(zoneID, groupID, deviceID, sceneNumber, undo)
+}
+
+func (a *Account) processStateChangeEvent(event *dssEvent) {
+	name, _ := event.Properties["statename"].(string)
+	state, _ := event.Properties["state"].(string)
+	oldValue, _ := jsonInt(event.Properties, "oldvalue")
+	value, _ := jsonInt(event.Properties, "value")
+	a.cacheMutex.Lock()
+	if cached, ok := a.States[name]; ok {
+		cached.State = state
+		cached.Value = value
+		a.touchValue("states")
+	}
+	a.cacheMutex.Unlock()
+	a.dispatchStateChange(name, state, oldValue, value)
+}
+
+// getEventSourceDevice returns the device that is the source of the given event. The caller
+// has to hold the cache lock.
+func (a *Account) getEventSourceDevice(event *dssEvent) (*Device, error) {
+	if dsuid, ok := event.Source["dSUID"].(string); ok {
+		return a.getDeviceByUuid(dsuid)
+	}
+	if dsid, ok := event.Source["dsid"].(string); ok {
+		for _, dev := range a.Devices {
+			if dev.ID == dsid {
+				return dev, nil
+			}
+		}
+		return nil, &NotFoundError{Kind: "device", ID: dsid}
+	}
+	return nil, errors.New("event source is not a device")
+}
@@ -0,0 +1,268 @@
+package digitalstrom_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+const sensorUUID = "302ed89f43f0000000000000000000003"
+
+// startEventListener starts the event listener of the account and returns a channel receiving the
+// events of the given kinds
+func startEventListener(t *testing.T, a *digitalstrom.Account, kinds ...digitalstrom.EventKind) chan digitalstrom.Event {
+	t.Helper()
+	events := make(chan digitalstrom.Event, 10)
+	cancel := a.SubscribeChannel(digitalstrom.SubscriptionSetup{
+		Name:   "event listener",
+		Filter: digitalstrom.EventFilter{Kinds: kinds},
+	}, events)
+	t.Cleanup(cancel)
+	if err := a.StartEventListener(); err != nil {
+		t.Fatalf("StartEventListener failed: %v", err)
+	}
+	t.Cleanup(func() { a.StopEventListener() })
+	return events
+}
+
+func TestEventListenerSubscription(t *testing.T) {
+	srv, a := newTestAccount(t)
+	a.SetSubscribedEvents(digitalstrom.EventCallScene, digitalstrom.EventStateChange)
+
+	if err := a.StartEventListener(); err != nil {
+		t.Fatalf("StartEventListener failed: %v", err)
+	}
+	if !a.IsEventListenerRunning() {
+		t.Errorf("event listener is not running")
+	}
+	if err := a.StartEventListener(); err == nil {
+		t.Errorf("expected an error when starting a running event listener")
+	}
+	subscribe := srv.CallsTo("/json/event/subscribe")
+	if len(subscribe) != 2 || subscribe[0].Params.Get("name") != digitalstrom.EventCallScene ||
+		subscribe[1].Params.Get("name") != digitalstrom.EventStateChange {
+		t.Fatalf("unexpected subscriptions %v", subscribe)
+	}
+	subscriptionID := subscribe[0].Params.Get("subscriptionID")
+	waitFor(t, time.Second, func() bool { return len(srv.CallsTo("/json/event/get")) > 0 })
+	if get := srv.CallsTo("/json/event/get")[0].Params; get.Get("subscriptionID") != subscriptionID || get.Get("timeout") == "" {
+		t.Errorf("unexpected event request %v", get)
+	}
+
+	if err := a.StopEventListener(); err != nil {
+		t.Fatalf("StopEventListener failed: %v", err)
+	}
+	if a.IsEventListenerRunning() {
+		t.Errorf("event listener is still running")
+	}
+	unsubscribe := srv.CallsTo("/json/event/unsubscribe")
+	if len(unsubscribe) != 2 || unsubscribe[0].Params.Get("subscriptionID") != subscriptionID {
+		t.Errorf("unexpected unsubscriptions %v", unsubscribe)
+	}
+	if err := a.StopEventListener(); err != nil {
+		t.Errorf("stopping a stopped event listener failed: %v", err)
+	}
+}
+
+// newProxyAccount returns an initialized account using a proxy of the server, which passes all requests to
+// the given handler
+func newProxyAccount(t *testing.T, srv *dsstest.Server, handler http.HandlerFunc) *digitalstrom.Account {
+	t.Helper()
+	proxy := httptest.NewServer(handler)
+	t.Cleanup(proxy.Close)
+	a := digitalstrom.NewAccount()
+	a.SetURL(proxy.URL)
+	a.SetApplicationToken(dsstest.ApplicationToken)
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return a
+}
+
+func TestStopEventListenerDoesNotBlock(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	// the dSS does not answer the unsubscribe requests until the test is finished
+	release := make(chan struct{})
+	defer close(release)
+	a := newProxyAccount(t, srv, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json/event/unsubscribe" {
+			<-release
+		}
+		srv.ServeHTTP(w, r)
+	})
+	if err := a.StartEventListener(); err != nil {
+		t.Fatalf("StartEventListener failed: %v", err)
+	}
+
+	go a.StopEventListener()
+	waitFor(t, time.Second, func() bool { return len(srv.CallsTo("/json/event/unsubscribe")) == 0 && !a.IsEventListenerRunning() })
+	done := make(chan struct{})
+	go func() {
+		a.IsEventListenerRunning()
+		a.Stats()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("event listener is locked while unsubscribing")
+	}
+}
+
+func TestStartEventListenerDoesNotBlock(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	// the dSS does not answer the subscribe requests until it is released
+	release := make(chan struct{})
+	var subscribing atomic.Bool
+	a := newProxyAccount(t, srv, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json/event/subscribe" {
+			subscribing.Store(true)
+			<-release
+		}
+		srv.ServeHTTP(w, r)
+	})
+
+	started := make(chan error)
+	go func() { started <- a.StartEventListener() }()
+	waitFor(t, time.Second, subscribing.Load)
+	done := make(chan struct{})
+	go func() {
+		a.IsEventListenerRunning()
+		a.Stats()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("event listener is locked while subscribing")
+	}
+	if err := a.StartEventListener(); err == nil {
+		t.Errorf("expected an error when starting an event listener that is subscribing")
+	}
+
+	close(release)
+	if err := <-started; err != nil {
+		t.Fatalf("StartEventListener failed: %v", err)
+	}
+	if !a.IsEventListenerRunning() {
+		t.Errorf("event listener is not running")
+	}
+	a.StopEventListener()
+}
+
+func TestFailedSubscriptionIsRemoved(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	var subscriptions atomic.Int32
+	a := newProxyAccount(t, srv, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json/event/subscribe" && subscriptions.Add(1) == 3 {
+			w.Write([]byte(`{"ok": false, "message": "subscription failed"}`))
+			return
+		}
+		srv.ServeHTTP(w, r)
+	})
+
+	if err := a.StartEventListener(); !errors.Is(err, digitalstrom.ErrAPI) {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+	if a.IsEventListenerRunning() {
+		t.Errorf("event listener is running")
+	}
+	unsubscribe := srv.CallsTo("/json/event/unsubscribe")
+	if len(unsubscribe) != 2 || unsubscribe[0].Params.Get("name") != digitalstrom.EventCallScene ||
+		unsubscribe[1].Params.Get("name") != digitalstrom.EventUndoScene {
+		t.Errorf("subscribed events have not been removed: %v", unsubscribe)
+	}
+}
+
+func TestDeviceSensorValueEvent(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := startEventListener(t, a, digitalstrom.EKsensorValueChanged)
+
+	srv.PushEvent(dsstest.Event{
+		Name:       digitalstrom.EventDeviceSensorValue,
+		Properties: map[string]interface{}{"sensorIndex": "0", "sensorType": "9", "sensorValueFloat": "23.5"},
+		Source:     map[string]interface{}{"dSUID": sensorUUID, "isDevice": true},
+	})
+	event, ok := receiveEvent(t, events).(digitalstrom.SensorValueChangeEvent)
+	if !ok || event.DeviceId != "00000003" || event.ZoneId != 2 || event.SensorIndex != 0 || event.OldValue != 21.5 || event.NewValue != 23.5 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if sensor, _ := a.GetSensor("00000003", 0); sensor.Value != 23.5 {
+		t.Errorf("cached sensor value has not been updated: %v", sensor.Value)
+	}
+	if stats := a.Stats(); stats.ReceivedEvents != 1 || !stats.EventListenerRunning {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDeviceBinaryInputEvent(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := startEventListener(t, a, digitalstrom.EKbinaryInputStateChanged)
+
+	srv.PushEvent(dsstest.Event{
+		Name:       digitalstrom.EventDeviceBinaryInputEvent,
+		Properties: map[string]interface{}{"inputIndex": "0", "inputState": "0"},
+		Source:     map[string]interface{}{"dSUID": sensorUUID, "isDevice": true},
+	})
+	event, ok := receiveEvent(t, events).(digitalstrom.BinaryInputStateChangeEvent)
+	if !ok || event.DeviceId != "00000003" || event.InputId != 0 || event.OldValue != 1 || event.NewValue != 0 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if state := a.Devices["00000003"].BinaryInputs[0].State; state != 0 {
+		t.Errorf("cached binary input state has not been updated: %v", state)
+	}
+}
+
+func TestZoneSensorValueEvent(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := startEventListener(t, a, digitalstrom.EKzoneSensorValueChanged, digitalstrom.EKzoneTemperatureControlChanged)
+
+	srv.PushEvent(dsstest.Event{
+		Name:       digitalstrom.EventZoneSensorValue,
+		Properties: map[string]interface{}{"sensorType": "9", "sensorValueFloat": "20.25"},
+		Source:     map[string]interface{}{"zoneID": "2", "groupID": "0"},
+	})
+	if _, ok := receiveEvent(t, events).(digitalstrom.ZoneTemperatureControlChangeEvent); !ok {
+		t.Errorf("temperature control change has not been dispatched")
+	}
+	event, ok := receiveEvent(t, events).(digitalstrom.ZoneSensorValueChangeEvent)
+	if !ok || event.ZoneId != 2 || event.SensorType != digitalstrom.STroomTemperature || event.NewValue != 20.25 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if value := a.TemperatureControl[2].TemperatureValue; value != 20.25 {
+		t.Errorf("cached temperature value has not been updated: %v", value)
+	}
+}
+
+func TestCallSceneEvent(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := startEventListener(t, a, digitalstrom.EKsceneCalled)
+
+	srv.PushEvent(dsstest.Event{
+		Name:       digitalstrom.EventCallScene,
+		Properties: map[string]interface{}{"sceneID": "5"},
+		Source:     map[string]interface{}{"zoneID": "1", "groupID": "1", "isDevice": false},
+	})
+	srv.PushEvent(dsstest.Event{
+		Name:       digitalstrom.EventUndoScene,
+		Properties: map[string]interface{}{"sceneID": "5"},
+		Source:     map[string]interface{}{"dSUID": "302ed89f43f0000000000000000000001", "zoneID": "1", "groupID": "1", "isDevice": true},
+	})
+	event, ok := receiveEvent(t, events).(digitalstrom.SceneCalledEvent)
+	if !ok || event.ZoneId != 1 || event.GroupId != 1 || event.SceneNumber != 5 || event.DeviceId != "" || event.Undo {
+		t.Errorf("unexpected event %+v", event)
+	}
+	event, ok = receiveEvent(t, events).(digitalstrom.SceneCalledEvent)
+	if !ok || event.DeviceId != "00000001" || !event.Undo {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
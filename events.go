@@ -1,7 +1,41 @@
 package digitalstrom
 
+// EventKind identifies the type of an Event
+type EventKind string
+
+// Event Kinds (EK)
+const (
+	EKchannelValueChanged            EventKind = "channelValueChanged"
+	EKsensorValueChanged             EventKind = "sensorValueChanged"
+	EKcircuitConsumptionValueChanged EventKind = "circuitConsumptionValueChanged"
+	EKcircuitMeterValueChanged       EventKind = "circuitMeterValueChanged"
+	EKonStateValueChanged            EventKind = "onStateValueChanged"
+	EKzoneTemperatureControlChanged  EventKind = "zoneTemperatureControlChanged"
+	EKbinaryInputStateChanged        EventKind = "binaryInputStateChanged"
+	EKzoneSensorValueChanged         EventKind = "zoneSensorValueChanged"
+	EKsceneCalled                    EventKind = "sceneCalled"
+	EKstateChanged                   EventKind = "stateChanged"
+	EKcacheReconciled                EventKind = "cacheReconciled"
+	EKconnectivityChanged            EventKind = "connectivityChanged"
+	EKdeviceAdded                    EventKind = "deviceAdded"
+	EKdeviceRemoved                  EventKind = "deviceRemoved"
+	EKdevicePresenceChanged          EventKind = "devicePresenceChanged"
+	EKdeviceRenamed                  EventKind = "deviceRenamed"
+	EKdeviceMoved                    EventKind = "deviceMoved"
+	EKgroupMembershipChanged         EventKind = "groupMembershipChanged"
+	EKzoneAdded                      EventKind = "zoneAdded"
+	EKzoneRemoved                    EventKind = "zoneRemoved"
+	EKzoneRenamed                    EventKind = "zoneRenamed"
+)
+
+// Event is implemented by all events that are published to subscribers of an Account
+type Event interface {
+	Kind() EventKind
+}
+
 type ChannelValueChangeEvent struct {
 	DeviceID     string
+	ZoneId       int
 	ChannelIndex int
 	OldValue     int
 	NewValue     int
@@ -9,7 +43,9 @@ type ChannelValueChangeEvent struct {
 
 type SensorValueChangeEvent struct {
 	DeviceId    string
+	ZoneId      int
 	SensorIndex int
+	SensorType  SensorType
 	OldValue    float64
 	NewValue    float64
 }
@@ -28,6 +64,7 @@ type CircuitMeterValueChangeEvent struct {
 
 type OnStateValueChangeEvent struct {
 	DeviceId string
+	ZoneId   int
 	OldValue bool
 	NewValue bool
 }
@@ -38,7 +75,132 @@ type ZoneTemperatureControlChangeEvent struct {
 
 type BinaryInputStateChangeEvent struct {
 	DeviceId string
+	ZoneId   int
 	InputId  int
 	OldValue int
 	NewValue int
 }
+
+// ZoneSensorValueChangeEvent is published when the dSS reported a new sensor value of a zone
+type ZoneSensorValueChangeEvent struct {
+	ZoneId     int
+	GroupId    int
+	SensorType SensorType
+	NewValue   float64
+}
+
+// SceneCalledEvent is published when a scene has been called or undone (Undo is set) in a zone or on a device
+type SceneCalledEvent struct {
+	ZoneId      int
+	GroupId     int
+	DeviceId    string
+	SceneNumber int
+	Undo        bool
+}
+
+// StateChangeEvent is published when an apartment state of the dSS changed its value
+type StateChangeEvent struct {
+	Name     string
+	State    string
+	OldValue int
+	NewValue int
+}
+
+// CacheReconciledEvent is published when the reconciliation of a cache loaded from a file has
+// been finished. Err is set when the reconciliation failed and the cache is still stale.
+type CacheReconciledEvent struct {
+	Err error
+}
+
+// ConnectivityChangeEvent is published when the circuit breaker of the connection opens because the
+// dSS became unreachable (Connected is false, Err is the last error) and when it closes again.
+type ConnectivityChangeEvent struct {
+	Connected bool
+	Err       error
+}
+
+// DeviceAddedEvent is published when a device appeared in the structure of the dSS
+type DeviceAddedEvent struct {
+	DeviceId string
+	ZoneId   int
+	Name     string
+}
+
+// DeviceRemovedEvent is published when a device is no longer part of the structure of the dSS
+type DeviceRemovedEvent struct {
+	DeviceId string
+	ZoneId   int
+	Name     string
+}
+
+// DevicePresenceChangeEvent is published when a device became present or absent
+type DevicePresenceChangeEvent struct {
+	DeviceId  string
+	ZoneId    int
+	IsPresent bool
+}
+
+// DeviceRenamedEvent is published when the name of a device changed
+type DeviceRenamedEvent struct {
+	DeviceId string
+	ZoneId   int
+	OldName  string
+	NewName  string
+}
+
+// DeviceMovedEvent is published when a device has been moved from the zone OldZoneId into the zone ZoneId
+type DeviceMovedEvent struct {
+	DeviceId  string
+	ZoneId    int
+	OldZoneId int
+}
+
+// GroupMembershipChangeEvent is published when a device joined or left groups. JoinedGroups and
+// LeftGroups are the ids of the groups.
+type GroupMembershipChangeEvent struct {
+	DeviceId     string
+	ZoneId       int
+	JoinedGroups []int
+	LeftGroups   []int
+}
+
+// ZoneAddedEvent is published when a zone appeared in the structure of the dSS
+type ZoneAddedEvent struct {
+	ZoneId int
+	Name   string
+}
+
+// ZoneRemovedEvent is published when a zone is no longer part of the structure of the dSS
+type ZoneRemovedEvent struct {
+	ZoneId int
+	Name   string
+}
+
+// ZoneRenamedEvent is published when the name of a zone changed
+type ZoneRenamedEvent struct {
+	ZoneId  int
+	OldName string
+	NewName string
+}
+
+func (ChannelValueChangeEvent) Kind() EventKind            { return EKchannelValueChanged }
+func (SensorValueChangeEvent) Kind() EventKind             { return EKsensorValueChanged }
+func (CircuitConsumptionValueChangeEvent) Kind() EventKind { return EKcircuitConsumptionValueChanged }
+func (CircuitMeterValueChangeEvent) Kind() EventKind       { return EKcircuitMeterValueChanged }
+func (OnStateValueChangeEvent) Kind() EventKind            { return EKonStateValueChanged }
+func (ZoneTemperatureControlChangeEvent) Kind() EventKind  { return EKzoneTemperatureControlChanged }
+func (BinaryInputStateChangeEvent) Kind() EventKind        { return EKbinaryInputStateChanged }
+func (ZoneSensorValueChangeEvent) Kind() EventKind         { return EKzoneSensorValueChanged }
+func (SceneCalledEvent) Kind() EventKind                   { return EKsceneCalled }
+func (StateChangeEvent) Kind() EventKind                   { return EKstateChanged }
+func (CacheReconciledEvent) Kind() EventKind               { return EKcacheReconciled }
+func (ConnectivityChangeEvent) Kind() EventKind            { return EKconnectivityChanged }
+func (DeviceAddedEvent) Kind() EventKind                   { return EKdeviceAdded }
+func (DeviceRemovedEvent) Kind() EventKind                 { return EKdeviceRemoved }
+func (DevicePresenceChangeEvent) Kind() EventKind          { return EKdevicePresenceChanged }
+func (DeviceRenamedEvent) Kind() EventKind                 { return EKdeviceRenamed }
+func (DeviceMovedEvent) Kind() EventKind                   { return EKdeviceMoved }
+func (GroupMembershipChangeEvent) Kind() EventKind         { return EKgroupMembershipChanged }
+func (ZoneAddedEvent) Kind() EventKind                     { return EKzoneAdded }
+func (ZoneRemovedEvent) Kind() EventKind                   { return EKzoneRemoved }
+func (ZoneRenamedEvent) Kind() EventKind                   { return EKzoneRenamed }
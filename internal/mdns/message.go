@@ -0,0 +1,377 @@
+// Package mdns implements the small part of multicast DNS (RFC 6762) and DNS based service discovery
+// (RFC 6763) the digitalstrom package needs to find a dSS in the local network: packing and unpacking
+// of DNS messages with PTR, SRV, TXT, A and AAAA records and a Responder advertising services.
+//
+// Names are fully qualified with a trailing dot, e.g. "_dssweb._tcp.local.". Dots and backslashes
+// that are part of a label are escaped by a backslash.
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Record types
+const (
+	TypeA    uint16 = 1
+	TypePTR  uint16 = 12
+	TypeTXT  uint16 = 16
+	TypeAAAA uint16 = 28
+	TypeSRV  uint16 = 33
+	TypeANY  uint16 = 255
+)
+
+const (
+	classIN         uint16 = 1
+	unicastResponse uint16 = 0x8000 // QU bit of a question
+	cacheFlush      uint16 = 0x8000 // cache flush bit of a record
+	flagResponse    uint16 = 0x8400 // response, authoritative answer
+	maxPointers            = 16
+)
+
+// Question is an entry of the question section
+type Question struct {
+	Name string
+	Type uint16
+	// Unicast requests a unicast response (QU bit)
+	Unicast bool
+}
+
+// Record is a resource record. Target is the data of PTR and SRV records, Port, Priority and
+// Weight are set for SRV records, Text for TXT records and IP for A and AAAA records.
+type Record struct {
+	Name     string
+	Type     uint16
+	TTL      uint32
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	Text     []string
+	IP       net.IP
+}
+
+// Message is a DNS message. Records of the authority section are ignored.
+type Message struct {
+	ID          uint16
+	Response    bool
+	Questions   []Question
+	Answers     []Record
+	Additionals []Record
+}
+
+// Pack returns the wire format of the message. Names are not compressed.
+func (m *Message) Pack() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	if m.Response {
+		binary.BigEndian.PutUint16(b[2:], flagResponse)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additionals)))
+
+	var err error
+	for _, q := range m.Questions {
+		b, err = appendName(b, q.Name)
+		if err != nil {
+			return nil, err
+		}
+		class := classIN
+		if q.Unicast {
+			class |= unicastResponse
+		}
+		b = appendUint16(b, q.Type)
+		b = appendUint16(b, class)
+	}
+	for _, records := range [][]Record{m.Answers, m.Additionals} {
+		for i := range records {
+			b, err = appendRecord(b, &records[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// Unpack parses a message in wire format. Records of unsupported types are skipped.
+func Unpack(data []byte) (*Message, error) {
+	if len(data) < 12 {
+		return nil, errors.New("mdns: message too short")
+	}
+	m := &Message{
+		ID:       binary.BigEndian.Uint16(data[0:]),
+		Response: binary.BigEndian.Uint16(data[2:])&0x8000 != 0,
+	}
+	counts := []int{
+		int(binary.BigEndian.Uint16(data[4:])),
+		int(binary.BigEndian.Uint16(data[6:])),
+		int(binary.BigEndian.Uint16(data[8:])),
+		int(binary.BigEndian.Uint16(data[10:])),
+	}
+	off := 12
+	for i := 0; i < counts[0]; i++ {
+		name, n, err := readName(data, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(data) {
+			return nil, errors.New("mdns: question truncated")
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:    name,
+			Type:    binary.BigEndian.Uint16(data[off:]),
+			Unicast: binary.BigEndian.Uint16(data[off+2:])&unicastResponse != 0,
+		})
+		off += 4
+	}
+	for section := 1; section < 4; section++ {
+		for i := 0; i < counts[section]; i++ {
+			r, n, err := readRecord(data, off)
+			if err != nil {
+				return nil, err
+			}
+			off = n
+			if r == nil {
+				continue
+			}
+			switch section {
+			case 1:
+				m.Answers = append(m.Answers, *r)
+			case 3:
+				m.Additionals = append(m.Additionals, *r)
+			}
+		}
+	}
+	return m, nil
+}
+
+// EqualNames compares two names case-insensitively like DNS does
+func EqualNames(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// JoinName returns the name of a service instance, e.g. "digitalSTROM Server._dssweb._tcp.local."
+// for instance "digitalSTROM Server" and service "_dssweb._tcp.local.". Dots in the instance are escaped.
+func JoinName(instance string, service string) string {
+	return escapeLabel(instance) + "." + service
+}
+
+// SplitName returns the unescaped first label of a name and the remaining name, e.g. "digitalSTROM Server"
+// and "_dssweb._tcp.local." for "digitalSTROM Server._dssweb._tcp.local.".
+func SplitName(name string) (string, string) {
+	labels := splitLabels(name)
+	if len(labels) == 0 {
+		return "", name
+	}
+	rest := ""
+	for _, label := range labels[1:] {
+		rest += escapeLabel(label) + "."
+	}
+	return labels[0], rest
+}
+
+func appendRecord(b []byte, r *Record) ([]byte, error) {
+	b, err := appendName(b, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	class := classIN
+	if r.Type != TypePTR {
+		class |= cacheFlush
+	}
+	b = appendUint16(b, r.Type)
+	b = appendUint16(b, class)
+	b = append(b, byte(r.TTL>>24), byte(r.TTL>>16), byte(r.TTL>>8), byte(r.TTL))
+
+	// the length of the data is filled in afterwards
+	lengthOffset := len(b)
+	b = appendUint16(b, 0)
+	switch r.Type {
+	case TypePTR:
+		b, err = appendName(b, r.Target)
+	case TypeSRV:
+		b = appendUint16(b, r.Priority)
+		b = appendUint16(b, r.Weight)
+		b = appendUint16(b, r.Port)
+		b, err = appendName(b, r.Target)
+	case TypeTXT:
+		if len(r.Text) == 0 {
+			b = append(b, 0)
+		}
+		for _, text := range r.Text {
+			if len(text) > 255 {
+				return nil, errors.New("mdns: text too long")
+			}
+			b = append(b, byte(len(text)))
+			b = append(b, text...)
+		}
+	case TypeA:
+		ip := r.IP.To4()
+		if ip == nil {
+			return nil, errors.New("mdns: no IPv4 address for A record " + r.Name)
+		}
+		b = append(b, ip...)
+	case TypeAAAA:
+		ip := r.IP.To16()
+		if ip == nil {
+			return nil, errors.New("mdns: no IPv6 address for AAAA record " + r.Name)
+		}
+		b = append(b, ip...)
+	default:
+		return nil, errors.New("mdns: unsupported record type")
+	}
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b[lengthOffset:], uint16(len(b)-lengthOffset-2))
+	return b, nil
+}
+
+// readRecord reads the record at off and returns the offset behind it. The returned record is nil
+// when its type is not supported.
+func readRecord(data []byte, off int) (*Record, int, error) {
+	name, off, err := readName(data, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off+10 > len(data) {
+		return nil, 0, errors.New("mdns: record truncated")
+	}
+	r := &Record{
+		Name: name,
+		Type: binary.BigEndian.Uint16(data[off:]),
+		TTL:  binary.BigEndian.Uint32(data[off+4:]),
+	}
+	length := int(binary.BigEndian.Uint16(data[off+8:]))
+	off += 10
+	end := off + length
+	if end > len(data) {
+		return nil, 0, errors.New("mdns: record data truncated")
+	}
+	switch r.Type {
+	case TypePTR:
+		r.Target, _, err = readName(data, off)
+	case TypeSRV:
+		if length < 7 {
+			return nil, 0, errors.New("mdns: SRV record too short")
+		}
+		r.Priority = binary.BigEndian.Uint16(data[off:])
+		r.Weight = binary.BigEndian.Uint16(data[off+2:])
+		r.Port = binary.BigEndian.Uint16(data[off+4:])
+		r.Target, _, err = readName(data, off+6)
+	case TypeTXT:
+		for i := off; i < end; {
+			n := int(data[i])
+			if i+1+n > end {
+				return nil, 0, errors.New("mdns: TXT record truncated")
+			}
+			if n > 0 {
+				r.Text = append(r.Text, string(data[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	case TypeA, TypeAAAA:
+		if (r.Type == TypeA && length != net.IPv4len) || (r.Type == TypeAAAA && length != net.IPv6len) {
+			return nil, 0, errors.New("mdns: invalid address length")
+		}
+		r.IP = net.IP(append([]byte(nil), data[off:end]...))
+	default:
+		return nil, end, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, end, nil
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range splitLabels(name) {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.New("mdns: invalid label in name '" + name + "'")
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// readName reads the possibly compressed name at off and returns the offset behind it
+func readName(data []byte, off int) (string, int, error) {
+	name := ""
+	end := -1
+	pointers := 0
+	for {
+		if off >= len(data) {
+			return "", 0, errors.New("mdns: name truncated")
+		}
+		n := int(data[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			if name == "" {
+				name = "."
+			}
+			return name, end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(data) {
+				return "", 0, errors.New("mdns: name truncated")
+			}
+			pointers++
+			if pointers > maxPointers {
+				return "", 0, errors.New("mdns: too many compression pointers")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(data[off:]) & 0x3fff)
+		case n&0xc0 != 0:
+			return "", 0, errors.New("mdns: invalid label")
+		default:
+			if off+1+n > len(data) {
+				return "", 0, errors.New("mdns: label truncated")
+			}
+			name += escapeLabel(string(data[off+1:off+1+n])) + "."
+			off += 1 + n
+		}
+	}
+}
+
+// splitLabels returns the unescaped labels of a name
+func splitLabels(name string) []string {
+	labels := []string{}
+	label := []byte{}
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '\\' && i+1 < len(name):
+			i++
+			label = append(label, name[i])
+		case name[i] == '.':
+			if i == 0 && len(name) == 1 {
+				break // root
+			}
+			labels = append(labels, string(label))
+			label = label[:0]
+		default:
+			label = append(label, name[i])
+		}
+	}
+	if len(label) > 0 {
+		labels = append(labels, string(label))
+	}
+	return labels
+}
+
+func escapeLabel(label string) string {
+	return strings.NewReplacer(`\`, `\\`, `.`, `\.`).Replace(label)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
@@ -0,0 +1,133 @@
+package mdns
+
+import (
+	"net"
+	"sync"
+)
+
+// Service is a service instance advertised by a Responder
+type Service struct {
+	Instance string   // e.g. "digitalSTROM Server"
+	Service  string   // e.g. "_dssweb._tcp.local."
+	Host     string   // e.g. "dss.local."
+	IPs      []net.IP // addresses of Host
+	Port     int
+	Text     []string // e.g. "path=/"
+}
+
+// Responder answers queries for its services. Responses are always sent directly to the source
+// address of a query, which is what RFC 6762 requires for queries not sent from port 5353.
+type Responder struct {
+	conn     *net.UDPConn
+	services []Service
+	wg       sync.WaitGroup
+}
+
+const responderTTL = 120
+
+// NewResponder starts a responder for the given services listening on address. When address is a
+// multicast address, e.g. "224.0.0.251:5353", the multicast group is joined on all interfaces.
+// Otherwise, e.g. "127.0.0.1:0" for tests, a unicast socket is used and queries have to be sent to Addr.
+func NewResponder(address string, services ...Service) (*Responder, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if udpAddr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", nil, udpAddr)
+	} else {
+		conn, err = net.ListenUDP("udp", udpAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{conn: conn, services: services}
+	r.wg.Add(1)
+	go r.serve()
+	return r, nil
+}
+
+// Addr returns the address the responder is listening on
+func (r *Responder) Addr() string {
+	return r.conn.LocalAddr().String()
+}
+
+// Close stops the responder
+func (r *Responder) Close() error {
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
+
+func (r *Responder) serve() {
+	defer r.wg.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		query, err := Unpack(buf[:n])
+		if err != nil || query.Response {
+			continue
+		}
+		response := r.answer(query)
+		if len(response.Answers) == 0 {
+			continue
+		}
+		data, err := response.Pack()
+		if err != nil {
+			continue
+		}
+		r.conn.WriteToUDP(data, src)
+	}
+}
+
+// answer returns the response to the query. Answers to PTR questions get the SRV, TXT and address
+// records of the instances as additional records.
+func (r *Responder) answer(query *Message) *Message {
+	response := &Message{ID: query.ID, Response: true, Questions: query.Questions}
+	for _, q := range query.Questions {
+		for i := range r.services {
+			s := &r.services[i]
+			instance := JoinName(s.Instance, s.Service)
+			switch {
+			case EqualNames(q.Name, s.Service) && (q.Type == TypePTR || q.Type == TypeANY):
+				response.Answers = append(response.Answers, Record{Name: s.Service, Type: TypePTR, TTL: responderTTL, Target: instance})
+				response.Additionals = append(response.Additionals, s.instanceRecords()...)
+				response.Additionals = append(response.Additionals, s.addressRecords(TypeANY)...)
+			case EqualNames(q.Name, instance):
+				for _, record := range s.instanceRecords() {
+					if q.Type == record.Type || q.Type == TypeANY {
+						response.Answers = append(response.Answers, record)
+					}
+				}
+			case EqualNames(q.Name, s.Host):
+				response.Answers = append(response.Answers, s.addressRecords(q.Type)...)
+			}
+		}
+	}
+	return response
+}
+
+func (s *Service) instanceRecords() []Record {
+	instance := JoinName(s.Instance, s.Service)
+	return []Record{
+		{Name: instance, Type: TypeSRV, TTL: responderTTL, Target: s.Host, Port: uint16(s.Port)},
+		{Name: instance, Type: TypeTXT, TTL: responderTTL, Text: s.Text},
+	}
+}
+
+// addressRecords returns the A and AAAA records of the host matching the given type
+func (s *Service) addressRecords(t uint16) []Record {
+	records := []Record{}
+	for _, ip := range s.IPs {
+		if ip.To4() != nil && (t == TypeA || t == TypeANY) {
+			records = append(records, Record{Name: s.Host, Type: TypeA, TTL: responderTTL, IP: ip})
+		} else if ip.To4() == nil && (t == TypeAAAA || t == TypeANY) {
+			records = append(records, Record{Name: s.Host, Type: TypeAAAA, TTL: responderTTL, IP: ip})
+		}
+	}
+	return records
+}
@@ -0,0 +1,484 @@
+// Package mqttpacket implements the control packets of MQTT 3.1.1 the mqtt client and the broker
+// stand-in of mqtttest need: CONNECT, CONNACK, PUBLISH, PUBACK, SUBSCRIBE, SUBACK, UNSUBSCRIBE,
+// UNSUBACK, PINGREQ, PINGRESP and DISCONNECT. QoS 2 is not supported.
+package mqttpacket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Control packet types
+const (
+	TypeConnect     byte = 1
+	TypeConnAck     byte = 2
+	TypePublish     byte = 3
+	TypePubAck      byte = 4
+	TypeSubscribe   byte = 8
+	TypeSubAck      byte = 9
+	TypeUnsubscribe byte = 10
+	TypeUnsubAck    byte = 11
+	TypePingReq     byte = 12
+	TypePingResp    byte = 13
+	TypeDisconnect  byte = 14
+)
+
+// Return codes of CONNACK
+const (
+	Accepted                   byte = 0
+	RefusedProtocolVersion     byte = 1
+	RefusedIdentifierRejected  byte = 2
+	RefusedServerUnavailable   byte = 3
+	RefusedBadUsernamePassword byte = 4
+	RefusedNotAuthorized       byte = 5
+	SubscriptionFailure        byte = 0x80 // return code of SUBACK
+)
+
+const (
+	protocolLevel           byte = 4
+	maxRemainingLength           = 268435455
+	connectFlagCleanSession byte = 0x02
+	connectFlagWill         byte = 0x04
+	connectFlagWillRetain   byte = 0x20
+	connectFlagPassword     byte = 0x40
+	connectFlagUsername     byte = 0x80
+	connectFlagWillQoSShift      = 3
+	publishFlagRetain       byte = 0x01
+	publishFlagDup          byte = 0x08
+	publishFlagQoSShift          = 1
+	subscribeFlags          byte = 0x02 // reserved flags of SUBSCRIBE and UNSUBSCRIBE
+)
+
+// ErrMalformed is returned for packets that could not be decoded
+var ErrMalformed = errors.New("malformed mqtt packet")
+
+// Packet is implemented by all control packets
+type Packet interface {
+	// Type returns the control packet type
+	Type() byte
+	flags() byte
+	body() []byte
+}
+
+// Connect is sent by the client to open a session
+type Connect struct {
+	ClientID     string
+	CleanSession bool
+	KeepAlive    uint16 // in seconds, 0 disables keep alive
+	Username     string
+	Password     string
+	Will         *Publish // published by the broker when the connection is lost, PacketID is ignored
+}
+
+// ConnAck is the answer of the broker to Connect
+type ConnAck struct {
+	SessionPresent bool
+	ReturnCode     byte
+}
+
+// Publish transports an application message. PacketID is only used for QoS 1.
+type Publish struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	PacketID uint16
+}
+
+// PubAck acknowledges a Publish with QoS 1
+type PubAck struct {
+	PacketID uint16
+}
+
+// Subscription is a topic filter with the maximum QoS of the subscription
+type Subscription struct {
+	Filter string
+	QoS    byte
+}
+
+// Subscribe requests subscriptions
+type Subscribe struct {
+	PacketID      uint16
+	Subscriptions []Subscription
+}
+
+// SubAck contains the granted QoS, or SubscriptionFailure, of each requested subscription
+type SubAck struct {
+	PacketID    uint16
+	ReturnCodes []byte
+}
+
+// Unsubscribe removes subscriptions
+type Unsubscribe struct {
+	PacketID uint16
+	Filters  []string
+}
+
+// UnsubAck acknowledges an Unsubscribe
+type UnsubAck struct {
+	PacketID uint16
+}
+
+// PingReq is sent by the client to keep the connection alive
+type PingReq struct{}
+
+// PingResp is the answer of the broker to PingReq
+type PingResp struct{}
+
+// Disconnect is sent by the client before closing the connection
+type Disconnect struct{}
+
+func (Connect) Type() byte     { return TypeConnect }
+func (ConnAck) Type() byte     { return TypeConnAck }
+func (Publish) Type() byte     { return TypePublish }
+func (PubAck) Type() byte      { return TypePubAck }
+func (Subscribe) Type() byte   { return TypeSubscribe }
+func (SubAck) Type() byte      { return TypeSubAck }
+func (Unsubscribe) Type() byte { return TypeUnsubscribe }
+func (UnsubAck) Type() byte    { return TypeUnsubAck }
+func (PingReq) Type() byte     { return TypePingReq }
+func (PingResp) Type() byte    { return TypePingResp }
+func (Disconnect) Type() byte  { return TypeDisconnect }
+
+func (Connect) flags() byte     { return 0 }
+func (ConnAck) flags() byte     { return 0 }
+func (PubAck) flags() byte      { return 0 }
+func (Subscribe) flags() byte   { return subscribeFlags }
+func (SubAck) flags() byte      { return 0 }
+func (Unsubscribe) flags() byte { return subscribeFlags }
+func (UnsubAck) flags() byte    { return 0 }
+func (PingReq) flags() byte     { return 0 }
+func (PingResp) flags() byte    { return 0 }
+func (Disconnect) flags() byte  { return 0 }
+
+func (p Publish) flags() byte {
+	flags := p.QoS << publishFlagQoSShift
+	if p.Retain {
+		flags |= publishFlagRetain
+	}
+	if p.Dup {
+		flags |= publishFlagDup
+	}
+	return flags
+}
+
+func (p Connect) body() []byte {
+	b := appendString(nil, "MQTT")
+	b = append(b, protocolLevel)
+	flags := byte(0)
+	if p.CleanSession {
+		flags |= connectFlagCleanSession
+	}
+	if p.Will != nil {
+		flags |= connectFlagWill | p.Will.QoS<<connectFlagWillQoSShift
+		if p.Will.Retain {
+			flags |= connectFlagWillRetain
+		}
+	}
+	if p.Username != "" {
+		flags |= connectFlagUsername
+	}
+	if p.Password != "" {
+		flags |= connectFlagPassword
+	}
+	b = append(b, flags)
+	b = appendUint16(b, p.KeepAlive)
+	b = appendString(b, p.ClientID)
+	if p.Will != nil {
+		b = appendString(b, p.Will.Topic)
+		b = appendBytes(b, p.Will.Payload)
+	}
+	if p.Username != "" {
+		b = appendString(b, p.Username)
+	}
+	if p.Password != "" {
+		b = appendString(b, p.Password)
+	}
+	return b
+}
+
+func (p ConnAck) body() []byte {
+	present := byte(0)
+	if p.SessionPresent {
+		present = 1
+	}
+	return []byte{present, p.ReturnCode}
+}
+
+func (p Publish) body() []byte {
+	b := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		b = appendUint16(b, p.PacketID)
+	}
+	return append(b, p.Payload...)
+}
+
+func (p PubAck) body() []byte   { return appendUint16(nil, p.PacketID) }
+func (p UnsubAck) body() []byte { return appendUint16(nil, p.PacketID) }
+
+func (p Subscribe) body() []byte {
+	b := appendUint16(nil, p.PacketID)
+	for _, s := range p.Subscriptions {
+		b = appendString(b, s.Filter)
+		b = append(b, s.QoS)
+	}
+	return b
+}
+
+func (p SubAck) body() []byte {
+	return append(appendUint16(nil, p.PacketID), p.ReturnCodes...)
+}
+
+func (p Unsubscribe) body() []byte {
+	b := appendUint16(nil, p.PacketID)
+	for _, filter := range p.Filters {
+		b = appendString(b, filter)
+	}
+	return b
+}
+
+func (PingReq) body() []byte    { return nil }
+func (PingResp) body() []byte   { return nil }
+func (Disconnect) body() []byte { return nil }
+
+// Write encodes the packet and writes it to w with a single write call
+func Write(w io.Writer, p Packet) error {
+	body := p.body()
+	if len(body) > maxRemainingLength {
+		return fmt.Errorf("mqtt packet too large (%d bytes)", len(body))
+	}
+	b := []byte{p.Type()<<4 | p.flags()}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(b, body...))
+	return err
+}
+
+// Read reads and decodes the next packet
+func Read(r *bufio.Reader) (Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return nil, ErrMalformed
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return decode(header>>4, header&0x0f, body)
+}
+
+func decode(typ byte, flags byte, body []byte) (Packet, error) {
+	d := &decoder{b: body}
+	switch typ {
+	case TypeConnect:
+		if d.string() != "MQTT" || d.byte() != protocolLevel {
+			return nil, ErrMalformed
+		}
+		connectFlags := d.byte()
+		p := Connect{CleanSession: connectFlags&connectFlagCleanSession != 0}
+		p.KeepAlive = d.uint16()
+		p.ClientID = d.string()
+		if connectFlags&connectFlagWill != 0 {
+			p.Will = &Publish{
+				Topic:  d.string(),
+				QoS:    (connectFlags >> connectFlagWillQoSShift) & 0x03,
+				Retain: connectFlags&connectFlagWillRetain != 0,
+			}
+			p.Will.Payload = d.bytes()
+		}
+		if connectFlags&connectFlagUsername != 0 {
+			p.Username = d.string()
+		}
+		if connectFlags&connectFlagPassword != 0 {
+			p.Password = d.string()
+		}
+		return p, d.done()
+	case TypeConnAck:
+		p := ConnAck{SessionPresent: d.byte()&0x01 != 0, ReturnCode: d.byte()}
+		return p, d.done()
+	case TypePublish:
+		p := Publish{
+			QoS:    (flags >> publishFlagQoSShift) & 0x03,
+			Retain: flags&publishFlagRetain != 0,
+			Dup:    flags&publishFlagDup != 0,
+		}
+		if p.QoS > 1 {
+			return nil, errors.New("mqtt QoS 2 is not supported")
+		}
+		p.Topic = d.string()
+		if p.QoS > 0 {
+			p.PacketID = d.uint16()
+		}
+		if d.err == nil {
+			p.Payload = d.b
+			d.b = nil
+		}
+		return p, d.done()
+	case TypePubAck:
+		p := PubAck{PacketID: d.uint16()}
+		return p, d.done()
+	case TypeSubscribe:
+		p := Subscribe{PacketID: d.uint16()}
+		for d.err == nil && len(d.b) > 0 {
+			p.Subscriptions = append(p.Subscriptions, Subscription{Filter: d.string(), QoS: d.byte()})
+		}
+		if len(p.Subscriptions) == 0 {
+			return nil, ErrMalformed
+		}
+		return p, d.done()
+	case TypeSubAck:
+		p := SubAck{PacketID: d.uint16()}
+		if d.err == nil {
+			p.ReturnCodes = d.b
+			d.b = nil
+		}
+		return p, d.done()
+	case TypeUnsubscribe:
+		p := Unsubscribe{PacketID: d.uint16()}
+		for d.err == nil && len(d.b) > 0 {
+			p.Filters = append(p.Filters, d.string())
+		}
+		return p, d.done()
+	case TypeUnsubAck:
+		p := UnsubAck{PacketID: d.uint16()}
+		return p, d.done()
+	case TypePingReq:
+		return PingReq{}, d.done()
+	case TypePingResp:
+		return PingResp{}, d.done()
+	case TypeDisconnect:
+		return Disconnect{}, d.done()
+	}
+	return nil, fmt.Errorf("unsupported mqtt packet type %d", typ)
+}
+
+// MatchTopic returns true when the topic matches the filter. The wildcards '+' (single level) and
+// '#' (remaining levels) are supported. Topics starting with '$' are not matched by wildcards at the
+// first level.
+func MatchTopic(filter string, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// ValidFilter returns false for empty filters and filters with misplaced wildcards
+func ValidFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = ErrMalformed
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.err = ErrMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.b) < n {
+		d.err = ErrMalformed
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// done returns an error when the body could not be decoded or contains unexpected bytes
+func (d *decoder) done() error {
+	if d.err == nil && len(d.b) > 0 {
+		d.err = ErrMalformed
+	}
+	return d.err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, v []byte) []byte {
+	b = appendUint16(b, uint16(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,309 @@
+package digitalstrom
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MeteringType defines the kind of values a metering series contains
+type MeteringType string
+
+// MeteringUnit is the unit of metering values
+type MeteringUnit string
+
+// Metering Types (MT)
+const (
+	MTconsumption MeteringType = "consumption" // power in W
+	MTenergy      MeteringType = "energy"      // meter value in Wh or Ws
+	MTenergyDelta MeteringType = "energyDelta" // energy consumed within each resolution interval
+)
+
+// Metering Units (MU)
+const (
+	MUwatt       MeteringUnit = "W"
+	MUwattHour   MeteringUnit = "Wh"
+	MUwattSecond MeteringUnit = "Ws"
+)
+
+// MeteringAllMeters addresses the values of all meters of the apartment
+const MeteringAllMeters = ".meters(all)"
+
+const meteringTimeFormat = "2006-01-02 15:04:05"
+
+// MeteringSeries describes a series of historic values the dSS is recording for a meter
+type MeteringSeries struct {
+	DSID  string
+	DSUID string
+	Type  MeteringType
+}
+
+// MeteringQuery defines which values of a series should be requested. Resolution has to be one of
+// the resolutions returned by RequestMeteringResolutions. Unit is only used for energy series and
+// defaults to Wh. Zero values of StartTime, EndTime and ValueCount are not sent to the dSS.
+type MeteringQuery struct {
+	Type       MeteringType
+	Resolution time.Duration
+	Unit       MeteringUnit
+	StartTime  time.Time
+	EndTime    time.Time
+	ValueCount int
+}
+
+// MeteringValue is a single value of a time series
+type MeteringValue struct {
+	Time  time.Time
+	Value float64
+}
+
+// MeteringTimeSeries contains the historic values of one meter or of all meters of the apartment
+type MeteringTimeSeries struct {
+	MeterIDs   []string
+	Type       MeteringType
+	Unit       MeteringUnit
+	Resolution time.Duration
+	Values     []MeteringValue
+}
+
+// MeteringLatestValue is the most recent value of a single meter
+type MeteringLatestValue struct {
+	DSID  string
+	DSUID string
+	Type  MeteringType
+	Unit  MeteringUnit
+	Time  time.Time
+	Value float64
+}
+
+// RequestMeteringResolutions returns the resolutions the dSS is recording metering values with.
+func (a *Account) RequestMeteringResolutions() ([]time.Duration, error) {
+	return a.RequestMeteringResolutionsContext(context.Background())
+}
+
+// RequestMeteringResolutionsContext is like RequestMeteringResolutions but aborts all performed requests when ctx is done.
+func (a *Account) RequestMeteringResolutionsContext(ctx context.Context) ([]time.Duration, error) {
+	res, err := a.requestMetering(ctx, "/json/metering/getResolutions", map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	list, ok := res.Result["resolutions"].([]interface{})
+	if !ok {
+		return nil, res.malformed("resolutions")
+	}
+	resolutions := []time.Duration{}
+	for i := range list {
+		entry, ok := list[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		seconds, ok := jsonInt(entry, "resolution")
+		if !ok {
+			continue
+		}
+		resolutions = append(resolutions, time.Duration(seconds)*time.Second)
+	}
+	return resolutions, nil
+}
+
+// RequestMeteringSeries returns all series the dSS is recording, one consumption and one energy series per meter.
+func (a *Account) RequestMeteringSeries() ([]MeteringSeries, error) {
+	return a.RequestMeteringSeriesContext(context.Background())
+}
+
+// RequestMeteringSeriesContext is like RequestMeteringSeries but aborts all performed requests when ctx is done.
+func (a *Account) RequestMeteringSeriesContext(ctx context.Context) ([]MeteringSeries, error) {
+	res, err := a.requestMetering(ctx, "/json/metering/getSeries", map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	list, ok := res.Result["series"].([]interface{})
+	if !ok {
+		return nil, res.malformed("series")
+	}
+	series := []MeteringSeries{}
+	for i := range list {
+		entry, ok := list[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		s := MeteringSeries{}
+		s.DSID, _ = entry["dsid"].(string)
+		s.DSUID, _ = entry["dSUID"].(string)
+		t, _ := entry["type"].(string)
+		s.Type = MeteringType(t)
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// RequestCircuitMeteringValues requests the historic values of the given circuit.
+func (a *Account) RequestCircuitMeteringValues(circuit *Circuit, query MeteringQuery) (*MeteringTimeSeries, error) {
+	return a.RequestCircuitMeteringValuesContext(context.Background(), circuit, query)
+}
+
+// RequestCircuitMeteringValuesContext is like RequestCircuitMeteringValues but aborts all performed requests when ctx is done.
+func (a *Account) RequestCircuitMeteringValuesContext(ctx context.Context, circuit *Circuit, query MeteringQuery) (*MeteringTimeSeries, error) {
+	return a.requestMeteringValues(ctx, circuit.DSUID, query)
+}
+
+// RequestApartmentMeteringValues requests the historic values summed up over all meters of the apartment.
+func (a *Account) RequestApartmentMeteringValues(query MeteringQuery) (*MeteringTimeSeries, error) {
+	return a.RequestApartmentMeteringValuesContext(context.Background(), query)
+}
+
+// RequestApartmentMeteringValuesContext is like RequestApartmentMeteringValues but aborts all performed requests when ctx is done.
+func (a *Account) RequestApartmentMeteringValuesContext(ctx context.Context, query MeteringQuery) (*MeteringTimeSeries, error) {
+	return a.requestMeteringValues(ctx, MeteringAllMeters, query)
+}
+
+// RequestLatestMeteringValues requests the most recent value of the given type for each meter of the apartment.
+func (a *Account) RequestLatestMeteringValues(meteringType MeteringType) ([]MeteringLatestValue, error) {
+	return a.RequestLatestMeteringValuesContext(context.Background(), meteringType)
+}
+
+// RequestLatestMeteringValuesContext is like RequestLatestMeteringValues but aborts all performed requests when ctx is done.
+func (a *Account) RequestLatestMeteringValuesContext(ctx context.Context, meteringType MeteringType) ([]MeteringLatestValue, error) {
+	params := map[string]string{
+		"from": MeteringAllMeters,
+		"type": string(meteringType),
+	}
+	res, err := a.requestMetering(ctx, "/json/metering/getLatest", params)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := res.Result["values"].([]interface{})
+	if !ok {
+		return nil, res.malformed("values")
+	}
+	unit := meteringUnit(meteringType, "")
+	values := []MeteringLatestValue{}
+	for i := range list {
+		entry, ok := list[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value := MeteringLatestValue{Type: meteringType, Unit: unit}
+		value.DSID, _ = entry["dsid"].(string)
+		value.DSUID, _ = entry["dSUID"].(string)
+		value.Value, ok = jsonFloat(entry, "value")
+		if !ok {
+			return nil, res.invalid("value", fmt.Errorf("no valid value for meter %s", value.DSUID))
+		}
+		value.Time, err = parseMeteringTime(entry["date"])
+		if err != nil {
+			return nil, res.invalid("date", err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (a *Account) requestMeteringValues(ctx context.Context, meter string, query MeteringQuery) (*MeteringTimeSeries, error) {
+	params := map[string]string{
+		"dsuid":      meter,
+		"type":       string(query.Type),
+		"resolution": strconv.Itoa(int(query.Resolution / time.Second)),
+	}
+	if query.Unit != "" {
+		params["unit"] = string(query.Unit)
+	}
+	if !query.StartTime.IsZero() {
+		params["startTime"] = strconv.FormatInt(query.StartTime.Unix(), 10)
+	}
+	if !query.EndTime.IsZero() {
+		params["endTime"] = strconv.FormatInt(query.EndTime.Unix(), 10)
+	}
+	if query.ValueCount > 0 {
+		params["valueCount"] = strconv.Itoa(query.ValueCount)
+	}
+	res, err := a.requestMetering(ctx, "/json/metering/getValues", params)
+	if err != nil {
+		return nil, err
+	}
+
+	series := &MeteringTimeSeries{Type: query.Type, Resolution: query.Resolution}
+	if t, ok := res.Result["type"].(string); ok {
+		series.Type = MeteringType(t)
+	}
+	if seconds, ok := jsonInt(res.Result, "resolution"); ok {
+		series.Resolution = time.Duration(seconds) * time.Second
+	}
+	unit, _ := res.Result["unit"].(string)
+	series.Unit = meteringUnit(series.Type, MeteringUnit(unit))
+	if unit == "" && query.Unit != "" {
+		series.Unit = query.Unit
+	}
+	switch ids := res.Result["meterID"].(type) {
+	case []interface{}:
+		for i := range ids {
+			if id, ok := ids[i].(string); ok {
+				series.MeterIDs = append(series.MeterIDs, id)
+			}
+		}
+	case string:
+		series.MeterIDs = []string{ids}
+	}
+
+	list, ok := res.Result["values"].([]interface{})
+	if !ok {
+		return nil, res.malformed("values")
+	}
+	for i := range list {
+		pair, ok := list[i].([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, res.invalid("values", fmt.Errorf("value %d is not a pair of timestamp and value", i))
+		}
+		timestamp, err := parseMeteringTime(pair[0])
+		if err != nil {
+			return nil, res.invalid("values", err)
+		}
+		value, ok := pair[1].(float64)
+		if !ok {
+			return nil, res.invalid("values", fmt.Errorf("value %d is not a number", i))
+		}
+		series.Values = append(series.Values, MeteringValue{Time: timestamp, Value: value})
+	}
+	return series, nil
+}
+
+func (a *Account) requestMetering(ctx context.Context, url string, params map[string]string) (*RequestResult, error) {
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+url, get, "", params)
+	if err != nil {
+		return nil, err
+	}
+	if !res.OK {
+		return nil, res.apiError()
+	}
+	return res, nil
+}
+
+// meteringUnit returns the given unit or the default unit of the metering type when no unit is given
+func meteringUnit(meteringType MeteringType, unit MeteringUnit) MeteringUnit {
+	if unit != "" {
+		return unit
+	}
+	if meteringType == MTconsumption {
+		return MUwatt
+	}
+	return MUwattHour
+}
+
+// parseMeteringTime converts the timestamps used by the metering requests. The dSS delivers either
+// unix timestamps or local time strings.
+func parseMeteringTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case string:
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(seconds, 0), nil
+		}
+		t, err := time.ParseInLocation(meteringTimeFormat, v, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp '%s'", v)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp '%v'", value)
+}
@@ -0,0 +1,145 @@
+package digitalstrom_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+)
+
+func TestRequestMeteringResolutions(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	resolutions, err := a.RequestMeteringResolutions()
+	if err != nil {
+		t.Fatalf("RequestMeteringResolutions failed: %v", err)
+	}
+	expected := []time.Duration{time.Second, time.Minute, 15 * time.Minute, 24 * time.Hour}
+	if len(resolutions) != len(expected) {
+		t.Fatalf("expected resolutions %v, got %v", expected, resolutions)
+	}
+	for i := range expected {
+		if resolutions[i] != expected[i] {
+			t.Errorf("expected resolutions %v, got %v", expected, resolutions)
+		}
+	}
+}
+
+func TestRequestMeteringSeries(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	series, err := a.RequestMeteringSeries()
+	if err != nil {
+		t.Fatalf("RequestMeteringSeries failed: %v", err)
+	}
+	if len(series) != 2 {
+		t.Fatalf("expected an energy and a consumption series, got %+v", series)
+	}
+	for _, s := range series {
+		if s.DSUID != "302ed89f43f00e40000c000000000001" || s.DSID != "302ed89f43f00e40000c0001" {
+			t.Errorf("unexpected meter of series %+v", s)
+		}
+	}
+	if series[0].Type != digitalstrom.MTenergy || series[1].Type != digitalstrom.MTconsumption {
+		t.Errorf("unexpected series types %q and %q", series[0].Type, series[1].Type)
+	}
+}
+
+func TestRequestCircuitMeteringValues(t *testing.T) {
+	srv, a := newTestAccount(t)
+	circuit := a.Circuits["0000c001"]
+
+	start := time.Unix(1600000000, 0)
+	series, err := a.RequestCircuitMeteringValues(circuit, digitalstrom.MeteringQuery{
+		Type:       digitalstrom.MTenergy,
+		Resolution: time.Minute,
+		Unit:       digitalstrom.MUwattSecond,
+		StartTime:  start,
+		ValueCount: 5,
+	})
+	if err != nil {
+		t.Fatalf("RequestCircuitMeteringValues failed: %v", err)
+	}
+	calls := srv.CallsTo("/json/metering/getValues")
+	if len(calls) != 1 {
+		t.Fatalf("expected one getValues request, got %d", len(calls))
+	}
+	params := calls[0].Params
+	if params.Get("dsuid") != circuit.DSUID || params.Get("type") != "energy" || params.Get("resolution") != "60" ||
+		params.Get("unit") != "Ws" || params.Get("startTime") != "1600000000" || params.Get("valueCount") != "5" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	if params.Has("endTime") {
+		t.Errorf("zero end time has been sent: %v", params)
+	}
+
+	if series.Type != digitalstrom.MTenergy || series.Unit != digitalstrom.MUwattSecond || series.Resolution != time.Minute {
+		t.Errorf("unexpected series %+v", series)
+	}
+	if len(series.MeterIDs) != 1 || series.MeterIDs[0] != circuit.DSUID {
+		t.Errorf("unexpected meter ids %v", series.MeterIDs)
+	}
+	if len(series.Values) != 5 {
+		t.Fatalf("expected 5 values, got %d", len(series.Values))
+	}
+	for i, value := range series.Values {
+		if value.Value != 5400000 {
+			t.Errorf("expected value 5400000, got %v", value.Value)
+		}
+		if i > 0 && value.Time.Sub(series.Values[i-1].Time) != time.Minute {
+			t.Errorf("values are not a minute apart: %v", series.Values)
+		}
+	}
+}
+
+func TestRequestApartmentMeteringValuesDefaultsUnits(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	energy, err := a.RequestApartmentMeteringValues(digitalstrom.MeteringQuery{Type: digitalstrom.MTenergy, Resolution: time.Hour})
+	if err != nil {
+		t.Fatalf("RequestApartmentMeteringValues failed: %v", err)
+	}
+	if energy.Unit != digitalstrom.MUwattHour || len(energy.Values) != 10 || energy.Values[0].Value != 1500 {
+		t.Errorf("unexpected energy series %+v", energy)
+	}
+
+	consumption, err := a.RequestApartmentMeteringValues(digitalstrom.MeteringQuery{Type: digitalstrom.MTconsumption, Resolution: time.Second})
+	if err != nil {
+		t.Fatalf("RequestApartmentMeteringValues failed: %v", err)
+	}
+	if consumption.Unit != digitalstrom.MUwatt || consumption.Values[0].Value != 120 {
+		t.Errorf("unexpected consumption series %+v", consumption)
+	}
+}
+
+func TestRequestLatestMeteringValues(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	values, err := a.RequestLatestMeteringValues(digitalstrom.MTconsumption)
+	if err != nil {
+		t.Fatalf("RequestLatestMeteringValues failed: %v", err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("expected one value, got %+v", values)
+	}
+	value := values[0]
+	if value.DSUID != "302ed89f43f00e40000c000000000001" || value.Value != 120 || value.Unit != digitalstrom.MUwatt {
+		t.Errorf("unexpected value %+v", value)
+	}
+	// the dSS delivers local time strings with a resolution of seconds
+	if age := time.Since(value.Time); age < 0 || age > time.Minute {
+		t.Errorf("unexpected time %v", value.Time)
+	}
+}
+
+func TestRequestMeteringValuesOfUnknownMeter(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	_, err := a.RequestCircuitMeteringValues(&digitalstrom.Circuit{DSUID: "unknown"}, digitalstrom.MeteringQuery{
+		Type:       digitalstrom.MTconsumption,
+		Resolution: time.Minute,
+	})
+	if err == nil {
+		t.Errorf("expected an error for an unknown meter")
+	}
+}
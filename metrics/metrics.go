@@ -0,0 +1,267 @@
+// Package metrics exposes the cached values and statistics of a digitalstrom.Account in the Prometheus
+// text exposition format. It does not depend on the Prometheus client library, the Handler could be
+// registered at any http.ServeMux and scraped by Prometheus directly.
+//
+//	http.Handle("/metrics", metrics.NewHandler(account))
+//	log.Fatal(http.ListenAndServe(":9100", nil))
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/connctd/digitalstrom"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+// family is a set of samples sharing name, help and type
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+// Handler serves the metrics of an account
+type Handler struct {
+	// ErrorHandler is called when the metrics could not be written, e.g. because the client has closed the
+	// connection, optional
+	ErrorHandler func(err error)
+	account      *digitalstrom.Account
+}
+
+// NewHandler returns an http.Handler serving the metrics of the given account
+func NewHandler(account *digitalstrom.Account) *Handler {
+	return &Handler{account: account}
+}
+
+// ServeHTTP writes the current metrics of the account
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	err := Write(w, h.account)
+	if err != nil && h.ErrorHandler != nil {
+		h.ErrorHandler(err)
+	}
+}
+
+// Write writes the current metrics of the account to w. Cached values are taken from a snapshot of the
+// account, so all values of one scrape are consistent.
+func Write(w io.Writer, account *digitalstrom.Account) error {
+	families := collect(account.Snapshot(), account.Stats())
+	bw := bufio.NewWriter(w)
+	for i := range families {
+		writeFamily(bw, &families[i])
+	}
+	return bw.Flush()
+}
+
+// collect generates all metric families of the given snapshot and stats
+func collect(snapshot *digitalstrom.Snapshot, stats digitalstrom.Stats) []family {
+	families := []family{}
+	families = append(families, collectCircuits(snapshot)...)
+	families = append(families, collectDevices(snapshot)...)
+	families = append(families, collectTemperatureControl(snapshot)...)
+	families = append(families, collectStats(stats)...)
+	for i := range families {
+		sortSamples(families[i].samples)
+	}
+	return families
+}
+
+func collectCircuits(snapshot *digitalstrom.Snapshot) []family {
+	consumption := family{name: "digitalstrom_circuit_consumption_watts", help: "Current power consumption of the circuit.", typ: typeGauge}
+	// the dSS reports the meter value in Ws, which equals the base unit joule
+	meter := family{name: "digitalstrom_circuit_energy_joules_total", help: "Energy meter value of the circuit in joules (watt seconds).", typ: typeCounter}
+	for _, circuit := range snapshot.Circuits {
+		if !circuit.HasMetering {
+			continue
+		}
+		labels := []label{{"circuit", circuit.DisplayID}, {"name", circuit.Name}}
+		consumption.samples = append(consumption.samples, sample{labels, float64(circuit.Consumption)})
+		meter.samples = append(meter.samples, sample{labels, float64(circuit.MeterValue)})
+	}
+	return []family{consumption, meter}
+}
+
+func collectDevices(snapshot *digitalstrom.Snapshot) []family {
+	sensors := family{name: "digitalstrom_sensor_value", help: "Current value of the device sensor.", typ: typeGauge}
+	channels := family{name: "digitalstrom_output_channel_value", help: "Current value of the device output channel.", typ: typeGauge}
+	on := family{name: "digitalstrom_device_on", help: "1 when the device is switched on.", typ: typeGauge}
+	present := family{name: "digitalstrom_device_present", help: "1 when the device is present.", typ: typeGauge}
+	inputs := family{name: "digitalstrom_binary_input_state", help: "Current state of the binary input.", typ: typeGauge}
+
+	for id, device := range snapshot.Devices {
+		labels := deviceLabels(snapshot, id, device)
+		on.samples = append(on.samples, sample{labels, boolValue(device.On)})
+		present.samples = append(present.samples, sample{labels, boolValue(device.IsPresent)})
+		for i, sensor := range device.Sensors {
+			l := append(copyLabels(labels), label{"sensor_index", strconv.Itoa(i)}, label{"sensor_type", sensor.Type.GetName()})
+			sensors.samples = append(sensors.samples, sample{l, sensor.Value})
+		}
+		for _, channel := range device.OutputChannels {
+			l := append(copyLabels(labels), label{"channel", string(channel.ChannelType)}, label{"channel_index", strconv.Itoa(channel.ChannelIndex)})
+			channels.samples = append(channels.samples, sample{l, float64(channel.Value)})
+		}
+		for _, input := range device.BinaryInputs {
+			l := append(copyLabels(labels), label{"input", strconv.Itoa(input.InputID)}, label{"input_type", input.InputType.GetName()})
+			inputs.samples = append(inputs.samples, sample{l, float64(input.State)})
+		}
+	}
+	return []family{sensors, channels, on, present, inputs}
+}
+
+func collectTemperatureControl(snapshot *digitalstrom.Snapshot) []family {
+	temperature := family{name: "digitalstrom_temperature_control_temperature_celsius", help: "Current room temperature of the zone.", typ: typeGauge}
+	nominal := family{name: "digitalstrom_temperature_control_nominal_celsius", help: "Nominal room temperature of the zone.", typ: typeGauge}
+	control := family{name: "digitalstrom_temperature_control_control_value", help: "Control value of the temperature control of the zone.", typ: typeGauge}
+	operation := family{name: "digitalstrom_temperature_control_operation_mode", help: "Operation mode of the temperature control of the zone.", typ: typeGauge}
+	mode := family{name: "digitalstrom_temperature_control_control_mode", help: "Control mode of the temperature control of the zone.", typ: typeGauge}
+	state := family{name: "digitalstrom_temperature_control_control_state", help: "Control state of the temperature control of the zone.", typ: typeGauge}
+
+	for zoneID, tempCtrl := range snapshot.TemperatureControl {
+		labels := []label{{"zone", strconv.Itoa(zoneID)}, {"zone_name", zoneName(snapshot, zoneID)}}
+		temperature.samples = append(temperature.samples, sample{labels, tempCtrl.TemperatureValue})
+		nominal.samples = append(nominal.samples, sample{labels, tempCtrl.NominalValue})
+		control.samples = append(control.samples, sample{labels, tempCtrl.ControlValue})
+		operation.samples = append(operation.samples, sample{labels, float64(tempCtrl.OperationMode)})
+		mode.samples = append(mode.samples, sample{labels, float64(tempCtrl.ControlMode)})
+		state.samples = append(state.samples, sample{labels, float64(tempCtrl.ControlState)})
+	}
+	return []family{temperature, nominal, control, operation, mode, state}
+}
+
+func collectStats(stats digitalstrom.Stats) []family {
+	families := []family{
+		{name: "digitalstrom_requests_total", help: "HTTP requests sent to the dSS.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.Requests)}}},
+		{name: "digitalstrom_request_errors_total", help: "HTTP requests that failed or were not answered with status 200.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.RequestErrors)}}},
+		{name: "digitalstrom_api_errors_total", help: "Requests the dSS answered with ok=false.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.APIErrors)}}},
+		{name: "digitalstrom_request_retries_total", help: "Requests repeated after a failure.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.Retries)}}},
+		{name: "digitalstrom_connected", help: "1 while the dSS is reachable (circuit breaker closed).", typ: typeGauge,
+			samples: []sample{{nil, boolValue(stats.CircuitState == digitalstrom.CSclosed)}}},
+		{name: "digitalstrom_logins_total", help: "Application logins performed.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.Logins)}}},
+		{name: "digitalstrom_request_duration_seconds_total", help: "Sum of the durations of all HTTP requests.", typ: typeCounter,
+			samples: []sample{{nil, stats.RequestTime.Seconds()}}},
+		{name: "digitalstrom_active_polls", help: "Polls that are currently running.", typ: typeGauge,
+			samples: []sample{{nil, float64(stats.ActivePolls)}}},
+		{name: "digitalstrom_polling_running", help: "1 when automatic polling is running.", typ: typeGauge,
+			samples: []sample{{nil, boolValue(stats.PollingRunning)}}},
+		{name: "digitalstrom_event_listener_running", help: "1 when the event listener is running.", typ: typeGauge,
+			samples: []sample{{nil, boolValue(stats.EventListenerRunning)}}},
+		{name: "digitalstrom_received_events_total", help: "Events received by the event listener.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.ReceivedEvents)}}},
+		{name: "digitalstrom_events_published_total", help: "Events published to subscribers.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.Events.Published)}}},
+		{name: "digitalstrom_events_delivered_total", help: "Events delivered to subscribers.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.Events.Delivered)}}},
+		{name: "digitalstrom_events_dropped_total", help: "Events dropped because of full subscriber queues.", typ: typeCounter,
+			samples: []sample{{nil, float64(stats.Events.Dropped)}}},
+	}
+
+	polls := family{name: "digitalstrom_polls_total", help: "Polls performed per category.", typ: typeCounter}
+	for category, count := range stats.Polls {
+		polls.samples = append(polls.samples, sample{[]label{{"category", category}}, float64(count)})
+	}
+	pollErrors := family{name: "digitalstrom_poll_errors_total", help: "Failed polls per category.", typ: typeCounter}
+	for category, count := range stats.PollErrors {
+		pollErrors.samples = append(pollErrors.samples, sample{[]label{{"category", category}}, float64(count)})
+	}
+	return append(families, polls, pollErrors)
+}
+
+func deviceLabels(snapshot *digitalstrom.Snapshot, id string, device *digitalstrom.Device) []label {
+	return []label{
+		{"device", id},
+		{"device_name", device.Name},
+		{"zone", strconv.Itoa(device.ZoneID)},
+		{"zone_name", zoneName(snapshot, device.ZoneID)},
+	}
+}
+
+func zoneName(snapshot *digitalstrom.Snapshot, zoneID int) string {
+	if zone, ok := snapshot.Zones[zoneID]; ok {
+		return zone.Name
+	}
+	return ""
+}
+
+func copyLabels(labels []label) []label {
+	return append([]label(nil), labels...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sortSamples sorts samples by their label values, so the output is stable between scrapes
+func sortSamples(samples []sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return labelString(samples[i].labels) < labelString(samples[j].labels)
+	})
+}
+
+func writeFamily(w *bufio.Writer, f *family) {
+	if len(f.samples) == 0 {
+		return
+	}
+	w.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+	for _, s := range f.samples {
+		w.WriteString(f.name)
+		w.WriteString(labelString(s.labels))
+		w.WriteString(" ")
+		w.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		w.WriteString("\n")
+	}
+}
+
+func labelString(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.name + "=\"" + escapeLabelValue(l.value) + "\""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+var helpReplacer = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
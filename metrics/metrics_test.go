@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// testSnapshot returns a snapshot with one value of each kind. The zone name contains characters that
+// have to be escaped in label values.
+func testSnapshot() *digitalstrom.Snapshot {
+	device := &digitalstrom.Device{
+		DisplayID: "00000001",
+		Name:      "Ceiling Lamp",
+		ZoneID:    1,
+		IsPresent: true,
+		On:        true,
+		Sensors: []*digitalstrom.Sensor{
+			{Type: digitalstrom.STtemperature, Value: 21.5, Valid: true},
+		},
+		OutputChannels: []*digitalstrom.OutputChannel{
+			{ChannelType: digitalstrom.OCTbrightness, ChannelIndex: 0, Value: 128},
+		},
+		BinaryInputs: []*digitalstrom.BinaryInput{
+			{InputID: 0, InputType: digitalstrom.BITwindowIsOpen, State: 1},
+		},
+	}
+	return &digitalstrom.Snapshot{
+		Devices: map[string]*digitalstrom.Device{"00000001": device},
+		Zones: map[int]*digitalstrom.Zone{
+			1: {ID: 1, Name: "Living \"Room\"\\1\nGround"},
+		},
+		Circuits: map[string]*digitalstrom.Circuit{
+			"0000c001": {DisplayID: "0000c001", Name: "Meter 1", HasMetering: true, Consumption: 120, MeterValue: 5400000},
+			"0000c002": {DisplayID: "0000c002", Name: "Meter 2", HasMetering: false},
+		},
+		TemperatureControl: map[int]*digitalstrom.TemperatureControlState{
+			1: {ZoneId: 1, ControlMode: 1, OperationMode: 2, TemperatureValue: 21.25, NominalValue: 22, ControlValue: 30},
+		},
+	}
+}
+
+func testStats() digitalstrom.Stats {
+	return digitalstrom.Stats{
+		Requests:             42,
+		RequestErrors:        2,
+		APIErrors:            1,
+		Retries:              4,
+		CircuitState:         digitalstrom.CSclosed,
+		Logins:               3,
+		RequestTime:          1500 * time.Millisecond,
+		Polls:                map[string]uint64{"sensor": 10, "channel": 5},
+		PollErrors:           map[string]uint64{"sensor": 1},
+		ActivePolls:          2,
+		PollingRunning:       true,
+		EventListenerRunning: false,
+		ReceivedEvents:       7,
+		Events:               digitalstrom.EventStats{Published: 7, Delivered: 6, Dropped: 1},
+	}
+}
+
+func render(families []family) string {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for i := range families {
+		writeFamily(w, &families[i])
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func TestGoldenOutput(t *testing.T) {
+	got := render(collect(testSnapshot(), testStats()))
+
+	golden := filepath.Join("testdata", "metrics.txt")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(expected) {
+		t.Errorf("output differs from %s, got\n%s", golden, got)
+	}
+}
+
+var (
+	metricName = `[a-zA-Z_:][a-zA-Z0-9_:]*`
+	labelValue = `"(?:[^"\\\n]|\\[\\"n])*"`
+	labelPair  = `[a-zA-Z_][a-zA-Z0-9_]*=` + labelValue
+	helpLine   = regexp.MustCompile(`^# HELP (` + metricName + `) (?:[^\\\n]|\\[\\n])*$`)
+	typeLine   = regexp.MustCompile(`^# TYPE (` + metricName + `) (counter|gauge|histogram|summary|untyped)$`)
+	sampleLine = regexp.MustCompile(`^(` + metricName + `)(?:\{` + labelPair + `(?:,` + labelPair + `)*\})? [-+]?(?:[0-9.eE+-]+|Inf|NaN)$`)
+)
+
+// TestTextFormat checks the output against the rules of the text exposition format: each family has
+// a HELP and a TYPE line preceding its samples, names and labels are valid, label values are escaped
+// and counters end with _total.
+func TestTextFormat(t *testing.T) {
+	output := render(collect(testSnapshot(), testStats()))
+	if !strings.HasSuffix(output, "\n") {
+		t.Errorf("output does not end with a line feed")
+	}
+
+	seen := map[string]bool{}
+	current, typ := "", ""
+	for i, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if m := helpLine.FindStringSubmatch(line); m != nil {
+			if seen[m[1]] {
+				t.Errorf("line %d: family %s is not contiguous", i+1, m[1])
+			}
+			seen[m[1]] = true
+			current, typ = m[1], ""
+			continue
+		}
+		if m := typeLine.FindStringSubmatch(line); m != nil {
+			if m[1] != current || typ != "" {
+				t.Errorf("line %d: TYPE does not follow the HELP of %s", i+1, m[1])
+			}
+			typ = m[2]
+			if typ == typeCounter && !strings.HasSuffix(current, "_total") {
+				t.Errorf("line %d: counter %s does not end with _total", i+1, current)
+			}
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("line %d is invalid: %q", i+1, line)
+			continue
+		}
+		if m[1] != current || typ == "" {
+			t.Errorf("line %d: sample of %s without HELP and TYPE", i+1, m[1])
+		}
+	}
+}
+
+func TestCircuitsWithoutMeteringAreSkipped(t *testing.T) {
+	output := render(collect(testSnapshot(), testStats()))
+	if strings.Contains(output, "0000c002") {
+		t.Errorf("circuit without metering has been exported")
+	}
+}
+
+// failingWriter is a response writer of a client that has closed the connection
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("connection closed")
+}
+
+func TestHandlerReportsWriteErrors(t *testing.T) {
+	var reported error
+	h := NewHandler(digitalstrom.NewAccount())
+	h.ErrorHandler = func(err error) { reported = err }
+
+	h.ServeHTTP(failingWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if reported == nil || reported.Error() != "connection closed" {
+		t.Errorf("write error has not been reported: %v", reported)
+	}
+
+	// without error handler the error is ignored
+	h.ErrorHandler = nil
+	h.ServeHTTP(failingWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+}
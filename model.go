@@ -2,8 +2,8 @@ package digitalstrom
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
+	"strconv"
 )
 
 /*
@@ -263,6 +263,7 @@ const (
 
 // Application Types
 const (
+	ATbroadcast            ApplicationType = 0
 	ATlights               ApplicationType = 1
 	ATblinds               ApplicationType = 2
 	ATheating              ApplicationType = 3
@@ -380,6 +381,8 @@ func (at ApplicationType) GetID() int {
 // GetName returns the name of the application type
 func (at ApplicationType) GetName() string {
 	switch at {
+	case ATbroadcast:
+		return "broadcast"
 	case ATlights:
 		return "lights"
 	case ATblinds:
@@ -496,7 +499,7 @@ func (d *Device) GetBinaryInputByInputID(inputId int) (*BinaryInput, error) {
 			return d.BinaryInputs[i], nil
 		}
 	}
-	return nil, fmt.Errorf("device has no binaryInput with id %d", inputId)
+	return nil, &NotFoundError{Kind: "binaryInput", ID: strconv.Itoa(inputId), Parent: d.DisplayID}
 }
 
 // GetOutputChannel returns a corresponding channel with the given output channel type.
@@ -506,7 +509,7 @@ func (d *Device) GetOutputChannel(outputChannelType OutputChannelType) (*OutputC
 			return d.OutputChannels[i], nil
 		}
 	}
-	return nil, errors.New("device '" + d.DisplayID + "' has no output channel of application type '" + string(outputChannelType) + "'")
+	return nil, &NotFoundError{Kind: "channel", ID: string(outputChannelType), Parent: d.DisplayID}
 }
 
 // GenerateApartment takes a json string and generates and returns an instance of structure Apartment
@@ -525,13 +528,13 @@ func GenerateApartment(j string) (*Apartment, error) {
 func (s *Structure) assignCrossReferences() {
 	for i := range s.Apartment.Zones {
 		for j := range s.Apartment.Zones[i].Devices {
-			device := s.Apartment.Zones[i].Devices[j]
+			device := &s.Apartment.Zones[i].Devices[j]
 			for n := range device.Sensors {
 				device.Sensors[n].Index = n
-				device.Sensors[n].device = &device
+				device.Sensors[n].device = device
 			}
 			for n := range device.OutputChannels {
-				device.OutputChannels[n].device = &device
+				device.OutputChannels[n].device = device
 			}
 		}
 	}
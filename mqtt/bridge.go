@@ -0,0 +1,511 @@
+// Package mqtt bridges a digitalstrom.Account to an MQTT broker. The Bridge publishes the cached values of
+// sensors, output channels, On states, binary inputs, circuits and temperature controls as retained messages
+// and executes commands received on command topics. The package contains a minimal MQTT 3.1.1 client, the
+// package mqtttest an in-process broker stand-in for tests.
+//
+//	bridge := mqtt.NewBridge(account, mqtt.BridgeSetup{Client: mqtt.ClientSetup{Address: "localhost:1883", ClientID: "dss"}})
+//	err := bridge.Start()
+//	defer bridge.Stop()
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+)
+
+const defaultReconnectInterval = 5 * time.Second
+
+// Payloads of the status topic
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// Topics are the templates of the topics the bridge publishes to and subscribes to. Levels in braces are
+// placeholders: {prefix}, {device} (display ID), {index} (sensor index), {channel} (channel type, e.g.
+// brightness), {input} (binary input id), {circuit} (display ID), {zone} and {group} (ids). Placeholders
+// have to be complete levels. Command topics are the On, Channel and scene topics with the CommandSuffix
+// appended. Empty fields are replaced by the corresponding field of DefaultTopics.
+type Topics struct {
+	Prefix             string
+	Status             string // online or offline, offline is the will of the bridge
+	Sensor             string
+	Channel            string // physical value
+	On                 string // true or false
+	BinaryInput        string
+	CircuitConsumption string // W
+	CircuitMeter       string // Ws
+	TemperatureControl string // json of the TemperatureControlState
+	DeviceScene        string // command only, the payload is the scene number
+	ZoneScene          string // command only, the payload is the scene number
+	ApartmentScene     string // command only, the payload is the scene number
+	CommandSuffix      string
+}
+
+// DefaultTopics are used for all topics that are not set
+var DefaultTopics = Topics{
+	Prefix:             "digitalstrom",
+	Status:             "{prefix}/status",
+	Sensor:             "{prefix}/device/{device}/sensor/{index}",
+	Channel:            "{prefix}/device/{device}/channel/{channel}",
+	On:                 "{prefix}/device/{device}/on",
+	BinaryInput:        "{prefix}/device/{device}/binaryInput/{input}",
+	CircuitConsumption: "{prefix}/circuit/{circuit}/consumption",
+	CircuitMeter:       "{prefix}/circuit/{circuit}/meter",
+	TemperatureControl: "{prefix}/zone/{zone}/temperatureControl",
+	DeviceScene:        "{prefix}/device/{device}/scene",
+	ZoneScene:          "{prefix}/zone/{zone}/group/{group}/scene",
+	ApartmentScene:     "{prefix}/apartment/group/{group}/scene",
+	CommandSuffix:      "/set",
+}
+
+// BridgeSetup configures a Bridge
+type BridgeSetup struct {
+	Client            ClientSetup
+	Topics            Topics
+	QoS               byte          // QoS of published values and command subscriptions, 0 or 1
+	ReconnectInterval time.Duration // wait time between connection attempts after the connection has been lost, default 5 s
+	// ErrorHandler is called for commands that could not be executed and for lost connections, optional
+	ErrorHandler func(err error)
+}
+
+// Bridge publishes the values of an Account to an MQTT broker and executes received commands
+type Bridge struct {
+	account *digitalstrom.Account
+	setup   BridgeSetup
+	topics  Topics
+	client  *Client
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	mutex   sync.Mutex
+}
+
+// NewBridge returns a bridge between the given account and the broker of the setup. The account has to be
+// initialized before the bridge is started.
+func NewBridge(account *digitalstrom.Account, setup BridgeSetup) *Bridge {
+	if setup.ReconnectInterval <= 0 {
+		setup.ReconnectInterval = defaultReconnectInterval
+	}
+	if setup.QoS > maxQoS {
+		setup.QoS = maxQoS
+	}
+	return &Bridge{account: account, setup: setup, topics: setup.Topics.withDefaults()}
+}
+
+// Start connects to the broker, publishes all cached values and subscribes to the command topics. Changes
+// of the account are published as long as the bridge is running. After the connection has been lost, the
+// bridge reconnects and publishes all values again.
+func (b *Bridge) Start() error {
+	return b.StartContext(context.Background())
+}
+
+// StartContext is like Start but the bridge stops when ctx is done.
+func (b *Bridge) StartContext(ctx context.Context) error {
+	b.mutex.Lock()
+	if b.cancel != nil {
+		b.mutex.Unlock()
+		return errors.New("mqtt bridge is already running")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	b.cancel = cancel
+	b.stopped = make(chan struct{})
+	b.mutex.Unlock()
+
+	err := b.connect(ctx)
+	if err != nil {
+		cancel()
+		b.mutex.Lock()
+		b.cancel = nil
+		close(b.stopped)
+		b.mutex.Unlock()
+		return err
+	}
+	unsubscribe := b.account.Subscribe(digitalstrom.SubscriptionSetup{Name: "mqtt bridge"}, func(event digitalstrom.Event) {
+		b.publishEvent(ctx, event)
+	})
+	go func() {
+		b.run(ctx)
+		unsubscribe()
+	}()
+	return nil
+}
+
+// Stop publishes the offline status and disconnects from the broker
+func (b *Bridge) Stop() {
+	b.mutex.Lock()
+	cancel, stopped := b.cancel, b.stopped
+	b.cancel = nil
+	b.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-stopped
+}
+
+// IsConnected returns true while the bridge is connected to the broker
+func (b *Bridge) IsConnected() bool {
+	client := b.currentClient()
+	if client == nil {
+		return false
+	}
+	select {
+	case <-client.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// run reconnects whenever the connection has been lost until ctx is done
+func (b *Bridge) run(ctx context.Context) {
+	defer close(b.stopped)
+	for {
+		client := b.currentClient()
+		if client != nil {
+			select {
+			case <-client.Done():
+				b.handleError(fmt.Errorf("mqtt connection lost: %w", client.Err()))
+			case <-ctx.Done():
+				client.Publish(Message{Topic: b.topics.Status, Payload: []byte(StatusOffline), QoS: b.setup.QoS, Retain: true})
+				client.Disconnect()
+				return
+			}
+		}
+		select {
+		case <-time.After(b.setup.ReconnectInterval):
+		case <-ctx.Done():
+			return
+		}
+		err := b.connect(ctx)
+		if err != nil {
+			b.handleError(err)
+		}
+	}
+}
+
+// connect connects to the broker, subscribes to the command topics and publishes all values
+func (b *Bridge) connect(ctx context.Context) error {
+	setup := b.setup.Client
+	setup.Will = &Message{Topic: b.topics.Status, Payload: []byte(StatusOffline), QoS: b.setup.QoS, Retain: true}
+	client, err := ConnectContext(ctx, setup)
+	if err != nil {
+		b.setClient(nil)
+		return err
+	}
+	b.setClient(client)
+
+	commands := []string{b.topics.On, b.topics.Channel, b.topics.DeviceScene, b.topics.ZoneScene, b.topics.ApartmentScene}
+	for _, command := range commands {
+		template := command + b.topics.CommandSuffix
+		err = client.SubscribeContext(ctx, filterOf(template), b.setup.QoS, func(msg Message) {
+			b.handleCommand(ctx, template, msg)
+		})
+		if err != nil {
+			client.Disconnect()
+			return err
+		}
+	}
+	err = b.publish(ctx, b.topics.Status, nil, StatusOnline)
+	if err != nil {
+		return err
+	}
+	return b.publishSnapshot(ctx, b.account.Snapshot())
+}
+
+// publishSnapshot publishes all values of the snapshot
+func (b *Bridge) publishSnapshot(ctx context.Context, snapshot *digitalstrom.Snapshot) error {
+	for id, device := range snapshot.Devices {
+		values := map[string]string{"device": id}
+		err := b.publish(ctx, b.topics.On, values, strconv.FormatBool(device.On))
+		for i, sensor := range device.Sensors {
+			if err == nil {
+				err = b.publish(ctx, b.topics.Sensor, withValue(values, "index", strconv.Itoa(i)), formatFloat(sensor.Value))
+			}
+		}
+		for _, channel := range device.OutputChannels {
+			if err == nil {
+				value, _ := channel.PhysicalValue()
+				err = b.publish(ctx, b.topics.Channel, withValue(values, "channel", string(channel.ChannelType)), formatFloat(value))
+			}
+		}
+		for _, input := range device.BinaryInputs {
+			if err == nil {
+				err = b.publish(ctx, b.topics.BinaryInput, withValue(values, "input", strconv.Itoa(input.InputID)), strconv.Itoa(input.State))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for id, circuit := range snapshot.Circuits {
+		if !circuit.HasMetering {
+			continue
+		}
+		values := map[string]string{"circuit": id}
+		err := b.publish(ctx, b.topics.CircuitConsumption, values, strconv.Itoa(circuit.Consumption))
+		if err == nil {
+			err = b.publish(ctx, b.topics.CircuitMeter, values, strconv.Itoa(circuit.MeterValue))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for id, state := range snapshot.TemperatureControl {
+		err := b.publishTemperatureControl(ctx, id, state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishEvent publishes the value changed by the event
+func (b *Bridge) publishEvent(ctx context.Context, event digitalstrom.Event) {
+	var err error
+	switch e := event.(type) {
+	case digitalstrom.SensorValueChangeEvent:
+		err = b.publish(ctx, b.topics.Sensor, map[string]string{"device": e.DeviceId, "index": strconv.Itoa(e.SensorIndex)}, formatFloat(e.NewValue))
+	case digitalstrom.ChannelValueChangeEvent:
+		channel, lookupErr := b.account.GetOutputChannel(e.DeviceID, e.ChannelIndex)
+		if lookupErr != nil {
+			return
+		}
+		value := float64(e.NewValue)
+		if r, ok := channel.ChannelType.GetRange(); ok {
+			value = r.ToPhysical(e.NewValue)
+		}
+		err = b.publish(ctx, b.topics.Channel, map[string]string{"device": e.DeviceID, "channel": string(channel.ChannelType)}, formatFloat(value))
+	case digitalstrom.OnStateValueChangeEvent:
+		err = b.publish(ctx, b.topics.On, map[string]string{"device": e.DeviceId}, strconv.FormatBool(e.NewValue))
+	case digitalstrom.BinaryInputStateChangeEvent:
+		err = b.publish(ctx, b.topics.BinaryInput, map[string]string{"device": e.DeviceId, "input": strconv.Itoa(e.InputId)}, strconv.Itoa(e.NewValue))
+	case digitalstrom.CircuitConsumptionValueChangeEvent:
+		err = b.publish(ctx, b.topics.CircuitConsumption, map[string]string{"circuit": e.CircuitID}, strconv.Itoa(e.NewValue))
+	case digitalstrom.CircuitMeterValueChangeEvent:
+		err = b.publish(ctx, b.topics.CircuitMeter, map[string]string{"circuit": e.CircuitID}, strconv.Itoa(e.NewValue))
+	case digitalstrom.ZoneTemperatureControlChangeEvent:
+		state, ok := b.account.Snapshot().TemperatureControl[e.ZoneId]
+		if ok {
+			err = b.publishTemperatureControl(ctx, e.ZoneId, state)
+		}
+	case digitalstrom.DeviceAddedEvent:
+		// the values of new devices are published with the next connect, publish the On state right now
+		device, ok := b.account.Snapshot().Devices[e.DeviceId]
+		if ok {
+			err = b.publish(ctx, b.topics.On, map[string]string{"device": e.DeviceId}, strconv.FormatBool(device.On))
+		}
+	}
+	if err != nil && !errors.Is(err, ErrClosed) {
+		b.handleError(err)
+	}
+}
+
+func (b *Bridge) publishTemperatureControl(ctx context.Context, zoneID int, state *digitalstrom.TemperatureControlState) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return b.publish(ctx, b.topics.TemperatureControl, map[string]string{"zone": strconv.Itoa(zoneID)}, string(payload))
+}
+
+// publish publishes the retained payload to the topic generated from the template and values. Nothing
+// is published while the bridge is not connected.
+func (b *Bridge) publish(ctx context.Context, template string, values map[string]string, payload string) error {
+	client := b.currentClient()
+	if client == nil {
+		return nil
+	}
+	return client.PublishContext(ctx, Message{Topic: expand(template, values), Payload: []byte(payload), QoS: b.setup.QoS, Retain: true})
+}
+
+// handleCommand executes the command received on a topic matching the given command template
+func (b *Bridge) handleCommand(ctx context.Context, template string, msg Message) {
+	values, ok := match(template, msg.Topic)
+	if !ok {
+		return
+	}
+	payload := strings.TrimSpace(string(msg.Payload))
+	err := b.executeCommand(ctx, template, values, payload)
+	if err != nil {
+		b.handleError(fmt.Errorf("mqtt command %s (%s) failed: %w", msg.Topic, payload, err))
+	}
+}
+
+func (b *Bridge) executeCommand(ctx context.Context, template string, values map[string]string, payload string) error {
+	suffix := b.topics.CommandSuffix
+	switch template {
+	case b.topics.On + suffix:
+		device, err := b.account.GetDevice(values["device"])
+		if err != nil {
+			return err
+		}
+		on, err := parseOn(payload)
+		if err != nil {
+			return err
+		}
+		return b.account.TurnOnContext(ctx, device, on)
+	case b.topics.Channel + suffix:
+		value, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return fmt.Errorf("invalid channel value '%s'", payload)
+		}
+		// the channels are resolved by the account, as the cached device might be updated concurrently
+		for i := 0; ; i++ {
+			channel, err := b.account.GetOutputChannel(values["device"], i)
+			if err != nil {
+				var notFoundErr *digitalstrom.NotFoundError
+				if errors.As(err, &notFoundErr) && notFoundErr.Kind == "channel" {
+					return &digitalstrom.NotFoundError{Kind: "channel", ID: values["channel"], Parent: values["device"]}
+				}
+				return err
+			}
+			if string(channel.ChannelType) == values["channel"] {
+				// values are published in the physical unit of the channel, so they are written the same way
+				return b.account.SetOutputChannelPhysicalValueContext(ctx, channel, value)
+			}
+		}
+	}
+
+	scene, err := strconv.Atoi(payload)
+	if err != nil {
+		return fmt.Errorf("invalid scene number '%s'", payload)
+	}
+	switch template {
+	case b.topics.DeviceScene + suffix:
+		device, err := b.account.GetDevice(values["device"])
+		if err != nil {
+			return err
+		}
+		return b.account.CallDeviceSceneContext(ctx, device, digitalstrom.SceneNumber(scene), false)
+	case b.topics.ZoneScene + suffix:
+		zoneID, err := strconv.Atoi(values["zone"])
+		if err != nil {
+			return fmt.Errorf("invalid zone id '%s'", values["zone"])
+		}
+		groupID, err := strconv.Atoi(values["group"])
+		if err != nil {
+			return fmt.Errorf("invalid group id '%s'", values["group"])
+		}
+		return b.account.CallZoneSceneContext(ctx, zoneID, digitalstrom.ApplicationType(groupID), digitalstrom.SceneNumber(scene), false)
+	case b.topics.ApartmentScene + suffix:
+		groupID, err := strconv.Atoi(values["group"])
+		if err != nil {
+			return fmt.Errorf("invalid group id '%s'", values["group"])
+		}
+		return b.account.CallApartmentSceneContext(ctx, digitalstrom.ApplicationType(groupID), digitalstrom.SceneNumber(scene), false)
+	}
+	return nil
+}
+
+func (b *Bridge) handleError(err error) {
+	if b.setup.ErrorHandler != nil {
+		b.setup.ErrorHandler(err)
+	}
+}
+
+func (b *Bridge) currentClient() *Client {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.client
+}
+
+func (b *Bridge) setClient(client *Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.client = client
+}
+
+// withDefaults returns the topics with all empty fields replaced by the default topics and the prefix
+// placeholder replaced by the prefix
+func (t Topics) withDefaults() Topics {
+	d := DefaultTopics
+	fields := []struct{ value, def *string }{
+		{&t.Prefix, &d.Prefix}, {&t.Status, &d.Status}, {&t.Sensor, &d.Sensor}, {&t.Channel, &d.Channel},
+		{&t.On, &d.On}, {&t.BinaryInput, &d.BinaryInput}, {&t.CircuitConsumption, &d.CircuitConsumption},
+		{&t.CircuitMeter, &d.CircuitMeter}, {&t.TemperatureControl, &d.TemperatureControl},
+		{&t.DeviceScene, &d.DeviceScene}, {&t.ZoneScene, &d.ZoneScene}, {&t.ApartmentScene, &d.ApartmentScene},
+		{&t.CommandSuffix, &d.CommandSuffix},
+	}
+	for _, f := range fields {
+		if *f.value == "" {
+			*f.value = *f.def
+		}
+		if f.value != &t.Prefix {
+			*f.value = strings.ReplaceAll(*f.value, "{prefix}", t.Prefix)
+		}
+	}
+	return t
+}
+
+// expand replaces the placeholders of the template by the given values
+func expand(template string, values map[string]string) string {
+	for name, value := range values {
+		template = strings.ReplaceAll(template, "{"+name+"}", value)
+	}
+	return template
+}
+
+// filterOf returns the topic filter matching all topics of the template
+func filterOf(template string) string {
+	levels := strings.Split(template, "/")
+	for i, level := range levels {
+		if isPlaceholder(level) {
+			levels[i] = "+"
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// match returns the values of the placeholders when the topic matches the template
+func match(template string, topic string) (map[string]string, bool) {
+	templateLevels := strings.Split(template, "/")
+	topicLevels := strings.Split(topic, "/")
+	if len(templateLevels) != len(topicLevels) {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, level := range templateLevels {
+		if isPlaceholder(level) {
+			values[level[1:len(level)-1]] = topicLevels[i]
+		} else if level != topicLevels[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func isPlaceholder(level string) bool {
+	return strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}")
+}
+
+// withValue returns a copy of values with the additional value
+func withValue(values map[string]string, name string, value string) map[string]string {
+	result := map[string]string{name: value}
+	for k, v := range values {
+		result[k] = v
+	}
+	return result
+}
+
+func parseOn(payload string) (bool, error) {
+	switch strings.ToLower(payload) {
+	case "true", "on", "1":
+		return true, nil
+	case "false", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid on state '%s', use true or false", payload)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
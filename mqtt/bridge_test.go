@@ -0,0 +1,198 @@
+package mqtt_test
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+	"github.com/connctd/digitalstrom/mqtt"
+	"github.com/connctd/digitalstrom/mqtt/mqtttest"
+	"github.com/go-logr/logr"
+)
+
+func TestMain(m *testing.M) {
+	digitalstrom.SetLogger(logr.Discard())
+	os.Exit(m.Run())
+}
+
+// bridgeTest contains a running bridge between an account using a dsstest server and a mqtttest broker
+type bridgeTest struct {
+	srv     *dsstest.Server
+	account *digitalstrom.Account
+	broker  *mqtttest.Broker
+	bridge  *mqtt.Bridge
+	errors  []error
+	mutex   sync.Mutex
+}
+
+func startBridge(t *testing.T) *bridgeTest {
+	t.Helper()
+	bt := &bridgeTest{srv: dsstest.NewServer(dsstest.NewApartment()), broker: mqtttest.NewBroker()}
+	t.Cleanup(bt.srv.Close)
+	t.Cleanup(bt.broker.Close)
+	bt.account = digitalstrom.NewAccount()
+	bt.account.SetURL(bt.srv.URL)
+	bt.account.SetApplicationToken(dsstest.ApplicationToken)
+	if err := bt.account.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	bt.bridge = mqtt.NewBridge(bt.account, mqtt.BridgeSetup{
+		Client:            mqtt.ClientSetup{Address: bt.broker.Addr, ClientID: "dss"},
+		QoS:               1,
+		ReconnectInterval: 50 * time.Millisecond,
+		ErrorHandler: func(err error) {
+			bt.mutex.Lock()
+			bt.errors = append(bt.errors, err)
+			bt.mutex.Unlock()
+		},
+	})
+	if err := bt.bridge.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(bt.bridge.Stop)
+	return bt
+}
+
+// expectRetained waits until the retained message of the topic has the given payload
+func (bt *bridgeTest) expectRetained(t *testing.T, topic string, payload string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; {
+		msg, ok := bt.broker.Retained(topic)
+		if ok && string(msg.Payload) == payload {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("retained message of %s is %q instead of %q", topic, msg.Payload, payload)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (bt *bridgeTest) lastError() error {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+	if len(bt.errors) == 0 {
+		return nil
+	}
+	return bt.errors[len(bt.errors)-1]
+}
+
+// waitFor fails the test with the given message when cond does not become true within a second
+func waitFor(t *testing.T, cond func() bool, format string, args ...interface{}) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBridgePublishesValues(t *testing.T) {
+	bt := startBridge(t)
+	bt.expectRetained(t, "digitalstrom/status", mqtt.StatusOnline)
+	bt.expectRetained(t, "digitalstrom/device/00000003/sensor/0", "21.5")
+	bt.expectRetained(t, "digitalstrom/device/00000003/binaryInput/0", "1")
+	bt.expectRetained(t, "digitalstrom/device/00000001/on", "false")
+	bt.expectRetained(t, "digitalstrom/device/00000002/channel/hue", "0")
+	bt.expectRetained(t, "digitalstrom/circuit/0000c001/consumption", "120")
+
+	bt.srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000003").Sensors[0].Value = 23
+		apartment.GetDevice("00000002").OutputChannels[1].Value = 128
+	})
+	sensor, _ := bt.account.GetSensor("00000003", 0)
+	if _, err := bt.account.PollSensorValue(sensor); err != nil {
+		t.Fatalf("PollSensorValue failed: %v", err)
+	}
+	channel, _ := bt.account.GetOutputChannel("00000002", 1)
+	if _, err := bt.account.PollChannelValue(channel); err != nil {
+		t.Fatalf("PollChannelValue failed: %v", err)
+	}
+	bt.expectRetained(t, "digitalstrom/device/00000003/sensor/0", "23")
+	// channel values are published in their physical unit, hue in degrees
+	r, _ := digitalstrom.OCThue.GetRange()
+	bt.expectRetained(t, "digitalstrom/device/00000002/channel/hue", strconv.FormatFloat(r.ToPhysical(128), 'f', -1, 64))
+
+	bt.bridge.Stop()
+	bt.expectRetained(t, "digitalstrom/status", mqtt.StatusOffline)
+}
+
+func TestBridgePublishesOnStateOfAddedDevice(t *testing.T) {
+	bt := startBridge(t)
+	bt.expectRetained(t, "digitalstrom/status", mqtt.StatusOnline)
+	bt.srv.Update(func(apartment *dsstest.Apartment) {
+		living := apartment.GetZone(1)
+		added := living.Devices[0]
+		added.ID, added.DisplayID, added.UUID = "302ed89f43f00e4000000004", "00000004", "302ed89f43f0000000000000000000004"
+		added.On = true
+		added.OutputChannels = nil
+		living.Devices = append(living.Devices, added)
+	})
+	if err := bt.account.PollStructureValues(); err != nil {
+		t.Fatalf("PollStructureValues failed: %v", err)
+	}
+	bt.expectRetained(t, "digitalstrom/device/00000004/on", "true")
+}
+
+func TestBridgeCommands(t *testing.T) {
+	bt := startBridge(t)
+	bt.expectRetained(t, "digitalstrom/status", mqtt.StatusOnline)
+
+	bt.broker.Publish("digitalstrom/device/00000001/on/set", []byte("on"), false)
+	waitFor(t, func() bool { return len(bt.srv.CallsTo("/json/device/turnOn")) == 1 }, "device not turned on")
+
+	bt.broker.Publish("digitalstrom/device/00000002/channel/hue/set", []byte("180"), false)
+	waitFor(t, func() bool { return len(bt.srv.CallsTo("/json/device/setOutputChannelValue")) == 1 }, "channel value not set")
+	if values := bt.srv.CallsTo("/json/device/setOutputChannelValue")[0].Params.Get("channelvalues"); values != "hue=180" {
+		t.Errorf("expected the physical value hue=180, got %s", values)
+	}
+
+	bt.broker.Publish("digitalstrom/zone/1/group/1/scene/set", []byte("5"), false)
+	waitFor(t, func() bool { return len(bt.srv.CallsTo("/json/zone/callScene")) == 1 }, "zone scene not called")
+	if call := bt.srv.CallsTo("/json/zone/callScene")[0]; call.Params.Get("id") != "1" || call.Params.Get("sceneNumber") != "5" {
+		t.Errorf("unexpected zone scene call %v", call.Params)
+	}
+
+	// hue is given in degrees, so 400 is out of range and not sent to the dSS
+	bt.broker.Publish("digitalstrom/device/00000002/channel/hue/set", []byte("400"), false)
+	waitFor(t, func() bool { return bt.lastError() != nil }, "invalid channel value not reported")
+	if !strings.Contains(bt.lastError().Error(), "digitalstrom/device/00000002/channel/hue/set") {
+		t.Errorf("error does not contain the topic: %v", bt.lastError())
+	}
+	if n := len(bt.srv.CallsTo("/json/device/setOutputChannelValue")); n != 1 {
+		t.Errorf("invalid channel value has been sent")
+	}
+
+	bt.broker.Publish("digitalstrom/device/00000001/channel/hue/set", []byte("180"), false)
+	var notFoundErr *digitalstrom.NotFoundError
+	waitFor(t, func() bool { return errors.As(bt.lastError(), &notFoundErr) }, "unknown channel not reported")
+	if notFoundErr.Kind != "channel" || notFoundErr.ID != "hue" || notFoundErr.Parent != "00000001" {
+		t.Errorf("unexpected error %+v", notFoundErr)
+	}
+}
+
+func TestBridgeReconnects(t *testing.T) {
+	bt := startBridge(t)
+	bt.expectRetained(t, "digitalstrom/status", mqtt.StatusOnline)
+
+	bt.broker.DisconnectClients()
+	waitFor(t, func() bool {
+		for _, msg := range bt.broker.MessagesTo("digitalstrom/status") {
+			if string(msg.Payload) == mqtt.StatusOffline {
+				return true
+			}
+		}
+		return false
+	}, "will has not been published")
+	bt.expectRetained(t, "digitalstrom/status", mqtt.StatusOnline)
+	if !bt.bridge.IsConnected() {
+		t.Errorf("bridge is not connected after reconnecting")
+	}
+}
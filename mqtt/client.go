@@ -0,0 +1,454 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connctd/digitalstrom/internal/mqttpacket"
+)
+
+const (
+	defaultKeepAlive      = 30 * time.Second
+	defaultTimeout        = 10 * time.Second
+	maxQoS           byte = 1
+)
+
+// ErrClosed is returned for requests on a client whose connection has been closed
+var ErrClosed = errors.New("mqtt connection closed")
+
+// ClientSetup configures the connection to a broker
+type ClientSetup struct {
+	Address   string        // host:port of the broker, the prefixes tcp://, mqtt://, ssl://, tls:// and mqtts:// are accepted
+	ClientID  string        // empty ids are only accepted by brokers supporting them
+	Username  string        // optional
+	Password  string        // optional
+	KeepAlive time.Duration // interval of keep alive pings, default 30 s
+	Timeout   time.Duration // timeout for establishing the connection and for acknowledgements, default 10 s
+	TLSConfig *tls.Config   // enables TLS, also used for the tls:// prefixes when nil
+	Will      *Message      // published by the broker when the connection is lost
+}
+
+// Message is an application message. QoS 0 and 1 are supported.
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// Client is a minimal MQTT 3.1.1 client. It supports QoS 0 and 1, retained messages, wills and keep
+// alive with clean sessions only. Messages of subscriptions are passed to the handlers sequentially
+// from a dedicated goroutine, so handlers are allowed to publish. The client does not reconnect; Done
+// is closed when the connection is lost.
+type Client struct {
+	setup       ClientSetup
+	conn        net.Conn
+	writeMutex  sync.Mutex
+	mutex       sync.Mutex
+	nextID      uint16
+	pending     map[uint16]chan mqttpacket.Packet
+	handlers    []subscriptionHandler
+	inbox       []mqttpacket.Publish // received messages that have not been passed to the handlers yet
+	notify      chan struct{}
+	pingPending bool
+	done        chan struct{}
+	closeOnce   sync.Once
+	err         error
+}
+
+type subscriptionHandler struct {
+	filter  string
+	handler func(Message)
+}
+
+// Connect connects to the broker of the given setup
+func Connect(setup ClientSetup) (*Client, error) {
+	return ConnectContext(context.Background(), setup)
+}
+
+// ConnectContext is like Connect but aborts establishing the connection when ctx is done.
+func ConnectContext(ctx context.Context, setup ClientSetup) (*Client, error) {
+	if setup.KeepAlive <= 0 {
+		setup.KeepAlive = defaultKeepAlive
+	}
+	if setup.Timeout <= 0 {
+		setup.Timeout = defaultTimeout
+	}
+	conn, err := dial(ctx, setup)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		setup:   setup,
+		conn:    conn,
+		pending: make(map[uint16]chan mqttpacket.Packet),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	connect := mqttpacket.Connect{
+		ClientID:     setup.ClientID,
+		CleanSession: true,
+		KeepAlive:    uint16(setup.KeepAlive / time.Second),
+		Username:     setup.Username,
+		Password:     setup.Password,
+	}
+	if setup.Will != nil {
+		connect.Will = &mqttpacket.Publish{Topic: setup.Will.Topic, Payload: setup.Will.Payload, QoS: setup.Will.QoS, Retain: setup.Will.Retain}
+	}
+	deadline := time.Now().Add(setup.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	r := bufio.NewReader(conn)
+	err = mqttpacket.Write(conn, connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p, err := mqttpacket.Read(r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack, ok := p.(mqttpacket.ConnAck)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected mqtt packet type %d instead of CONNACK", p.Type())
+	}
+	if ack.ReturnCode != mqttpacket.Accepted {
+		conn.Close()
+		return nil, &ConnectError{ReturnCode: ack.ReturnCode}
+	}
+	conn.SetDeadline(time.Time{})
+
+	go c.readLoop(r)
+	go c.dispatchLoop()
+	go c.keepAliveLoop()
+	return c, nil
+}
+
+// ConnectError is returned when the broker refused the connection
+type ConnectError struct {
+	ReturnCode byte
+}
+
+func (e *ConnectError) Error() string {
+	reasons := map[byte]string{
+		mqttpacket.RefusedProtocolVersion:     "unacceptable protocol version",
+		mqttpacket.RefusedIdentifierRejected:  "identifier rejected",
+		mqttpacket.RefusedServerUnavailable:   "server unavailable",
+		mqttpacket.RefusedBadUsernamePassword: "bad user name or password",
+		mqttpacket.RefusedNotAuthorized:       "not authorized",
+	}
+	reason, ok := reasons[e.ReturnCode]
+	if !ok {
+		reason = fmt.Sprintf("return code %d", e.ReturnCode)
+	}
+	return "mqtt connection refused - " + reason
+}
+
+// Publish sends the message. For QoS 1, Publish waits until the broker acknowledged the message.
+func (c *Client) Publish(msg Message) error {
+	return c.PublishContext(context.Background(), msg)
+}
+
+// PublishContext is like Publish but stops waiting for the acknowledgement when ctx is done.
+func (c *Client) PublishContext(ctx context.Context, msg Message) error {
+	if msg.QoS > maxQoS {
+		return fmt.Errorf("mqtt QoS %d is not supported", msg.QoS)
+	}
+	p := mqttpacket.Publish{Topic: msg.Topic, Payload: msg.Payload, QoS: msg.QoS, Retain: msg.Retain}
+	if msg.QoS == 0 {
+		return c.write(p)
+	}
+	_, err := c.request(ctx, func(id uint16) mqttpacket.Packet {
+		p.PacketID = id
+		return p
+	})
+	return err
+}
+
+// Subscribe subscribes to the given topic filter. Messages matching the filter are passed to the handler.
+// The QoS of the subscription is limited to 1.
+func (c *Client) Subscribe(filter string, qos byte, handler func(Message)) error {
+	return c.SubscribeContext(context.Background(), filter, qos, handler)
+}
+
+// SubscribeContext is like Subscribe but stops waiting for the acknowledgement when ctx is done.
+func (c *Client) SubscribeContext(ctx context.Context, filter string, qos byte, handler func(Message)) error {
+	if !mqttpacket.ValidFilter(filter) {
+		return fmt.Errorf("invalid mqtt topic filter '%s'", filter)
+	}
+	if qos > maxQoS {
+		qos = maxQoS
+	}
+	// the handler has to be known before the broker sends retained messages
+	c.mutex.Lock()
+	c.handlers = append(c.handlers, subscriptionHandler{filter: filter, handler: handler})
+	c.mutex.Unlock()
+	p, err := c.request(ctx, func(id uint16) mqttpacket.Packet {
+		return mqttpacket.Subscribe{PacketID: id, Subscriptions: []mqttpacket.Subscription{{Filter: filter, QoS: qos}}}
+	})
+	if err == nil {
+		ack, ok := p.(mqttpacket.SubAck)
+		if !ok || len(ack.ReturnCodes) != 1 || ack.ReturnCodes[0] == mqttpacket.SubscriptionFailure {
+			err = fmt.Errorf("mqtt subscription of '%s' has been rejected", filter)
+		}
+	}
+	if err != nil {
+		c.removeHandlers(filter)
+	}
+	return err
+}
+
+// Unsubscribe removes the subscription of the given topic filter
+func (c *Client) Unsubscribe(filter string) error {
+	return c.UnsubscribeContext(context.Background(), filter)
+}
+
+// UnsubscribeContext is like Unsubscribe but stops waiting for the acknowledgement when ctx is done.
+func (c *Client) UnsubscribeContext(ctx context.Context, filter string) error {
+	_, err := c.request(ctx, func(id uint16) mqttpacket.Packet {
+		return mqttpacket.Unsubscribe{PacketID: id, Filters: []string{filter}}
+	})
+	c.removeHandlers(filter)
+	return err
+}
+
+// Disconnect closes the connection gracefully, the broker discards the will.
+func (c *Client) Disconnect() error {
+	err := c.write(mqttpacket.Disconnect{})
+	c.close(ErrClosed)
+	return err
+}
+
+// Done is closed when the connection has been closed or lost
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection has been closed, nil while it is open
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// request sends the packet created with a new packet id and waits for the acknowledgement
+func (c *Client) request(ctx context.Context, packet func(id uint16) mqttpacket.Packet) (mqttpacket.Packet, error) {
+	ack := make(chan mqttpacket.Packet, 1)
+	c.mutex.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mutex.Unlock()
+		return nil, err
+	}
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	id := c.nextID
+	c.pending[id] = ack
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+	}()
+
+	err := c.write(packet(id))
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(c.setup.Timeout)
+	defer timer.Stop()
+	select {
+	case p := <-ack:
+		return p, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-timer.C:
+		return nil, errors.New("mqtt acknowledgement timed out")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) write(p mqttpacket.Packet) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.setup.Timeout))
+	err := mqttpacket.Write(c.conn, p)
+	if err != nil {
+		c.close(err)
+	}
+	return err
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		p, err := mqttpacket.Read(r)
+		if err != nil {
+			c.close(err)
+			return
+		}
+		switch p := p.(type) {
+		case mqttpacket.Publish:
+			// the inbox is not limited, as handlers waiting for acknowledgements must not block reading
+			c.mutex.Lock()
+			c.inbox = append(c.inbox, p)
+			c.mutex.Unlock()
+			select {
+			case c.notify <- struct{}{}:
+			default:
+			}
+			if p.QoS > 0 {
+				c.write(mqttpacket.PubAck{PacketID: p.PacketID})
+			}
+		case mqttpacket.PubAck:
+			c.acknowledge(p.PacketID, p)
+		case mqttpacket.SubAck:
+			c.acknowledge(p.PacketID, p)
+		case mqttpacket.UnsubAck:
+			c.acknowledge(p.PacketID, p)
+		case mqttpacket.PingResp:
+			c.mutex.Lock()
+			c.pingPending = false
+			c.mutex.Unlock()
+		default:
+			c.close(fmt.Errorf("unexpected mqtt packet type %d", p.Type()))
+			return
+		}
+	}
+}
+
+func (c *Client) acknowledge(id uint16, p mqttpacket.Packet) {
+	c.mutex.Lock()
+	ack, ok := c.pending[id]
+	c.mutex.Unlock()
+	if ok {
+		ack <- p
+	}
+}
+
+// dispatchLoop passes received messages to the handlers of all matching subscriptions
+func (c *Client) dispatchLoop() {
+	for {
+		select {
+		case <-c.notify:
+		case <-c.done:
+			return
+		}
+		for {
+			c.mutex.Lock()
+			if len(c.inbox) == 0 {
+				c.mutex.Unlock()
+				break
+			}
+			p := c.inbox[0]
+			c.inbox = c.inbox[1:]
+			handlers := []func(Message){}
+			for _, h := range c.handlers {
+				if mqttpacket.MatchTopic(h.filter, p.Topic) {
+					handlers = append(handlers, h.handler)
+				}
+			}
+			c.mutex.Unlock()
+			msg := Message{Topic: p.Topic, Payload: p.Payload, QoS: p.QoS, Retain: p.Retain}
+			for _, handler := range handlers {
+				handler(msg)
+			}
+		}
+	}
+}
+
+// keepAliveLoop sends a ping in each keep alive interval and closes the connection when the previous
+// ping has not been answered
+func (c *Client) keepAliveLoop() {
+	ticker := time.NewTicker(c.setup.KeepAlive * 3 / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mutex.Lock()
+			unanswered := c.pingPending
+			c.pingPending = true
+			c.mutex.Unlock()
+			if unanswered {
+				c.close(errors.New("mqtt keep alive timed out"))
+				return
+			}
+			c.write(mqttpacket.PingReq{})
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) removeHandlers(filter string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	handlers := []subscriptionHandler{}
+	for _, h := range c.handlers {
+		if h.filter != filter {
+			handlers = append(handlers, h)
+		}
+	}
+	c.handlers = handlers
+}
+
+func (c *Client) close(err error) {
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		c.err = err
+		c.mutex.Unlock()
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// dial opens the network connection to the broker
+func dial(ctx context.Context, setup ClientSetup) (net.Conn, error) {
+	address := setup.Address
+	useTLS := setup.TLSConfig != nil
+	for _, prefix := range []string{"tcp://", "mqtt://", "ssl://", "tls://", "mqtts://"} {
+		if strings.HasPrefix(address, prefix) {
+			address = strings.TrimPrefix(address, prefix)
+			useTLS = useTLS || (prefix != "tcp://" && prefix != "mqtt://")
+		}
+	}
+	dialer := &net.Dialer{Timeout: setup.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil || !useTLS {
+		return conn, err
+	}
+	config := setup.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,334 @@
+// Package mqtttest provides an in-process MQTT 3.1.1 broker stand-in to test the mqtt bridge or any
+// other MQTT client without an external broker. The broker supports QoS 0 and 1, retained messages,
+// wills and the wildcards '+' and '#' and records all messages published by clients.
+//
+//	broker := mqtttest.NewBroker()
+//	defer broker.Close()
+//	bridge := mqtt.NewBridge(account, mqtt.BridgeSetup{Client: mqtt.ClientSetup{Address: broker.Addr}})
+package mqtttest
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/connctd/digitalstrom/internal/mqttpacket"
+)
+
+// Message is a message published by a client or by Publish. ClientID is empty for messages published
+// by Publish and for wills.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	ClientID string
+}
+
+// Broker is an MQTT broker listening on a loopback address. Clients could connect to Addr.
+type Broker struct {
+	Addr string // host:port
+
+	listener  net.Listener
+	sessions  map[*session]bool
+	retained  map[string]Message
+	published []Message
+	username  string
+	password  string
+	mutex     sync.Mutex
+	wg        sync.WaitGroup
+}
+
+type session struct {
+	conn          net.Conn
+	clientID      string
+	subscriptions map[string]byte
+	will          *Message
+	nextID        uint16
+	writeMutex    sync.Mutex
+}
+
+// NewBroker starts a broker listening on a random port of the loopback interface. It panics when
+// listening is not possible.
+func NewBroker() *Broker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("mqtttest: failed to listen on a port: " + err.Error())
+	}
+	b := &Broker{
+		Addr:     l.Addr().String(),
+		listener: l,
+		sessions: make(map[*session]bool),
+		retained: make(map[string]Message),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b
+}
+
+// Close stops the broker and closes all client connections
+func (b *Broker) Close() {
+	b.listener.Close()
+	b.DisconnectClients()
+	b.wg.Wait()
+}
+
+// SetCredentials lets the broker refuse clients that do not connect with the given user name and password
+func (b *Broker) SetCredentials(username string, password string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.username, b.password = username, password
+}
+
+// DisconnectClients closes the connections of all clients without a DISCONNECT, so their wills are published.
+func (b *Broker) DisconnectClients() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for s := range b.sessions {
+		s.conn.Close()
+	}
+}
+
+// Clients returns the ids of all connected clients
+func (b *Broker) Clients() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ids := []string{}
+	for s := range b.sessions {
+		ids = append(ids, s.clientID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Retained returns the retained message of the given topic
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	msg, ok := b.retained[topic]
+	return msg, ok
+}
+
+// RetainedTopics returns the topics of all retained messages in lexical order
+func (b *Broker) RetainedTopics() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	topics := []string{}
+	for topic := range b.retained {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Messages returns all messages published by clients, including wills, in the order they have been received
+func (b *Broker) Messages() []Message {
+	return b.MessagesTo("#")
+}
+
+// MessagesTo returns all messages published by clients whose topic matches the given filter
+func (b *Broker) MessagesTo(filter string) []Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	messages := []Message{}
+	for _, msg := range b.published {
+		if mqttpacket.MatchTopic(filter, msg.Topic) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// Publish delivers a message to all subscribed clients as if it had been published by a client, e.g. to
+// send commands. The message is not recorded in Messages.
+func (b *Broker) Publish(topic string, payload []byte, retain bool) {
+	b.route(Message{Topic: topic, Payload: payload, Retain: retain})
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+// serve handles the connection of a single client
+func (b *Broker) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	p, err := mqttpacket.Read(r)
+	if err != nil {
+		return
+	}
+	connect, ok := p.(mqttpacket.Connect)
+	if !ok {
+		return
+	}
+	b.mutex.Lock()
+	refused := b.username != "" && (connect.Username != b.username || connect.Password != b.password)
+	b.mutex.Unlock()
+	if refused {
+		mqttpacket.Write(conn, mqttpacket.ConnAck{ReturnCode: mqttpacket.RefusedBadUsernamePassword})
+		return
+	}
+
+	s := &session{conn: conn, clientID: connect.ClientID, subscriptions: make(map[string]byte)}
+	if connect.Will != nil {
+		s.will = &Message{Topic: connect.Will.Topic, Payload: connect.Will.Payload, QoS: connect.Will.QoS, Retain: connect.Will.Retain}
+	}
+	b.mutex.Lock()
+	b.sessions[s] = true
+	b.mutex.Unlock()
+	defer func() {
+		b.mutex.Lock()
+		delete(b.sessions, s)
+		b.mutex.Unlock()
+		if s.will != nil {
+			b.record(*s.will)
+			b.route(*s.will)
+		}
+	}()
+	if s.write(mqttpacket.ConnAck{ReturnCode: mqttpacket.Accepted}) != nil {
+		return
+	}
+
+	for {
+		p, err := mqttpacket.Read(r)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case mqttpacket.Publish:
+			if p.QoS > 0 {
+				s.write(mqttpacket.PubAck{PacketID: p.PacketID})
+			}
+			msg := Message{Topic: p.Topic, Payload: p.Payload, QoS: p.QoS, Retain: p.Retain, ClientID: s.clientID}
+			b.record(msg)
+			b.route(msg)
+		case mqttpacket.Subscribe:
+			b.subscribe(s, p)
+		case mqttpacket.Unsubscribe:
+			b.mutex.Lock()
+			for _, filter := range p.Filters {
+				delete(s.subscriptions, filter)
+			}
+			b.mutex.Unlock()
+			s.write(mqttpacket.UnsubAck{PacketID: p.PacketID})
+		case mqttpacket.PingReq:
+			s.write(mqttpacket.PingResp{})
+		case mqttpacket.Disconnect:
+			s.will = nil
+			return
+		}
+	}
+}
+
+func (b *Broker) subscribe(s *session, p mqttpacket.Subscribe) {
+	codes := []byte{}
+	retained := []Message{}
+	retainedQoS := map[string]byte{}
+	b.mutex.Lock()
+	for _, sub := range p.Subscriptions {
+		if !mqttpacket.ValidFilter(sub.Filter) {
+			codes = append(codes, mqttpacket.SubscriptionFailure)
+			continue
+		}
+		qos := sub.QoS
+		if qos > 1 {
+			qos = 1
+		}
+		s.subscriptions[sub.Filter] = qos
+		codes = append(codes, qos)
+		for topic, msg := range b.retained {
+			if mqttpacket.MatchTopic(sub.Filter, topic) {
+				retained = append(retained, msg)
+			}
+		}
+	}
+	for _, msg := range retained {
+		retainedQoS[msg.Topic], _ = s.matchingQoS(msg.Topic)
+	}
+	b.mutex.Unlock()
+	s.write(mqttpacket.SubAck{PacketID: p.PacketID, ReturnCodes: codes})
+	sort.Slice(retained, func(i, j int) bool { return retained[i].Topic < retained[j].Topic })
+	for _, msg := range retained {
+		s.deliver(msg, retainedQoS[msg.Topic], true)
+	}
+}
+
+// record adds a message published by a client to the list of messages
+func (b *Broker) record(msg Message) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.published = append(b.published, msg)
+}
+
+// route updates the retained messages and delivers the message to all subscribed clients
+func (b *Broker) route(msg Message) {
+	b.mutex.Lock()
+	if msg.Retain {
+		if len(msg.Payload) == 0 {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg
+		}
+	}
+	receivers := map[*session]byte{}
+	for s := range b.sessions {
+		if qos, ok := s.matchingQoS(msg.Topic); ok {
+			receivers[s] = qos
+		}
+	}
+	b.mutex.Unlock()
+	for s, qos := range receivers {
+		s.deliver(msg, qos, false)
+	}
+}
+
+// matchingQoS returns the maximum QoS of all subscriptions matching the topic. The broker lock has to be
+// held by the caller.
+func (s *session) matchingQoS(topic string) (byte, bool) {
+	found := false
+	max := byte(0)
+	for filter, qos := range s.subscriptions {
+		if mqttpacket.MatchTopic(filter, topic) {
+			found = true
+			if qos > max {
+				max = qos
+			}
+		}
+	}
+	return max, found
+}
+
+// deliver sends the message with the lower QoS of message and subscription. Acknowledgements of the
+// client are ignored.
+func (s *session) deliver(msg Message, subscriptionQoS byte, retain bool) {
+	p := mqttpacket.Publish{Topic: msg.Topic, Payload: msg.Payload, QoS: msg.QoS, Retain: retain}
+	if subscriptionQoS < p.QoS {
+		p.QoS = subscriptionQoS
+	}
+	s.writeMutex.Lock()
+	if p.QoS > 0 {
+		s.nextID++
+		if s.nextID == 0 {
+			s.nextID = 1
+		}
+		p.PacketID = s.nextID
+	}
+	mqttpacket.Write(s.conn, p)
+	s.writeMutex.Unlock()
+}
+
+func (s *session) write(p mqttpacket.Packet) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return mqttpacket.Write(s.conn, p)
+}
@@ -0,0 +1,301 @@
+package digitalstrom
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ChannelUnit is the physical unit of output channel values
+type ChannelUnit string
+
+// Channel Units (CU)
+const (
+	CUpercent ChannelUnit = "%"
+	CUdegree  ChannelUnit = "°"
+	CUmired   ChannelUnit = "mired"
+	CUnone    ChannelUnit = "" // dimensionless values like CIE coordinates or enumerations
+)
+
+// OutputChannelRange describes the values of an output channel type. Min and Max are the limits of the
+// physical values setOutputChannelValue expects, RawMin and RawMax are the limits of the raw values
+// getOutputValue delivers (and PollChannelValue returns).
+type OutputChannelRange struct {
+	Unit   ChannelUnit
+	Min    float64
+	Max    float64
+	RawMin int
+	RawMax int
+}
+
+var outputChannelRanges = map[OutputChannelType]OutputChannelRange{
+	OCTbrightness:               {CUpercent, 0, 100, 0, 255},
+	OCThue:                      {CUdegree, 0, 360, 0, 255},
+	OCTsaturation:               {CUpercent, 0, 100, 0, 255},
+	OCTcolortemp:                {CUmired, 100, 1000, 100, 1000},
+	OCTx:                        {CUnone, 0, 1, 0, 65535},
+	OCTy:                        {CUnone, 0, 1, 0, 65535},
+	OCTshadePositionOutside:     {CUpercent, 0, 100, 0, 65535},
+	OCTshadePositionIndoor:      {CUpercent, 0, 100, 0, 65535},
+	OCTshadeOpeningAngleOutside: {CUpercent, 0, 100, 0, 255},
+	OCTshadeOpeningAngleInside:  {CUpercent, 0, 100, 0, 255},
+	OCTtransparency:             {CUpercent, 0, 100, 0, 255},
+	OCTairFlowIntensity:         {CUpercent, 0, 100, 0, 255},
+	OCTairFlowDirection:         {CUnone, 0, 2, 0, 2}, // 0 = both, 1 = supply, 2 = exhaust
+	OCTairFlapPosition:          {CUpercent, 0, 100, 0, 255},
+	OCTairLouverPosition:        {CUpercent, 0, 100, 0, 255},
+	OCTheatingPower:             {CUpercent, 0, 100, 0, 255},
+	OCTcoolingCapacity:          {CUpercent, 0, 100, 0, 255},
+	OCTaudioVolume:              {CUpercent, 0, 100, 0, 255},
+	OCTpowerState:               {CUnone, 0, 2, 0, 2}, // 0 = off, 1 = on, 2 = forced off
+	OCTpowerLevel:               {CUpercent, 0, 100, 0, 255},
+}
+
+// GetRange returns the range of the output channel type. Returns false for unknown channel types.
+func (oct OutputChannelType) GetRange() (OutputChannelRange, bool) {
+	r, ok := outputChannelRanges[oct]
+	return r, ok
+}
+
+// ToPhysical converts a raw value to its physical value
+func (r OutputChannelRange) ToPhysical(raw int) float64 {
+	if r.RawMax == r.RawMin {
+		return r.Min
+	}
+	return r.Min + float64(raw-r.RawMin)*(r.Max-r.Min)/float64(r.RawMax-r.RawMin)
+}
+
+// ToRaw converts a physical value to the nearest raw value
+func (r OutputChannelRange) ToRaw(value float64) int {
+	if r.Max == r.Min {
+		return r.RawMin
+	}
+	return r.RawMin + int(math.Round((value-r.Min)*float64(r.RawMax-r.RawMin)/(r.Max-r.Min)))
+}
+
+// Contains returns true when the physical value is within the range
+func (r OutputChannelRange) Contains(value float64) bool {
+	return value >= r.Min && value <= r.Max
+}
+
+// MiredToKelvin converts a color temperature in mired to Kelvin
+func MiredToKelvin(mired float64) float64 {
+	return 1000000 / mired
+}
+
+// KelvinToMired converts a color temperature in Kelvin to mired
+func KelvinToMired(kelvin float64) float64 {
+	return 1000000 / kelvin
+}
+
+// PhysicalValue returns the cached value of the channel in its physical unit. Unknown channel types are
+// returned unconverted. The value is read without locking, use it on channels of a Snapshot.
+func (c *OutputChannel) PhysicalValue() (float64, ChannelUnit) {
+	r, ok := c.ChannelType.GetRange()
+	if !ok {
+		return float64(c.Value), CUnone
+	}
+	return r.ToPhysical(c.Value), r.Unit
+}
+
+// GetOutputChannelPhysicalValue returns the cached value of the output channel with the given index of
+// the device with the given display ID in its physical unit.
+func (a *Account) GetOutputChannelPhysicalValue(deviceID string, channelIndex int) (float64, ChannelUnit, error) {
+	channel, err := a.GetOutputChannel(deviceID, channelIndex)
+	if err != nil {
+		return 0, CUnone, err
+	}
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	value, unit := channel.PhysicalValue()
+	return value, unit, nil
+}
+
+// PollChannelPhysicalValue works like PollChannelValue but returns the value in the physical unit of the
+// channel, e.g. brightness in percent or hue in degrees. The raw value is cached as usual.
+func (a *Account) PollChannelPhysicalValue(channel *OutputChannel) (float64, error) {
+	return a.PollChannelPhysicalValueContext(context.Background(), channel)
+}
+
+// PollChannelPhysicalValueContext is like PollChannelPhysicalValue but aborts all performed requests when ctx is done.
+func (a *Account) PollChannelPhysicalValueContext(ctx context.Context, channel *OutputChannel) (float64, error) {
+	raw, err := a.PollChannelValueContext(ctx, channel)
+	if err != nil {
+		return 0, err
+	}
+	r, ok := channel.ChannelType.GetRange()
+	if !ok {
+		return float64(raw), nil
+	}
+	return r.ToPhysical(raw), nil
+}
+
+// SetOutputChannelPhysicalValue sets the value of the channel in its physical unit. Values outside the
+// range of the channel type are rejected. Use SetOutputChannelValue to send values unchecked.
+func (a *Account) SetOutputChannelPhysicalValue(channel *OutputChannel, value float64) error {
+	return a.SetOutputChannelPhysicalValueContext(context.Background(), channel, value)
+}
+
+// SetOutputChannelPhysicalValueContext is like SetOutputChannelPhysicalValue but aborts all performed requests when ctx is done.
+func (a *Account) SetOutputChannelPhysicalValueContext(ctx context.Context, channel *OutputChannel, value float64) error {
+	err := checkChannelValue(channel.ChannelType, value)
+	if err != nil {
+		return err
+	}
+	return a.SetOutputChannelValueContext(ctx, channel, formatFloat(value))
+}
+
+// channelValue is a physical value of a single output channel
+type channelValue struct {
+	channelType  OutputChannelType
+	channelIndex int
+	value        float64
+}
+
+// ChannelBatch collects output channel values of several devices that are applied together by Commit.
+// Create it with NewChannelBatch. A ChannelBatch is not safe for concurrent use.
+type ChannelBatch struct {
+	account *Account
+	devices []string // dSUIDs in the order the devices have been added
+	values  map[string][]channelValue
+}
+
+// SetOutputChannelValues sets several output channels of the device with a single request, e.g. hue,
+// saturation and brightness of an RGB lamp. Values are given in the physical unit of each channel.
+// When applyNow is false, the dSS buffers the values until a later request of the device is sent
+// with applyNow set to true.
+func (a *Account) SetOutputChannelValues(device *Device, values map[OutputChannelType]float64, applyNow bool) error {
+	return a.SetOutputChannelValuesContext(context.Background(), device, values, applyNow)
+}
+
+// SetOutputChannelValuesContext is like SetOutputChannelValues but aborts all performed requests when ctx is done.
+func (a *Account) SetOutputChannelValuesContext(ctx context.Context, device *Device, values map[OutputChannelType]float64, applyNow bool) error {
+	if len(values) == 0 {
+		return errors.New("no channel values given")
+	}
+	list := []channelValue{}
+	a.cacheMutex.RLock()
+	dsuid := device.UUID
+	for channelType, value := range values {
+		channel, err := device.GetOutputChannel(channelType)
+		if err != nil {
+			a.cacheMutex.RUnlock()
+			return err
+		}
+		list = append(list, channelValue{channelType: channelType, channelIndex: channel.ChannelIndex, value: value})
+	}
+	a.cacheMutex.RUnlock()
+	for _, v := range list {
+		if err := checkChannelValue(v.channelType, v.value); err != nil {
+			return err
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].channelIndex < list[j].channelIndex })
+	return a.setOutputChannelValues(ctx, dsuid, list, applyNow)
+}
+
+// NewChannelBatch returns an empty batch of output channel values.
+func (a *Account) NewChannelBatch() *ChannelBatch {
+	return &ChannelBatch{account: a, values: make(map[string][]channelValue)}
+}
+
+// Set adds the physical value of the channel to the batch. A value that has already been added for
+// the channel is replaced. No request is performed until Commit is called. Only channels of cached devices
+// could be added, as the device is required for the request.
+func (b *ChannelBatch) Set(channel *OutputChannel, value float64) error {
+	err := checkChannelValue(channel.ChannelType, value)
+	if err != nil {
+		return err
+	}
+	b.account.cacheMutex.RLock()
+	device := channel.device
+	dsuid := ""
+	if device != nil {
+		dsuid = device.UUID
+	}
+	b.account.cacheMutex.RUnlock()
+	if device == nil {
+		return errors.New("channel " + string(channel.ChannelType) + " does not belong to a cached device")
+	}
+
+	values, ok := b.values[dsuid]
+	if !ok {
+		b.devices = append(b.devices, dsuid)
+	}
+	for i := range values {
+		if values[i].channelType == channel.ChannelType {
+			values[i].value = value
+			return nil
+		}
+	}
+	b.values[dsuid] = append(values, channelValue{channelType: channel.ChannelType, channelIndex: channel.ChannelIndex, value: value})
+	return nil
+}
+
+// Len returns the number of devices that are part of the batch
+func (b *ChannelBatch) Len() int {
+	return len(b.devices)
+}
+
+// Commit transfers the values of all devices without applying them first. Afterwards, the application
+// is triggered on each device with a short request, so all devices change their outputs at nearly the
+// same time. The batch is empty afterwards, even when an error occurred.
+func (b *ChannelBatch) Commit() error {
+	return b.CommitContext(context.Background())
+}
+
+// CommitContext is like Commit but aborts all performed requests when ctx is done.
+func (b *ChannelBatch) CommitContext(ctx context.Context) error {
+	devices := b.devices
+	values := b.values
+	b.devices = nil
+	b.values = make(map[string][]channelValue)
+
+	for _, dsuid := range devices {
+		sort.Slice(values[dsuid], func(i, j int) bool { return values[dsuid][i].channelIndex < values[dsuid][j].channelIndex })
+		err := b.account.setOutputChannelValues(ctx, dsuid, values[dsuid], false)
+		if err != nil {
+			return err
+		}
+	}
+	// sending the last value again with applyNow applies all buffered values of the device
+	for _, dsuid := range devices {
+		list := values[dsuid]
+		err := b.account.setOutputChannelValues(ctx, dsuid, list[len(list)-1:], true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Account) setOutputChannelValues(ctx context.Context, dsuid string, values []channelValue, applyNow bool) error {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = string(v.channelType) + "=" + formatFloat(v.value)
+	}
+	params := map[string]string{
+		"dsuid":         dsuid,
+		"channelvalues": strings.Join(pairs, ";"),
+		"applyNow":      strconv.FormatBool(applyNow),
+	}
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+"/json/device/setOutputChannelValue", get, "", params)
+	if err != nil {
+		return err
+	}
+	if !res.OK {
+		return res.apiError()
+	}
+	return nil
+}
+
+// checkChannelValue returns an error when the value is out of the range of the channel type
+func checkChannelValue(channelType OutputChannelType, value float64) error {
+	if r, ok := channelType.GetRange(); ok && !r.Contains(value) {
+		return errors.New("value " + formatFloat(value) + " out of range [" + formatFloat(r.Min) + ", " + formatFloat(r.Max) + "] for channel " + string(channelType))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package digitalstrom_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+var channelTypes = []digitalstrom.OutputChannelType{
+	digitalstrom.OCTbrightness, digitalstrom.OCThue, digitalstrom.OCTsaturation, digitalstrom.OCTcolortemp,
+	digitalstrom.OCTx, digitalstrom.OCTy, digitalstrom.OCTshadePositionOutside, digitalstrom.OCTshadePositionIndoor,
+	digitalstrom.OCTshadeOpeningAngleOutside, digitalstrom.OCTshadeOpeningAngleInside, digitalstrom.OCTtransparency,
+	digitalstrom.OCTairFlowIntensity, digitalstrom.OCTairFlowDirection, digitalstrom.OCTairFlapPosition,
+	digitalstrom.OCTairLouverPosition, digitalstrom.OCTheatingPower, digitalstrom.OCTcoolingCapacity,
+	digitalstrom.OCTaudioVolume, digitalstrom.OCTpowerState, digitalstrom.OCTpowerLevel,
+}
+
+func TestRawValuesRoundTrip(t *testing.T) {
+	for _, channelType := range channelTypes {
+		r, ok := channelType.GetRange()
+		if !ok {
+			t.Errorf("no range for channel type %s", channelType)
+			continue
+		}
+		if r.ToPhysical(r.RawMin) != r.Min || r.ToPhysical(r.RawMax) != r.Max {
+			t.Errorf("%s: raw limits are not converted to the physical limits", channelType)
+		}
+		for raw := r.RawMin; raw <= r.RawMax; raw++ {
+			if got := r.ToRaw(r.ToPhysical(raw)); got != raw {
+				t.Errorf("%s: raw value %d converted to %v and back to %d", channelType, raw, r.ToPhysical(raw), got)
+				break
+			}
+		}
+	}
+}
+
+func TestPhysicalValuesRoundTrip(t *testing.T) {
+	for _, channelType := range channelTypes {
+		r, _ := channelType.GetRange()
+		// a raw step is the maximum error of a physical value after a round trip
+		step := (r.Max - r.Min) / float64(r.RawMax-r.RawMin)
+		for i := 0; i <= 100; i++ {
+			value := r.Min + float64(i)*(r.Max-r.Min)/100
+			raw := r.ToRaw(value)
+			if raw < r.RawMin || raw > r.RawMax {
+				t.Errorf("%s: value %v converted to raw value %d out of range", channelType, value, raw)
+			}
+			if got := r.ToPhysical(raw); math.Abs(got-value) > step/2+1e-9 {
+				t.Errorf("%s: value %v converted to %d and back to %v", channelType, value, raw, got)
+			}
+		}
+	}
+}
+
+func TestChannelRanges(t *testing.T) {
+	brightness, _ := digitalstrom.OCTbrightness.GetRange()
+	if brightness.Unit != digitalstrom.CUpercent || brightness.ToRaw(50) != 128 || brightness.ToPhysical(255) != 100 {
+		t.Errorf("unexpected brightness conversion")
+	}
+	if !brightness.Contains(0) || !brightness.Contains(100) || brightness.Contains(-0.1) || brightness.Contains(100.1) {
+		t.Errorf("unexpected brightness limits")
+	}
+	colortemp, _ := digitalstrom.OCTcolortemp.GetRange()
+	if colortemp.ToRaw(370) != 370 {
+		t.Errorf("color temperature is not delivered in mired")
+	}
+	if _, ok := digitalstrom.OutputChannelType("unknown").GetRange(); ok {
+		t.Errorf("range of an unknown channel type")
+	}
+	if digitalstrom.MiredToKelvin(250) != 4000 || digitalstrom.KelvinToMired(2500) != 400 {
+		t.Errorf("unexpected color temperature conversion")
+	}
+}
+
+func TestPhysicalChannelValues(t *testing.T) {
+	srv, a := newTestAccount(t)
+	hue, err := a.GetOutputChannel("00000002", 1)
+	if err != nil {
+		t.Fatalf("GetOutputChannel failed: %v", err)
+	}
+
+	if err := a.SetOutputChannelPhysicalValue(hue, 180); err != nil {
+		t.Fatalf("SetOutputChannelPhysicalValue failed: %v", err)
+	}
+	if got := srv.CallsTo("/json/device/setOutputChannelValue")[0].Params.Get("channelvalues"); got != "hue=180" {
+		t.Errorf("expected channelvalues hue=180, got %q", got)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		if raw := apartment.GetDevice("00000002").OutputChannels[1].Value; raw != 128 {
+			t.Errorf("expected raw value 128, got %d", raw)
+		}
+	})
+
+	value, err := a.PollChannelPhysicalValue(hue)
+	if err != nil {
+		t.Fatalf("PollChannelPhysicalValue failed: %v", err)
+	}
+	if math.Abs(value-180) > 1 {
+		t.Errorf("expected a value close to 180, got %v", value)
+	}
+	cached, unit, err := a.GetOutputChannelPhysicalValue("00000002", 1)
+	if err != nil || cached != value || unit != digitalstrom.CUdegree {
+		t.Errorf("unexpected cached value %v%s, %v", cached, unit, err)
+	}
+
+	if err := a.SetOutputChannelPhysicalValue(hue, 361); err == nil {
+		t.Errorf("expected an error for a value out of range")
+	}
+	if n := len(srv.CallsTo("/json/device/setOutputChannelValue")); n != 1 {
+		t.Errorf("value out of range has been sent")
+	}
+}
+
+func TestSetOutputChannelValues(t *testing.T) {
+	srv, a := newTestAccount(t)
+	device, _ := a.GetDevice("00000002")
+
+	values := map[digitalstrom.OutputChannelType]float64{
+		digitalstrom.OCTsaturation: 100,
+		digitalstrom.OCTbrightness: 50,
+		digitalstrom.OCThue:        22.5,
+	}
+	if err := a.SetOutputChannelValues(device, values, true); err != nil {
+		t.Fatalf("SetOutputChannelValues failed: %v", err)
+	}
+	params := srv.CallsTo("/json/device/setOutputChannelValue")[0].Params
+	// values are ordered by channel index
+	if params.Get("dsuid") != device.UUID || params.Get("channelvalues") != "brightness=50;hue=22.5;saturation=100" || params.Get("applyNow") != "true" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		channels := apartment.GetDevice("00000002").OutputChannels
+		if channels[0].Value != 128 || channels[1].Value != 16 || channels[2].Value != 255 {
+			t.Errorf("values have not been applied: %d, %d, %d", channels[0].Value, channels[1].Value, channels[2].Value)
+		}
+	})
+
+	if err := a.SetOutputChannelValues(device, map[digitalstrom.OutputChannelType]float64{digitalstrom.OCTbrightness: 10}, false); err != nil {
+		t.Fatalf("SetOutputChannelValues failed: %v", err)
+	}
+	if got := srv.CallsTo("/json/device/setOutputChannelValue")[1].Params.Get("applyNow"); got != "false" {
+		t.Errorf("expected applyNow=false, got %q", got)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		if raw := apartment.GetDevice("00000002").OutputChannels[0].Value; raw != 128 {
+			t.Errorf("value without applyNow has been applied")
+		}
+	})
+}
+
+func TestSetOutputChannelValuesRejectsInvalidValues(t *testing.T) {
+	srv, a := newTestAccount(t)
+	device, _ := a.GetDevice("00000001")
+
+	tests := map[string]map[digitalstrom.OutputChannelType]float64{
+		"no values":          {},
+		"unknown channel":    {digitalstrom.OCThue: 10},
+		"value out of range": {digitalstrom.OCTbrightness: 101},
+	}
+	for name, values := range tests {
+		if err := a.SetOutputChannelValues(device, values, true); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if n := len(srv.CallsTo("/json/device/setOutputChannelValue")); n != 0 {
+		t.Errorf("invalid values have been sent")
+	}
+}
+
+func TestChannelBatch(t *testing.T) {
+	srv, a := newTestAccount(t)
+	lamp, _ := a.GetOutputChannel("00000001", 0)
+	rgbBrightness, _ := a.GetOutputChannel("00000002", 0)
+	rgbHue, _ := a.GetOutputChannel("00000002", 1)
+
+	batch := a.NewChannelBatch()
+	for _, set := range []struct {
+		channel *digitalstrom.OutputChannel
+		value   float64
+	}{{rgbHue, 90}, {lamp, 20}, {rgbBrightness, 30}, {lamp, 40}} {
+		if err := batch.Set(set.channel, set.value); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if batch.Len() != 2 {
+		t.Errorf("expected 2 devices, got %d", batch.Len())
+	}
+	if err := batch.Set(&digitalstrom.OutputChannel{ChannelType: digitalstrom.OCTbrightness}, 10); err == nil {
+		t.Errorf("expected an error for a channel without device")
+	}
+	if err := batch.Set(lamp, -1); err == nil {
+		t.Errorf("expected an error for a value out of range")
+	}
+
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if batch.Len() != 0 {
+		t.Errorf("batch is not empty after commit")
+	}
+	calls := srv.CallsTo("/json/device/setOutputChannelValue")
+	expected := []struct{ dsuid, channelValues, applyNow string }{
+		{"302ed89f43f0000000000000000000002", "brightness=30;hue=90", "false"},
+		{"302ed89f43f0000000000000000000001", "brightness=40", "false"},
+		{"302ed89f43f0000000000000000000002", "hue=90", "true"},
+		{"302ed89f43f0000000000000000000001", "brightness=40", "true"},
+	}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected %d requests, got %d", len(expected), len(calls))
+	}
+	for i, e := range expected {
+		params := calls[i].Params
+		if params.Get("dsuid") != e.dsuid || params.Get("channelvalues") != e.channelValues || params.Get("applyNow") != e.applyNow {
+			t.Errorf("request %d: unexpected parameters %v", i, params)
+		}
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		rgb := apartment.GetDevice("00000002").OutputChannels
+		if apartment.GetDevice("00000001").OutputChannels[0].Value != 102 || rgb[0].Value != 77 || rgb[1].Value != 64 {
+			t.Errorf("batch values have not been applied")
+		}
+	})
+}
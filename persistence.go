@@ -0,0 +1,243 @@
+package digitalstrom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// cacheFileVersion is the version of the file format written by Save. Files of other versions are
+// rejected by Load.
+const cacheFileVersion = 1
+
+// cacheFile is the content of a file written by Save. Tokens and credentials are never stored.
+type cacheFile struct {
+	Version            int                       `json:"version"`
+	Saved              time.Time                 `json:"saved"`
+	Structure          Structure                 `json:"structure"`
+	Circuits           []Circuit                 `json:"circuits"`
+	TemperatureControl []TemperatureControlState `json:"temperatureControl"`
+	States             []ApartmentState          `json:"states,omitempty"`
+	ValueTimes         map[string]time.Time      `json:"valueTimes"`
+}
+
+// Save writes the cached structure, circuits, temperature control states, apartment states and the
+// last known values including their update times as versioned JSON to w.
+func (a *Account) Save(w io.Writer) error {
+	a.cacheMutex.RLock()
+	file := cacheFile{
+		Version:            cacheFileVersion,
+		Saved:              time.Now(),
+		Structure:          a.Structure,
+		Circuits:           []Circuit{},
+		TemperatureControl: []TemperatureControlState{},
+		States:             []ApartmentState{},
+		ValueTimes:         a.valueTimes,
+	}
+	for _, circuit := range a.Circuits {
+		file.Circuits = append(file.Circuits, *circuit)
+	}
+	for _, state := range a.TemperatureControl {
+		file.TemperatureControl = append(file.TemperatureControl, *state)
+	}
+	for _, state := range a.States {
+		file.States = append(file.States, *state)
+	}
+	sort.Slice(file.Circuits, func(i, j int) bool { return file.Circuits[i].DisplayID < file.Circuits[j].DisplayID })
+	sort.Slice(file.TemperatureControl, func(i, j int) bool {
+		return file.TemperatureControl[i].ZoneId < file.TemperatureControl[j].ZoneId
+	})
+	sort.Slice(file.States, func(i, j int) bool { return file.States[i].Name < file.States[j].Name })
+	data, err := json.Marshal(file)
+	a.cacheMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// SaveFile saves the cache to the file with the given path. The file is replaced atomically, so a
+// crash while saving never leaves a corrupt file behind.
+func (a *Account) SaveFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = a.Save(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load replaces the cache by the content written by Save. No request is performed and no events will
+// be dispatched. The cache is marked as stale until Reconcile has been performed successfully.
+func (a *Account) Load(r io.Reader) error {
+	file := cacheFile{}
+	err := json.NewDecoder(r).Decode(&file)
+	if err != nil {
+		return err
+	}
+	if file.Version != cacheFileVersion {
+		return fmt.Errorf("unsupported cache file version %d", file.Version)
+	}
+	if file.ValueTimes == nil {
+		file.ValueTimes = make(map[string]time.Time)
+	}
+
+	a.cacheMutex.Lock()
+	defer a.cacheMutex.Unlock()
+	a.setStructure(file.Structure)
+	for id := range a.Circuits {
+		delete(a.Circuits, id)
+	}
+	for i := range file.Circuits {
+		a.Circuits[file.Circuits[i].DisplayID] = &file.Circuits[i]
+	}
+	for id := range a.TemperatureControl {
+		delete(a.TemperatureControl, id)
+	}
+	for i := range file.TemperatureControl {
+		a.TemperatureControl[file.TemperatureControl[i].ZoneId] = &file.TemperatureControl[i]
+	}
+	a.assignTempControlStatesToZones()
+	for name := range a.States {
+		delete(a.States, name)
+	}
+	for i := range file.States {
+		a.States[file.States[i].Name] = &file.States[i]
+	}
+	a.valueTimes = file.ValueTimes
+	a.staleBefore = time.Now()
+	return nil
+}
+
+// LoadFile loads the cache from the file with the given path.
+func (a *Account) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return a.Load(f)
+}
+
+// IsStale returns true when the cache has been loaded from a file and has not been reconciled yet.
+// Stale values might be outdated, but are the last known values of the dSS.
+func (a *Account) IsStale() bool {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	return !a.staleBefore.IsZero()
+}
+
+// WarmStart loads the cache from the file with the given path and reconciles it with the dSS in the
+// background, so the cached values are available immediately. A CacheReconciledEvent is published when
+// the reconciliation has been finished. When the file could not be loaded, a regular Init is performed.
+func (a *Account) WarmStart(path string) error {
+	return a.WarmStartContext(context.Background(), path)
+}
+
+// WarmStartContext is like WarmStart but aborts all performed requests when ctx is done.
+func (a *Account) WarmStartContext(ctx context.Context, path string) error {
+	err := a.LoadFile(path)
+	if err != nil {
+		logger.Error(err, "unable to load cache file, performing regular initialization")
+		return a.InitContext(ctx)
+	}
+	logger.Info("cache loaded, reconciling in background")
+	go func() {
+		err := a.ReconcileContext(ctx)
+		if err != nil {
+			logger.Error(err, "reconciliation of the loaded cache failed")
+		}
+	}()
+	return nil
+}
+
+// Reconcile initializes the account like Init. Sensor values and binary input states of the dSS replace
+// the loaded ones and change events are dispatched for the ones that differ. Output channel and circuit
+// values, which are not part of the initial requests, keep their loaded values and are polled when they
+// have not been updated since the cache has been loaded. Values that could not be polled keep their old
+// value and update time. A CacheReconciledEvent is published in any case.
+func (a *Account) Reconcile() error {
+	return a.ReconcileContext(context.Background())
+}
+
+// ReconcileContext is like Reconcile but aborts all performed requests when ctx is done.
+func (a *Account) ReconcileContext(ctx context.Context) error {
+	err := a.reconcile(ctx)
+	a.eventBus.publish(CacheReconciledEvent{Err: err})
+	return err
+}
+
+func (a *Account) reconcile(ctx context.Context) error {
+	err := a.InitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.cacheMutex.RLock()
+	staleBefore := a.staleBefore
+	channels := []*OutputChannel{}
+	for id, device := range a.Devices {
+		if !device.IsPresent {
+			continue
+		}
+		for _, channel := range device.OutputChannels {
+			if a.isValueStale("channel•"+id+"•"+strconv.Itoa(channel.ChannelIndex), staleBefore) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	circuits := []*Circuit{}
+	for id, circuit := range a.Circuits {
+		if circuit.HasMetering && circuit.IsPresent && a.isValueStale("circuit•"+id, staleBefore) {
+			circuits = append(circuits, circuit)
+		}
+	}
+	a.cacheMutex.RUnlock()
+
+	for _, channel := range channels {
+		_, err := a.PollChannelValueContext(ctx, channel)
+		if err != nil {
+			logger.Error(err, "unable to reconcile output channel value")
+		}
+	}
+	for _, circuit := range circuits {
+		_, err := a.PollCircuitConsumptionValueContext(ctx, circuit)
+		if err == nil {
+			_, err = a.PollCircuitMeterValueContext(ctx, circuit)
+		}
+		if err != nil {
+			logger.Error(err, "unable to reconcile circuit values of "+circuit.DisplayID)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	a.cacheMutex.Lock()
+	a.staleBefore = time.Time{}
+	a.cacheMutex.Unlock()
+	return nil
+}
+
+// isValueStale returns true when the value with the given id has not been updated since the given time.
+// The cache lock has to be held by the caller.
+func (a *Account) isValueStale(id string, staleBefore time.Time) bool {
+	t, ok := a.valueTimes[id]
+	return !ok || !t.After(staleBefore)
+}
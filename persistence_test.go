@@ -0,0 +1,199 @@
+package digitalstrom_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// newTestServerAccount returns an account that is using the given server but has not been initialized
+func newTestServerAccount(srv *dsstest.Server) *digitalstrom.Account {
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetApplicationToken(dsstest.ApplicationToken)
+	return a
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	srv, a := newTestAccount(t)
+	circuit := a.Circuits["0000c001"]
+	if _, err := a.PollCircuitMeterValue(circuit); err != nil {
+		t.Fatalf("PollCircuitMeterValue failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := a.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "token") {
+		t.Errorf("tokens have been saved: %s", buf.String())
+	}
+
+	calls := len(srv.Calls())
+	loaded := newTestServerAccount(srv)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(srv.Calls()) != calls {
+		t.Errorf("Load performed requests")
+	}
+	if !loaded.IsStale() {
+		t.Errorf("loaded cache is not stale")
+	}
+	if len(loaded.Devices) != 3 || len(loaded.Zones) != 2 {
+		t.Errorf("expected 3 devices and 2 zones, got %d and %d", len(loaded.Devices), len(loaded.Zones))
+	}
+	if loaded.Circuits["0000c001"].MeterValue != 5400000 {
+		t.Errorf("meter value has not been loaded")
+	}
+	if state, ok := loaded.TemperatureControl[2]; !ok || loaded.Zones[2].TemperatureControl != state {
+		t.Errorf("temperature control state is not assigned to its zone")
+	}
+	sensor, err := loaded.GetSensor("00000003", 0)
+	if err != nil || sensor.Value != 21.5 {
+		t.Errorf("sensor value has not been loaded: %v, %v", sensor, err)
+	}
+}
+
+func TestLoadRejectsOtherVersions(t *testing.T) {
+	a := digitalstrom.NewAccount()
+	err := a.Load(strings.NewReader(`{"version": 2}`))
+	if err == nil {
+		t.Errorf("expected an error for an unsupported version")
+	}
+	if a.IsStale() {
+		t.Errorf("cache is stale after a failed load")
+	}
+}
+
+func TestReconcilePollsStaleValues(t *testing.T) {
+	srv, a := newTestAccount(t)
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := a.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+
+	loaded := newTestServerAccount(srv)
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	events := make(chan digitalstrom.Event, 10)
+	cancel := loaded.SubscribeChannel(digitalstrom.SubscriptionSetup{
+		Filter: digitalstrom.EventFilter{Kinds: []digitalstrom.EventKind{digitalstrom.EKcacheReconciled}},
+	}, events)
+	defer cancel()
+
+	meterPolls := len(srv.CallsTo("/json/circuit/getEnergyMeterValue"))
+	if err := loaded.Reconcile(); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if loaded.IsStale() {
+		t.Errorf("cache is still stale after reconciliation")
+	}
+	if len(srv.CallsTo("/json/circuit/getEnergyMeterValue")) != meterPolls+1 {
+		t.Errorf("stale circuit values have not been polled")
+	}
+	if len(srv.CallsTo("/json/device/getOutputValue")) == 0 {
+		t.Errorf("stale output channel values have not been polled")
+	}
+	event, ok := receiveEvent(t, events).(digitalstrom.CacheReconciledEvent)
+	if !ok || event.Err != nil {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestWarmStartWithoutFile(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	a := newTestServerAccount(srv)
+
+	if err := a.WarmStart(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("WarmStart failed: %v", err)
+	}
+	if a.IsStale() || len(a.Devices) != 3 {
+		t.Errorf("regular initialization has not been performed")
+	}
+}
+
+func TestReconcilePrefersValuesOfTheDSS(t *testing.T) {
+	srv, a := newTestAccount(t)
+	var buf bytes.Buffer
+	if err := a.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		device := apartment.GetDevice("00000003")
+		device.Sensors[0].Value = 30
+		device.BinaryInputs[0].State = 2
+	})
+
+	loaded := newTestServerAccount(srv)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	events := make(chan digitalstrom.Event, 10)
+	cancel := loaded.SubscribeChannel(digitalstrom.SubscriptionSetup{
+		Filter: digitalstrom.EventFilter{Kinds: []digitalstrom.EventKind{digitalstrom.EKsensorValueChanged, digitalstrom.EKbinaryInputStateChanged}},
+	}, events)
+	defer cancel()
+	if err := loaded.Reconcile(); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if sensor, _ := loaded.GetSensor("00000003", 0); sensor.Value != 30 {
+		t.Errorf("loaded sensor value has not been replaced: %v", sensor.Value)
+	}
+	if sensor, _ := loaded.GetSensor("00000003", 1); sensor.Value != 45 {
+		t.Errorf("unexpected sensor value %v", sensor.Value)
+	}
+	if state := loaded.Devices["00000003"].BinaryInputs[0].State; state != 2 {
+		t.Errorf("loaded binary input state has not been replaced: %v", state)
+	}
+	sensorEvent, ok := receiveEvent(t, events).(digitalstrom.SensorValueChangeEvent)
+	if !ok || sensorEvent.DeviceId != "00000003" || sensorEvent.ZoneId != 2 || sensorEvent.SensorIndex != 0 ||
+		sensorEvent.OldValue != 21.5 || sensorEvent.NewValue != 30 {
+		t.Errorf("unexpected event %+v", sensorEvent)
+	}
+	inputEvent, ok := receiveEvent(t, events).(digitalstrom.BinaryInputStateChangeEvent)
+	if !ok || inputEvent.DeviceId != "00000003" || inputEvent.InputId != 0 || inputEvent.OldValue != 1 || inputEvent.NewValue != 2 {
+		t.Errorf("unexpected event %+v", inputEvent)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestReconcileKeepsChannelValuesThatCouldNotBePolled(t *testing.T) {
+	srv, a := newTestAccount(t)
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000001").OutputChannels[0].Value = 80
+	})
+	channel, _ := a.GetOutputChannel("00000001", 0)
+	if _, err := a.PollChannelValue(channel); err != nil {
+		t.Fatalf("PollChannelValue failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := a.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded := newTestServerAccount(srv)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	srv.SetFailure("/json/device/getOutputValue", "not available")
+	if err := loaded.Reconcile(); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(srv.CallsTo("/json/device/getOutputValue")) < 2 {
+		t.Errorf("stale output channel values have not been polled")
+	}
+	if channel, _ := loaded.GetOutputChannel("00000001", 0); channel.Value != 80 {
+		t.Errorf("loaded output channel value has not been kept: %d", channel.Value)
+	}
+}
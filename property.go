@@ -0,0 +1,275 @@
+package digitalstrom
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// PropertyType is the type of a node of the dSS property tree
+type PropertyType string
+
+// Property Types (PT)
+const (
+	PTnone     PropertyType = "none" // node without value, e.g. a folder
+	PTstring   PropertyType = "string"
+	PTinteger  PropertyType = "integer"
+	PTboolean  PropertyType = "boolean"
+	PTfloating PropertyType = "floating"
+)
+
+// PropertyNode is a child node of the property tree as returned by RequestPropertyChildren
+type PropertyNode struct {
+	Name string
+	Type PropertyType
+}
+
+// PropertyQueryNode is a node of the result of a property query. Values contains the requested
+// properties of the node, Children contains the matched child nodes by name. Nodes matched by a
+// wildcard are collected in a list under the name of their parent node.
+type PropertyQueryNode struct {
+	Values   map[string]interface{}
+	Children map[string][]*PropertyQueryNode
+}
+
+// RequestPropertyString requests the value of the string property with the given path.
+func (a *Account) RequestPropertyString(path string) (string, error) {
+	return a.RequestPropertyStringContext(context.Background(), path)
+}
+
+// RequestPropertyStringContext is like RequestPropertyString but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyStringContext(ctx context.Context, path string) (string, error) {
+	res, err := a.requestProperty(ctx, "/json/property/getString", path, map[string]string{})
+	if err != nil {
+		return "", err
+	}
+	value, ok := res.Result["value"].(string)
+	if !ok {
+		return "", res.invalid("value", errors.New("no string value found for property "+path))
+	}
+	return value, nil
+}
+
+// RequestPropertyInteger requests the value of the integer property with the given path.
+func (a *Account) RequestPropertyInteger(path string) (int, error) {
+	return a.RequestPropertyIntegerContext(context.Background(), path)
+}
+
+// RequestPropertyIntegerContext is like RequestPropertyInteger but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyIntegerContext(ctx context.Context, path string) (int, error) {
+	res, err := a.requestProperty(ctx, "/json/property/getInteger", path, map[string]string{})
+	if err != nil {
+		return 0, err
+	}
+	value, ok := jsonInt(res.Result, "value")
+	if !ok {
+		return 0, res.invalid("value", errors.New("no integer value found for property "+path))
+	}
+	return value, nil
+}
+
+// RequestPropertyBoolean requests the value of the boolean property with the given path.
+func (a *Account) RequestPropertyBoolean(path string) (bool, error) {
+	return a.RequestPropertyBooleanContext(context.Background(), path)
+}
+
+// RequestPropertyBooleanContext is like RequestPropertyBoolean but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyBooleanContext(ctx context.Context, path string) (bool, error) {
+	res, err := a.requestProperty(ctx, "/json/property/getBoolean", path, map[string]string{})
+	if err != nil {
+		return false, err
+	}
+	switch value := res.Result["value"].(type) {
+	case bool:
+		return value, nil
+	case string:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b, nil
+		}
+	}
+	return false, res.invalid("value", errors.New("no boolean value found for property "+path))
+}
+
+// RequestPropertyFloating requests the value of the floating point property with the given path.
+func (a *Account) RequestPropertyFloating(path string) (float64, error) {
+	return a.RequestPropertyFloatingContext(context.Background(), path)
+}
+
+// RequestPropertyFloatingContext is like RequestPropertyFloating but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyFloatingContext(ctx context.Context, path string) (float64, error) {
+	res, err := a.requestProperty(ctx, "/json/property/getFloating", path, map[string]string{})
+	if err != nil {
+		return 0, err
+	}
+	value, ok := jsonFloat(res.Result, "value")
+	if !ok {
+		return 0, res.invalid("value", errors.New("no floating value found for property "+path))
+	}
+	return value, nil
+}
+
+// SetPropertyString sets the value of the string property with the given path.
+func (a *Account) SetPropertyString(path string, value string) error {
+	return a.SetPropertyStringContext(context.Background(), path, value)
+}
+
+// SetPropertyStringContext is like SetPropertyString but aborts all performed requests when ctx is done.
+func (a *Account) SetPropertyStringContext(ctx context.Context, path string, value string) error {
+	_, err := a.requestProperty(ctx, "/json/property/setString", path, map[string]string{"value": value})
+	return err
+}
+
+// SetPropertyInteger sets the value of the integer property with the given path.
+func (a *Account) SetPropertyInteger(path string, value int) error {
+	return a.SetPropertyIntegerContext(context.Background(), path, value)
+}
+
+// SetPropertyIntegerContext is like SetPropertyInteger but aborts all performed requests when ctx is done.
+func (a *Account) SetPropertyIntegerContext(ctx context.Context, path string, value int) error {
+	_, err := a.requestProperty(ctx, "/json/property/setInteger", path, map[string]string{"value": strconv.Itoa(value)})
+	return err
+}
+
+// SetPropertyBoolean sets the value of the boolean property with the given path.
+func (a *Account) SetPropertyBoolean(path string, value bool) error {
+	return a.SetPropertyBooleanContext(context.Background(), path, value)
+}
+
+// SetPropertyBooleanContext is like SetPropertyBoolean but aborts all performed requests when ctx is done.
+func (a *Account) SetPropertyBooleanContext(ctx context.Context, path string, value bool) error {
+	_, err := a.requestProperty(ctx, "/json/property/setBoolean", path, map[string]string{"value": strconv.FormatBool(value)})
+	return err
+}
+
+// SetPropertyFloating sets the value of the floating point property with the given path.
+func (a *Account) SetPropertyFloating(path string, value float64) error {
+	return a.SetPropertyFloatingContext(context.Background(), path, value)
+}
+
+// SetPropertyFloatingContext is like SetPropertyFloating but aborts all performed requests when ctx is done.
+func (a *Account) SetPropertyFloatingContext(ctx context.Context, path string, value float64) error {
+	_, err := a.requestProperty(ctx, "/json/property/setFloating", path, map[string]string{"value": formatFloat(value)})
+	return err
+}
+
+// RequestPropertyChildren requests the names and types of the child nodes of the node with the given path.
+func (a *Account) RequestPropertyChildren(path string) ([]PropertyNode, error) {
+	return a.RequestPropertyChildrenContext(context.Background(), path)
+}
+
+// RequestPropertyChildrenContext is like RequestPropertyChildren but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyChildrenContext(ctx context.Context, path string) ([]PropertyNode, error) {
+	res, err := a.requestProperty(ctx, "/json/property/getChildren", path, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	children := []PropertyNode{}
+	for i := range res.ResultList {
+		entry, ok := res.ResultList[i].(map[string]interface{})
+		if !ok {
+			return nil, res.invalid("result", errors.New("child "+strconv.Itoa(i)+" of property "+path+" is not an object"))
+		}
+		child := PropertyNode{}
+		child.Name, _ = entry["name"].(string)
+		t, _ := entry["type"].(string)
+		child.Type = PropertyType(t)
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+// RequestPropertyType requests the type of the node with the given path.
+func (a *Account) RequestPropertyType(path string) (PropertyType, error) {
+	return a.RequestPropertyTypeContext(context.Background(), path)
+}
+
+// RequestPropertyTypeContext is like RequestPropertyType but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyTypeContext(ctx context.Context, path string) (PropertyType, error) {
+	res, err := a.requestProperty(ctx, "/json/property/getType", path, map[string]string{})
+	if err != nil {
+		return "", err
+	}
+	t, ok := res.Result["type"].(string)
+	if !ok {
+		return "", res.invalid("type", errors.New("no type found for property "+path))
+	}
+	return PropertyType(t), nil
+}
+
+// RequestPropertyQuery performs a query of the property query language, e.g.
+// "/apartment/zones/*(ZoneID,name)/groups/*(group,name)", and returns the root node of the result.
+func (a *Account) RequestPropertyQuery(query string) (*PropertyQueryNode, error) {
+	return a.RequestPropertyQueryContext(context.Background(), query)
+}
+
+// RequestPropertyQueryContext is like RequestPropertyQuery but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyQueryContext(ctx context.Context, query string) (*PropertyQueryNode, error) {
+	return a.requestPropertyQuery(ctx, "/json/property/query", query)
+}
+
+// RequestPropertyQuery2 works like RequestPropertyQuery but uses the second version of the query request.
+// Nodes matched by a wildcard are not collected in lists, they are children of their parent's result
+// node under their own name.
+func (a *Account) RequestPropertyQuery2(query string) (*PropertyQueryNode, error) {
+	return a.RequestPropertyQuery2Context(context.Background(), query)
+}
+
+// RequestPropertyQuery2Context is like RequestPropertyQuery2 but aborts all performed requests when ctx is done.
+func (a *Account) RequestPropertyQuery2Context(ctx context.Context, query string) (*PropertyQueryNode, error) {
+	return a.requestPropertyQuery(ctx, "/json/property/query2", query)
+}
+
+func (a *Account) requestPropertyQuery(ctx context.Context, url string, query string) (*PropertyQueryNode, error) {
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+url, get, "", map[string]string{"query": query})
+	if err != nil {
+		return nil, err
+	}
+	if !res.OK {
+		return nil, res.apiError()
+	}
+	return newPropertyQueryNode(res.Result), nil
+}
+
+func (a *Account) requestProperty(ctx context.Context, url string, path string, params map[string]string) (*RequestResult, error) {
+	params["path"] = path
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+url, get, "", params)
+	if err != nil {
+		return nil, err
+	}
+	if !res.OK {
+		return nil, res.apiError()
+	}
+	return res, nil
+}
+
+// newPropertyQueryNode converts an object of a query result. Objects and lists of objects are
+// children, all other fields are values.
+func newPropertyQueryNode(object map[string]interface{}) *PropertyQueryNode {
+	node := &PropertyQueryNode{
+		Values:   make(map[string]interface{}),
+		Children: make(map[string][]*PropertyQueryNode),
+	}
+	for key, value := range object {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			node.Children[key] = append(node.Children[key], newPropertyQueryNode(v))
+		case []interface{}:
+			isList := len(v) > 0
+			for i := range v {
+				if _, ok := v[i].(map[string]interface{}); !ok {
+					isList = false
+				}
+			}
+			if !isList {
+				node.Values[key] = v
+				continue
+			}
+			for i := range v {
+				node.Children[key] = append(node.Children[key], newPropertyQueryNode(v[i].(map[string]interface{})))
+			}
+		default:
+			node.Values[key] = v
+		}
+	}
+	return node
+}
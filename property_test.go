@@ -0,0 +1,114 @@
+package digitalstrom_test
+
+import (
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+func TestRequestProperties(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	if value, err := a.RequestPropertyString("/system/host/hostname"); err != nil || value != "dsstest" {
+		t.Errorf("expected hostname dsstest, got %q, %v", value, err)
+	}
+	if value, err := a.RequestPropertyInteger("/apartment/zones/zone2/ZoneID"); err != nil || value != 2 {
+		t.Errorf("expected zone id 2, got %d, %v", value, err)
+	}
+	if value, err := a.RequestPropertyBoolean("/config/subsystems/Metering/enabled"); err != nil || !value {
+		t.Errorf("expected true, got %v, %v", value, err)
+	}
+	if value, err := a.RequestPropertyFloating("/config/geodata/latitude"); err != nil || value != 50.7753 {
+		t.Errorf("expected latitude 50.7753, got %v, %v", value, err)
+	}
+	if _, err := a.RequestPropertyInteger("/system/host/hostname"); err == nil {
+		t.Errorf("expected an error for a property of another type")
+	}
+	if _, err := a.RequestPropertyString("/unknown"); err == nil {
+		t.Errorf("expected an error for an unknown property")
+	}
+}
+
+func TestSetProperties(t *testing.T) {
+	srv, a := newTestAccount(t)
+
+	if err := a.SetPropertyString("/system/host/hostname", "dss"); err != nil {
+		t.Fatalf("SetPropertyString failed: %v", err)
+	}
+	if err := a.SetPropertyInteger("/config/test/count", 3); err != nil {
+		t.Fatalf("SetPropertyInteger failed: %v", err)
+	}
+	if err := a.SetPropertyBoolean("/config/subsystems/Metering/enabled", false); err != nil {
+		t.Fatalf("SetPropertyBoolean failed: %v", err)
+	}
+	if err := a.SetPropertyFloating("/config/geodata/latitude", 51.25); err != nil {
+		t.Fatalf("SetPropertyFloating failed: %v", err)
+	}
+	params := srv.CallsTo("/json/property/setFloating")[0].Params
+	if params.Get("path") != "/config/geodata/latitude" || params.Get("value") != "51.25" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		expected := map[string]interface{}{
+			"/system/host/hostname":               "dss",
+			"/config/test/count":                  3,
+			"/config/subsystems/Metering/enabled": false,
+			"/config/geodata/latitude":            51.25,
+		}
+		for path, value := range expected {
+			if apartment.Properties[path] != value {
+				t.Errorf("expected %v for %s, got %v", value, path, apartment.Properties[path])
+			}
+		}
+	})
+}
+
+func TestRequestPropertyChildrenAndType(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	children, err := a.RequestPropertyChildren("/config")
+	if err != nil {
+		t.Fatalf("RequestPropertyChildren failed: %v", err)
+	}
+	expected := []digitalstrom.PropertyNode{{Name: "geodata", Type: digitalstrom.PTnone}, {Name: "subsystems", Type: digitalstrom.PTnone}}
+	if len(children) != len(expected) || children[0] != expected[0] || children[1] != expected[1] {
+		t.Errorf("expected children %v, got %v", expected, children)
+	}
+	children, err = a.RequestPropertyChildren("/config/geodata/latitude")
+	if err != nil || len(children) != 0 {
+		t.Errorf("expected no children of a value, got %v, %v", children, err)
+	}
+
+	if typ, err := a.RequestPropertyType("/config/geodata/latitude"); err != nil || typ != digitalstrom.PTfloating {
+		t.Errorf("expected type floating, got %q, %v", typ, err)
+	}
+	if typ, err := a.RequestPropertyType("/apartment"); err != nil || typ != digitalstrom.PTnone {
+		t.Errorf("expected type none, got %q, %v", typ, err)
+	}
+}
+
+func TestRequestPropertyQuery(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	root, err := a.RequestPropertyQuery("/apartment/zones/*(ZoneID,name)")
+	if err != nil {
+		t.Fatalf("RequestPropertyQuery failed: %v", err)
+	}
+	zones := root.Children["zones"]
+	if len(zones) != 2 {
+		t.Fatalf("expected a list of 2 zones, got %+v", root)
+	}
+	if zones[0].Values["ZoneID"] != float64(1) || zones[1].Values["name"] != "Kitchen" {
+		t.Errorf("unexpected zones %+v, %+v", zones[0], zones[1])
+	}
+
+	root, err = a.RequestPropertyQuery2("/apartment/zones/*(name)")
+	if err != nil {
+		t.Fatalf("RequestPropertyQuery2 failed: %v", err)
+	}
+	zone2 := root.Children["zone2"]
+	if len(zone2) != 1 || zone2[0].Values["name"] != "Kitchen" || zone2[0].Values["ZoneID"] != nil {
+		t.Errorf("unexpected result %+v", root)
+	}
+}
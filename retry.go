@@ -0,0 +1,260 @@
+package digitalstrom
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy configures how often and when failed requests are repeated. Network errors, 5xx
+// responses and requests the dSS rejected as busy are retried with an exponential backoff. Requests
+// that change the state of the dSS (everything except get*, query*, version, login* and (un)subscribe
+// requests) are only repeated when they could not have been processed, i.e. the connection could not
+// be established or the dSS answered 503 or busy, so e.g. setOutputChannelValue is never duplicated.
+type RetryPolicy struct {
+	MaxAttempts    int           // attempts including the first one, values lower than 2 disable retries
+	InitialBackoff time.Duration // wait time before the first retry
+	MaxBackoff     time.Duration // upper limit of the wait time
+	Multiplier     float64       // factor the wait time grows with each retry
+	Jitter         float64       // random deviation of the wait time, 0.2 means ±20%
+	// RetryNonIdempotent treats all requests as idempotent
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy is used by new accounts
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// idempotentRequests are the prefixes of the last path element of requests that could be repeated
+// without side effects
+var idempotentRequests = []string{"get", "query", "version", "login", "subscribe", "unsubscribe"}
+
+// CircuitState is the state of the circuit breaker of a Connection
+type CircuitState string
+
+// Circuit States (CS)
+const (
+	CSclosed   CircuitState = "closed"   // dSS reachable, requests are performed
+	CSopen     CircuitState = "open"     // dSS unreachable, requests fail immediately
+	CShalfOpen CircuitState = "halfOpen" // a single request is performed to check whether the dSS is reachable again
+)
+
+// ErrCircuitOpen is returned for requests that are not performed because the dSS is unreachable
+var ErrCircuitOpen = errors.New("dSS unreachable - circuit breaker is open")
+
+// CircuitBreakerSetup configures the circuit breaker of a Connection. After FailureThreshold
+// consecutive requests failed because the dSS was unreachable, all requests fail with ErrCircuitOpen
+// and polling is paused. After OpenDuration, a single request is performed as probe. When it succeeds,
+// the circuit is closed again, otherwise it stays open for another OpenDuration. A FailureThreshold
+// lower than 1 disables the circuit breaker.
+type CircuitBreakerSetup struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+// DefaultCircuitBreakerSetup is used by new accounts
+var DefaultCircuitBreakerSetup = CircuitBreakerSetup{
+	FailureThreshold: 5,
+	OpenDuration:     30 * time.Second,
+}
+
+type circuitBreaker struct {
+	setup    CircuitBreakerSetup
+	failures int
+	openedAt time.Time // zero while the circuit is closed
+	probing  bool
+	onChange func(state CircuitState, err error)
+	mutex    sync.Mutex
+}
+
+// request outcomes as seen by the circuit breaker
+const (
+	outcomeNeutral = iota // aborted by the caller
+	outcomeSuccess        // the dSS answered
+	outcomeFailure        // the dSS was unreachable
+)
+
+// SetCircuitBreaker replaces the circuit breaker of the connection. The new circuit breaker starts closed.
+// It could be called while requests are performed, running requests are still recorded by the former one.
+func (c *Connection) SetCircuitBreaker(setup CircuitBreakerSetup) {
+	var onChange func(CircuitState, error)
+	if old := c.breaker.Load(); old != nil {
+		onChange = old.onChange
+	}
+	c.breaker.Store(&circuitBreaker{setup: setup, onChange: onChange})
+}
+
+// CircuitState returns the current state of the circuit breaker. CSclosed is returned when no
+// circuit breaker is used.
+func (c *Connection) CircuitState() CircuitState {
+	breaker := c.breaker.Load()
+	if breaker == nil {
+		return CSclosed
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return breaker.state()
+}
+
+// state returns the current state. The lock of the breaker has to be held by the caller.
+func (b *circuitBreaker) state() CircuitState {
+	if b.openedAt.IsZero() {
+		return CSclosed
+	}
+	if time.Since(b.openedAt) >= b.setup.OpenDuration {
+		return CShalfOpen
+	}
+	return CSopen
+}
+
+// allow returns ErrCircuitOpen when a request must not be performed. In half open state, only a single
+// probe request is allowed until its outcome has been recorded.
+func (b *circuitBreaker) allow() error {
+	if b == nil || b.setup.FailureThreshold < 1 {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state() {
+	case CSclosed:
+		return nil
+	case CShalfOpen:
+		if !b.probing {
+			b.probing = true
+			return nil
+		}
+	}
+	return ErrCircuitOpen
+}
+
+// record records the outcome of an allowed request and notifies about opening and closing the circuit.
+func (b *circuitBreaker) record(outcome int, err error) {
+	if b == nil || b.setup.FailureThreshold < 1 {
+		return
+	}
+	b.mutex.Lock()
+	wasOpen := !b.openedAt.IsZero()
+	b.probing = false
+	switch outcome {
+	case outcomeSuccess:
+		b.failures = 0
+		b.openedAt = time.Time{}
+	case outcomeFailure:
+		b.failures++
+		if wasOpen || b.failures >= b.setup.FailureThreshold {
+			b.openedAt = time.Now()
+		}
+	}
+	isOpen := !b.openedAt.IsZero()
+	onChange := b.onChange
+	b.mutex.Unlock()
+
+	if wasOpen == isOpen || onChange == nil {
+		return
+	}
+	if isOpen {
+		onChange(CSopen, err)
+	} else {
+		onChange(CSclosed, nil)
+	}
+}
+
+// shouldRetry returns true when the failed attempt should be repeated
+func (p RetryPolicy) shouldRetry(ctx context.Context, url string, res *RequestResult, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	idempotent := p.RetryNonIdempotent || isIdempotentRequest(url)
+	if err != nil {
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) {
+			return reqErr.StatusCode == 503 || (idempotent && reqErr.StatusCode >= 500)
+		}
+		if isTransportError(err) {
+			return idempotent || isDialError(err)
+		}
+		return false
+	}
+	return res != nil && !res.OK && strings.Contains(strings.ToLower(res.Message), "busy")
+}
+
+// backoff returns the wait time before the given retry (starting at 1)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(math.Max(p.Multiplier, 1), float64(retry-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// isIdempotentRequest returns true for requests without side effects
+func isIdempotentRequest(requestURL string) bool {
+	path := endpointOf(requestURL)
+	name := strings.ToLower(path[strings.LastIndex(path, "/")+1:])
+	for _, prefix := range idempotentRequests {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOutcome classifies the result of a request for the circuit breaker. Only transport errors
+// and 5xx responses are indicating an unreachable dSS.
+func requestOutcome(ctx context.Context, err error) int {
+	if err == nil {
+		return outcomeSuccess
+	}
+	if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+		return outcomeNeutral
+	}
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.StatusCode >= 500 {
+			return outcomeFailure
+		}
+		return outcomeSuccess
+	}
+	if isTransportError(err) {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// isTransportError returns true for errors of the http client
+func isTransportError(err error) bool {
+	var transportErr *TransportError
+	return errors.As(err, &transportErr)
+}
+
+// isDialError returns true when the connection to the dSS could not be established, so the
+// request has definitely not been processed
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// sleepContext waits for the given duration or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,192 @@
+package digitalstrom_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// fastRetryPolicy repeats requests without noticeable backoff
+var fastRetryPolicy = digitalstrom.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	Multiplier:     2,
+}
+
+func TestRetryIdempotentRequest(t *testing.T) {
+	srv, a := newTestAccount(t)
+	a.Connection.RetryPolicy = fastRetryPolicy
+	srv.SetHTTPStatus("/json/device/getSensorValue", http.StatusInternalServerError, 2)
+	sensor, _ := a.GetSensor("00000003", 0)
+
+	if _, err := a.PollSensorValue(sensor); err != nil {
+		t.Fatalf("PollSensorValue failed: %v", err)
+	}
+	if n := len(srv.CallsTo("/json/device/getSensorValue")); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+	if retries := a.Stats().Retries; retries != 2 {
+		t.Errorf("expected 2 retries, got %d", retries)
+	}
+}
+
+func TestNoRetryOfProcessedWriteRequest(t *testing.T) {
+	srv, a := newTestAccount(t)
+	a.Connection.RetryPolicy = fastRetryPolicy
+	srv.SetHTTPStatus("/json/device/turnOn", http.StatusInternalServerError, 1)
+	device, _ := a.GetDevice("00000001")
+
+	err := a.TurnOn(device, true)
+	var reqErr *digitalstrom.RequestError
+	if !errors.As(err, &reqErr) || reqErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected a RequestError with status 500, got %v", err)
+	}
+	if n := len(srv.CallsTo("/json/device/turnOn")); n != 1 {
+		t.Errorf("a write request that could have been processed has been repeated: %d attempts", n)
+	}
+
+	// 503 means the request has not been processed, so it is repeated
+	srv.SetHTTPStatus("/json/device/turnOn", http.StatusServiceUnavailable, 1)
+	if err := a.TurnOn(device, true); err != nil {
+		t.Fatalf("TurnOn after 503 failed: %v", err)
+	}
+	if n := len(srv.CallsTo("/json/device/turnOn")); n != 3 {
+		t.Errorf("expected the rejected request to be repeated, got %d attempts", n)
+	}
+}
+
+func TestRetryBusyDSS(t *testing.T) {
+	srv, a := newTestAccount(t)
+	a.Connection.RetryPolicy = fastRetryPolicy
+	srv.SetFailure("/json/device/turnOff", "dSS busy")
+	device, _ := a.GetDevice("00000001")
+
+	err := a.TurnOn(device, false)
+	if !errors.Is(err, digitalstrom.ErrAPI) {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+	if n := len(srv.CallsTo("/json/device/turnOff")); n != 3 {
+		t.Errorf("expected 3 attempts while the dSS is busy, got %d", n)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	srv, a := newTestAccount(t)
+	a.Connection.RetryPolicy = digitalstrom.RetryPolicy{MaxAttempts: 1}
+	a.Connection.SetCircuitBreaker(digitalstrom.CircuitBreakerSetup{FailureThreshold: 2, OpenDuration: 100 * time.Millisecond})
+	events := make(chan digitalstrom.Event, 10)
+	cancel := a.SubscribeChannel(digitalstrom.SubscriptionSetup{
+		Filter: digitalstrom.EventFilter{Kinds: []digitalstrom.EventKind{digitalstrom.EKconnectivityChanged}},
+	}, events)
+	defer cancel()
+	sensor, _ := a.GetSensor("00000003", 0)
+
+	srv.SetHTTPStatus("/json/device/getSensorValue", http.StatusBadGateway, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := a.PollSensorValue(sensor); err == nil {
+			t.Fatalf("expected an error while the dSS fails")
+		}
+	}
+	if state := a.Connection.CircuitState(); state != digitalstrom.CSopen {
+		t.Fatalf("expected an open circuit, got %s", state)
+	}
+	if _, err := a.PollSensorValue(sensor); !errors.Is(err, digitalstrom.ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if n := len(srv.CallsTo("/json/device/getSensorValue")); n != 2 {
+		t.Errorf("request performed while the circuit is open: %d calls", n)
+	}
+
+	srv.SetHTTPStatus("/json/device/getSensorValue", 0, 0)
+	time.Sleep(150 * time.Millisecond)
+	if state := a.Connection.CircuitState(); state != digitalstrom.CShalfOpen {
+		t.Fatalf("expected a half open circuit, got %s", state)
+	}
+	if _, err := a.PollSensorValue(sensor); err != nil {
+		t.Fatalf("probe request failed: %v", err)
+	}
+	if state := a.Connection.CircuitState(); state != digitalstrom.CSclosed {
+		t.Errorf("expected a closed circuit, got %s", state)
+	}
+
+	for _, connected := range []bool{false, true} {
+		select {
+		case event := <-events:
+			if e := event.(digitalstrom.ConnectivityChangeEvent); e.Connected != connected {
+				t.Errorf("expected connected=%v, got %+v", connected, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no connectivity event with connected=%v", connected)
+		}
+	}
+}
+
+func TestSetCircuitBreakerWhileRequesting(t *testing.T) {
+	_, a := newTestAccount(t)
+	sensor, _ := a.GetSensor("00000003", 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if _, err := a.PollSensorValue(sensor); err != nil {
+				t.Errorf("PollSensorValue failed: %v", err)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		a.Connection.SetCircuitBreaker(digitalstrom.CircuitBreakerSetup{FailureThreshold: i + 1, OpenDuration: time.Second})
+		a.Connection.CircuitState()
+	}
+	<-done
+	if state := a.Connection.CircuitState(); state != digitalstrom.CSclosed {
+		t.Errorf("expected a closed circuit, got %s", state)
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	var empty atomic.Bool
+	// once empty is set, all requests are answered without body, either with or without content length
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !empty.Load() {
+			srv.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/json/zone/callScene" {
+			w.(http.Flusher).Flush()
+			return
+		}
+		w.Header().Set("Content-Length", "0")
+	}))
+	defer proxy.Close()
+	a := digitalstrom.NewAccount()
+	a.SetURL(proxy.URL)
+	a.SetApplicationToken(dsstest.ApplicationToken)
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	empty.Store(true)
+	sensor, _ := a.GetSensor("00000003", 0)
+
+	res, err := a.Connection.Get(proxy.URL + "/json/system/version")
+	var malformedErr *digitalstrom.MalformedResponseError
+	if res != nil || !errors.As(err, &malformedErr) || malformedErr.Endpoint != "/json/system/version" {
+		t.Errorf("expected a MalformedResponseError, got %+v, %v", res, err)
+	}
+	if _, err := a.PollSensorValue(sensor); !errors.Is(err, digitalstrom.ErrMalformedResponse) {
+		t.Errorf("PollSensorValue: expected a malformed response, got %v", err)
+	}
+	if _, err := a.RequestCircuits(); !errors.Is(err, digitalstrom.ErrMalformedResponse) {
+		t.Errorf("RequestCircuits: expected a malformed response, got %v", err)
+	}
+	if err := a.CallZoneScene(1, digitalstrom.ATlights, digitalstrom.SNpreset1, false); !errors.Is(err, digitalstrom.ErrMalformedResponse) {
+		t.Errorf("CallZoneScene: expected a malformed response, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package digitalstrom
+
+import (
+	"context"
+	"strconv"
+)
+
+// SceneNumber ...
+type SceneNumber int
+
+// Scene Numbers (SN). Presets and area scenes could be called for each application type (group),
+// apartment scenes like Present or Absent are defined for the broadcast group (ATbroadcast).
+const (
+	SNoff         SceneNumber = 0
+	SNarea1Off    SceneNumber = 1
+	SNarea2Off    SceneNumber = 2
+	SNarea3Off    SceneNumber = 3
+	SNarea4Off    SceneNumber = 4
+	SNpreset1     SceneNumber = 5
+	SNarea1On     SceneNumber = 6
+	SNarea2On     SceneNumber = 7
+	SNarea3On     SceneNumber = 8
+	SNarea4On     SceneNumber = 9
+	SNdecrement   SceneNumber = 11
+	SNincrement   SceneNumber = 12
+	SNminimum     SceneNumber = 13
+	SNmaximum     SceneNumber = 14
+	SNstop        SceneNumber = 15
+	SNpreset2     SceneNumber = 17
+	SNpreset3     SceneNumber = 18
+	SNpreset4     SceneNumber = 19
+	SNautoOff     SceneNumber = 40
+	SNautoStandby SceneNumber = 64
+	SNpanic       SceneNumber = 65
+	SNstandby     SceneNumber = 67
+	SNdeepOff     SceneNumber = 68
+	SNsleeping    SceneNumber = 69
+	SNwakeup      SceneNumber = 70
+	SNpresent     SceneNumber = 71
+	SNabsent      SceneNumber = 72
+	SNdoorBell    SceneNumber = 73
+	SNalarm1      SceneNumber = 74
+	SNzoneActive  SceneNumber = 75
+	SNfire        SceneNumber = 76
+	SNalarm2      SceneNumber = 83
+	SNalarm3      SceneNumber = 84
+	SNalarm4      SceneNumber = 85
+	SNwind        SceneNumber = 86
+	SNnoWind      SceneNumber = 87
+	SNrain        SceneNumber = 88
+	SNnoRain      SceneNumber = 89
+	SNhail        SceneNumber = 90
+	SNnoHail      SceneNumber = 91
+)
+
+// GetID returns the identifier of the scene number
+func (sn SceneNumber) GetID() int {
+	return int(sn)
+}
+
+// GetName returns the name of the scene number
+func (sn SceneNumber) GetName() string {
+	switch sn {
+	case SNoff:
+		return "Off"
+	case SNarea1Off:
+		return "Area 1 Off"
+	case SNarea2Off:
+		return "Area 2 Off"
+	case SNarea3Off:
+		return "Area 3 Off"
+	case SNarea4Off:
+		return "Area 4 Off"
+	case SNpreset1:
+		return "Preset 1"
+	case SNarea1On:
+		return "Area 1 On"
+	case SNarea2On:
+		return "Area 2 On"
+	case SNarea3On:
+		return "Area 3 On"
+	case SNarea4On:
+		return "Area 4 On"
+	case SNdecrement:
+		return "Decrement"
+	case SNincrement:
+		return "Increment"
+	case SNminimum:
+		return "Minimum"
+	case SNmaximum:
+		return "Maximum"
+	case SNstop:
+		return "Stop"
+	case SNpreset2:
+		return "Preset 2"
+	case SNpreset3:
+		return "Preset 3"
+	case SNpreset4:
+		return "Preset 4"
+	case SNautoOff:
+		return "Auto Off"
+	case SNautoStandby:
+		return "Auto Standby"
+	case SNpanic:
+		return "Panic"
+	case SNstandby:
+		return "Standby"
+	case SNdeepOff:
+		return "Deep Off"
+	case SNsleeping:
+		return "Sleeping"
+	case SNwakeup:
+		return "Wakeup"
+	case SNpresent:
+		return "Present"
+	case SNabsent:
+		return "Absent"
+	case SNdoorBell:
+		return "Door Bell"
+	case SNalarm1:
+		return "Alarm 1"
+	case SNzoneActive:
+		return "Zone Active"
+	case SNfire:
+		return "Fire"
+	case SNalarm2:
+		return "Alarm 2"
+	case SNalarm3:
+		return "Alarm 3"
+	case SNalarm4:
+		return "Alarm 4"
+	case SNwind:
+		return "Wind"
+	case SNnoWind:
+		return "No Wind"
+	case SNrain:
+		return "Rain"
+	case SNnoRain:
+		return "No Rain"
+	case SNhail:
+		return "Hail"
+	case SNnoHail:
+		return "No Hail"
+	}
+	return "unknown scene number"
+}
+
+// CallZoneScene calls the given scene for all devices of the zone with the given id that are part of the
+// given group. Use ATbroadcast to address all groups. A forced scene call will be performed even if the
+// devices are already in the given scene. Use zone id 0 to call a scene for the whole apartment.
+func (a *Account) CallZoneScene(zoneID int, group ApplicationType, scene SceneNumber, force bool) error {
+	return a.CallZoneSceneContext(context.Background(), zoneID, group, scene, force)
+}
+
+// CallZoneSceneContext is like CallZoneScene but aborts all performed requests when ctx is done.
+func (a *Account) CallZoneSceneContext(ctx context.Context, zoneID int, group ApplicationType, scene SceneNumber, force bool) error {
+	params := zoneSceneParams(zoneID, group, scene)
+	params["force"] = strconv.FormatBool(force)
+	return a.requestScene(ctx, "/json/zone/callScene", params)
+}
+
+// UndoZoneScene restores the state the devices of the zone and group had before the given scene was called.
+func (a *Account) UndoZoneScene(zoneID int, group ApplicationType, scene SceneNumber) error {
+	return a.UndoZoneSceneContext(context.Background(), zoneID, group, scene)
+}
+
+// UndoZoneSceneContext is like UndoZoneScene but aborts all performed requests when ctx is done.
+func (a *Account) UndoZoneSceneContext(ctx context.Context, zoneID int, group ApplicationType, scene SceneNumber) error {
+	return a.requestScene(ctx, "/json/zone/undoScene", zoneSceneParams(zoneID, group, scene))
+}
+
+// SaveZoneScene stores the current output values of the devices of the zone and group as the given scene.
+func (a *Account) SaveZoneScene(zoneID int, group ApplicationType, scene SceneNumber) error {
+	return a.SaveZoneSceneContext(context.Background(), zoneID, group, scene)
+}
+
+// SaveZoneSceneContext is like SaveZoneScene but aborts all performed requests when ctx is done.
+func (a *Account) SaveZoneSceneContext(ctx context.Context, zoneID int, group ApplicationType, scene SceneNumber) error {
+	return a.requestScene(ctx, "/json/zone/saveScene", zoneSceneParams(zoneID, group, scene))
+}
+
+// CallDeviceScene calls the given scene for a single device. A forced scene call will be performed even
+// if the device is already in the given scene.
+func (a *Account) CallDeviceScene(device *Device, scene SceneNumber, force bool) error {
+	return a.CallDeviceSceneContext(context.Background(), device, scene, force)
+}
+
+// CallDeviceSceneContext is like CallDeviceScene but aborts all performed requests when ctx is done.
+func (a *Account) CallDeviceSceneContext(ctx context.Context, device *Device, scene SceneNumber, force bool) error {
+	params := deviceSceneParams(device, scene)
+	params["force"] = strconv.FormatBool(force)
+	return a.requestScene(ctx, "/json/device/callScene", params)
+}
+
+// UndoDeviceScene restores the state the device had before the given scene was called.
+func (a *Account) UndoDeviceScene(device *Device, scene SceneNumber) error {
+	return a.UndoDeviceSceneContext(context.Background(), device, scene)
+}
+
+// UndoDeviceSceneContext is like UndoDeviceScene but aborts all performed requests when ctx is done.
+func (a *Account) UndoDeviceSceneContext(ctx context.Context, device *Device, scene SceneNumber) error {
+	return a.requestScene(ctx, "/json/device/undoScene", deviceSceneParams(device, scene))
+}
+
+// SaveDeviceScene stores the current output values of the device as the given scene.
+func (a *Account) SaveDeviceScene(device *Device, scene SceneNumber) error {
+	return a.SaveDeviceSceneContext(context.Background(), device, scene)
+}
+
+// SaveDeviceSceneContext is like SaveDeviceScene but aborts all performed requests when ctx is done.
+func (a *Account) SaveDeviceSceneContext(ctx context.Context, device *Device, scene SceneNumber) error {
+	return a.requestScene(ctx, "/json/device/saveScene", deviceSceneParams(device, scene))
+}
+
+// CallApartmentScene calls the given scene for all devices of the apartment that are part of the given
+// group. Apartment scenes like SNpresent, SNabsent or SNpanic have to be called for group ATbroadcast.
+func (a *Account) CallApartmentScene(group ApplicationType, scene SceneNumber, force bool) error {
+	return a.CallApartmentSceneContext(context.Background(), group, scene, force)
+}
+
+// CallApartmentSceneContext is like CallApartmentScene but aborts all performed requests when ctx is done.
+func (a *Account) CallApartmentSceneContext(ctx context.Context, group ApplicationType, scene SceneNumber, force bool) error {
+	params := map[string]string{
+		"groupID":     strconv.Itoa(group.GetID()),
+		"sceneNumber": strconv.Itoa(scene.GetID()),
+		"force":       strconv.FormatBool(force),
+	}
+	return a.requestScene(ctx, "/json/apartment/callScene", params)
+}
+
+// UndoApartmentScene restores the state the devices of the group had before the given scene was called.
+// The dSS has no saveScene request for the apartment, scenes could only be saved for zones and devices.
+func (a *Account) UndoApartmentScene(group ApplicationType, scene SceneNumber) error {
+	return a.UndoApartmentSceneContext(context.Background(), group, scene)
+}
+
+// UndoApartmentSceneContext is like UndoApartmentScene but aborts all performed requests when ctx is done.
+func (a *Account) UndoApartmentSceneContext(ctx context.Context, group ApplicationType, scene SceneNumber) error {
+	params := map[string]string{
+		"groupID":     strconv.Itoa(group.GetID()),
+		"sceneNumber": strconv.Itoa(scene.GetID()),
+	}
+	return a.requestScene(ctx, "/json/apartment/undoScene", params)
+}
+
+func (a *Account) requestScene(ctx context.Context, url string, params map[string]string) error {
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+url, get, "", params)
+	if err != nil {
+		return err
+	}
+	if !res.OK {
+		return res.apiError()
+	}
+	return nil
+}
+
+func zoneSceneParams(zoneID int, group ApplicationType, scene SceneNumber) map[string]string {
+	return map[string]string{
+		"id":          strconv.Itoa(zoneID),
+		"groupID":     strconv.Itoa(group.GetID()),
+		"sceneNumber": strconv.Itoa(scene.GetID()),
+	}
+}
+
+func deviceSceneParams(device *Device, scene SceneNumber) map[string]string {
+	return map[string]string{
+		"dsuid":       device.UUID,
+		"sceneNumber": strconv.Itoa(scene.GetID()),
+	}
+}
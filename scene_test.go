@@ -0,0 +1,142 @@
+package digitalstrom_test
+
+import (
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// deviceStates returns the On states of all devices of the server by display ID
+func deviceStates(srv *dsstest.Server) map[string]bool {
+	states := map[string]bool{}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		for _, zone := range apartment.Structure.Apartment.Zones {
+			for _, device := range zone.Devices {
+				states[device.DisplayID] = device.On
+			}
+		}
+	})
+	return states
+}
+
+func TestCallSceneForApartmentZone(t *testing.T) {
+	srv, a := newTestAccount(t)
+
+	if err := a.CallZoneScene(0, digitalstrom.ATlights, digitalstrom.SNpreset1, false); err != nil {
+		t.Fatalf("CallZoneScene failed: %v", err)
+	}
+	states := deviceStates(srv)
+	if !states["00000001"] || !states["00000002"] {
+		t.Errorf("lights of all zones have not been turned on: %v", states)
+	}
+	if states["00000003"] {
+		t.Errorf("device that is not part of the group has been turned on")
+	}
+
+	if err := a.CallZoneScene(0, digitalstrom.ATtemperatureControl, digitalstrom.SceneNumber(digitalstrom.OMnight), false); err != nil {
+		t.Fatalf("CallZoneScene failed: %v", err)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		if state := apartment.GetTemperatureControl(2); state.OperationMode != int(digitalstrom.OMnight) || state.NominalValue != 17 {
+			t.Errorf("operation mode of zone 2 has not been changed: %+v", state)
+		}
+	})
+
+	if err := a.CallZoneScene(7, digitalstrom.ATlights, digitalstrom.SNoff, false); err == nil {
+		t.Errorf("expected an error for an unknown zone")
+	}
+}
+
+func TestSceneRequests(t *testing.T) {
+	srv, a := newTestAccount(t)
+	device, err := a.GetDevice("00000001")
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		path     string
+		expected map[string]string
+	}{
+		{"call zone scene", func() error { return a.CallZoneScene(1, digitalstrom.ATlights, digitalstrom.SNpreset1, false) },
+			"/json/zone/callScene", map[string]string{"id": "1", "groupID": "1", "sceneNumber": "5", "force": "false"}},
+		{"force zone scene", func() error { return a.CallZoneScene(2, digitalstrom.ATbroadcast, digitalstrom.SNoff, true) },
+			"/json/zone/callScene", map[string]string{"id": "2", "groupID": "0", "sceneNumber": "0", "force": "true"}},
+		{"undo zone scene", func() error { return a.UndoZoneScene(1, digitalstrom.ATlights, digitalstrom.SNpreset1) },
+			"/json/zone/undoScene", map[string]string{"id": "1", "groupID": "1", "sceneNumber": "5"}},
+		{"save zone scene", func() error { return a.SaveZoneScene(1, digitalstrom.ATlights, digitalstrom.SNpreset2) },
+			"/json/zone/saveScene", map[string]string{"id": "1", "groupID": "1", "sceneNumber": "17"}},
+		{"call device scene", func() error { return a.CallDeviceScene(device, digitalstrom.SNpreset1, false) },
+			"/json/device/callScene", map[string]string{"dsuid": device.UUID, "sceneNumber": "5", "force": "false"}},
+		{"force device scene", func() error { return a.CallDeviceScene(device, digitalstrom.SNoff, true) },
+			"/json/device/callScene", map[string]string{"dsuid": device.UUID, "sceneNumber": "0", "force": "true"}},
+		{"undo device scene", func() error { return a.UndoDeviceScene(device, digitalstrom.SNpreset1) },
+			"/json/device/undoScene", map[string]string{"dsuid": device.UUID, "sceneNumber": "5"}},
+		{"save device scene", func() error { return a.SaveDeviceScene(device, digitalstrom.SNpreset3) },
+			"/json/device/saveScene", map[string]string{"dsuid": device.UUID, "sceneNumber": "18"}},
+		{"call apartment scene", func() error { return a.CallApartmentScene(digitalstrom.ATbroadcast, digitalstrom.SNpresent, false) },
+			"/json/apartment/callScene", map[string]string{"groupID": "0", "sceneNumber": "71", "force": "false"}},
+		{"force apartment scene", func() error { return a.CallApartmentScene(digitalstrom.ATlights, digitalstrom.SNoff, true) },
+			"/json/apartment/callScene", map[string]string{"groupID": "1", "sceneNumber": "0", "force": "true"}},
+		{"undo apartment scene", func() error { return a.UndoApartmentScene(digitalstrom.ATbroadcast, digitalstrom.SNpresent) },
+			"/json/apartment/undoScene", map[string]string{"groupID": "0", "sceneNumber": "71"}},
+	}
+	for _, test := range tests {
+		before := len(srv.CallsTo(test.path))
+		if err := test.call(); err != nil {
+			t.Errorf("%s: request failed: %v", test.name, err)
+			continue
+		}
+		calls := srv.CallsTo(test.path)
+		if len(calls) != before+1 {
+			t.Errorf("%s: expected a request to %s", test.name, test.path)
+			continue
+		}
+		params := calls[len(calls)-1].Params
+		for key, value := range test.expected {
+			if params.Get(key) != value {
+				t.Errorf("%s: expected parameter %s=%s, got %v", test.name, key, value, params)
+			}
+		}
+		if _, ok := params["force"]; ok != (test.expected["force"] != "") {
+			t.Errorf("%s: unexpected force parameter %v", test.name, params)
+		}
+	}
+}
+
+func TestSceneCallsChangeDevices(t *testing.T) {
+	srv, a := newTestAccount(t)
+
+	if err := a.CallZoneScene(1, digitalstrom.ATlights, digitalstrom.SNpreset1, false); err != nil {
+		t.Fatalf("CallZoneScene failed: %v", err)
+	}
+	if states := deviceStates(srv); !states["00000001"] || !states["00000002"] {
+		t.Errorf("lights of the zone have not been turned on: %v", states)
+	}
+
+	device, _ := a.GetDevice("00000002")
+	if err := a.CallDeviceScene(device, digitalstrom.SNoff, true); err != nil {
+		t.Fatalf("CallDeviceScene failed: %v", err)
+	}
+	if states := deviceStates(srv); !states["00000001"] || states["00000002"] {
+		t.Errorf("only the called device should have been turned off: %v", states)
+	}
+
+	if err := a.CallApartmentScene(digitalstrom.ATlights, digitalstrom.SNoff, false); err != nil {
+		t.Fatalf("CallApartmentScene failed: %v", err)
+	}
+	if states := deviceStates(srv); states["00000001"] || states["00000002"] {
+		t.Errorf("lights of the apartment have not been turned off: %v", states)
+	}
+
+	if err := a.CallZoneScene(7, digitalstrom.ATlights, digitalstrom.SNpreset1, false); err == nil {
+		t.Errorf("expected an error for an unknown zone")
+	}
+	unknown := &digitalstrom.Device{UUID: "302ed89f43f0000000000000000000009"}
+	if err := a.CallDeviceScene(unknown, digitalstrom.SNpreset1, false); err == nil {
+		t.Errorf("expected an error for an unknown device")
+	}
+}
@@ -0,0 +1,379 @@
+package digitalstrom
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PollItem is a value polled regularly, e.g. a sensor or a circuit
+type PollItem struct {
+	ID       string        // polling id, e.g. sensor•<device ID>•<sensor index>
+	Category string        // first part of the id: circuit, sensor, channel, structure, temperatureControlState, binaryInputs or states
+	Interval time.Duration // polling interval
+	Due      time.Time     // the value should be polled at this time, zero to poll it as soon as possible
+}
+
+// Scheduler decides which value is polled next. The polling of an account schedules all values with their
+// next due time and takes the next item whenever a poll could be started without exceeding MaxParallelPolls
+// and MaxRequestsPerSecond. Taken items are scheduled again when their poll has finished. Implementations
+// have to be safe for concurrent use.
+type Scheduler interface {
+	// Schedule adds the item or replaces the item with the same id
+	Schedule(item PollItem)
+	// Remove removes the item with the given id
+	Remove(id string)
+	// Next removes and returns the item that should be polled now. If no item is due, it returns false and
+	// the time the next item becomes due, which is zero if there are no items.
+	Next(now time.Time) (PollItem, time.Time, bool)
+}
+
+// SchedulerSetup defines the behaviour of a PriorityScheduler
+type SchedulerSetup struct {
+	// Weights per category, 1 if not given. When the dSS could not keep up with the polling, values with a
+	// higher weight are polled first.
+	Weights map[string]float64
+	// Jitter shifts the due time by up to the given fraction of the interval in both directions, so values
+	// with the same interval do not become due at the same time
+	Jitter float64
+}
+
+// DefaultSchedulerSetup is used by new accounts
+var DefaultSchedulerSetup = SchedulerSetup{
+	Jitter: 0.1,
+}
+
+// PriorityScheduler is the default Scheduler. Values are kept in a priority queue of their due times. Due
+// values are polled by their deadline, which is the due time plus the interval divided by the weight of the
+// category. So a value polled every 15 seconds does not delay a value polled every 15 minutes for more
+// than a few seconds, unless its weight is higher.
+type PriorityScheduler struct {
+	setup   SchedulerSetup
+	entries map[string]*pollEntry
+	waiting pollQueue // ordered by due time
+	ready   pollQueue // due items, ordered by deadline
+	mutex   sync.Mutex
+}
+
+// NewPriorityScheduler returns an empty scheduler with the given setup
+func NewPriorityScheduler(setup SchedulerSetup) *PriorityScheduler {
+	return &PriorityScheduler{
+		setup:   setup,
+		entries: make(map[string]*pollEntry),
+		waiting: pollQueue{less: func(a, b *pollEntry) bool { return a.item.Due.Before(b.item.Due) }},
+		ready:   pollQueue{less: func(a, b *pollEntry) bool { return a.deadline.Before(b.deadline) }},
+	}
+}
+
+// Schedule adds the item with a jittered due time. Items without due time are not jittered.
+func (s *PriorityScheduler) Schedule(item PollItem) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(item.ID)
+	if !item.Due.IsZero() && s.setup.Jitter > 0 {
+		item.Due = item.Due.Add(time.Duration(float64(item.Interval) * s.setup.Jitter * (2*rand.Float64() - 1)))
+	}
+	e := &pollEntry{
+		item:     item,
+		deadline: item.Due.Add(time.Duration(float64(item.Interval) / s.weight(item.Category))),
+	}
+	s.entries[item.ID] = e
+	heap.Push(&s.waiting, e)
+}
+
+// Remove removes the item with the given id
+func (s *PriorityScheduler) Remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(id)
+}
+
+// Next returns the due item with the earliest deadline
+func (s *PriorityScheduler) Next(now time.Time) (PollItem, time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.waiting.Len() > 0 && !s.waiting.entries[0].item.Due.After(now) {
+		e := heap.Pop(&s.waiting).(*pollEntry)
+		e.ready = true
+		heap.Push(&s.ready, e)
+	}
+	if s.ready.Len() > 0 {
+		e := heap.Pop(&s.ready).(*pollEntry)
+		delete(s.entries, e.item.ID)
+		return e.item, now, true
+	}
+	if s.waiting.Len() > 0 {
+		return PollItem{}, s.waiting.entries[0].item.Due, false
+	}
+	return PollItem{}, time.Time{}, false
+}
+
+// Len returns the number of scheduled items
+func (s *PriorityScheduler) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
+
+// remove removes the item with the given id. The lock has to be held by the caller.
+func (s *PriorityScheduler) remove(id string) {
+	e, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	delete(s.entries, id)
+	if e.ready {
+		heap.Remove(&s.ready, e.index)
+	} else {
+		heap.Remove(&s.waiting, e.index)
+	}
+}
+
+func (s *PriorityScheduler) weight(category string) float64 {
+	if w, ok := s.setup.Weights[category]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+type pollEntry struct {
+	item     PollItem
+	deadline time.Time
+	ready    bool
+	index    int // position in its queue
+}
+
+// pollQueue implements heap.Interface
+type pollQueue struct {
+	entries []*pollEntry
+	less    func(a, b *pollEntry) bool
+}
+
+func (q pollQueue) Len() int { return len(q.entries) }
+
+func (q pollQueue) Less(i, j int) bool { return q.less(q.entries[i], q.entries[j]) }
+
+func (q pollQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+
+func (q *pollQueue) Push(x interface{}) {
+	e := x.(*pollEntry)
+	e.index = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *pollQueue) Pop() interface{} {
+	n := len(q.entries)
+	e := q.entries[n-1]
+	q.entries[n-1] = nil
+	q.entries = q.entries[:n-1]
+	return e
+}
+
+// requestBudget limits the requests of the polling to a number per second. Up to one second of unused
+// budget could be used at once.
+type requestBudget struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait returns how long to wait until a poll with the given number of requests could be started at the
+// given rate, which is unlimited if it is not positive
+func (b *requestBudget) wait(now time.Time, rate float64, requests int) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+	b.last = now
+	b.tokens = math.Min(b.tokens, math.Max(rate, float64(requests)))
+	if b.tokens >= float64(requests) {
+		return 0
+	}
+	return time.Duration((float64(requests) - b.tokens) / rate * float64(time.Second))
+}
+
+// take uses the budget for the given number of requests
+func (b *requestBudget) take(requests int) {
+	b.tokens -= float64(requests)
+}
+
+// SetScheduler replaces the scheduler deciding which value is polled next. It has to be set before polling
+// is started.
+func (a *Account) SetScheduler(scheduler Scheduler) {
+	a.pollingHelpers.mapMutex.Lock()
+	a.pollingHelpers.scheduler = scheduler
+	a.pollingHelpers.scheduledIntervals = make(map[string]int)
+	a.pollingIntervalsChanged()
+	a.pollingHelpers.mapMutex.Unlock()
+}
+
+// runPolling starts the due polls until ctx is done
+func (a *Account) runPolling(ctx context.Context, run uint64) {
+	for {
+		// the wait time is limited, so changes of the circuit state are noticed
+		wait := a.startDuePolls(ctx)
+		if wait > time.Second {
+			wait = time.Second
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-a.pollingHelpers.wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			a.Events.chanMutex.Lock()
+			if a.pollingHelpers.pollingRun == run {
+				a.pollingHelpers.pollingStopped = true
+			}
+			a.Events.chanMutex.Unlock()
+			return
+		}
+	}
+}
+
+// startDuePolls starts polls until no more value is due, MaxParallelPolls is reached or the request budget
+// is exhausted. It returns the time to wait before the next poll could be started.
+func (a *Account) startDuePolls(ctx context.Context) time.Duration {
+	// polling is paused while the dSS is unreachable, it is resumed with a single probe poll
+	if a.Connection.CircuitState() == CSopen {
+		return time.Second
+	}
+	a.syncScheduler()
+	for ctx.Err() == nil {
+		a.pollingHelpers.countMutex.Lock()
+		pollCount := a.pollingHelpers.parallelPollCount
+		a.pollingHelpers.countMutex.Unlock()
+		if pollCount >= a.PollingSetup.MaxParallelPolls {
+			// finished polls are waking up the polling
+			return time.Second
+		}
+
+		now := time.Now()
+		a.pollingHelpers.mapMutex.Lock()
+		if wait := a.pollingHelpers.budget.wait(now, a.PollingSetup.MaxRequestsPerSecond, 1); wait > 0 {
+			a.pollingHelpers.mapMutex.Unlock()
+			return wait
+		}
+		item, next, ok := a.pollingHelpers.scheduler.Next(now)
+		if !ok {
+			a.pollingHelpers.mapMutex.Unlock()
+			if next.IsZero() {
+				return time.Second
+			}
+			return next.Sub(now)
+		}
+		if _, active := a.pollingHelpers.activePollingMap[item.ID]; active {
+			// scheduled again when the running poll has finished
+			a.pollingHelpers.mapMutex.Unlock()
+			continue
+		}
+		requests := pollRequests(item.Category)
+		if wait := a.pollingHelpers.budget.wait(now, a.PollingSetup.MaxRequestsPerSecond, requests); wait > 0 {
+			// the value stays the next one to poll, e.g. a circuit needing two requests
+			item.Due = time.Time{}
+			a.pollingHelpers.scheduler.Schedule(item)
+			a.pollingHelpers.mapMutex.Unlock()
+			return wait
+		}
+		a.pollingHelpers.budget.take(requests)
+		// remember that value with this id will be polled now
+		a.pollingHelpers.activePollingMap[item.ID] = now
+		a.pollingHelpers.mapMutex.Unlock()
+
+		a.pollingHelpers.countMutex.Lock()
+		a.pollingHelpers.parallelPollCount++
+		a.pollingHelpers.countMutex.Unlock()
+		go a.performPolling(ctx, item.ID)
+	}
+	return 0
+}
+
+// pollRequests returns the number of requests a poll of the given category performs
+func pollRequests(category string) int {
+	if category == "circuit" {
+		return 2 // consumption and meter value
+	}
+	return 1
+}
+
+// syncScheduler schedules the values whose polling interval has been added or changed and removes the values
+// without polling interval from the scheduler.
+func (a *Account) syncScheduler() {
+	a.pollingHelpers.mapMutex.Lock()
+	defer a.pollingHelpers.mapMutex.Unlock()
+	if !a.pollingHelpers.intervalsChanged {
+		return
+	}
+	a.pollingHelpers.intervalsChanged = false
+	for id, interval := range a.pollingHelpers.pollIntervalMap {
+		if interval < 0 { // intervals lower than 0 will be skipped
+			continue
+		}
+		if old, ok := a.pollingHelpers.scheduledIntervals[id]; ok && old == interval {
+			continue
+		}
+		a.pollingHelpers.scheduledIntervals[id] = interval
+		if _, active := a.pollingHelpers.activePollingMap[id]; !active {
+			a.schedulePoll(id, interval)
+		}
+	}
+	for id := range a.pollingHelpers.scheduledIntervals {
+		if interval, ok := a.pollingHelpers.pollIntervalMap[id]; !ok || interval < 0 {
+			delete(a.pollingHelpers.scheduledIntervals, id)
+			a.pollingHelpers.scheduler.Remove(id)
+		}
+	}
+}
+
+// reschedulePoll schedules the value with the given id again according to its last poll, unless it is not
+// scheduled or currently polled. The map lock has to be held by the caller.
+func (a *Account) reschedulePoll(id string) {
+	interval, ok := a.pollingHelpers.scheduledIntervals[id]
+	if !ok {
+		return
+	}
+	if _, active := a.pollingHelpers.activePollingMap[id]; active {
+		return
+	}
+	a.schedulePoll(id, interval)
+	a.wakePolling()
+}
+
+// schedulePoll schedules the value one interval after its last poll or immediately if it has not been polled
+// yet. The map lock has to be held by the caller.
+func (a *Account) schedulePoll(id string, interval int) {
+	// values are polled at most once a second
+	d := time.Duration(interval) * time.Second
+	if d < time.Second {
+		d = time.Second
+	}
+	item := PollItem{ID: id, Category: strings.Split(id, "•")[0], Interval: d}
+	if t, ok := a.pollingHelpers.lastPollMap[id]; ok {
+		item.Due = t.Add(d)
+	}
+	a.pollingHelpers.scheduler.Schedule(item)
+}
+
+// pollingIntervalsChanged lets the polling update the scheduler. The map lock has to be held by the caller.
+func (a *Account) pollingIntervalsChanged() {
+	a.pollingHelpers.intervalsChanged = true
+	a.wakePolling()
+}
+
+// wakePolling lets the polling check for due values immediately
+func (a *Account) wakePolling() {
+	select {
+	case a.pollingHelpers.wake <- struct{}{}:
+	default:
+	}
+}
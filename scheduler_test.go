@@ -0,0 +1,134 @@
+package digitalstrom
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPrioritySchedulerOrder(t *testing.T) {
+	s := NewPriorityScheduler(SchedulerSetup{})
+	now := time.Now()
+	// both are due, the sensor polled every 15 seconds has the earlier deadline
+	s.Schedule(PollItem{ID: "channel•1•0", Category: "channel", Interval: 15 * time.Minute, Due: now.Add(-time.Minute)})
+	s.Schedule(PollItem{ID: "sensor•1•0", Category: "sensor", Interval: 15 * time.Second, Due: now.Add(-time.Second)})
+	s.Schedule(PollItem{ID: "circuit•1", Category: "circuit", Interval: 15 * time.Second, Due: now.Add(time.Minute)})
+
+	for _, id := range []string{"sensor•1•0", "channel•1•0"} {
+		item, _, ok := s.Next(now)
+		if !ok || item.ID != id {
+			t.Fatalf("expected %s, got %s (%v)", id, item.ID, ok)
+		}
+	}
+	_, next, ok := s.Next(now)
+	if ok || !next.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected the due time of the circuit, got %v (%v)", next, ok)
+	}
+	if s.Len() != 1 {
+		t.Errorf("expected 1 scheduled item, got %d", s.Len())
+	}
+}
+
+func TestPrioritySchedulerWeights(t *testing.T) {
+	s := NewPriorityScheduler(SchedulerSetup{Weights: map[string]float64{"channel": 100}})
+	now := time.Now()
+	s.Schedule(PollItem{ID: "sensor•1•0", Category: "sensor", Interval: 15 * time.Second, Due: now.Add(-time.Second)})
+	s.Schedule(PollItem{ID: "channel•1•0", Category: "channel", Interval: 15 * time.Minute, Due: now.Add(-time.Second)})
+
+	if item, _, _ := s.Next(now); item.ID != "channel•1•0" {
+		t.Errorf("expected the channel with the higher weight first, got %s", item.ID)
+	}
+}
+
+func TestPrioritySchedulerReplaceAndRemove(t *testing.T) {
+	s := NewPriorityScheduler(SchedulerSetup{})
+	now := time.Now()
+	s.Schedule(PollItem{ID: "structure", Category: "structure", Interval: time.Minute, Due: now.Add(time.Hour)})
+	s.Schedule(PollItem{ID: "structure", Category: "structure", Interval: time.Minute})
+	if s.Len() != 1 {
+		t.Fatalf("expected the item to be replaced, got %d items", s.Len())
+	}
+	if _, _, ok := s.Next(now); !ok {
+		t.Errorf("item without due time is not due")
+	}
+
+	s.Schedule(PollItem{ID: "states", Category: "states", Interval: time.Minute})
+	s.Remove("states")
+	if _, next, ok := s.Next(now); ok || !next.IsZero() {
+		t.Errorf("removed item is still scheduled")
+	}
+}
+
+func TestPrioritySchedulerJitter(t *testing.T) {
+	s := NewPriorityScheduler(SchedulerSetup{Jitter: 0.1})
+	due := time.Now().Add(time.Hour)
+	for i := 0; i < 20; i++ {
+		s.Schedule(PollItem{ID: "sensor•1•0", Category: "sensor", Interval: 100 * time.Second, Due: due})
+		_, next, _ := s.Next(time.Now())
+		if d := next.Sub(due); d < -10*time.Second || d > 10*time.Second {
+			t.Fatalf("due time shifted by %v", d)
+		}
+	}
+}
+
+func TestRequestBudget(t *testing.T) {
+	b := requestBudget{}
+	start := time.Now()
+	if wait := b.wait(start, 0, 2); wait != 0 {
+		t.Errorf("rate 0 is not unlimited, wait %v", wait)
+	}
+
+	b = requestBudget{}
+	// the first call starts with an empty budget
+	if wait := b.wait(start, 10, 1); wait != 100*time.Millisecond {
+		t.Errorf("expected to wait 100ms, got %v", wait)
+	}
+	now := start.Add(time.Second)
+	for i := 0; i < 10; i++ {
+		if wait := b.wait(now, 10, 1); wait != 0 {
+			t.Fatalf("request %d: budget of one second exhausted early, wait %v", i, wait)
+		}
+		b.take(1)
+	}
+	if wait := b.wait(now, 10, 2); wait != 200*time.Millisecond {
+		t.Errorf("expected to wait 200ms for two requests, got %v", wait)
+	}
+	// unused budget is limited to one second
+	if wait := b.wait(now.Add(time.Hour), 10, 1); wait != 0 || b.tokens != 10 {
+		t.Errorf("expected a budget of 10 requests, got %v", b.tokens)
+	}
+}
+
+// polledWithin returns the number of values the polling of the account has started within the given time. The
+// values are unknown sensors, so the polls finish without performing requests.
+func polledWithin(a *Account, d time.Duration) int {
+	a.pollingHelpers.mapMutex.Lock()
+	a.pollingHelpers.pollIntervalMap = map[string]int{}
+	for _, id := range []string{"sensor•1•0", "sensor•1•1", "sensor•1•2", "sensor•2•0", "sensor•2•1"} {
+		a.pollingHelpers.pollIntervalMap[id] = 60
+	}
+	a.pollingIntervalsChanged()
+	a.pollingHelpers.mapMutex.Unlock()
+
+	for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+		a.startDuePolls(context.Background())
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.pollingHelpers.mapMutex.Lock()
+	defer a.pollingHelpers.mapMutex.Unlock()
+	return len(a.pollingHelpers.lastPollMap)
+}
+
+func TestPollingHonorsRequestLimit(t *testing.T) {
+	a := NewAccount()
+	a.PollingSetup.MaxRequestsPerSecond = 5
+	if n := polledWithin(a, 300*time.Millisecond); n != 1 {
+		t.Errorf("expected 1 poll within 300ms at 5 requests per second, got %d", n)
+	}
+
+	a = NewAccount()
+	a.PollingSetup.MaxRequestsPerSecond = 0
+	if n := polledWithin(a, 100*time.Millisecond); n != 5 {
+		t.Errorf("expected all values to be polled without limit, got %d", n)
+	}
+}
@@ -0,0 +1,155 @@
+package digitalstrom
+
+import (
+	"strconv"
+	"time"
+)
+
+// Snapshot is a consistent, deep copy of the cached account state. It is detached from the Account,
+// so values in a snapshot never change and can be read without locking while polling or the event
+// listener keep updating the account in the background. The maps of a snapshot are pointing into
+// the copied Structure the same way the maps of the Account do.
+//
+// ValueTimes contains the time of the last update of each value, keyed by the ids used for polling,
+// e.g. "sensor•<device>•<index>", "channel•<device>•<index>", "binaryInput•<device>•<input>",
+// "circuit•<circuit>", "structure", "temperatureControlState" and "states". Stale is true as long as the
+// values have been loaded from a file and have not been reconciled with the dSS yet.
+type Snapshot struct {
+	Time               time.Time
+	Structure          Structure
+	Devices            map[string]*Device
+	Groups             map[int]*Group
+	Zones              map[int]*Zone
+	Floors             map[int]*Floor
+	Circuits           map[string]*Circuit
+	TemperatureControl map[int]*TemperatureControlState
+	States             map[string]*ApartmentState
+	ValueTimes         map[string]time.Time
+	Stale              bool
+}
+
+// Snapshot returns a consistent copy of all cached values. Use it whenever more than a single value
+// has to be read, e.g. to display the current state of the installation.
+func (a *Account) Snapshot() *Snapshot {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+
+	snapshot := &Snapshot{
+		Time:               time.Now(),
+		Structure:          a.Structure.copy(),
+		Devices:            make(map[string]*Device),
+		Groups:             make(map[int]*Group),
+		Zones:              make(map[int]*Zone),
+		Floors:             make(map[int]*Floor),
+		Circuits:           make(map[string]*Circuit),
+		TemperatureControl: make(map[int]*TemperatureControlState),
+		States:             make(map[string]*ApartmentState),
+		ValueTimes:         make(map[string]time.Time),
+		Stale:              !a.staleBefore.IsZero(),
+	}
+	for id, t := range a.valueTimes {
+		snapshot.ValueTimes[id] = t
+	}
+	for id, circuit := range a.Circuits {
+		c := *circuit
+		snapshot.Circuits[id] = &c
+	}
+	for id, state := range a.TemperatureControl {
+		s := *state
+		snapshot.TemperatureControl[id] = &s
+	}
+	for name, state := range a.States {
+		s := *state
+		snapshot.States[name] = &s
+	}
+
+	for i := range snapshot.Structure.Apartment.Zones {
+		zone := &snapshot.Structure.Apartment.Zones[i]
+		zone.TemperatureControl = snapshot.TemperatureControl[zone.ID]
+		snapshot.Zones[zone.ID] = zone
+		for j := range zone.Groups {
+			snapshot.Groups[zone.Groups[j].ID] = &zone.Groups[j]
+		}
+		for j := range zone.Devices {
+			snapshot.Devices[zone.Devices[j].DisplayID] = &zone.Devices[j]
+		}
+	}
+	for i := range snapshot.Structure.Apartment.Floors {
+		floor := &snapshot.Structure.Apartment.Floors[i]
+		snapshot.Floors[floor.ID] = floor
+	}
+	return snapshot
+}
+
+// GetSensor returns the sensor with the given index of the device with the given display ID.
+func (s *Snapshot) GetSensor(deviceID string, sensorIndex int) (*Sensor, error) {
+	device, ok := s.Devices[deviceID]
+	if !ok {
+		return nil, &NotFoundError{Kind: "device", ID: deviceID}
+	}
+	if sensorIndex < 0 || sensorIndex >= len(device.Sensors) {
+		return nil, &NotFoundError{Kind: "sensor", ID: strconv.Itoa(sensorIndex), Parent: deviceID}
+	}
+	return device.Sensors[sensorIndex], nil
+}
+
+// copy returns a deep copy of the structure with cross references assigned to the copied devices.
+func (s *Structure) copy() Structure {
+	c := Structure{}
+	if s.Apartment.Zones != nil {
+		c.Apartment.Zones = make([]Zone, len(s.Apartment.Zones))
+	}
+	for i := range s.Apartment.Zones {
+		zone := s.Apartment.Zones[i]
+		if zone.Devices != nil {
+			zone.Devices = make([]Device, len(s.Apartment.Zones[i].Devices))
+			for j := range s.Apartment.Zones[i].Devices {
+				zone.Devices[j] = s.Apartment.Zones[i].Devices[j].copy()
+			}
+		}
+		if zone.Groups != nil {
+			zone.Groups = make([]Group, len(s.Apartment.Zones[i].Groups))
+			for j := range s.Apartment.Zones[i].Groups {
+				group := s.Apartment.Zones[i].Groups[j]
+				group.Devices = append([]string(nil), group.Devices...)
+				zone.Groups[j] = group
+			}
+		}
+		zone.TemperatureControl = nil
+		c.Apartment.Zones[i] = zone
+	}
+	if s.Apartment.Floors != nil {
+		c.Apartment.Floors = make([]Floor, len(s.Apartment.Floors))
+	}
+	for i := range s.Apartment.Floors {
+		floor := s.Apartment.Floors[i]
+		floor.Zones = append([]int(nil), floor.Zones...)
+		c.Apartment.Floors[i] = floor
+	}
+	c.assignCrossReferences()
+	return c
+}
+
+// copy returns a deep copy of the device. Cross references of sensors and output channels
+// have to be assigned afterwards.
+func (d *Device) copy() Device {
+	c := *d
+	c.BinaryInputs = nil
+	for _, input := range d.BinaryInputs {
+		i := *input
+		c.BinaryInputs = append(c.BinaryInputs, &i)
+	}
+	c.Sensors = nil
+	for _, sensor := range d.Sensors {
+		s := *sensor
+		c.Sensors = append(c.Sensors, &s)
+	}
+	c.OutputChannels = nil
+	for _, channel := range d.OutputChannels {
+		o := *channel
+		c.OutputChannels = append(c.OutputChannels, &o)
+	}
+	c.PairedDevices = append([]string(nil), d.PairedDevices...)
+	c.Groups = append([]int(nil), d.Groups...)
+	return c
+}
@@ -0,0 +1,77 @@
+package digitalstrom_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+func TestSnapshotIsDetached(t *testing.T) {
+	srv, a := newTestAccount(t)
+	snapshot := a.Snapshot()
+
+	snapshot.Devices["00000001"].Name = "changed"
+	if device, _ := a.GetDevice("00000001"); device.Name != "Ceiling Lamp" {
+		t.Errorf("changing the snapshot changed the account: %q", device.Name)
+	}
+
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000003").Sensors[0].Value = 18
+	})
+	sensor, _ := a.GetSensor("00000003", 0)
+	if _, err := a.PollSensorValue(sensor); err != nil {
+		t.Fatalf("PollSensorValue failed: %v", err)
+	}
+	if got, _ := snapshot.GetSensor("00000003", 0); got.Value != 21.5 {
+		t.Errorf("polling changed the snapshot: %v", got.Value)
+	}
+	if got, _ := a.Snapshot().GetSensor("00000003", 0); got.Value != 18 {
+		t.Errorf("expected 18 in a new snapshot, got %v", got.Value)
+	}
+
+	zone := snapshot.Zones[1]
+	if len(zone.Devices) != 2 || snapshot.Devices["00000002"] != &zone.Devices[1] {
+		t.Errorf("devices of the snapshot are not pointing into its structure")
+	}
+}
+
+func TestPollingSkipsAbsentDevices(t *testing.T) {
+	apartment := dsstest.NewApartment()
+	apartment.GetDevice("00000001").IsPresent = false
+	absentUUID := apartment.GetDevice("00000001").UUID
+	presentUUID := apartment.GetDevice("00000002").UUID
+	srv := dsstest.NewServer(apartment)
+	defer srv.Close()
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetApplicationToken(dsstest.ApplicationToken)
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	a.PollingSetup.DefaultChannelsPollingInterval = 1
+	a.SetDefaultPollingIntervals()
+
+	polls := func(uuid string) int {
+		n := 0
+		for _, call := range srv.CallsTo("/json/device/getOutputValue") {
+			if call.Params.Get("dsuid") == uuid {
+				n++
+			}
+		}
+		return n
+	}
+	// channels may have been polled during the initialization already
+	absentPolls, presentPolls := polls(absentUUID), polls(presentUUID)
+	a.StartPolling()
+	defer a.StopPolling()
+	// snapshots are taken while the polling updates the cache, which is checked by the race detector
+	waitFor(t, 5*time.Second, func() bool {
+		a.Snapshot()
+		return polls(presentUUID) > presentPolls
+	})
+	if polls(absentUUID) != absentPolls {
+		t.Errorf("channel of an absent device has been polled")
+	}
+}
@@ -0,0 +1,64 @@
+package digitalstrom
+
+import (
+	"time"
+)
+
+// Stats contains the counters of the requests, polls and events an Account has performed or
+// received since it has been created.
+type Stats struct {
+	Requests             uint64        // http requests sent to the dSS
+	RequestErrors        uint64        // http requests that failed or were answered with a status other than 200
+	APIErrors            uint64        // requests the dSS answered with ok=false
+	Retries              uint64        // repeated requests according to the RetryPolicy
+	CircuitState         CircuitState  // state of the circuit breaker, CSclosed while the dSS is reachable
+	Logins               uint64        // application logins, including automatic logins after expired sessions
+	RequestTime          time.Duration // sum of the durations of all http requests
+	Polls                map[string]uint64
+	PollErrors           map[string]uint64
+	ActivePolls          int
+	PollingRunning       bool
+	EventListenerRunning bool
+	ReceivedEvents       uint64 // events received by the event listener
+	Events               EventStats
+}
+
+// Stats returns the current counters of the account. Polls and PollErrors are counted per
+// category: circuit, sensor, channel, structure, temperatureControlState and binaryInputs.
+func (a *Account) Stats() Stats {
+	stats := Stats{
+		Polls:      make(map[string]uint64),
+		PollErrors: make(map[string]uint64),
+	}
+	if a.Connection.stats != nil {
+		a.Connection.stats.mutex.Lock()
+		stats.Requests = a.Connection.stats.requests
+		stats.RequestErrors = a.Connection.stats.requestErrors
+		stats.APIErrors = a.Connection.stats.apiErrors
+		stats.Retries = a.Connection.stats.retries
+		stats.Logins = a.Connection.stats.logins
+		stats.RequestTime = a.Connection.stats.requestTime
+		a.Connection.stats.mutex.Unlock()
+	}
+
+	stats.CircuitState = a.Connection.CircuitState()
+
+	a.pollingHelpers.countMutex.Lock()
+	for category, count := range a.pollingHelpers.pollCounts {
+		stats.Polls[category] = count
+	}
+	for category, count := range a.pollingHelpers.pollErrors {
+		stats.PollErrors[category] = count
+	}
+	stats.ActivePolls = a.pollingHelpers.parallelPollCount
+	a.pollingHelpers.countMutex.Unlock()
+	stats.PollingRunning = !a.isPollingStopped()
+
+	a.eventListener.mutex.Lock()
+	stats.EventListenerRunning = a.eventListener.running
+	stats.ReceivedEvents = a.eventListener.received
+	a.eventListener.mutex.Unlock()
+
+	stats.Events = a.EventStats()
+	return stats
+}
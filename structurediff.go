@@ -0,0 +1,245 @@
+package digitalstrom
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// structureDiff contains the differences between the cached structure and a newly requested one
+type structureDiff struct {
+	events   []Event
+	onEvents []OnStateValueChangeEvent
+	// rebuild is true when devices, zones, groups or floors have been added, removed or moved, so the
+	// cached structure has to be replaced. Otherwise, the changes are applied to the cached devices and
+	// zones, which keeps references to them valid.
+	rebuild bool
+}
+
+// diffStructure compares the given structure with the cached one. The cache lock has to be held by the caller.
+func (a *Account) diffStructure(s *Structure) structureDiff {
+	diff := structureDiff{}
+	newZones := make(map[int]*Zone)
+	newDevices := make(map[string]*Device)
+	for i := range s.Apartment.Zones {
+		zone := &s.Apartment.Zones[i]
+		newZones[zone.ID] = zone
+		for j := range zone.Devices {
+			newDevices[zone.Devices[j].DisplayID] = &zone.Devices[j]
+		}
+	}
+
+	for i := range s.Apartment.Zones {
+		zone := &s.Apartment.Zones[i]
+		old, ok := a.Zones[zone.ID]
+		if !ok {
+			diff.events = append(diff.events, ZoneAddedEvent{ZoneId: zone.ID, Name: zone.Name})
+			diff.rebuild = true
+			continue
+		}
+		if old.Name != zone.Name {
+			diff.events = append(diff.events, ZoneRenamedEvent{ZoneId: zone.ID, OldName: old.Name, NewName: zone.Name})
+		}
+		if old.FloorID != zone.FloorID || !sameGroupMemberships(old.Groups, zone.Groups) {
+			diff.rebuild = true
+		}
+	}
+
+	for i := range s.Apartment.Zones {
+		for j := range s.Apartment.Zones[i].Devices {
+			device := &s.Apartment.Zones[i].Devices[j]
+			old, ok := a.Devices[device.DisplayID]
+			if !ok {
+				diff.events = append(diff.events, DeviceAddedEvent{DeviceId: device.DisplayID, ZoneId: device.ZoneID, Name: device.Name})
+				diff.rebuild = true
+				continue
+			}
+			if old.ZoneID != device.ZoneID {
+				diff.events = append(diff.events, DeviceMovedEvent{DeviceId: device.DisplayID, ZoneId: device.ZoneID, OldZoneId: old.ZoneID})
+			}
+			if old.ZoneID != device.ZoneID || len(old.Sensors) != len(device.Sensors) ||
+				len(old.OutputChannels) != len(device.OutputChannels) || len(old.BinaryInputs) != len(device.BinaryInputs) {
+				diff.rebuild = true
+			}
+			if old.Name != device.Name {
+				diff.events = append(diff.events, DeviceRenamedEvent{DeviceId: device.DisplayID, ZoneId: device.ZoneID, OldName: old.Name, NewName: device.Name})
+			}
+			if old.IsPresent != device.IsPresent {
+				diff.events = append(diff.events, DevicePresenceChangeEvent{DeviceId: device.DisplayID, ZoneId: device.ZoneID, IsPresent: device.IsPresent})
+			}
+			joined, left := diffGroups(old.Groups, device.Groups)
+			if len(joined) > 0 || len(left) > 0 {
+				diff.events = append(diff.events, GroupMembershipChangeEvent{DeviceId: device.DisplayID, ZoneId: device.ZoneID, JoinedGroups: joined, LeftGroups: left})
+			}
+			// On states of devices that are not present are not reliable
+			if old.On != device.On && device.IsPresent && device.IsValid {
+				diff.onEvents = append(diff.onEvents, OnStateValueChangeEvent{DeviceId: device.DisplayID, ZoneId: device.ZoneID, OldValue: old.On, NewValue: device.On})
+			}
+		}
+	}
+
+	for i := range a.Structure.Apartment.Zones {
+		for _, device := range a.Structure.Apartment.Zones[i].Devices {
+			if _, ok := newDevices[device.DisplayID]; !ok {
+				diff.events = append(diff.events, DeviceRemovedEvent{DeviceId: device.DisplayID, ZoneId: device.ZoneID, Name: device.Name})
+				diff.rebuild = true
+			}
+		}
+	}
+	for _, zone := range a.Structure.Apartment.Zones {
+		if _, ok := newZones[zone.ID]; !ok {
+			diff.events = append(diff.events, ZoneRemovedEvent{ZoneId: zone.ID, Name: zone.Name})
+			diff.rebuild = true
+		}
+	}
+
+	if !reflect.DeepEqual(a.Structure.Apartment.Floors, s.Apartment.Floors) {
+		diff.rebuild = true
+	}
+	return diff
+}
+
+// applyStructure updates the cache with the given structure. When devices, zones, groups or floors have
+// been added, removed or moved, the cached structure is replaced and the polling intervals of new devices
+// are set to the defaults. Otherwise, names, presence, On states and group memberships are copied into
+// the cached structure. The cache lock has to be held by the caller.
+func (a *Account) applyStructure(s *Structure, rebuild bool) {
+	if rebuild {
+		a.carryOverValues(s)
+		a.setStructure(*s)
+		a.assignTempControlStatesToZones()
+		a.touchStructureValues(false)
+		a.syncDevicePollingIntervals()
+		return
+	}
+	for i := range s.Apartment.Zones {
+		zone := &s.Apartment.Zones[i]
+		if old, ok := a.Zones[zone.ID]; ok {
+			old.Name = zone.Name
+			old.IsPresent = zone.IsPresent
+			// the memberships are the same, the groups are updated in place as the cached groups point to them
+			for _, group := range zone.Groups {
+				for k := range old.Groups {
+					if old.Groups[k].ID == group.ID {
+						old.Groups[k] = group
+					}
+				}
+			}
+		}
+		for j := range zone.Devices {
+			device := &zone.Devices[j]
+			old, ok := a.Devices[device.DisplayID]
+			if !ok {
+				continue
+			}
+			old.Name = device.Name
+			old.IsPresent = device.IsPresent
+			old.IsValid = device.IsValid
+			old.On = device.On
+			old.Groups = device.Groups
+		}
+	}
+	a.touchValue("structure")
+}
+
+// syncDevicePollingIntervals adds the default polling intervals for sensors and output channels of
+// devices that have no interval yet and removes intervals and update times of devices that are no
+// longer part of the structure. Nothing is changed while no polling intervals have been set. The cache
+// lock has to be held by the caller.
+func (a *Account) syncDevicePollingIntervals() {
+	for id := range a.valueTimes {
+		if !a.isKnownDeviceValue(id) {
+			delete(a.valueTimes, id)
+		}
+	}
+
+	a.pollingHelpers.mapMutex.Lock()
+	defer a.pollingHelpers.mapMutex.Unlock()
+	if a.pollingHelpers.pollIntervalMap == nil {
+		return
+	}
+	for id := range a.pollingHelpers.pollIntervalMap {
+		if !a.isKnownDeviceValue(id) {
+			delete(a.pollingHelpers.pollIntervalMap, id)
+		}
+	}
+	for devID, dev := range a.Devices {
+		for i := range dev.Sensors {
+			id := "sensor•" + devID + "•" + strconv.Itoa(i)
+			if _, ok := a.pollingHelpers.pollIntervalMap[id]; !ok {
+				a.pollingHelpers.pollIntervalMap[id] = a.PollingSetup.DefaultSensorsPollingInterval
+			}
+		}
+		for i := range dev.OutputChannels {
+			id := "channel•" + devID + "•" + strconv.Itoa(i)
+			if _, ok := a.pollingHelpers.pollIntervalMap[id]; !ok {
+				a.pollingHelpers.pollIntervalMap[id] = a.PollingSetup.DefaultChannelsPollingInterval
+			}
+		}
+	}
+	a.pollingIntervalsChanged()
+}
+
+// isKnownDeviceValue returns false for sensor, channel and binary input ids of devices that are not
+// cached. Ids of other values are always known. The cache lock has to be held by the caller.
+func (a *Account) isKnownDeviceValue(id string) bool {
+	s := strings.Split(id, "•")
+	if len(s) != 3 || (s[0] != "sensor" && s[0] != "channel" && s[0] != "binaryInput") {
+		return true
+	}
+	_, ok := a.Devices[s[1]]
+	return ok
+}
+
+// sameGroupMemberships returns true when both lists contain the same groups with the same devices, regardless
+// of their order
+func sameGroupMemberships(oldGroups []Group, newGroups []Group) bool {
+	if len(oldGroups) != len(newGroups) {
+		return false
+	}
+	members := make(map[int][]string, len(oldGroups))
+	for _, group := range oldGroups {
+		members[group.ID] = sortedCopy(group.Devices)
+	}
+	for _, group := range newGroups {
+		old, ok := members[group.ID]
+		if !ok || !reflect.DeepEqual(old, sortedCopy(group.Devices)) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedCopy returns a sorted copy of the given values
+func sortedCopy(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// diffGroups returns the group ids that are only part of newGroups (joined) and those that are only
+// part of oldGroups (left)
+func diffGroups(oldGroups []int, newGroups []int) (joined []int, left []int) {
+	contains := func(groups []int, id int) bool {
+		for _, group := range groups {
+			if group == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range newGroups {
+		if !contains(oldGroups, id) {
+			joined = append(joined, id)
+		}
+	}
+	for _, id := range oldGroups {
+		if !contains(newGroups, id) {
+			left = append(left, id)
+		}
+	}
+	sort.Ints(joined)
+	sort.Ints(left)
+	return joined, left
+}
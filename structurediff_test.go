@@ -0,0 +1,167 @@
+package digitalstrom_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// subscribeStructureEvents returns a channel receiving all device and zone lifecycle events of the account
+func subscribeStructureEvents(t *testing.T, a *digitalstrom.Account) <-chan digitalstrom.Event {
+	events := make(chan digitalstrom.Event, 20)
+	cancel := a.SubscribeChannel(digitalstrom.SubscriptionSetup{
+		Filter: digitalstrom.EventFilter{Kinds: []digitalstrom.EventKind{
+			digitalstrom.EKdeviceAdded, digitalstrom.EKdeviceRemoved, digitalstrom.EKdevicePresenceChanged,
+			digitalstrom.EKdeviceRenamed, digitalstrom.EKdeviceMoved, digitalstrom.EKgroupMembershipChanged,
+			digitalstrom.EKzoneAdded, digitalstrom.EKzoneRemoved, digitalstrom.EKzoneRenamed,
+		}},
+	}, events)
+	t.Cleanup(cancel)
+	return events
+}
+
+// expectEvents fails the test unless exactly the given events are received in the given order
+func expectEvents(t *testing.T, events <-chan digitalstrom.Event, expected ...digitalstrom.Event) {
+	t.Helper()
+	for _, e := range expected {
+		select {
+		case event := <-events:
+			if !reflect.DeepEqual(event, e) {
+				t.Errorf("expected %#v, got %#v", e, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %#v, got nothing", e)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %#v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStructureChangesKeepReferences(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStructureEvents(t, a)
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000001").Name = "Floor Lamp"
+		apartment.GetDevice("00000002").IsPresent = false
+		apartment.GetZone(2).Name = "Cellar"
+		// reordered groups and members are no structural change
+		z := apartment.GetZone(1)
+		z.Groups = append(z.Groups, digitalstrom.Group{ID: 2, Name: "gray", ApplicationType: digitalstrom.ATblinds})
+		z.Groups[0], z.Groups[1] = z.Groups[1], z.Groups[0]
+		z.Groups[1].Devices[0], z.Groups[1].Devices[1] = z.Groups[1].Devices[1], z.Groups[1].Devices[0]
+	})
+	// the new group changes the memberships of zone 1, so the first poll rebuilds the structure
+	if err := a.PollStructureValues(); err != nil {
+		t.Fatalf("PollStructureValues failed: %v", err)
+	}
+	expectEvents(t, events,
+		digitalstrom.ZoneRenamedEvent{ZoneId: 2, OldName: "Kitchen", NewName: "Cellar"},
+		digitalstrom.DeviceRenamedEvent{DeviceId: "00000001", ZoneId: 1, OldName: "Ceiling Lamp", NewName: "Floor Lamp"},
+		digitalstrom.DevicePresenceChangeEvent{DeviceId: "00000002", ZoneId: 1, IsPresent: false},
+	)
+
+	device, _ := a.GetDevice("00000001")
+	zone := a.Zones[1]
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000001").Name = "Desk Lamp"
+		z := apartment.GetZone(1)
+		z.Groups[0], z.Groups[1] = z.Groups[1], z.Groups[0]
+		z.Groups[0].Devices[0], z.Groups[0].Devices[1] = z.Groups[0].Devices[1], z.Groups[0].Devices[0]
+	})
+	if err := a.PollStructureValues(); err != nil {
+		t.Fatalf("PollStructureValues failed: %v", err)
+	}
+	expectEvents(t, events,
+		digitalstrom.DeviceRenamedEvent{DeviceId: "00000001", ZoneId: 1, OldName: "Floor Lamp", NewName: "Desk Lamp"},
+	)
+	if current, _ := a.GetDevice("00000001"); current != device {
+		t.Errorf("device has been replaced although the structure has not been changed")
+	}
+	if a.Zones[1] != zone {
+		t.Errorf("zone has been replaced although only the order of its groups has changed")
+	}
+	if device.Name != "Desk Lamp" {
+		t.Errorf("name of the cached device not updated: %q", device.Name)
+	}
+}
+
+func TestStructureRebuildCarriesOverValues(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStructureEvents(t, a)
+	srv.Update(func(apartment *dsstest.Apartment) {
+		apartment.GetDevice("00000003").Sensors[0].Value = 23
+		apartment.GetDevice("00000001").OutputChannels[0].Value = 128
+	})
+	sensor, _ := a.GetSensor("00000003", 0)
+	channel, _ := a.GetOutputChannel("00000001", 0)
+	if _, err := a.PollSensorValue(sensor); err != nil {
+		t.Fatalf("PollSensorValue failed: %v", err)
+	}
+	if _, err := a.PollChannelValue(channel); err != nil {
+		t.Fatalf("PollChannelValue failed: %v", err)
+	}
+
+	srv.Update(func(apartment *dsstest.Apartment) {
+		// the structure of the dSS contains outdated sensor values
+		apartment.GetDevice("00000003").Sensors[0].Value = 19
+		kitchen := apartment.GetZone(2)
+		added := kitchen.Devices[0]
+		added.ID, added.DisplayID, added.UUID, added.Name = "302ed89f43f00e4000000004", "00000004", "302ed89f43f0000000000000000000004", "Second Sensor"
+		kitchen.Devices = append(kitchen.Devices, added)
+		living := apartment.GetZone(1)
+		living.Devices = living.Devices[:1]
+		living.Groups[0].Devices = living.Groups[0].Devices[:1]
+	})
+	if err := a.PollStructureValues(); err != nil {
+		t.Fatalf("PollStructureValues failed: %v", err)
+	}
+	expectEvents(t, events,
+		digitalstrom.DeviceAddedEvent{DeviceId: "00000004", ZoneId: 2, Name: "Second Sensor"},
+		digitalstrom.DeviceRemovedEvent{DeviceId: "00000002", ZoneId: 1, Name: "RGB Lamp"},
+	)
+
+	snapshot := a.Snapshot()
+	if _, ok := snapshot.Devices["00000004"]; !ok {
+		t.Errorf("added device not cached")
+	}
+	if _, ok := snapshot.Devices["00000002"]; ok {
+		t.Errorf("removed device still cached")
+	}
+	if s, _ := snapshot.GetSensor("00000003", 0); s.Value != 23 {
+		t.Errorf("polled sensor value not carried over: %v", s.Value)
+	}
+	if v := snapshot.Devices["00000001"].OutputChannels[0].Value; v != 128 {
+		t.Errorf("polled channel value not carried over: %v", v)
+	}
+	if current, _ := a.GetSensor("00000003", 0); current == sensor {
+		t.Errorf("sensor has not been replaced by the rebuild")
+	}
+}
+
+func TestPollStructureUpdatesGroupsInPlace(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStructureEvents(t, a)
+	group := &a.Zones[1].Groups[0]
+	if a.Groups[group.ID] != group {
+		t.Fatalf("cached group does not point into the structure")
+	}
+
+	srv.Update(func(apartment *dsstest.Apartment) {
+		zone := apartment.GetZone(1)
+		zone.Groups[0].Name = "renamed"
+		zone.Groups[0].Color = 7
+	})
+	pollStructureWithoutEvents(t, a, events)
+	if a.Groups[group.ID] != group || &a.Zones[1].Groups[0] != group {
+		t.Errorf("cached group has been replaced")
+	}
+	if group.Name != "renamed" || group.Color != 7 {
+		t.Errorf("cached group has not been updated: %+v", group)
+	}
+}
@@ -0,0 +1,389 @@
+package digitalstrom
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// SetDeviceName renames the device with the given display ID. The cached device will be renamed on success.
+func (a *Account) SetDeviceName(deviceID string, name string) error {
+	return a.SetDeviceNameContext(context.Background(), deviceID, name)
+}
+
+// SetDeviceNameContext is like SetDeviceName but aborts all performed requests when ctx is done.
+func (a *Account) SetDeviceNameContext(ctx context.Context, deviceID string, name string) error {
+	device, err := a.GetDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	err = a.requestStructureChange(ctx, "/json/device/setName", map[string]string{"dsuid": device.UUID, "newName": name})
+	if err != nil {
+		return err
+	}
+
+	events := []Event{}
+	a.cacheMutex.Lock()
+	if device, ok := a.Devices[deviceID]; ok && device.Name != name {
+		events = append(events, DeviceRenamedEvent{DeviceId: deviceID, ZoneId: device.ZoneID, OldName: device.Name, NewName: name})
+		device.Name = name
+	}
+	a.cacheMutex.Unlock()
+	a.publishEvents(events)
+	return nil
+}
+
+// SetZoneName renames the zone with the given id. The cached zone and its temperature control state will be
+// renamed on success.
+func (a *Account) SetZoneName(zoneID int, name string) error {
+	return a.SetZoneNameContext(context.Background(), zoneID, name)
+}
+
+// SetZoneNameContext is like SetZoneName but aborts all performed requests when ctx is done.
+func (a *Account) SetZoneNameContext(ctx context.Context, zoneID int, name string) error {
+	err := a.requestStructureChange(ctx, "/json/zone/setName", map[string]string{"id": strconv.Itoa(zoneID), "newName": name})
+	if err != nil {
+		return err
+	}
+
+	events := []Event{}
+	a.cacheMutex.Lock()
+	if zone, ok := a.Zones[zoneID]; ok && zone.Name != name {
+		events = append(events, ZoneRenamedEvent{ZoneId: zoneID, OldName: zone.Name, NewName: name})
+		zone.Name = name
+	}
+	if state, ok := a.TemperatureControl[zoneID]; ok {
+		state.Name = name
+	}
+	a.cacheMutex.Unlock()
+	a.publishEvents(events)
+	return nil
+}
+
+// SetFloorName renames the floor with the given id. The cached floor will be renamed on success.
+func (a *Account) SetFloorName(floorID int, name string) error {
+	return a.SetFloorNameContext(context.Background(), floorID, name)
+}
+
+// SetFloorNameContext is like SetFloorName but aborts all performed requests when ctx is done.
+func (a *Account) SetFloorNameContext(ctx context.Context, floorID int, name string) error {
+	err := a.requestStructureChange(ctx, "/json/apartment/setFloorName", map[string]string{"floorID": strconv.Itoa(floorID), "newName": name})
+	if err != nil {
+		return err
+	}
+	a.cacheMutex.Lock()
+	if floor, ok := a.Floors[floorID]; ok {
+		floor.Name = name
+	}
+	a.cacheMutex.Unlock()
+	return nil
+}
+
+// MoveDevice moves the device with the given display ID into the zone with the given id. The device will
+// be moved within the cached structure on success and keeps its group memberships. As the cached structure
+// is rebuilt, references to devices, sensors or channels obtained before have to be looked up again.
+func (a *Account) MoveDevice(deviceID string, zoneID int) error {
+	return a.MoveDeviceContext(context.Background(), deviceID, zoneID)
+}
+
+// MoveDeviceContext is like MoveDevice but aborts all performed requests when ctx is done.
+func (a *Account) MoveDeviceContext(ctx context.Context, deviceID string, zoneID int) error {
+	device, err := a.GetDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	if _, err := a.getZone(zoneID); err != nil {
+		return err
+	}
+	err = a.requestStructureChange(ctx, "/json/structure/zoneAddDevice", map[string]string{"dsuid": device.UUID, "zone": strconv.Itoa(zoneID)})
+	if err != nil {
+		return err
+	}
+
+	events := []Event{}
+	a.cacheMutex.Lock()
+	if device, ok := a.Devices[deviceID]; ok && device.ZoneID != zoneID {
+		events = append(events, DeviceMovedEvent{DeviceId: deviceID, ZoneId: zoneID, OldZoneId: device.ZoneID})
+		a.Structure.Apartment.moveDevice(deviceID, zoneID)
+		a.setStructure(a.Structure)
+		a.assignTempControlStatesToZones()
+	}
+	a.cacheMutex.Unlock()
+	a.publishEvents(events)
+	return nil
+}
+
+// AddZone creates a zone with the given id and assigns the given name, unless it is empty. The zone will be
+// added to the cached structure on success.
+func (a *Account) AddZone(zoneID int, name string) error {
+	return a.AddZoneContext(context.Background(), zoneID, name)
+}
+
+// AddZoneContext is like AddZone but aborts all performed requests when ctx is done.
+func (a *Account) AddZoneContext(ctx context.Context, zoneID int, name string) error {
+	err := a.requestStructureChange(ctx, "/json/structure/addZone", map[string]string{"zoneID": strconv.Itoa(zoneID)})
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		err = a.requestStructureChange(ctx, "/json/zone/setName", map[string]string{"id": strconv.Itoa(zoneID), "newName": name})
+	}
+
+	events := []Event{}
+	a.cacheMutex.Lock()
+	if _, ok := a.Zones[zoneID]; !ok {
+		zone := Zone{ID: zoneID, IsPresent: true, Devices: []Device{}, Groups: []Group{}}
+		if err == nil {
+			zone.Name = name
+		}
+		events = append(events, ZoneAddedEvent{ZoneId: zoneID, Name: zone.Name})
+		a.Structure.Apartment.Zones = append(a.Structure.Apartment.Zones, zone)
+		a.setStructure(a.Structure)
+		a.assignTempControlStatesToZones()
+	}
+	a.cacheMutex.Unlock()
+	a.publishEvents(events)
+	return err
+}
+
+// RemoveZone removes the zone with the given id. Only zones without devices could be removed. The zone will
+// be removed from the cached structure and floors on success.
+func (a *Account) RemoveZone(zoneID int) error {
+	return a.RemoveZoneContext(context.Background(), zoneID)
+}
+
+// RemoveZoneContext is like RemoveZone but aborts all performed requests when ctx is done.
+func (a *Account) RemoveZoneContext(ctx context.Context, zoneID int) error {
+	a.cacheMutex.RLock()
+	zone, ok := a.Zones[zoneID]
+	hasDevices := ok && len(zone.Devices) > 0
+	a.cacheMutex.RUnlock()
+	if hasDevices {
+		return errors.New("zone " + strconv.Itoa(zoneID) + " still contains devices")
+	}
+	err := a.requestStructureChange(ctx, "/json/structure/removeZone", map[string]string{"zoneID": strconv.Itoa(zoneID)})
+	if err != nil {
+		return err
+	}
+
+	events := []Event{}
+	a.cacheMutex.Lock()
+	if zone, ok := a.Zones[zoneID]; ok {
+		events = append(events, ZoneRemovedEvent{ZoneId: zoneID, Name: zone.Name})
+		a.Structure.Apartment.removeZone(zoneID)
+		delete(a.TemperatureControl, zoneID)
+		a.setStructure(a.Structure)
+		a.assignTempControlStatesToZones()
+	}
+	a.cacheMutex.Unlock()
+	a.publishEvents(events)
+	return nil
+}
+
+// AddGroup creates a user group with the given id, name and color in the zone with the given id. The group
+// will be added to the cached zone on success.
+func (a *Account) AddGroup(zoneID int, groupID int, name string, color int) error {
+	return a.AddGroupContext(context.Background(), zoneID, groupID, name, color)
+}
+
+// AddGroupContext is like AddGroup but aborts all performed requests when ctx is done.
+func (a *Account) AddGroupContext(ctx context.Context, zoneID int, groupID int, name string, color int) error {
+	if _, err := a.getZone(zoneID); err != nil {
+		return err
+	}
+	params := map[string]string{
+		"zoneID":     strconv.Itoa(zoneID),
+		"groupID":    strconv.Itoa(groupID),
+		"groupName":  name,
+		"groupColor": strconv.Itoa(color),
+	}
+	err := a.requestStructureChange(ctx, "/json/structure/addGroup", params)
+	if err != nil {
+		return err
+	}
+
+	a.cacheMutex.Lock()
+	if zone, ok := a.Zones[zoneID]; ok && zone.getGroup(groupID) == nil {
+		zone.Groups = append(zone.Groups, Group{ID: groupID, Name: name, Color: color, IsPresent: true, IsValid: true, Devices: []string{}})
+		a.setStructure(a.Structure)
+		a.assignTempControlStatesToZones()
+	}
+	a.cacheMutex.Unlock()
+	return nil
+}
+
+// GroupAddDevice adds the device with the given display ID to the group with the given id. The cached
+// device and group will be updated on success.
+func (a *Account) GroupAddDevice(groupID int, deviceID string) error {
+	return a.GroupAddDeviceContext(context.Background(), groupID, deviceID)
+}
+
+// GroupAddDeviceContext is like GroupAddDevice but aborts all performed requests when ctx is done.
+func (a *Account) GroupAddDeviceContext(ctx context.Context, groupID int, deviceID string) error {
+	return a.changeGroupMembership(ctx, "/json/structure/groupAddDevice", groupID, deviceID, true)
+}
+
+// GroupRemoveDevice removes the device with the given display ID from the group with the given id. The
+// cached device and group will be updated on success.
+func (a *Account) GroupRemoveDevice(groupID int, deviceID string) error {
+	return a.GroupRemoveDeviceContext(context.Background(), groupID, deviceID)
+}
+
+// GroupRemoveDeviceContext is like GroupRemoveDevice but aborts all performed requests when ctx is done.
+func (a *Account) GroupRemoveDeviceContext(ctx context.Context, groupID int, deviceID string) error {
+	return a.changeGroupMembership(ctx, "/json/structure/groupRemoveDevice", groupID, deviceID, false)
+}
+
+func (a *Account) changeGroupMembership(ctx context.Context, url string, groupID int, deviceID string, join bool) error {
+	device, err := a.GetDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	err = a.requestStructureChange(ctx, url, map[string]string{"dsuid": device.UUID, "groupID": strconv.Itoa(groupID)})
+	if err != nil {
+		return err
+	}
+
+	events := []Event{}
+	a.cacheMutex.Lock()
+	if device, ok := a.Devices[deviceID]; ok {
+		groups := removeInt(device.Groups, groupID)
+		if join {
+			groups = append(groups, groupID)
+		}
+		joined, left := diffGroups(device.Groups, groups)
+		if len(joined) > 0 || len(left) > 0 {
+			events = append(events, GroupMembershipChangeEvent{DeviceId: deviceID, ZoneId: device.ZoneID, JoinedGroups: joined, LeftGroups: left})
+		}
+		device.Groups = groups
+		// groups are part of each zone, the device is member of the group of its own zone and of the
+		// apartment zone 0
+		for i := range a.Structure.Apartment.Zones {
+			zone := &a.Structure.Apartment.Zones[i]
+			if zone.ID != device.ZoneID && zone.ID != 0 {
+				continue
+			}
+			if group := zone.getGroup(groupID); group != nil {
+				group.Devices = removeString(group.Devices, device.UUID)
+				if join {
+					group.Devices = append(group.Devices, device.UUID)
+				}
+			}
+		}
+	}
+	a.cacheMutex.Unlock()
+	a.publishEvents(events)
+	return nil
+}
+
+func (a *Account) requestStructureChange(ctx context.Context, url string, params map[string]string) error {
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+url, get, "", params)
+	if err != nil {
+		return err
+	}
+	if !res.OK {
+		return res.apiError()
+	}
+	return nil
+}
+
+// getZone returns the cached zone with the given id
+func (a *Account) getZone(zoneID int) (*Zone, error) {
+	a.cacheMutex.RLock()
+	defer a.cacheMutex.RUnlock()
+	zone, ok := a.Zones[zoneID]
+	if !ok {
+		return nil, &NotFoundError{Kind: "zone", ID: strconv.Itoa(zoneID)}
+	}
+	return zone, nil
+}
+
+// publishEvents publishes the given events to all subscribers. It must not be called while holding the cache lock.
+func (a *Account) publishEvents(events []Event) {
+	for _, event := range events {
+		a.eventBus.publish(event)
+	}
+}
+
+// moveDevice moves the device with the given display ID into the zone with the given id. The device is removed
+// from the groups of its former zone and added to the groups of the new zone it is member of.
+func (ap *Apartment) moveDevice(deviceID string, zoneID int) {
+	var device *Device
+	for i := range ap.Zones {
+		zone := &ap.Zones[i]
+		for j := range zone.Devices {
+			if zone.Devices[j].DisplayID != deviceID {
+				continue
+			}
+			d := zone.Devices[j]
+			device = &d
+			zone.Devices = append(zone.Devices[:j:j], zone.Devices[j+1:]...)
+			for k := range zone.Groups {
+				zone.Groups[k].Devices = removeString(zone.Groups[k].Devices, d.UUID)
+			}
+			break
+		}
+	}
+	if device == nil {
+		return
+	}
+	device.ZoneID = zoneID
+	for i := range ap.Zones {
+		zone := &ap.Zones[i]
+		if zone.ID != zoneID {
+			continue
+		}
+		zone.Devices = append(zone.Devices, *device)
+		for _, id := range device.Groups {
+			if group := zone.getGroup(id); group != nil {
+				group.Devices = append(group.Devices, device.UUID)
+			}
+		}
+	}
+}
+
+// removeZone removes the zone with the given id and its references of the floors
+func (ap *Apartment) removeZone(zoneID int) {
+	zones := []Zone{}
+	for _, zone := range ap.Zones {
+		if zone.ID != zoneID {
+			zones = append(zones, zone)
+		}
+	}
+	ap.Zones = zones
+	for i := range ap.Floors {
+		ap.Floors[i].Zones = removeInt(ap.Floors[i].Zones, zoneID)
+	}
+}
+
+// getGroup returns the group of the zone with the given id or nil
+func (z *Zone) getGroup(groupID int) *Group {
+	for i := range z.Groups {
+		if z.Groups[i].ID == groupID {
+			return &z.Groups[i]
+		}
+	}
+	return nil
+}
+
+// removeInt returns a copy of list without value
+func removeInt(list []int, value int) []int {
+	result := []int{}
+	for _, v := range list {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// removeString returns a copy of list without value
+func removeString(list []string, value string) []string {
+	result := []string{}
+	for _, v := range list {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
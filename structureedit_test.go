@@ -0,0 +1,187 @@
+package digitalstrom_test
+
+import (
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// pollStructureWithoutEvents polls the structure and fails the test when the cached structure differed
+// from the one of the dSS
+func pollStructureWithoutEvents(t *testing.T, a *digitalstrom.Account, events <-chan digitalstrom.Event) {
+	t.Helper()
+	if err := a.PollStructureValues(); err != nil {
+		t.Fatalf("PollStructureValues failed: %v", err)
+	}
+	expectEvents(t, events)
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRenameStructure(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStructureEvents(t, a)
+
+	if err := a.SetDeviceName("00000001", "Desk Lamp"); err != nil {
+		t.Fatalf("SetDeviceName failed: %v", err)
+	}
+	if err := a.SetZoneName(2, "Cellar"); err != nil {
+		t.Fatalf("SetZoneName failed: %v", err)
+	}
+	if err := a.SetFloorName(1, "Basement"); err != nil {
+		t.Fatalf("SetFloorName failed: %v", err)
+	}
+	expectEvents(t, events,
+		digitalstrom.DeviceRenamedEvent{DeviceId: "00000001", ZoneId: 1, OldName: "Ceiling Lamp", NewName: "Desk Lamp"},
+		digitalstrom.ZoneRenamedEvent{ZoneId: 2, OldName: "Kitchen", NewName: "Cellar"},
+	)
+	if params := srv.CallsTo("/json/device/setName")[0].Params; params.Get("dsuid") != "302ed89f43f0000000000000000000001" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	if a.TemperatureControl[2].Name != "Cellar" {
+		t.Errorf("name of the temperature control state has not been updated")
+	}
+	if a.Floors[1].Name != "Basement" {
+		t.Errorf("name of the cached floor has not been updated")
+	}
+	pollStructureWithoutEvents(t, a, events)
+
+	if err := a.SetDeviceName("00000009", "Unknown"); err == nil {
+		t.Errorf("expected an error for an unknown device")
+	}
+}
+
+func TestMoveDevice(t *testing.T) {
+	_, a := newTestAccount(t)
+	events := subscribeStructureEvents(t, a)
+
+	if err := a.MoveDevice("00000003", 1); err != nil {
+		t.Fatalf("MoveDevice failed: %v", err)
+	}
+	expectEvents(t, events, digitalstrom.DeviceMovedEvent{DeviceId: "00000003", ZoneId: 1, OldZoneId: 2})
+
+	device, err := a.GetDevice("00000003")
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+	if device.ZoneID != 1 || len(device.Sensors) != 2 {
+		t.Errorf("unexpected device %+v", device)
+	}
+	living := a.Zones[1]
+	if len(living.Devices) != 3 || &living.Devices[2] != device {
+		t.Errorf("device is not part of the structure of its new zone")
+	}
+	kitchen := a.Zones[2]
+	if len(kitchen.Devices) != 0 || containsString(kitchen.Groups[0].Devices, device.UUID) {
+		t.Errorf("device is still part of its former zone")
+	}
+	if kitchen.TemperatureControl != a.TemperatureControl[2] {
+		t.Errorf("temperature control state is not assigned to its zone")
+	}
+	pollStructureWithoutEvents(t, a, events)
+
+	if err := a.MoveDevice("00000003", 7); err == nil {
+		t.Errorf("expected an error for an unknown zone")
+	}
+}
+
+func TestAddAndRemoveZone(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeStructureEvents(t, a)
+
+	if err := a.AddZone(3, "Bathroom"); err != nil {
+		t.Fatalf("AddZone failed: %v", err)
+	}
+	expectEvents(t, events, digitalstrom.ZoneAddedEvent{ZoneId: 3, Name: "Bathroom"})
+	if zone, ok := a.Zones[3]; !ok || zone.Name != "Bathroom" || !zone.IsPresent {
+		t.Errorf("zone has not been added to the cache")
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		if zone := apartment.GetZone(3); zone == nil || zone.Name != "Bathroom" {
+			t.Errorf("zone has not been added to the dSS")
+		}
+	})
+	pollStructureWithoutEvents(t, a, events)
+
+	if err := a.RemoveZone(2); err == nil {
+		t.Errorf("expected an error for a zone with devices")
+	}
+	if err := a.MoveDevice("00000003", 3); err != nil {
+		t.Fatalf("MoveDevice failed: %v", err)
+	}
+	expectEvents(t, events, digitalstrom.DeviceMovedEvent{DeviceId: "00000003", ZoneId: 3, OldZoneId: 2})
+	if err := a.RemoveZone(2); err != nil {
+		t.Fatalf("RemoveZone failed: %v", err)
+	}
+	expectEvents(t, events, digitalstrom.ZoneRemovedEvent{ZoneId: 2, Name: "Kitchen"})
+	if _, ok := a.Zones[2]; ok {
+		t.Errorf("zone has not been removed from the cache")
+	}
+	if _, ok := a.TemperatureControl[2]; ok {
+		t.Errorf("temperature control state of the removed zone is still cached")
+	}
+	if zones := a.Floors[1].Zones; len(zones) != 1 || zones[0] != 1 {
+		t.Errorf("removed zone is still referenced by its floor: %v", zones)
+	}
+	if device, _ := a.GetDevice("00000003"); device != &a.Zones[3].Devices[0] {
+		t.Errorf("cached device does not point into the structure")
+	}
+	pollStructureWithoutEvents(t, a, events)
+}
+
+func TestGroupMembership(t *testing.T) {
+	_, a := newTestAccount(t)
+	events := subscribeStructureEvents(t, a)
+
+	if err := a.AddGroup(1, 16, "custom", 5); err != nil {
+		t.Fatalf("AddGroup failed: %v", err)
+	}
+	group, ok := a.Groups[16]
+	if !ok || group.Name != "custom" || group.Color != 5 {
+		t.Fatalf("group has not been added to the cache")
+	}
+	if zone := a.Zones[1]; len(zone.Groups) != 2 || &zone.Groups[1] != group {
+		t.Errorf("cached group does not point into the structure")
+	}
+
+	if err := a.GroupAddDevice(16, "00000001"); err != nil {
+		t.Fatalf("GroupAddDevice failed: %v", err)
+	}
+	event, ok := receiveEvent(t, events).(digitalstrom.GroupMembershipChangeEvent)
+	if !ok || event.DeviceId != "00000001" || event.ZoneId != 1 || len(event.JoinedGroups) != 1 ||
+		event.JoinedGroups[0] != 16 || len(event.LeftGroups) != 0 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	device, _ := a.GetDevice("00000001")
+	if len(device.Groups) != 2 || device.Groups[1] != 16 {
+		t.Errorf("group has not been added to the device: %v", device.Groups)
+	}
+	if !containsString(a.Groups[16].Devices, device.UUID) {
+		t.Errorf("device has not been added to the group")
+	}
+	group = a.Groups[16]
+	pollStructureWithoutEvents(t, a, events)
+	if a.Groups[16] != group || &a.Zones[1].Groups[1] != group {
+		t.Errorf("cached group does not point into the structure after polling")
+	}
+
+	if err := a.GroupRemoveDevice(16, "00000001"); err != nil {
+		t.Fatalf("GroupRemoveDevice failed: %v", err)
+	}
+	event, ok = receiveEvent(t, events).(digitalstrom.GroupMembershipChangeEvent)
+	if !ok || len(event.LeftGroups) != 1 || event.LeftGroups[0] != 16 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if containsString(a.Groups[16].Devices, device.UUID) || len(device.Groups) != 1 {
+		t.Errorf("device is still member of the group")
+	}
+	pollStructureWithoutEvents(t, a, events)
+}
@@ -0,0 +1,256 @@
+package digitalstrom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// OperationMode of the temperature control of a zone
+type OperationMode int
+
+// ControlMode of the temperature control of a zone
+type ControlMode int
+
+// Operation Modes (OM). Operation modes are called as scenes of the temperature control group (ATtemperatureControl).
+const (
+	OMoff        OperationMode = 0
+	OMcomfort    OperationMode = 1
+	OMeconomy    OperationMode = 2
+	OMnotUsed    OperationMode = 3
+	OMnight      OperationMode = 4
+	OMholiday    OperationMode = 5
+	OMcooling    OperationMode = 6
+	OMcoolingOff OperationMode = 7
+)
+
+// Control Modes (CM)
+const (
+	CMoff          ControlMode = 0
+	CMpid          ControlMode = 1 // room temperature controlled by the dSS
+	CMzoneFollower ControlMode = 2 // control value follows a reference zone
+	CMfixedValue   ControlMode = 3
+	CMmanual       ControlMode = 4 // control value set via SetZoneControlValue
+)
+
+// operationModeKeys are the names the dSS uses for the nominal values of each operation mode
+var operationModeKeys = [...]string{"Off", "Comfort", "Economy", "NotUsed", "Night", "Holiday", "Cooling", "CoolingOff"}
+
+var operationModeNames = [...]string{"Off", "Comfort", "Economy", "Not Used", "Night", "Holiday", "Cooling", "Cooling Off"}
+
+// TemperatureControlConfig is the configuration of the temperature controller of a zone
+type TemperatureControlConfig struct {
+	ControlMode       ControlMode `json:"ControlMode"`
+	ReferenceZone     int         `json:"ReferenceZone"`
+	CtrlOffset        int         `json:"CtrlOffset"`
+	EmergencyValue    int         `json:"EmergencyValue"`
+	ManualValue       int         `json:"ManualValue"`
+	CtrlKp            float64     `json:"CtrlKp"`
+	CtrlTs            float64     `json:"CtrlTs"`
+	CtrlTi            float64     `json:"CtrlTi"`
+	CtrlKd            float64     `json:"CtrlKd"`
+	CtrlImin          float64     `json:"CtrlImin"`
+	CtrlImax          float64     `json:"CtrlImax"`
+	CtrlYmin          float64     `json:"CtrlYmin"`
+	CtrlYmax          float64     `json:"CtrlYmax"`
+	CtrlAntiWindUp    bool        `json:"CtrlAntiWindUp"`
+	CtrlKeepFloorWarm bool        `json:"CtrlKeepFloorWarm"`
+}
+
+// GetID returns the identifier of the operation mode
+func (om OperationMode) GetID() int {
+	return int(om)
+}
+
+// GetName returns the name of the operation mode
+func (om OperationMode) GetName() string {
+	if om < 0 || int(om) >= len(operationModeNames) {
+		return "unknown operation mode"
+	}
+	return operationModeNames[om]
+}
+
+// RequestZoneTemperatureControlConfig requests the temperature control configuration of the zone with the given id.
+func (a *Account) RequestZoneTemperatureControlConfig(zoneID int) (*TemperatureControlConfig, error) {
+	return a.RequestZoneTemperatureControlConfigContext(context.Background(), zoneID)
+}
+
+// RequestZoneTemperatureControlConfigContext is like RequestZoneTemperatureControlConfig but aborts all performed requests when ctx is done.
+func (a *Account) RequestZoneTemperatureControlConfigContext(ctx context.Context, zoneID int) (*TemperatureControlConfig, error) {
+	res, err := a.requestZoneTemperatureControl(ctx, "/json/zone/getTemperatureControlConfig", zoneID, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	jsonString, err := json.Marshal(res.Result)
+	if err != nil {
+		return nil, err
+	}
+	config := &TemperatureControlConfig{}
+	err = json.Unmarshal(jsonString, config)
+	if err != nil {
+		return nil, res.invalid("result", err)
+	}
+	return config, nil
+}
+
+// SetZoneTemperatureControlConfig sets the temperature control configuration of the zone with the given id.
+// The control mode of the cached temperature control state will be updated on success.
+func (a *Account) SetZoneTemperatureControlConfig(zoneID int, config TemperatureControlConfig) error {
+	return a.SetZoneTemperatureControlConfigContext(context.Background(), zoneID, config)
+}
+
+// SetZoneTemperatureControlConfigContext is like SetZoneTemperatureControlConfig but aborts all performed requests when ctx is done.
+func (a *Account) SetZoneTemperatureControlConfigContext(ctx context.Context, zoneID int, config TemperatureControlConfig) error {
+	params := map[string]string{
+		"ControlMode":       strconv.Itoa(int(config.ControlMode)),
+		"ReferenceZone":     strconv.Itoa(config.ReferenceZone),
+		"CtrlOffset":        strconv.Itoa(config.CtrlOffset),
+		"EmergencyValue":    strconv.Itoa(config.EmergencyValue),
+		"ManualValue":       strconv.Itoa(config.ManualValue),
+		"CtrlKp":            formatFloat(config.CtrlKp),
+		"CtrlTs":            formatFloat(config.CtrlTs),
+		"CtrlTi":            formatFloat(config.CtrlTi),
+		"CtrlKd":            formatFloat(config.CtrlKd),
+		"CtrlImin":          formatFloat(config.CtrlImin),
+		"CtrlImax":          formatFloat(config.CtrlImax),
+		"CtrlYmin":          formatFloat(config.CtrlYmin),
+		"CtrlYmax":          formatFloat(config.CtrlYmax),
+		"CtrlAntiWindUp":    strconv.FormatBool(config.CtrlAntiWindUp),
+		"CtrlKeepFloorWarm": strconv.FormatBool(config.CtrlKeepFloorWarm),
+	}
+	_, err := a.requestZoneTemperatureControl(ctx, "/json/zone/setTemperatureControlConfig", zoneID, params)
+	if err != nil {
+		return err
+	}
+	a.updateTemperatureControlState(zoneID, func(state *TemperatureControlState) {
+		state.ControlMode = int(config.ControlMode)
+	})
+	return nil
+}
+
+// RequestZoneTemperatureControlValues requests the nominal temperatures of each operation mode of the zone with the given id.
+func (a *Account) RequestZoneTemperatureControlValues(zoneID int) (map[OperationMode]float64, error) {
+	return a.RequestZoneTemperatureControlValuesContext(context.Background(), zoneID)
+}
+
+// RequestZoneTemperatureControlValuesContext is like RequestZoneTemperatureControlValues but aborts all performed requests when ctx is done.
+func (a *Account) RequestZoneTemperatureControlValuesContext(ctx context.Context, zoneID int) (map[OperationMode]float64, error) {
+	res, err := a.requestZoneTemperatureControl(ctx, "/json/zone/getTemperatureControlValues", zoneID, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[OperationMode]float64)
+	for i, key := range operationModeKeys {
+		if value, ok := jsonFloat(res.Result, key); ok {
+			values[OperationMode(i)] = value
+		}
+	}
+	return values, nil
+}
+
+// SetZoneTemperatureControlValues sets the nominal temperatures for the given operation modes of the zone with
+// the given id. Operation modes not part of values remain unchanged. The nominal value of the cached temperature
+// control state will be updated when the value of the current operation mode has been set.
+func (a *Account) SetZoneTemperatureControlValues(zoneID int, values map[OperationMode]float64) error {
+	return a.SetZoneTemperatureControlValuesContext(context.Background(), zoneID, values)
+}
+
+// SetZoneTemperatureControlValuesContext is like SetZoneTemperatureControlValues but aborts all performed requests when ctx is done.
+func (a *Account) SetZoneTemperatureControlValuesContext(ctx context.Context, zoneID int, values map[OperationMode]float64) error {
+	params := map[string]string{}
+	for mode, value := range values {
+		if mode < 0 || int(mode) >= len(operationModeKeys) {
+			return errors.New("invalid operation mode " + strconv.Itoa(int(mode)))
+		}
+		params[operationModeKeys[mode]] = formatFloat(value)
+	}
+	_, err := a.requestZoneTemperatureControl(ctx, "/json/zone/setTemperatureControlValues", zoneID, params)
+	if err != nil {
+		return err
+	}
+	a.updateTemperatureControlState(zoneID, func(state *TemperatureControlState) {
+		if value, ok := values[OperationMode(state.OperationMode)]; ok {
+			state.NominalValue = value
+		}
+	})
+	return nil
+}
+
+// SetZoneOperationMode changes the operation mode of the temperature control of the zone with the given id by
+// calling the corresponding scene of the temperature control group. The nominal value of the new operation mode
+// will be requested during the next polling cycle.
+func (a *Account) SetZoneOperationMode(zoneID int, mode OperationMode) error {
+	return a.SetZoneOperationModeContext(context.Background(), zoneID, mode)
+}
+
+// SetZoneOperationModeContext is like SetZoneOperationMode but aborts all performed requests when ctx is done.
+func (a *Account) SetZoneOperationModeContext(ctx context.Context, zoneID int, mode OperationMode) error {
+	err := a.CallZoneSceneContext(ctx, zoneID, ATtemperatureControl, SceneNumber(mode), true)
+	if err != nil {
+		return err
+	}
+	a.updateTemperatureControlState(zoneID, func(state *TemperatureControlState) {
+		state.OperationMode = int(mode)
+	})
+	a.expirePollingTimeStamp("temperatureControlState")
+	return nil
+}
+
+// SetZoneControlValue sets the control value (e.g. valve opening in percent) of a zone whose temperature
+// control is in control mode CMmanual.
+func (a *Account) SetZoneControlValue(zoneID int, value float64) error {
+	return a.SetZoneControlValueContext(context.Background(), zoneID, value)
+}
+
+// SetZoneControlValueContext is like SetZoneControlValue but aborts all performed requests when ctx is done.
+func (a *Account) SetZoneControlValueContext(ctx context.Context, zoneID int, value float64) error {
+	params := map[string]string{
+		"groupID":     strconv.Itoa(ATtemperatureControl.GetID()),
+		"sensorType":  strconv.Itoa(STroomTemperatureControlVariable.GetID()),
+		"sensorValue": formatFloat(value),
+	}
+	_, err := a.requestZoneTemperatureControl(ctx, "/json/zone/pushSensorValue", zoneID, params)
+	if err != nil {
+		return err
+	}
+	a.updateTemperatureControlState(zoneID, func(state *TemperatureControlState) {
+		state.ControlValue = value
+	})
+	return nil
+}
+
+func (a *Account) requestZoneTemperatureControl(ctx context.Context, url string, zoneID int, params map[string]string) (*RequestResult, error) {
+	params["id"] = strconv.Itoa(zoneID)
+	res, err := a.Connection.RequestContext(ctx, a.Connection.BaseURL+url, get, "", params)
+	if err != nil {
+		return nil, err
+	}
+	if !res.OK {
+		return nil, res.apiError()
+	}
+	return res, nil
+}
+
+// updateTemperatureControlState applies update to the cached temperature control state of the zone
+// and dispatches a change event when the state has been changed.
+func (a *Account) updateTemperatureControlState(zoneID int, update func(state *TemperatureControlState)) {
+	a.cacheMutex.Lock()
+	state, ok := a.TemperatureControl[zoneID]
+	changed := false
+	if ok {
+		old := *state
+		update(state)
+		changed = old != *state
+		a.touchValue("temperatureControlState")
+	}
+	a.cacheMutex.Unlock()
+
+	if changed {
+		a.dispatchTemperatureControlStateChanged(zoneID)
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
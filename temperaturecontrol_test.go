@@ -0,0 +1,151 @@
+package digitalstrom_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+// subscribeTemperatureControl returns a channel receiving the temperature control change events of the account
+func subscribeTemperatureControl(t *testing.T, a *digitalstrom.Account) chan digitalstrom.Event {
+	t.Helper()
+	events := make(chan digitalstrom.Event, 10)
+	cancel := a.SubscribeChannel(digitalstrom.SubscriptionSetup{
+		Name:   "temperature control",
+		Filter: digitalstrom.EventFilter{Kinds: []digitalstrom.EventKind{digitalstrom.EKzoneTemperatureControlChanged}},
+	}, events)
+	t.Cleanup(cancel)
+	return events
+}
+
+// receiveEvent returns the next event of ch or fails the test
+func receiveEvent(t *testing.T, ch <-chan digitalstrom.Event) digitalstrom.Event {
+	t.Helper()
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+		return nil
+	}
+}
+
+func TestZoneTemperatureControlConfig(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeTemperatureControl(t, a)
+
+	config, err := a.RequestZoneTemperatureControlConfig(2)
+	if err != nil {
+		t.Fatalf("RequestZoneTemperatureControlConfig failed: %v", err)
+	}
+	if config.ControlMode != digitalstrom.CMpid || config.CtrlTi != 240 || config.CtrlImin != -13.33 || !config.CtrlAntiWindUp {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	config.ControlMode = digitalstrom.CMmanual
+	config.ManualValue = 40
+	if err := a.SetZoneTemperatureControlConfig(2, *config); err != nil {
+		t.Fatalf("SetZoneTemperatureControlConfig failed: %v", err)
+	}
+	params := srv.CallsTo("/json/zone/setTemperatureControlConfig")[0].Params
+	if params.Get("id") != "2" || params.Get("ControlMode") != "4" || params.Get("ManualValue") != "40" ||
+		params.Get("CtrlImin") != "-13.33" || params.Get("CtrlAntiWindUp") != "true" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	srv.Update(func(apartment *dsstest.Apartment) {
+		if apartment.TemperatureControlConfig[2].ManualValue != 40 {
+			t.Errorf("configuration has not been stored")
+		}
+	})
+	if a.TemperatureControl[2].ControlMode != int(digitalstrom.CMmanual) {
+		t.Errorf("cached control mode has not been updated")
+	}
+	receiveEvent(t, events)
+}
+
+func TestZoneTemperatureControlValues(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeTemperatureControl(t, a)
+
+	values, err := a.RequestZoneTemperatureControlValues(2)
+	if err != nil {
+		t.Fatalf("RequestZoneTemperatureControlValues failed: %v", err)
+	}
+	if len(values) != 8 || values[digitalstrom.OMcomfort] != 22 || values[digitalstrom.OMcoolingOff] != 35 {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	// the zone is in comfort mode, so setting the economy value keeps the nominal value
+	if err := a.SetZoneTemperatureControlValues(2, map[digitalstrom.OperationMode]float64{digitalstrom.OMeconomy: 19.5}); err != nil {
+		t.Fatalf("SetZoneTemperatureControlValues failed: %v", err)
+	}
+	params := srv.CallsTo("/json/zone/setTemperatureControlValues")[0].Params
+	if params.Get("Economy") != "19.5" || params.Has("Comfort") {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	if a.TemperatureControl[2].NominalValue != 22 {
+		t.Errorf("nominal value changed to %v", a.TemperatureControl[2].NominalValue)
+	}
+
+	if err := a.SetZoneTemperatureControlValues(2, map[digitalstrom.OperationMode]float64{digitalstrom.OMcomfort: 22.5}); err != nil {
+		t.Fatalf("SetZoneTemperatureControlValues failed: %v", err)
+	}
+	if a.TemperatureControl[2].NominalValue != 22.5 {
+		t.Errorf("expected nominal value 22.5, got %v", a.TemperatureControl[2].NominalValue)
+	}
+	receiveEvent(t, events)
+
+	err = a.SetZoneTemperatureControlValues(2, map[digitalstrom.OperationMode]float64{digitalstrom.OperationMode(8): 20})
+	if err == nil {
+		t.Errorf("expected an error for an invalid operation mode")
+	}
+}
+
+func TestSetZoneOperationMode(t *testing.T) {
+	srv, a := newTestAccount(t)
+	events := subscribeTemperatureControl(t, a)
+
+	if err := a.SetZoneOperationMode(2, digitalstrom.OMnight); err != nil {
+		t.Fatalf("SetZoneOperationMode failed: %v", err)
+	}
+	params := srv.CallsTo("/json/zone/callScene")[0].Params
+	if params.Get("id") != "2" || params.Get("groupID") != "48" || params.Get("sceneNumber") != "4" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	if a.TemperatureControl[2].OperationMode != int(digitalstrom.OMnight) {
+		t.Errorf("cached operation mode has not been updated")
+	}
+	receiveEvent(t, events)
+
+	if err := a.PollTemperatureControlValues(); err != nil {
+		t.Fatalf("PollTemperatureControlValues failed: %v", err)
+	}
+	if a.TemperatureControl[2].NominalValue != 17 {
+		t.Errorf("expected the nominal value of night mode, got %v", a.TemperatureControl[2].NominalValue)
+	}
+}
+
+func TestSetZoneControlValue(t *testing.T) {
+	srv, a := newTestAccount(t)
+
+	if err := a.SetZoneControlValue(2, 55.5); err != nil {
+		t.Fatalf("SetZoneControlValue failed: %v", err)
+	}
+	params := srv.CallsTo("/json/zone/pushSensorValue")[0].Params
+	if params.Get("groupID") != "48" || params.Get("sensorType") != "51" || params.Get("sensorValue") != "55.5" {
+		t.Errorf("unexpected parameters %v", params)
+	}
+	if a.TemperatureControl[2].ControlValue != 55.5 {
+		t.Errorf("cached control value has not been updated")
+	}
+}
+
+func TestZoneWithoutTemperatureControl(t *testing.T) {
+	_, a := newTestAccount(t)
+
+	if _, err := a.RequestZoneTemperatureControlConfig(1); err == nil {
+		t.Errorf("expected an error for a zone without temperature control")
+	}
+}
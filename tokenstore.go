@@ -0,0 +1,107 @@
+package digitalstrom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DefaultTokenEnvironmentVariable is the variable read by an EnvTokenStore without a name
+const DefaultTokenEnvironmentVariable = "DSS_APPLICATION_TOKEN"
+
+// TokenStore persists the application token, so it does not have to appear in code or shell history.
+// When Connection.TokenStore is set, the token is loaded by the application login if no token has been
+// set and saved after the registration of a new application.
+type TokenStore interface {
+	// LoadToken returns the stored token or an error matching ErrNoToken when no token is stored
+	LoadToken() (string, error)
+	// SaveToken stores the token. Read-only stores return an error matching ErrReadOnlyTokenStore, the
+	// token is not saved then without reporting a failure.
+	SaveToken(token string) error
+}
+
+// FileTokenStore stores the token in a file that is only accessible by the user. Files that could be
+// read by other users are refused on unix systems.
+type FileTokenStore struct {
+	Path string
+}
+
+// LoadToken returns the content of the file without surrounding white space
+func (s FileTokenStore) LoadToken() (string, error) {
+	info, err := os.Stat(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w in %s", ErrNoToken, s.Path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("token file %s is accessible by other users (%s), use chmod 600", s.Path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%w in %s", ErrNoToken, s.Path)
+	}
+	return token, nil
+}
+
+// SaveToken replaces the file with a new one with permissions 0600. Missing directories are created
+// with permissions 0700.
+func (s FileTokenStore) SaveToken(token string) error {
+	dir := filepath.Dir(s.Path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	// CreateTemp already uses 0600, but the umask is not trusted
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.WriteString(token + "\n")
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
+
+// EnvTokenStore reads the token from an environment variable, DSS_APPLICATION_TOKEN if Name is empty.
+// It is read-only, tokens of new applications have to be stored by other means.
+type EnvTokenStore struct {
+	Name string
+}
+
+// LoadToken returns the value of the environment variable
+func (s EnvTokenStore) LoadToken() (string, error) {
+	token := strings.TrimSpace(os.Getenv(s.name()))
+	if token == "" {
+		return "", fmt.Errorf("%w in environment variable %s", ErrNoToken, s.name())
+	}
+	return token, nil
+}
+
+// SaveToken always returns ErrReadOnlyTokenStore, as environment variables could not be persisted
+func (s EnvTokenStore) SaveToken(token string) error {
+	return fmt.Errorf("%w, environment variable %s could not be saved", ErrReadOnlyTokenStore, s.name())
+}
+
+func (s EnvTokenStore) name() string {
+	if s.Name == "" {
+		return DefaultTokenEnvironmentVariable
+	}
+	return s.Name
+}
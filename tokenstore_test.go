@@ -0,0 +1,70 @@
+package digitalstrom_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/connctd/digitalstrom"
+	"github.com/connctd/digitalstrom/dsstest"
+)
+
+func TestRegisterApplicationSavesToken(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	store := digitalstrom.FileTokenStore{Path: filepath.Join(t.TempDir(), "dss", "token")}
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetTokenStore(store)
+
+	token, err := a.RegisterApplication("test", dsstest.Username, dsstest.Password)
+	if err != nil {
+		t.Fatalf("RegisterApplication failed: %v", err)
+	}
+	if saved, err := store.LoadToken(); err != nil || saved != token {
+		t.Fatalf("expected the saved token %q, got %q (%v)", token, saved, err)
+	}
+
+	// a new account loads the token from the store
+	b := digitalstrom.NewAccount()
+	b.SetURL(srv.URL)
+	b.SetTokenStore(store)
+	if err := b.Init(); err != nil {
+		t.Errorf("Init with the stored token failed: %v", err)
+	}
+}
+
+func TestRegisterApplicationWithReadOnlyTokenStore(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetTokenStore(digitalstrom.EnvTokenStore{Name: "DSS_TEST_APPLICATION_TOKEN"})
+
+	token, err := a.RegisterApplication("test", dsstest.Username, dsstest.Password)
+	if err != nil || token == "" {
+		t.Errorf("expected a token without error, got %q (%v)", token, err)
+	}
+}
+
+func TestRegisterApplicationWithFailingTokenStore(t *testing.T) {
+	srv := dsstest.NewServer(dsstest.NewApartment())
+	defer srv.Close()
+	dir := t.TempDir()
+	// the path of the token file is an existing directory
+	if err := os.Mkdir(filepath.Join(dir, "token"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	a := digitalstrom.NewAccount()
+	a.SetURL(srv.URL)
+	a.SetTokenStore(digitalstrom.FileTokenStore{Path: filepath.Join(dir, "token")})
+
+	token, err := a.RegisterApplication("test", dsstest.Username, dsstest.Password)
+	if !errors.Is(err, digitalstrom.ErrTokenNotSaved) {
+		t.Fatalf("expected ErrTokenNotSaved, got %v", err)
+	}
+	if token == "" {
+		t.Errorf("the token of the registered application has not been returned")
+	}
+}
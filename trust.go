@@ -0,0 +1,266 @@
+package digitalstrom
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TLSSetup defines how the certificate of the dSS is verified. Most dSS are using a self-signed certificate,
+// which could not be verified against the system roots. Without any settings the system roots are used,
+// all given checks have to succeed otherwise.
+type TLSSetup struct {
+	// TrustOnFirstUse records the fingerprint of the certificate at the first connection to a host and
+	// refuses other certificates of this host afterwards
+	TrustOnFirstUse bool
+	// FingerprintStore keeps the recorded fingerprints, they are only kept in memory when nil
+	FingerprintStore FingerprintStore
+	// RootCAs are used to verify the certificate chain including the host name
+	RootCAs *x509.CertPool
+	// PinnedSPKI are base64 encoded SHA-256 hashes of the subject public key info, one of the presented
+	// certificates has to match. The prefix "sha256//" used by curl is accepted.
+	PinnedSPKI []string
+}
+
+// FingerprintStore persists the certificate fingerprints recorded by trust on first use
+type FingerprintStore interface {
+	// LoadFingerprint returns the fingerprint of the host or an empty string when the host is unknown
+	LoadFingerprint(host string) (string, error)
+	// SaveFingerprint stores the fingerprint of the host
+	SaveFingerprint(host string, fingerprint string) error
+}
+
+// AccountOption changes the setup of an account created by NewAccount
+type AccountOption func(o *accountOptions)
+
+type accountOptions struct {
+	tls *TLSSetup
+}
+
+func (o *accountOptions) tlsSetup() *TLSSetup {
+	if o.tls == nil {
+		o.tls = &TLSSetup{}
+	}
+	return o.tls
+}
+
+// WithTLS verifies the certificate of the dSS according to the setup
+func WithTLS(setup TLSSetup) AccountOption {
+	return func(o *accountOptions) {
+		*o.tlsSetup() = setup
+	}
+}
+
+// WithTrustOnFirstUse records the certificate fingerprint of the dSS at the first connection and refuses
+// other certificates afterwards. The fingerprints are only kept in memory when store is nil.
+func WithTrustOnFirstUse(store FingerprintStore) AccountOption {
+	return func(o *accountOptions) {
+		o.tlsSetup().TrustOnFirstUse = true
+		o.tlsSetup().FingerprintStore = store
+	}
+}
+
+// WithRootCAs verifies the certificate of the dSS against the given certificates, see LoadCABundle
+func WithRootCAs(pool *x509.CertPool) AccountOption {
+	return func(o *accountOptions) {
+		o.tlsSetup().RootCAs = pool
+	}
+}
+
+// WithPinnedSPKI only accepts certificates with one of the given base64 encoded SHA-256 hashes of the
+// subject public key info, see SPKIHash
+func WithPinnedSPKI(hashes ...string) AccountOption {
+	return func(o *accountOptions) {
+		o.tlsSetup().PinnedSPKI = append(o.tlsSetup().PinnedSPKI, hashes...)
+	}
+}
+
+// LoadCABundle reads a PEM file with the certificates to trust
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// CertificateFingerprint returns the hex encoded SHA-256 hash of the certificate as recorded by trust on
+// first use
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// SPKIHash returns the base64 encoded SHA-256 hash of the subject public key info of the certificate as
+// used by TLSSetup.PinnedSPKI
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// SetTLS replaces the http client of the connection by one verifying the certificate of the dSS according to
+// the setup
+func (c *Connection) SetTLS(setup TLSSetup) {
+	v := &certificateVerifier{setup: setup, fingerprints: make(map[string]string)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialTLSContext = v.dial
+	c.HTTPClient = &http.Client{Transport: transport}
+}
+
+// certificateVerifier performs the checks of a TLSSetup. The host is only known when dialing, as the server
+// name of the tls connection is empty for IP addresses.
+type certificateVerifier struct {
+	setup        TLSSetup
+	fingerprints map[string]string // recorded fingerprints, if no store is used
+	mutex        sync.Mutex
+}
+
+func (v *certificateVerifier) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName: host,
+		// the chain is verified by verify, as self-signed certificates have to be accepted for trust on
+		// first use and pinning
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return v.verify(addr, host, cs.PeerCertificates)
+		},
+	}}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+func (v *certificateVerifier) verify(addr string, host string, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("no certificate presented by " + addr)
+	}
+	if v.setup.RootCAs != nil || (!v.setup.TrustOnFirstUse && len(v.setup.PinnedSPKI) == 0) {
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Roots: v.setup.RootCAs, Intermediates: intermediates})
+		if err != nil {
+			return err
+		}
+	}
+	if len(v.setup.PinnedSPKI) > 0 && !v.matchesPin(certs) {
+		return &CertificateMismatchError{Host: addr, Expected: strings.Join(v.setup.PinnedSPKI, ", "), Actual: SPKIHash(certs[0]), Pinned: true}
+	}
+	if v.setup.TrustOnFirstUse {
+		return v.trust(addr, CertificateFingerprint(certs[0]))
+	}
+	return nil
+}
+
+func (v *certificateVerifier) matchesPin(certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		hash := SPKIHash(cert)
+		for _, pin := range v.setup.PinnedSPKI {
+			if strings.TrimPrefix(pin, "sha256//") == hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trust compares the fingerprint with the recorded one and records it at the first connection
+func (v *certificateVerifier) trust(addr string, fingerprint string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	expected := v.fingerprints[addr]
+	if v.setup.FingerprintStore != nil {
+		var err error
+		expected, err = v.setup.FingerprintStore.LoadFingerprint(addr)
+		if err != nil {
+			return err
+		}
+	}
+	if expected == "" {
+		logger.Info("trusting certificate of " + addr + " on first use, fingerprint " + fingerprint)
+		if v.setup.FingerprintStore != nil {
+			return v.setup.FingerprintStore.SaveFingerprint(addr, fingerprint)
+		}
+		v.fingerprints[addr] = fingerprint
+		return nil
+	}
+	if !strings.EqualFold(expected, fingerprint) {
+		return &CertificateMismatchError{Host: addr, Expected: expected, Actual: fingerprint}
+	}
+	return nil
+}
+
+// FileFingerprintStore stores the fingerprints of all hosts as json object in a file that is only accessible
+// by the user
+type FileFingerprintStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+// LoadFingerprint returns the fingerprint of the host or an empty string when the host or the file is unknown
+func (s *FileFingerprintStore) LoadFingerprint(host string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fingerprints, err := s.read()
+	return fingerprints[host], err
+}
+
+// SaveFingerprint adds the fingerprint of the host to the file. The file is created with permissions 0600,
+// missing directories with permissions 0700.
+func (s *FileFingerprintStore) SaveFingerprint(host string, fingerprint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fingerprints, err := s.read()
+	if err != nil {
+		return err
+	}
+	fingerprints[host] = fingerprint
+	data, err := json.MarshalIndent(fingerprints, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.Path), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(s.Path, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(s.Path, 0600)
+}
+
+func (s *FileFingerprintStore) read() (map[string]string, error) {
+	fingerprints := make(map[string]string)
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fingerprints, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &fingerprints)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fingerprint file %s: %w", s.Path, err)
+	}
+	return fingerprints, nil
+}
@@ -0,0 +1,247 @@
+package digitalstrom_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/connctd/digitalstrom"
+)
+
+// newCertificate returns a self-signed certificate for the given host names and addresses
+func newCertificate(t *testing.T, dnsNames []string, ips ...net.IP) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "dss"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// tlsServer is a dSS answering all requests with an empty result. The presented certificate could be
+// replaced while the server is running.
+type tlsServer struct {
+	*httptest.Server
+	certificate atomic.Pointer[tls.Certificate]
+}
+
+func newTLSServer(t *testing.T, certificate tls.Certificate) *tlsServer {
+	t.Helper()
+	srv := &tlsServer{}
+	srv.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true, "result": {}}`))
+	}))
+	// refused handshakes are expected
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.certificate.Store(&certificate)
+	// the certificate is chosen per connection, as clients connecting to an IP address send no server name
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{*srv.certificate.Load()}}, nil
+		},
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// setCertificate replaces the certificate and closes open connections, so the next request performs a
+// new handshake
+func (s *tlsServer) setCertificate(certificate tls.Certificate) {
+	s.certificate.Store(&certificate)
+	s.CloseClientConnections()
+}
+
+func newTLSAccount(srv *tlsServer, options ...digitalstrom.AccountOption) *digitalstrom.Account {
+	a := digitalstrom.NewAccount(options...)
+	a.SetURL(srv.URL)
+	a.Connection.RetryPolicy = digitalstrom.RetryPolicy{MaxAttempts: 1}
+	return a
+}
+
+func request(a *digitalstrom.Account) error {
+	_, err := a.Connection.Get(a.Connection.BaseURL + "/json/system/version")
+	return err
+}
+
+var localhost = net.ParseIP("127.0.0.1")
+
+func TestTrustOnFirstUse(t *testing.T) {
+	first := newCertificate(t, nil, localhost)
+	srv := newTLSServer(t, first)
+	a := newTLSAccount(srv, digitalstrom.WithTrustOnFirstUse(nil))
+
+	if err := request(a); err != nil {
+		t.Fatalf("first connection failed: %v", err)
+	}
+	srv.setCertificate(first)
+	if err := request(a); err != nil {
+		t.Fatalf("connection with the recorded certificate failed: %v", err)
+	}
+
+	second := newCertificate(t, nil, localhost)
+	srv.setCertificate(second)
+	err := request(a)
+	var mismatchErr *digitalstrom.CertificateMismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Fatalf("expected a CertificateMismatchError, got %v", err)
+	}
+	if !errors.Is(err, digitalstrom.ErrCertificateMismatch) || mismatchErr.Pinned {
+		t.Errorf("unexpected error %+v", mismatchErr)
+	}
+	if mismatchErr.Expected != digitalstrom.CertificateFingerprint(first.Leaf) ||
+		mismatchErr.Actual != digitalstrom.CertificateFingerprint(second.Leaf) ||
+		mismatchErr.Host != strings.TrimPrefix(srv.URL, "https://") {
+		t.Errorf("unexpected error %+v", mismatchErr)
+	}
+}
+
+func TestTrustOnFirstUseWithFileStore(t *testing.T) {
+	first := newCertificate(t, nil, localhost)
+	srv := newTLSServer(t, first)
+	path := filepath.Join(t.TempDir(), "trust", "fingerprints.json")
+	store := &digitalstrom.FileFingerprintStore{Path: path}
+
+	if err := request(newTLSAccount(srv, digitalstrom.WithTrustOnFirstUse(store))); err != nil {
+		t.Fatalf("first connection failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("fingerprint has not been saved: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected permissions 0600 of the file, got %v", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(filepath.Dir(path)); info.Mode().Perm() != 0700 {
+		t.Errorf("expected permissions 0700 of the directory, got %v", info.Mode().Perm())
+	}
+	host := strings.TrimPrefix(srv.URL, "https://")
+	if fingerprint, _ := store.LoadFingerprint(host); fingerprint != digitalstrom.CertificateFingerprint(first.Leaf) {
+		t.Errorf("unexpected fingerprint %q", fingerprint)
+	}
+
+	// a new account refuses another certificate, as the fingerprint has been recorded in the file
+	srv.setCertificate(newCertificate(t, nil, localhost))
+	err = request(newTLSAccount(srv, digitalstrom.WithTrustOnFirstUse(&digitalstrom.FileFingerprintStore{Path: path})))
+	if !errors.Is(err, digitalstrom.ErrCertificateMismatch) {
+		t.Errorf("expected a certificate mismatch, got %v", err)
+	}
+}
+
+func TestInvalidFingerprintFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fingerprints.json")
+	if err := os.WriteFile(path, []byte("no json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTLSServer(t, newCertificate(t, nil, localhost))
+
+	err := request(newTLSAccount(srv, digitalstrom.WithTrustOnFirstUse(&digitalstrom.FileFingerprintStore{Path: path})))
+	if err == nil {
+		t.Errorf("expected an error for an invalid fingerprint file")
+	}
+}
+
+func TestPinnedSPKI(t *testing.T) {
+	pinned := newCertificate(t, nil, localhost)
+	other := newCertificate(t, nil, localhost)
+	srv := newTLSServer(t, pinned)
+	hash := digitalstrom.SPKIHash(pinned.Leaf)
+
+	for _, pin := range []string{hash, "sha256//" + hash} {
+		srv.setCertificate(pinned)
+		a := newTLSAccount(srv, digitalstrom.WithPinnedSPKI(digitalstrom.SPKIHash(other.Leaf), pin))
+		if err := request(a); err != nil {
+			t.Errorf("pin %q: connection failed: %v", pin, err)
+		}
+
+		srv.setCertificate(other)
+		a = newTLSAccount(srv, digitalstrom.WithPinnedSPKI(pin))
+		err := request(a)
+		var mismatchErr *digitalstrom.CertificateMismatchError
+		if !errors.As(err, &mismatchErr) {
+			t.Fatalf("pin %q: expected a CertificateMismatchError, got %v", pin, err)
+		}
+		if !mismatchErr.Pinned || mismatchErr.Actual != digitalstrom.SPKIHash(other.Leaf) {
+			t.Errorf("pin %q: unexpected error %+v", pin, mismatchErr)
+		}
+	}
+}
+
+func TestRootCAs(t *testing.T) {
+	valid := newCertificate(t, nil, localhost)
+	otherHost := newCertificate(t, []string{"dss.local"})
+	pool := x509.NewCertPool()
+	pool.AddCert(valid.Leaf)
+	pool.AddCert(otherHost.Leaf)
+	srv := newTLSServer(t, valid)
+	a := newTLSAccount(srv, digitalstrom.WithRootCAs(pool))
+
+	if err := request(a); err != nil {
+		t.Fatalf("connection with a trusted certificate failed: %v", err)
+	}
+
+	// the certificate is signed by a trusted CA, but issued for another host
+	srv.setCertificate(otherHost)
+	err := request(a)
+	var hostErr x509.HostnameError
+	if !errors.As(err, &hostErr) {
+		t.Errorf("expected a host name error, got %v", err)
+	}
+
+	srv.setCertificate(newCertificate(t, nil, localhost))
+	var authorityErr x509.UnknownAuthorityError
+	if err := request(a); !errors.As(err, &authorityErr) {
+		t.Errorf("expected an unknown authority error, got %v", err)
+	}
+}
+
+func TestLoadCABundle(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := digitalstrom.LoadCABundle(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	path := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(path, []byte("no certificates"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := digitalstrom.LoadCABundle(path); err == nil {
+		t.Errorf("expected an error for a file without certificates")
+	}
+}